@@ -1,6 +1,7 @@
 package lxd
 
 import (
+	"archive/tar"
 	"bytes"
 	"crypto/sha256"
 	"crypto/x509"
@@ -21,7 +22,9 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/chai2010/gettext-go/gettext"
 	"github.com/gorilla/websocket"
@@ -43,6 +46,16 @@ type Client struct {
 	keyf            string
 	websocketDialer websocket.Dialer
 
+	// Target is the member of the cluster the requests about containers
+	// are sent to, the server itself when empty.
+	Target string
+
+	extensions []string // the server's API extensions, once fetched
+
+	// ResponseHook, when set, is called with every response this client
+	// parses, which lets a caller record the raw API responses.
+	ResponseHook func(resp *Response)
+
 	scert *x509.Certificate // the cert stored on disk
 
 	scertWire          *x509.Certificate // the cert from the tls connection
@@ -62,14 +75,41 @@ const (
 var (
 	// LXDErrors are special errors; the client library hoists error codes
 	// to these errors internally so that user code can compare against
-	// them. We probably shouldn't hoist BadRequest or InternalError, since
-	// LXD passes an error string along which is more informative than
-	// whatever static error message we would put here.
+	// them with IsLXDError. We probably shouldn't hoist BadRequest or
+	// InternalError, since LXD passes an error string along which is more
+	// informative than whatever static error message we would put here.
 	LXDErrors = map[int]error{
 		http.StatusNotFound: fmt.Errorf("not found"),
 	}
 )
 
+// APIError is the error returned for error responses; Code tells what kind
+// of error it is.
+type APIError struct {
+	Code    shared.ErrorCode
+	Message string
+
+	// RequestID identifies the failed request in the server's log
+	RequestID string
+
+	// Hoisted is the matching error of LXDErrors, if any
+	Hoisted error
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// IsLXDError tells whether err is the error of LXDErrors for the given HTTP
+// status.
+func IsLXDError(err error, status int) bool {
+	if apiErr, ok := err.(*APIError); ok {
+		err = apiErr.Hoisted
+	}
+
+	return err != nil && err == LXDErrors[status]
+}
+
 type Response struct {
 	Type ResponseType `json:"type"`
 
@@ -82,8 +122,9 @@ type Response struct {
 	Resources map[string][]string `json:"resources"`
 
 	/* Valid only for Error responses */
-	Code  int    `json:"error_code"`
-	Error string `json:"error"`
+	Code      int    `json:"error_code"`
+	Error     string `json:"error"`
+	RequestID string `json:"request_id"`
 
 	/* Valid for Sync and Error responses */
 	Metadata json.RawMessage `json:"metadata"`
@@ -139,13 +180,22 @@ func HoistResponse(r *http.Response, rtype ResponseType) (*Response, error) {
 		return nil, err
 	}
 
+	return hoistParsedResponse(resp, rtype)
+}
+
+func hoistParsedResponse(resp *Response, rtype ResponseType) (*Response, error) {
 	if resp.Type == Error {
+		shared.Debugf("Request %s failed: %s", resp.RequestID, resp.Error)
+
+		details := shared.ErrorDetails{}
+		json.Unmarshal(resp.Metadata, &details)
+
 		// Try and use a known error if we have one for this code.
-		err, ok := LXDErrors[resp.Code]
-		if !ok {
-			return nil, fmt.Errorf(resp.Error)
+		if err, ok := LXDErrors[resp.Code]; ok {
+			return nil, &APIError{Code: details.Code, Message: err.Error(), RequestID: resp.RequestID, Hoisted: err}
 		}
-		return nil, err
+
+		return nil, &APIError{Code: details.Code, Message: resp.Error, RequestID: resp.RequestID}
 	}
 
 	if resp.Type != rtype {
@@ -155,6 +205,32 @@ func HoistResponse(r *http.Response, rtype ResponseType) (*Response, error) {
 	return resp, nil
 }
 
+// parseResponse is ParseResponse, handing the response to the client's
+// ResponseHook.
+func (c *Client) parseResponse(r *http.Response) (*Response, error) {
+	resp, err := ParseResponse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.ResponseHook != nil {
+		c.ResponseHook(resp)
+	}
+
+	return resp, nil
+}
+
+// hoistResponse is HoistResponse, handing the response to the client's
+// ResponseHook.
+func (c *Client) hoistResponse(r *http.Response, rtype ResponseType) (*Response, error) {
+	resp, err := c.parseResponse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return hoistParsedResponse(resp, rtype)
+}
+
 func readMyCert() (string, string, error) {
 	certf := ConfigPath("client.crt")
 	keyf := ConfigPath("client.key")
@@ -177,17 +253,64 @@ func (c *Client) loadServerCert() {
 	c.scert = cert
 }
 
+// Settings of the HTTP transports used by the clients. Those are shared by
+// all the clients talking to the same remote, so that connections are kept
+// alive and reused across requests instead of redoing a TLS handshake each
+// time. Changes only apply to transports created afterwards.
+var (
+	TLSHandshakeTimeout = 10 * time.Second
+	KeepAlivePeriod     = 30 * time.Second
+	MaxIdleConnsPerHost = 8
+)
+
+var transports = map[string]*http.Transport{}
+var transportsLock sync.Mutex
+
+/*
+ * remoteTransport returns the transport shared by the clients of the given
+ * address, creating it with newTransport if there isn't one yet.
+ */
+func remoteTransport(key string, newTransport func() *http.Transport) *http.Transport {
+	transportsLock.Lock()
+	defer transportsLock.Unlock()
+
+	tr, ok := transports[key]
+	if !ok {
+		tr = newTransport()
+		tr.MaxIdleConnsPerHost = MaxIdleConnsPerHost
+		transports[key] = tr
+	}
+
+	return tr
+}
+
+func keepAliveDial(network, address string) (net.Conn, error) {
+	conn, err := shared.RFC3493Dialer(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetKeepAlive(true)
+		tcpConn.SetKeepAlivePeriod(KeepAlivePeriod)
+	}
+
+	return conn, nil
+}
+
 // NewClient returns a new LXD client.
 func NewClient(config *Config, remote string) (*Client, error) {
 	c := Client{
-		config: *config,
-		http:   http.Client{},
+		config:       *config,
+		http:         http.Client{},
+		ResponseHook: config.ResponseHook,
 	}
 
 	c.name = remote
 
 	// TODO: Here, we don't support configurable local remotes, we only
-	// support the default local LXD at /var/lib/lxd/unix.socket.
+	// support the default local LXD at /var/lib/lxd/unix.socket (or
+	// $LXD_SOCKET).
 	if remote == "" {
 		c.BaseURL = "http://unix.socket"
 		c.BaseWSURL = "ws://unix.socket"
@@ -212,7 +335,9 @@ func NewClient(config *Config, remote string) (*Client, error) {
 				}
 				return net.DialUnix("unix", nil, raddr)
 			}
-			c.http.Transport = &http.Transport{Dial: uDial}
+			c.http.Transport = remoteTransport(r.Addr, func() *http.Transport {
+				return &http.Transport{Dial: uDial}
+			})
 			c.websocketDialer.NetDial = uDial
 			c.Remote = &r
 			return &c, nil
@@ -227,15 +352,20 @@ func NewClient(config *Config, remote string) (*Client, error) {
 				return nil, err
 			}
 
-			tr := &http.Transport{
-				TLSClientConfig: tlsconfig,
-				Dial:            shared.RFC3493Dialer,
-				Proxy:           http.ProxyFromEnvironment,
-			}
+			tr := remoteTransport(r.Addr+"|"+certf, func() *http.Transport {
+				return &http.Transport{
+					TLSClientConfig:     tlsconfig,
+					Dial:                keepAliveDial,
+					Proxy:               http.ProxyFromEnvironment,
+					TLSHandshakeTimeout: TLSHandshakeTimeout,
+				}
+			})
 
 			c.websocketDialer = websocket.Dialer{
-				NetDial:         shared.RFC3493Dialer,
-				TLSClientConfig: tlsconfig,
+				NetDial:          keepAliveDial,
+				Proxy:            http.ProxyFromEnvironment,
+				TLSClientConfig:  tlsconfig,
+				HandshakeTimeout: TLSHandshakeTimeout,
 			}
 
 			c.certf = certf
@@ -256,6 +386,10 @@ func NewClient(config *Config, remote string) (*Client, error) {
 	} else {
 		return nil, fmt.Errorf(gettext.Gettext("unknown remote name: %q"), remote)
 	}
+
+	// Servers only compress the websockets they're configured to
+	c.websocketDialer.EnableCompression = true
+
 	if err := c.Finger(); err != nil {
 		return nil, err
 	}
@@ -321,10 +455,20 @@ func (c *Client) baseGet(getUrl string) (*Response, error) {
 		c.scertDigestSet = true
 	}
 
-	return HoistResponse(resp, Sync)
+	return c.hoistResponse(resp, Sync)
 }
 
 func (c *Client) put(base string, args shared.Jmap, rtype ResponseType) (*Response, error) {
+	return c.update("PUT", base, args, rtype)
+}
+
+// patch only sends the changes to apply to the resource, which the server
+// merges into its current state.
+func (c *Client) patch(base string, args shared.Jmap, rtype ResponseType) (*Response, error) {
+	return c.update("PATCH", base, args, rtype)
+}
+
+func (c *Client) update(method string, base string, args shared.Jmap, rtype ResponseType) (*Response, error) {
 	uri := c.url(shared.APIVersion, base)
 
 	buf := bytes.Buffer{}
@@ -333,9 +477,9 @@ func (c *Client) put(base string, args shared.Jmap, rtype ResponseType) (*Respon
 		return nil, err
 	}
 
-	shared.Debugf("Putting %s to %s", buf.String(), uri)
+	shared.Debugf("Sending %s %s to %s", method, buf.String(), uri)
 
-	req, err := http.NewRequest("PUT", uri, &buf)
+	req, err := http.NewRequest(method, uri, &buf)
 	if err != nil {
 		return nil, err
 	}
@@ -347,7 +491,7 @@ func (c *Client) put(base string, args shared.Jmap, rtype ResponseType) (*Respon
 		return nil, err
 	}
 
-	return HoistResponse(resp, rtype)
+	return c.hoistResponse(resp, rtype)
 }
 
 func (c *Client) post(base string, args shared.Jmap, rtype ResponseType) (*Response, error) {
@@ -373,7 +517,7 @@ func (c *Client) post(base string, args shared.Jmap, rtype ResponseType) (*Respo
 		return nil, err
 	}
 
-	return HoistResponse(resp, rtype)
+	return c.hoistResponse(resp, rtype)
 }
 
 func (c *Client) getRaw(uri string) (*http.Response, error) {
@@ -390,7 +534,7 @@ func (c *Client) getRaw(uri string) (*http.Response, error) {
 
 	// because it is raw data, we need to check for http status
 	if raw.StatusCode != 200 {
-		resp, err := HoistResponse(raw, Sync)
+		resp, err := c.hoistResponse(raw, Sync)
 		if err != nil {
 			return nil, err
 		}
@@ -423,7 +567,43 @@ func (c *Client) delete(base string, args shared.Jmap, rtype ResponseType) (*Res
 		return nil, err
 	}
 
-	return HoistResponse(resp, rtype)
+	return c.hoistResponse(resp, rtype)
+}
+
+// Query sends a raw request to the remote, queryPath being the full API path
+// (e.g. /1.0/containers), and returns the response whatever its type is.
+func (c *Client) Query(method string, queryPath string, data []byte) (*Response, error) {
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+
+	shared.Debugf("Querying %s %s", method, queryPath)
+
+	req, err := http.NewRequest(method, c.BaseURL+queryPath, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", shared.UserAgent)
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	raw, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.parseResponse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Type == Error {
+		return nil, fmt.Errorf(resp.Error)
+	}
+
+	return resp, nil
 }
 
 func (c *Client) websocket(operation string, secret string) (*websocket.Conn, error) {
@@ -433,14 +613,23 @@ func (c *Client) websocket(operation string, secret string) (*websocket.Conn, er
 }
 
 func (c *Client) url(elem ...string) string {
-	return c.BaseURL + "/" + path.Join(elem...)
+	uri := path.Join(elem...)
+	if c.Target != "" && strings.HasPrefix(uri, shared.APIVersion+"/containers") {
+		if strings.Contains(uri, "?") {
+			uri += "&target=" + url.QueryEscape(c.Target)
+		} else {
+			uri += "?target=" + url.QueryEscape(c.Target)
+		}
+	}
+
+	return c.BaseURL + "/" + uri
 }
 
 func unixDial(networ, addr string) (net.Conn, error) {
 	var raddr *net.UnixAddr
 	var err error
 	if addr == "unix.socket:80" {
-		raddr, err = net.ResolveUnixAddr("unix", shared.VarPath("unix.socket"))
+		raddr, err = net.ResolveUnixAddr("unix", shared.UnixSocketPath())
 		if err != nil {
 			return nil, fmt.Errorf(gettext.Gettext("cannot resolve unix socket address: %v"), err)
 		}
@@ -566,13 +755,25 @@ func (c *Client) CopyImage(image string, dest *Client, copy_aliases bool, aliase
 		return err
 	}
 
+	// The destination repoints the aliases itself, all at once
+	replaceAliases := len(aliases) != 0 && dest.HasExtension("image_alias_replace")
+
 	for _, addr := range addresses {
 		sourceUrl := "https://" + addr
 
 		source["server"] = sourceUrl
 		body := shared.Jmap{"public": public, "source": source}
+		if replaceAliases {
+			body["aliases"] = aliases
+		}
+
+		var resp *Response
+		resp, err = dest.post("images", body, Async)
+		if err != nil {
+			continue
+		}
 
-		_, err = dest.post("images", body, Sync)
+		err = dest.WaitForSuccess(resp.Operation)
 		if err != nil {
 			continue
 		}
@@ -595,6 +796,10 @@ func (c *Client) CopyImage(image string, dest *Client, copy_aliases bool, aliase
 		}
 	}
 
+	if replaceAliases {
+		return nil
+	}
+
 	/* add new aliases */
 	for _, alias := range aliases {
 		dest.DeleteAlias(alias)
@@ -621,53 +826,25 @@ func (c *Client) ExportImage(image string, target string) (*Response, string, er
 
 	// Deal with split images
 	if ctype == "multipart/form-data" {
-		if !shared.IsDir(target) {
-			return nil, "", fmt.Errorf(gettext.Gettext("Split images can only be written to a directory."))
-		}
-
-		// Parse the POST data
 		mr := multipart.NewReader(raw.Body, ctypeParams["boundary"])
 
-		// Get the metadata tarball
-		part, err := mr.NextPart()
-		if err != nil {
-			return nil, "", err
-		}
-
-		if part.FormName() != "metadata" {
-			return nil, "", fmt.Errorf("Invalid multipart image")
-		}
-
-		imageTarf, err := os.OpenFile(part.FileName(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
-		if err != nil {
-			return nil, "", err
-		}
-
-		_, err = io.Copy(imageTarf, part)
-
-		imageTarf.Close()
-		if err != nil {
-			return nil, "", err
-		}
-
-		// Get the rootfs tarball
-		part, err = mr.NextPart()
-		if err != nil {
-			return nil, "", err
+		if target == "-" {
+			return nil, "stdout", exportSplitImageTar(mr, os.Stdout)
 		}
 
-		if part.FormName() != "rootfs" {
-			return nil, "", fmt.Errorf("Invalid multipart image")
+		if !shared.PathExists(target) {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return nil, "", err
+			}
 		}
 
-		rootfsTarf, err := os.OpenFile(part.FileName(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
-		if err != nil {
-			return nil, "", err
+		if !shared.IsDir(target) {
+			return nil, "", fmt.Errorf(gettext.Gettext("Split images can only be written to a directory."))
 		}
 
-		_, err = io.Copy(rootfsTarf, part)
-
-		rootfsTarf.Close()
+		err := exportSplitImage(mr, func(name string) (io.WriteCloser, error) {
+			return os.OpenFile(filepath.Join(target, name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		})
 		if err != nil {
 			return nil, "", err
 		}
@@ -737,6 +914,147 @@ func (c *Client) ExportImage(image string, target string) (*Response, string, er
 	return nil, destpath, nil
 }
 
+/*
+ * ExportContainer downloads a stopped container, or one of its snapshots, as
+ * a tarball without publishing it as an image. The tarball is written to
+ * stdout if target is "-", in the directory target under the name the
+ * server suggests, or to the file target otherwise.
+ */
+func (c *Client) ExportContainer(name string, snapshot string, compress bool, target string) (string, error) {
+	query := url.Values{}
+	if snapshot != "" {
+		query.Set("snapshot", snapshot)
+	}
+	if compress {
+		query.Set("compression", "gzip")
+	}
+
+	uri := c.url(shared.APIVersion, "containers", name, "export")
+	if len(query) > 0 {
+		if strings.Contains(uri, "?") {
+			uri += "&" + query.Encode()
+		} else {
+			uri += "?" + query.Encode()
+		}
+	}
+
+	raw, err := c.getRaw(uri)
+	if err != nil {
+		return "", err
+	}
+	defer raw.Body.Close()
+
+	if target == "-" {
+		_, err := io.Copy(os.Stdout, raw.Body)
+		return "stdout", err
+	}
+
+	destpath := target
+	if shared.IsDir(target) {
+		_, params, err := mime.ParseMediaType(raw.Header.Get("Content-Disposition"))
+		if err != nil || params["filename"] == "" {
+			return "", fmt.Errorf(gettext.Gettext("No filename was given for the tarball"))
+		}
+		destpath = filepath.Join(target, filepath.Base(params["filename"]))
+	}
+
+	f, err := os.OpenFile(destpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, raw.Body); err != nil {
+		return "", err
+	}
+
+	return destpath, nil
+}
+
+/*
+ * exportSplitImage reads the metadata and rootfs parts of a split image
+ * export, writing each of them to the writer open returns for its filename.
+ */
+func exportSplitImage(mr *multipart.Reader, open func(name string) (io.WriteCloser, error)) error {
+	for _, formName := range []string{"metadata", "rootfs"} {
+		part, err := mr.NextPart()
+		if err != nil {
+			return err
+		}
+
+		if part.FormName() != formName {
+			return fmt.Errorf("Invalid multipart image")
+		}
+
+		f, err := open(filepath.Base(part.FileName()))
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(f, part)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * exportSplitImageTar writes both files of a split image export as a single
+ * tarball, the parts are spooled to disk first since tar needs their size.
+ */
+func exportSplitImageTar(mr *multipart.Reader, w io.Writer) error {
+	dir, err := ioutil.TempDir("", "lxc_image_export_")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	names := []string{}
+	err = exportSplitImage(mr, func(name string) (io.WriteCloser, error) {
+		names = append(names, name)
+		return os.Create(filepath.Join(dir, name))
+	})
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	for _, name := range names {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			f.Close()
+			return err
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			f.Close()
+			return err
+		}
+
+		_, err = io.Copy(tw, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
 func (c *Client) PostImage(imageFile string, rootfsFile string, properties []string, public bool, aliases []string) (string, error) {
 	uri := c.url(shared.APIVersion, "images")
 
@@ -758,34 +1076,39 @@ func (c *Client) PostImage(imageFile string, rootfsFile string, properties []str
 		}
 		defer fRootfs.Close()
 
-		body := &bytes.Buffer{}
-		w := multipart.NewWriter(body)
-
-		// Metadata file
-		fw, err := w.CreateFormFile("metadata", path.Base(imageFile))
-		if err != nil {
-			return "", err
-		}
+		/*
+		 * Stream the multipart body rather than building it in memory,
+		 * rootfs tarballs can easily be several hundred MB.
+		 */
+		body, pw := io.Pipe()
+		w := multipart.NewWriter(pw)
+
+		go func() {
+			for _, entry := range []struct {
+				name string
+				f    *os.File
+			}{{"metadata", fImage}, {"rootfs", fRootfs}} {
+				fw, err := w.CreateFormFile(entry.name, path.Base(entry.f.Name()))
+				if err != nil {
+					pw.CloseWithError(err)
+					return
+				}
 
-		_, err = io.Copy(fw, fImage)
-		if err != nil {
-			return "", err
-		}
+				_, err = io.Copy(fw, entry.f)
+				if err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+			}
 
-		// Rootfs file
-		fw, err = w.CreateFormFile("rootfs", path.Base(rootfsFile))
-		if err != nil {
-			return "", err
-		}
+			pw.CloseWithError(w.Close())
+		}()
 
-		_, err = io.Copy(fw, fRootfs)
+		req, err = http.NewRequest("POST", uri, body)
 		if err != nil {
+			body.Close()
 			return "", err
 		}
-
-		w.Close()
-
-		req, err = http.NewRequest("POST", uri, body)
 		req.Header.Set("Content-Type", w.FormDataContentType())
 	} else {
 		req, err = http.NewRequest("POST", uri, fImage)
@@ -822,12 +1145,18 @@ func (c *Client) PostImage(imageFile string, rootfsFile string, properties []str
 		req.Header.Set("X-LXD-properties", imgProps.Encode())
 	}
 
+	// The server repoints the aliases itself, all at once
+	replaceAliases := len(aliases) != 0 && c.HasExtension("image_alias_replace")
+	if replaceAliases {
+		req.Header.Set("X-LXD-aliases", strings.Join(aliases, ","))
+	}
+
 	raw, err := c.http.Do(req)
 	if err != nil {
 		return "", err
 	}
 
-	resp, err := HoistResponse(raw, Sync)
+	resp, err := c.hoistResponse(raw, Sync)
 	if err != nil {
 		return "", err
 	}
@@ -842,6 +1171,10 @@ func (c *Client) PostImage(imageFile string, rootfsFile string, properties []str
 		return "", err
 	}
 
+	if replaceAliases {
+		return fingerprint, nil
+	}
+
 	/* add new aliases */
 	for _, alias := range aliases {
 		c.DeleteAlias(alias)
@@ -905,13 +1238,13 @@ func (c *Client) DeleteAlias(alias string) error {
 	return err
 }
 
-func (c *Client) ListAliases() ([]shared.ImageAlias, error) {
+func (c *Client) ListAliases() ([]shared.ImageAliasInfo, error) {
 	resp, err := c.get("images/aliases?recursion=1")
 	if err != nil {
 		return nil, err
 	}
 
-	var result []shared.ImageAlias
+	var result []shared.ImageAliasInfo
 
 	if err := json.Unmarshal(resp.Metadata, &result); err != nil {
 		return nil, err
@@ -986,27 +1319,107 @@ func (c *Client) AddMyCertToServer(pwd string) error {
 	return err
 }
 
-func (c *Client) CertificateAdd(cert *x509.Certificate, name string) error {
-	b64 := base64.StdEncoding.EncodeToString(cert.Raw)
-	_, err := c.post("certificates", shared.Jmap{"type": "client", "certificate": b64, "name": name}, Sync)
-	return err
-}
+// AddMyCertToServerWithToken adds the client certificate to the server's
+// trust store using a join token obtained with CertificateTokenCreate.
+func (c *Client) AddMyCertToServerWithToken(token string) error {
+	body := shared.Jmap{"type": "client", "token": token}
 
-func (c *Client) CertificateRemove(fingerprint string) error {
-	_, err := c.delete(fmt.Sprintf("certificates/%s", fingerprint), nil, Sync)
+	_, err := c.post("certificates", body, Sync)
 	return err
 }
 
-func (c *Client) IsAlias(alias string) (bool, error) {
-	_, err := c.get(fmt.Sprintf("images/aliases/%s", alias))
-	if err != nil {
-		if err == LXDErrors[http.StatusNotFound] {
-			return false, nil
-		}
-		return false, err
+func (c *Client) CertificateTokenCreate(name string, expiry int) (*shared.CertTokenInfo, error) {
+	if err := c.requireExtension("certificate_tokens"); err != nil {
+		return nil, err
 	}
 
-	return true, nil
+	body := shared.Jmap{"name": name, "expiry": expiry}
+
+	resp, err := c.post("certificates/tokens", body, Sync)
+	if err != nil {
+		return nil, err
+	}
+
+	token := shared.CertTokenInfo{}
+	if err := json.Unmarshal(resp.Metadata, &token); err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (c *Client) CertificateTokenList() ([]shared.CertTokenInfo, error) {
+	if err := c.requireExtension("certificate_tokens"); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.get("certificates/tokens?recursion=1")
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []shared.CertTokenInfo
+	if err := json.Unmarshal(resp.Metadata, &tokens); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (c *Client) CertificateTokenRevoke(id int) error {
+	if err := c.requireExtension("certificate_tokens"); err != nil {
+		return err
+	}
+
+	_, err := c.delete(fmt.Sprintf("certificates/tokens/%d", id), nil, Sync)
+	return err
+}
+
+func (c *Client) CertificateAdd(cert *x509.Certificate, name string) error {
+	b64 := base64.StdEncoding.EncodeToString(cert.Raw)
+	_, err := c.post("certificates", shared.Jmap{"type": "client", "certificate": b64, "name": name}, Sync)
+	return err
+}
+
+/*
+ * CertificateAddLimited adds a certificate to the trust store with limited
+ * access: readOnly only allows GET requests and a non-nil containers list
+ * restricts the certificate to those containers.
+ */
+func (c *Client) CertificateAddLimited(cert *x509.Certificate, name string, readOnly bool, containers []string) error {
+	if err := c.requireExtension("certificate_limits"); err != nil {
+		return err
+	}
+
+	b64 := base64.StdEncoding.EncodeToString(cert.Raw)
+	body := shared.Jmap{
+		"type":        "client",
+		"certificate": b64,
+		"name":        name,
+		"read_only":   readOnly,
+		"restricted":  containers != nil,
+		"containers":  containers,
+	}
+
+	_, err := c.post("certificates", body, Sync)
+	return err
+}
+
+func (c *Client) CertificateRemove(fingerprint string) error {
+	_, err := c.delete(fmt.Sprintf("certificates/%s", fingerprint), nil, Sync)
+	return err
+}
+
+func (c *Client) IsAlias(alias string) (bool, error) {
+	_, err := c.get(fmt.Sprintf("images/aliases/%s", alias))
+	if err != nil {
+		if IsLXDError(err, http.StatusNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
 }
 
 func (c *Client) GetAlias(alias string) string {
@@ -1019,16 +1432,16 @@ func (c *Client) GetAlias(alias string) string {
 		return ""
 	}
 
-	var result shared.ImageAlias
+	var result shared.ImageAliasInfo
 	if err := json.Unmarshal(resp.Metadata, &result); err != nil {
 		return ""
 	}
-	return result.Name
+	return result.Target
 }
 
 // Init creates a container from either a fingerprint or an alias; you must
 // provide at least one.
-func (c *Client) Init(name string, imgremote string, image string, profiles *[]string, ephem bool) (*Response, error) {
+func (c *Client) Init(name string, imgremote string, image string, profiles *[]string, config map[string]string, ephem bool) (*Response, error) {
 	var operation string
 	var tmpremote *Client
 	var err error
@@ -1039,12 +1452,40 @@ func (c *Client) Init(name string, imgremote string, image string, profiles *[]s
 	}
 	architectures := serverStatus.Environment.Architectures
 
+	if c.Target != "" {
+		if err := c.requireExtension("cluster"); err != nil {
+			return nil, err
+		}
+	}
+
 	source := shared.Jmap{"type": "image"}
 
 	if image == "" {
 		return nil, fmt.Errorf(gettext.Gettext("You must provide an image hash or alias name."))
 	}
 
+	initBody := func(source shared.Jmap) shared.Jmap {
+		body := shared.Jmap{"source": source}
+
+		if name != "" {
+			body["name"] = name
+		}
+
+		if profiles != nil {
+			body["profiles"] = *profiles
+		}
+
+		if len(config) != 0 {
+			body["config"] = config
+		}
+
+		if ephem {
+			body["ephemeral"] = ephem
+		}
+
+		return body
+	}
+
 	if imgremote != c.name {
 		source["type"] = "image"
 		source["mode"] = "pull"
@@ -1053,6 +1494,17 @@ func (c *Client) Init(name string, imgremote string, image string, profiles *[]s
 			return nil, err
 		}
 
+		if strings.HasPrefix(tmpremote.BaseURL, "https://") && c.HasExtension("image_remote_cache") {
+			/* The target resolves the alias itself, using the image it
+			 * cached for it while the remote isn't due a check. What it
+			 * can't resolve, a fingerprint or a private image, is
+			 * handled below. */
+			alias := shared.Jmap{"type": "image", "mode": "pull", "server": tmpremote.BaseURL, "alias": image}
+			if resp, err := c.post("containers", initBody(alias), Async); err == nil {
+				return resp, nil
+			}
+		}
+
 		fingerprint := tmpremote.GetAlias(image)
 		if fingerprint == "" {
 			fingerprint = image
@@ -1090,6 +1542,17 @@ func (c *Client) Init(name string, imgremote string, image string, profiles *[]s
 
 		source["server"] = tmpremote.BaseURL
 		source["fingerprint"] = fingerprint
+	} else if c.Target != "" {
+		/* The target checks the image, pulling it from this server or
+		 * another member of the cluster if it doesn't have it. */
+		fingerprint := c.GetAlias(image)
+		if fingerprint != "" {
+			source["fingerprint"] = fingerprint
+		} else if _, err := c.GetImageInfo(image); err == nil {
+			source["fingerprint"] = image
+		} else {
+			source["alias"] = image
+		}
 	} else {
 		fingerprint := c.GetAlias(image)
 		if fingerprint == "" {
@@ -1107,19 +1570,7 @@ func (c *Client) Init(name string, imgremote string, image string, profiles *[]s
 		source["fingerprint"] = fingerprint
 	}
 
-	body := shared.Jmap{"source": source}
-
-	if name != "" {
-		body["name"] = name
-	}
-
-	if profiles != nil {
-		body["profiles"] = *profiles
-	}
-
-	if ephem {
-		body["ephemeral"] = ephem
-	}
+	body := initBody(source)
 
 	var resp *Response
 
@@ -1149,7 +1600,7 @@ func (c *Client) Init(name string, imgremote string, image string, profiles *[]s
 	}
 
 	if err != nil {
-		if LXDErrors[http.StatusNotFound] == err {
+		if IsLXDError(err, http.StatusNotFound) {
 			return nil, fmt.Errorf("image doesn't exist")
 		}
 		return nil, err
@@ -1158,11 +1609,18 @@ func (c *Client) Init(name string, imgremote string, image string, profiles *[]s
 	return resp, nil
 }
 
-func (c *Client) LocalCopy(source string, name string, config map[string]string, profiles []string) (*Response, error) {
+func (c *Client) LocalCopy(source string, name string, config map[string]string, profiles []string, containerOnly bool) (*Response, error) {
+	if containerOnly {
+		if err := c.requireExtension("container_only_copy"); err != nil {
+			return nil, err
+		}
+	}
+
 	body := shared.Jmap{
 		"source": shared.Jmap{
-			"type":   "copy",
-			"source": source,
+			"type":           "copy",
+			"source":         source,
+			"container_only": containerOnly,
 		},
 		"name":     name,
 		"config":   config,
@@ -1180,9 +1638,101 @@ type secretMd struct {
 	Secret string `json:"secret"`
 }
 
-func (c *Client) Exec(name string, cmd []string, env map[string]string, stdin *os.File, stdout *os.File, stderr *os.File) (int, error) {
-	interactive := terminal.IsTerminal(int(stdin.Fd()))
+// How long the client keeps trying to reattach to an interactive exec
+// session whose websocket dropped, this matches the daemon's grace period.
+const execReconnectTimeout = 30 * time.Second
+
+/*
+ * execInteractive mirrors stdin and stdout over the websocket of an
+ * interactive exec session, reattaching to it if the connection drops
+ * while the command is still running.
+ */
+func (c *Client) execInteractive(operation string, secret string, stdin *os.File, stdout *os.File) error {
+	conn, err := c.websocket(operation, secret)
+	if err != nil {
+		return err
+	}
+
+	in := shared.ReaderToChannel(stdin)
+	stdinEOF := make(chan bool)
+	for {
+		stop := make(chan bool)
+		go func(conn *websocket.Conn) {
+			for {
+				select {
+				case buf, ok := <-in:
+					if !ok {
+						closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+						conn.WriteMessage(websocket.CloseMessage, closeMsg)
+						close(stdinEOF)
+						return
+					}
+
+					if err := conn.WriteMessage(websocket.BinaryMessage, buf); err != nil {
+						shared.Debugf("Got err writing %s", err)
+						return
+					}
+				case <-stop:
+					return
+				}
+			}
+		}(conn)
+
+		<-shared.WebsocketRecvStream(stdout, conn)
+		close(stop)
+		conn.Close()
+
+		// We hung up ourselves, there's nothing to reattach to
+		select {
+		case <-stdinEOF:
+			return nil
+		default:
+		}
+
+		conn = c.execReattach(operation, secret)
+		if conn == nil {
+			return nil
+		}
+	}
+}
+
+/*
+ * execReattach reconnects to the websocket of an exec operation which is
+ * still running, it returns nil once the operation is over or if the daemon
+ * couldn't be reached again in time.
+ */
+func (c *Client) execReattach(operation string, secret string) *websocket.Conn {
+	deadline := time.Now().Add(execReconnectTimeout)
+	for time.Now().Before(deadline) {
+		// Give a command which just exited the time to wrap up
+		resp, err := c.baseGet(c.url(operation, "wait") + "?timeout=1")
+		if err == nil {
+			op, err := resp.MetadataAsOperation()
+			if err != nil || op.StatusCode != shared.Running {
+				return nil
+			}
+
+			conn, err := c.websocket(operation, secret)
+			if err == nil {
+				shared.Debugf("Reattached to %s", operation)
+				return conn
+			}
+		}
+
+		time.Sleep(time.Second)
+	}
+
+	return nil
+}
 
+/*
+ * Exec runs a command in a container, returning its exit code. An
+ * interactive command gets a pty, stdin and stdout being mirrored to it,
+ * while the others get pipes, stdin being streamed to the command until
+ * it's closed and its stdout and stderr being kept apart, as pipelines
+ * need.
+ */
+func (c *Client) Exec(name string, cmd []string, env map[string]string, interactive bool, stdin *os.File, stdout *os.File, stderr *os.File) (int, error) {
 	body := shared.Jmap{"command": cmd, "wait-for-websocket": true, "interactive": interactive, "environment": env}
 
 	resp, err := c.post(fmt.Sprintf("containers/%s/exec", name), body, Async)
@@ -1248,12 +1798,9 @@ func (c *Client) Exec(name string, cmd []string, env map[string]string, stdin *o
 			}()
 		}
 
-		conn, err := c.websocket(resp.Operation, md.FDs["0"])
-		if err != nil {
+		if err := c.execInteractive(resp.Operation, md.FDs["0"], stdin, stdout); err != nil {
 			return -1, err
 		}
-		shared.WebsocketSendStream(conn, stdin)
-		<-shared.WebsocketRecvStream(stdout, conn)
 	} else {
 		sources := []*os.File{stdin, stdout, stderr}
 		conns := make([]*websocket.Conn, 3)
@@ -1310,7 +1857,18 @@ func (c *Client) Exec(name string, cmd []string, env map[string]string, stdin *o
 		return -1, err
 	}
 
-	return opMd.GetInt("return")
+	exitCode, err := opMd.GetInt("exit_code")
+	if err == nil {
+		return exitCode, nil
+	}
+
+	// Older servers only give us the raw waitpid() status
+	ret, err := opMd.GetInt("return")
+	if err != nil {
+		return -1, err
+	}
+
+	return ret >> 8, nil
 }
 
 func (c *Client) Action(name string, action shared.ContainerAction, timeout int, force bool) (*Response, error) {
@@ -1345,6 +1903,32 @@ func (c *Client) ServerStatus() (*shared.ServerState, error) {
 	return &ss, nil
 }
 
+// HasExtension tells whether the server advertises the API extension.
+func (c *Client) HasExtension(extension string) bool {
+	if c.extensions == nil {
+		serverStatus, err := c.ServerStatus()
+		if err != nil {
+			return false
+		}
+
+		c.extensions = serverStatus.APIExtensions
+		if c.extensions == nil {
+			c.extensions = []string{}
+		}
+	}
+
+	return shared.StringInSlice(extension, c.extensions)
+}
+
+// requireExtension fails if the server doesn't advertise the API extension.
+func (c *Client) requireExtension(extension string) error {
+	if !c.HasExtension(extension) {
+		return fmt.Errorf(gettext.Gettext("The server is missing the required \"%s\" API extension"), extension)
+	}
+
+	return nil
+}
+
 func (c *Client) ContainerStatus(name string) (*shared.ContainerState, error) {
 	ct := shared.ContainerState{}
 
@@ -1404,7 +1988,7 @@ func (c *Client) PushFile(container string, p string, gid int, uid int, mode os.
 		return err
 	}
 
-	_, err = HoistResponse(raw, Sync)
+	_, err = c.hoistResponse(raw, Sync)
 	return err
 }
 
@@ -1482,6 +2066,36 @@ func (c *Client) WaitFor(waitURL string) (*shared.Operation, error) {
 	return resp.MetadataAsOperation()
 }
 
+/*
+ * WaitForStatus waits for up to timeout seconds (forever if negative) for
+ * the operation to reach the given status, or to finish, and returns its
+ * state at that point.
+ */
+func (c *Client) WaitForStatus(waitURL string, status shared.StatusCode, timeout int) (*shared.Operation, error) {
+	if err := c.requireExtension("operation_wait"); err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("?status_code=%d&timeout=%d", status, timeout)
+	resp, err := c.baseGet(c.url(waitURL, "wait") + query)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.MetadataAsOperation()
+}
+
+// GetOperation returns the current state of the operation at opURL, in the
+// same form as Response.Operation.
+func (c *Client) GetOperation(opURL string) (*shared.Operation, error) {
+	resp, err := c.baseGet(c.url(opURL))
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.MetadataAsOperation()
+}
+
 func (c *Client) WaitForSuccess(waitURL string) error {
 	op, err := c.WaitFor(waitURL)
 	if err != nil {
@@ -1531,6 +2145,70 @@ func (c *Client) ListSnapshots(container string) ([]string, error) {
 	return names, nil
 }
 
+func (c *Client) ListSnapshotsInfo(container string) ([]shared.SnapshotInfo, error) {
+	qUrl := fmt.Sprintf("containers/%s/snapshots?recursion=1", container)
+	resp, err := c.get(qUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []shared.SnapshotInfo
+
+	if err := json.Unmarshal(resp.Metadata, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// ListOperations returns the URLs of all the pending and running operations
+// along with their current state, only those touching the resource at the
+// given URL (e.g. /1.0/containers/foo) if it isn't empty.
+func (c *Client) ListOperations(resource string) (map[string]*shared.Operation, error) {
+	filtered := resource != "" && c.HasExtension("operation_resource_filter")
+
+	uri := "operations"
+	if filtered {
+		uri += "?resource=" + url.QueryEscape(resource)
+	}
+
+	resp, err := c.get(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var urls map[string][]string
+
+	if err := json.Unmarshal(resp.Metadata, &urls); err != nil {
+		return nil, err
+	}
+
+	ops := map[string]*shared.Operation{}
+	for _, list := range urls {
+		for _, url := range list {
+			resp, err := c.baseGet(c.BaseURL + url)
+			if err != nil {
+				// The operation may have finished in the meantime
+				continue
+			}
+
+			op, err := resp.MetadataAsOperation()
+			if err != nil {
+				return nil, err
+			}
+
+			// Older servers can't filter the operations themselves
+			if resource != "" && !filtered && !operationTouches(op, resource) {
+				continue
+			}
+
+			ops[url] = op
+		}
+	}
+
+	return ops, nil
+}
+
 func (c *Client) GetServerConfigString() ([]string, error) {
 	ss, err := c.ServerStatus()
 	var resp []string
@@ -1553,6 +2231,213 @@ func (c *Client) GetServerConfigString() ([]string, error) {
 	return resp, nil
 }
 
+// ConfigKeys returns the server and container configuration keys the
+// server knows about.
+func (c *Client) ConfigKeys() (*shared.ConfigKeys, error) {
+	resp, err := c.get("config_keys")
+	if err != nil {
+		return nil, err
+	}
+
+	keys := shared.ConfigKeys{}
+	if err := json.Unmarshal(resp.Metadata, &keys); err != nil {
+		return nil, err
+	}
+
+	return &keys, nil
+}
+
+/*
+ * Batch runs an action ("create", "launch", "start", "stop" or "delete") on
+ * the batch of containers named <prefix>-<n>, concurrency containers at a
+ * time (0 for the server's default), and waits for it to be done. The image
+ * and count only matter when creating containers.
+ */
+func (c *Client) Batch(action string, prefix string, image string, count int, concurrency int) (*shared.BatchResult, error) {
+	if err := c.requireExtension("batch"); err != nil {
+		return nil, err
+	}
+
+	body := shared.Jmap{"action": action, "prefix": prefix, "count": count, "concurrency": concurrency}
+	if image != "" {
+		source := shared.Jmap{"type": "image", "fingerprint": image}
+		if isAlias, err := c.IsAlias(image); err != nil {
+			return nil, err
+		} else if isAlias {
+			source = shared.Jmap{"type": "image", "alias": image}
+		}
+		body["source"] = source
+	}
+
+	resp, err := c.post("batch", body, Async)
+	if err != nil {
+		return nil, err
+	}
+
+	op, err := c.WaitFor(resp.Operation)
+	if err != nil {
+		return nil, err
+	}
+
+	if op.StatusCode != shared.Success {
+		return nil, op.GetError()
+	}
+
+	result := shared.BatchResult{}
+	if err := json.Unmarshal(op.Metadata, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+/*
+ * Shutdown stops all the containers of the server, checkpointing them if
+ * stateful, each getting timeout seconds to shut down cleanly (0 for the
+ * default). The server exits once the returned operation is done.
+ */
+func (c *Client) Shutdown(stateful bool, timeout int) (*Response, error) {
+	if err := c.requireExtension("shutdown"); err != nil {
+		return nil, err
+	}
+
+	body := shared.Jmap{"stateful": stateful, "timeout": timeout}
+	return c.post("shutdown", body, Async)
+}
+
+// WaitReady blocks until the daemon finished initializing, for at most
+// timeout seconds (0 waits as long as it takes).
+func (c *Client) WaitReady(timeout int) error {
+	if err := c.requireExtension("waitready"); err != nil {
+		return err
+	}
+
+	query := ""
+	if timeout > 0 {
+		query = fmt.Sprintf("?timeout=%d", timeout)
+	}
+
+	_, err := c.baseGet(c.url(shared.APIVersion, "ready") + query)
+	return err
+}
+
+// DatabaseDump returns the content of the server's database as SQL.
+func (c *Client) DatabaseDump() (string, error) {
+	if err := c.requireExtension("database_admin"); err != nil {
+		return "", err
+	}
+
+	resp, err := c.get("database/dump")
+	if err != nil {
+		return "", err
+	}
+
+	dump := shared.DatabaseDump{}
+	if err := json.Unmarshal(resp.Metadata, &dump); err != nil {
+		return "", err
+	}
+
+	return dump.Dump, nil
+}
+
+// DatabaseCheck runs the consistency checks of the server's database.
+func (c *Client) DatabaseCheck() (*shared.DatabaseCheck, error) {
+	if err := c.requireExtension("database_admin"); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.get("database/check")
+	if err != nil {
+		return nil, err
+	}
+
+	check := shared.DatabaseCheck{}
+	if err := json.Unmarshal(resp.Metadata, &check); err != nil {
+		return nil, err
+	}
+
+	return &check, nil
+}
+
+// ClusterInfo returns the name and certificate the server goes by in a
+// cluster.
+func (c *Client) ClusterInfo() (*shared.ClusterInfo, error) {
+	if err := c.requireExtension("cluster"); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.get("cluster")
+	if err != nil {
+		return nil, err
+	}
+
+	info := shared.ClusterInfo{}
+	if err := json.Unmarshal(resp.Metadata, &info); err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+// ClusterMembers returns the other members of the server's cluster.
+func (c *Client) ClusterMembers() ([]shared.ClusterMember, error) {
+	if err := c.requireExtension("cluster"); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.get("cluster/members?recursion=1")
+	if err != nil {
+		return nil, err
+	}
+
+	members := []shared.ClusterMember{}
+	if err := json.Unmarshal(resp.Metadata, &members); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// ClusterMemberAdd registers another server as a member of the cluster.
+func (c *Client) ClusterMemberAdd(member shared.ClusterMember) error {
+	if err := c.requireExtension("cluster"); err != nil {
+		return err
+	}
+
+	body := shared.Jmap{"name": member.Name, "address": member.Address, "certificate": member.Certificate}
+	_, err := c.post("cluster/members", body, Sync)
+	return err
+}
+
+// ClusterMemberRemove removes a member from the cluster.
+func (c *Client) ClusterMemberRemove(name string) error {
+	if err := c.requireExtension("cluster"); err != nil {
+		return err
+	}
+
+	_, err := c.delete("cluster/members/"+name, nil, Sync)
+	return err
+}
+
+// ClusterContainers lists the containers of all the members of the cluster.
+func (c *Client) ClusterContainers() (*shared.ClusterContainers, error) {
+	if err := c.requireExtension("cluster"); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.get("cluster/containers")
+	if err != nil {
+		return nil, err
+	}
+
+	containers := shared.ClusterContainers{}
+	if err := json.Unmarshal(resp.Metadata, &containers); err != nil {
+		return nil, err
+	}
+
+	return &containers, nil
+}
+
 func (c *Client) SetServerConfig(key string, value string) (*Response, error) {
 	body := shared.Jmap{"config": shared.Jmap{key: value}}
 	return c.put("", body, Sync)
@@ -1581,24 +2466,13 @@ func (c *Client) GetContainerConfig(container string) ([]string, error) {
 }
 
 func (c *Client) SetContainerConfig(container, key, value string) error {
-	st, err := c.ContainerStatus(container)
-	if err != nil {
-		return err
-	}
-
-	if value == "" {
-		delete(st.Config, key)
-	} else {
-		st.Config[key] = value
-	}
-
-	body := shared.Jmap{"config": st.Config, "profiles": st.Profiles, "name": container, "devices": st.Devices}
+	// An empty value removes the key
+	body := shared.Jmap{"config": map[string]string{key: value}}
 	/*
-	 * Although container config is an async operation (we PUT to restore a
-	 * snapshot), we expect config to be a sync operation, so let's just
-	 * handle it here.
+	 * Although container config is an async operation, we expect config
+	 * to be a sync operation, so let's just handle it here.
 	 */
-	resp, err := c.put(fmt.Sprintf("containers/%s", container), body, Async)
+	resp, err := c.patch(fmt.Sprintf("containers/%s", container), body, Async)
 	if err != nil {
 		return err
 	}
@@ -1628,6 +2502,13 @@ func (c *Client) ProfileDelete(p string) error {
 	return err
 }
 
+func (c *Client) ProfileRename(p string, newName string) error {
+	body := shared.Jmap{"name": newName}
+
+	_, err := c.post(fmt.Sprintf("profiles/%s", p), body, Sync)
+	return err
+}
+
 func (c *Client) GetProfileConfig(profile string) (map[string]string, error) {
 	st, err := c.ProfileConfig(profile)
 	if err != nil {
@@ -1638,20 +2519,9 @@ func (c *Client) GetProfileConfig(profile string) (map[string]string, error) {
 }
 
 func (c *Client) SetProfileConfigItem(profile, key, value string) error {
-	st, err := c.ProfileConfig(profile)
-	if err != nil {
-		shared.Debugf("Error getting profile %s to update", profile)
-		return err
-	}
-
-	if value == "" {
-		delete(st.Config, key)
-	} else {
-		st.Config[key] = value
-	}
-
-	body := shared.Jmap{"name": profile, "config": st.Config, "devices": st.Devices}
-	_, err = c.put(fmt.Sprintf("profiles/%s", profile), body, Sync)
+	// An empty value removes the key
+	body := shared.Jmap{"config": map[string]string{key: value}}
+	_, err := c.patch(fmt.Sprintf("profiles/%s", profile), body, Sync)
 	return err
 }
 
@@ -1664,6 +2534,14 @@ func (c *Client) PutProfile(name string, profile shared.ProfileConfig) error {
 	return err
 }
 
+// PatchProfile only sends changes to a profile: the config keys set to an
+// empty value and the devices set to nil are removed, the others set.
+func (c *Client) PatchProfile(name string, config map[string]string, devices shared.Devices) error {
+	body := shared.Jmap{"config": config, "devices": devices}
+	_, err := c.patch(fmt.Sprintf("profiles/%s", name), body, Sync)
+	return err
+}
+
 func (c *Client) ListProfiles() ([]string, error) {
 	resp, err := c.get("profiles")
 	if err != nil {
@@ -1701,27 +2579,158 @@ func (c *Client) ListProfiles() ([]string, error) {
 	return names, nil
 }
 
-func (c *Client) ApplyProfile(container, profile string) (*Response, error) {
-	st, err := c.ContainerStatus(container)
+// ListNetworks returns the host's interfaces and the managed networks.
+func (c *Client) ListNetworks() ([]shared.NetworkConfig, error) {
+	resp, err := c.get("networks?recursion=1")
 	if err != nil {
 		return nil, err
 	}
-	profiles := strings.Split(profile, ",")
-	body := shared.Jmap{"config": st.Config, "profiles": profiles, "name": st.Name, "devices": st.Devices}
 
-	return c.put(fmt.Sprintf("containers/%s", container), body, Async)
+	networks := []shared.NetworkConfig{}
+	if err := json.Unmarshal(resp.Metadata, &networks); err != nil {
+		return nil, err
+	}
+
+	return networks, nil
 }
 
-func (c *Client) ContainerDeviceDelete(container, devname string) (*Response, error) {
-	st, err := c.ContainerStatus(container)
+func (c *Client) NetworkGet(name string) (*shared.NetworkConfig, error) {
+	network := shared.NetworkConfig{}
+
+	resp, err := c.get(fmt.Sprintf("networks/%s", name))
 	if err != nil {
 		return nil, err
 	}
 
-	delete(st.Devices, devname)
+	if err := json.Unmarshal(resp.Metadata, &network); err != nil {
+		return nil, err
+	}
 
-	body := shared.Jmap{"config": st.Config, "profiles": st.Profiles, "name": st.Name, "devices": st.Devices}
-	return c.put(fmt.Sprintf("containers/%s", container), body, Async)
+	return &network, nil
+}
+
+// NetworkCreate creates a managed network, a bridge set up by the daemon.
+func (c *Client) NetworkCreate(name string, config map[string]string) error {
+	if err := c.requireExtension("network"); err != nil {
+		return err
+	}
+
+	body := shared.Jmap{"name": name, "config": config}
+	_, err := c.post("networks", body, Sync)
+	return err
+}
+
+// NetworkPut replaces the configuration of a managed network.
+func (c *Client) NetworkPut(name string, config map[string]string) error {
+	if err := c.requireExtension("network"); err != nil {
+		return err
+	}
+
+	body := shared.Jmap{"config": config}
+	_, err := c.put(fmt.Sprintf("networks/%s", name), body, Sync)
+	return err
+}
+
+// NetworkRename renames a managed network, which no nic may be using.
+func (c *Client) NetworkRename(name string, newName string) error {
+	if err := c.requireExtension("network_rename"); err != nil {
+		return err
+	}
+
+	body := shared.Jmap{"name": newName}
+	_, err := c.post(fmt.Sprintf("networks/%s", name), body, Sync)
+	return err
+}
+
+func (c *Client) NetworkDelete(name string) error {
+	if err := c.requireExtension("network"); err != nil {
+		return err
+	}
+
+	_, err := c.delete(fmt.Sprintf("networks/%s", name), nil, Sync)
+	return err
+}
+
+// NetworkLeases returns the DHCP leases of a managed network.
+func (c *Client) NetworkLeases(name string) ([]shared.NetworkLease, error) {
+	if err := c.requireExtension("network_leases"); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.get(fmt.Sprintf("networks/%s/leases", name))
+	if err != nil {
+		return nil, err
+	}
+
+	leases := []shared.NetworkLease{}
+	if err := json.Unmarshal(resp.Metadata, &leases); err != nil {
+		return nil, err
+	}
+
+	return leases, nil
+}
+
+// NetworkForwards returns the port forwards of a managed network.
+func (c *Client) NetworkForwards(name string) ([]shared.NetworkForward, error) {
+	if err := c.requireExtension("network_forward"); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.get(fmt.Sprintf("networks/%s/forwards?recursion=1", name))
+	if err != nil {
+		return nil, err
+	}
+
+	forwards := []shared.NetworkForward{}
+	if err := json.Unmarshal(resp.Metadata, &forwards); err != nil {
+		return nil, err
+	}
+
+	return forwards, nil
+}
+
+func (c *Client) NetworkForwardAdd(name string, forward shared.NetworkForward) error {
+	if err := c.requireExtension("network_forward"); err != nil {
+		return err
+	}
+
+	body := shared.Jmap{
+		"protocol":       forward.Protocol,
+		"listen_address": forward.ListenAddress,
+		"listen_port":    forward.ListenPort,
+		"target_address": forward.TargetAddress,
+		"target_port":    forward.TargetPort,
+		"description":    forward.Description}
+	_, err := c.post(fmt.Sprintf("networks/%s/forwards", name), body, Sync)
+	return err
+}
+
+func (c *Client) NetworkForwardDelete(name string, forward string) error {
+	if err := c.requireExtension("network_forward"); err != nil {
+		return err
+	}
+
+	_, err := c.delete(fmt.Sprintf("networks/%s/forwards/%s", name, forward), nil, Sync)
+	return err
+}
+
+func (c *Client) ApplyProfile(container, profile string) (*Response, error) {
+	// The order matters here, later profiles override earlier ones
+	profiles := []string{}
+	if profile != "" {
+		for _, p := range strings.Split(profile, ",") {
+			profiles = append(profiles, strings.TrimSpace(p))
+		}
+	}
+	body := shared.Jmap{"profiles": profiles}
+
+	return c.patch(fmt.Sprintf("containers/%s", container), body, Async)
+}
+
+func (c *Client) ContainerDeviceDelete(container, devname string) (*Response, error) {
+	// A null device gets removed
+	body := shared.Jmap{"devices": shared.Devices{devname: nil}}
+	return c.patch(fmt.Sprintf("containers/%s", container), body, Async)
 }
 
 func (c *Client) ContainerDeviceAdd(container, devname, devtype string, props []string) (*Response, error) {
@@ -1744,13 +2753,9 @@ func (c *Client) ContainerDeviceAdd(container, devname, devtype string, props []
 		return nil, fmt.Errorf(gettext.Gettext("device already exists\n"))
 	}
 	newdev["type"] = devtype
-	if st.Devices == nil {
-		st.Devices = shared.Devices{}
-	}
-	st.Devices[devname] = newdev
 
-	body := shared.Jmap{"config": st.Config, "profiles": st.Profiles, "name": st.Name, "devices": st.Devices}
-	return c.put(fmt.Sprintf("containers/%s", container), body, Async)
+	body := shared.Jmap{"devices": shared.Devices{devname: newdev}}
+	return c.patch(fmt.Sprintf("containers/%s", container), body, Async)
 }
 
 func (c *Client) ContainerListDevices(container string) ([]string, error) {
@@ -1766,19 +2771,9 @@ func (c *Client) ContainerListDevices(container string) ([]string, error) {
 }
 
 func (c *Client) ProfileDeviceDelete(profile, devname string) (*Response, error) {
-	st, err := c.ProfileConfig(profile)
-	if err != nil {
-		return nil, err
-	}
-
-	for n, _ := range st.Devices {
-		if n == devname {
-			delete(st.Devices, n)
-		}
-	}
-
-	body := shared.Jmap{"config": st.Config, "name": st.Name, "devices": st.Devices}
-	return c.put(fmt.Sprintf("profiles/%s", profile), body, Sync)
+	// A null device gets removed
+	body := shared.Jmap{"devices": shared.Devices{devname: nil}}
+	return c.patch(fmt.Sprintf("profiles/%s", profile), body, Sync)
 }
 
 func (c *Client) ProfileDeviceAdd(profile, devname, devtype string, props []string) (*Response, error) {
@@ -1801,13 +2796,9 @@ func (c *Client) ProfileDeviceAdd(profile, devname, devtype string, props []stri
 		return nil, fmt.Errorf(gettext.Gettext("device already exists\n"))
 	}
 	newdev["type"] = devtype
-	if st.Devices == nil {
-		st.Devices = shared.Devices{}
-	}
-	st.Devices[devname] = newdev
 
-	body := shared.Jmap{"config": st.Config, "name": st.Name, "devices": st.Devices}
-	return c.put(fmt.Sprintf("profiles/%s", profile), body, Sync)
+	body := shared.Jmap{"devices": shared.Devices{devname: newdev}}
+	return c.patch(fmt.Sprintf("profiles/%s", profile), body, Sync)
 }
 
 func (c *Client) ProfileListDevices(profile string) ([]string, error) {
@@ -1840,6 +2831,39 @@ func WebsocketDial(dialer websocket.Dialer, url string) (*websocket.Conn, error)
 	return conn, err
 }
 
+// Monitor connects to the events websocket and calls handler with every
+// event of the given types (all of them if types is empty) until the
+// connection goes away.
+func (c *Client) Monitor(types []string, handler func(message interface{})) error {
+	uri := c.BaseWSURL + path.Join("/", shared.APIVersion, "events")
+	if len(types) != 0 {
+		query := url.Values{"type": []string{strings.Join(types, ",")}}
+		uri += "?" + query.Encode()
+	}
+
+	conn, err := WebsocketDial(c.websocketDialer, uri)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for {
+		message := make(map[string]interface{})
+
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		err = json.Unmarshal(data, &message)
+		if err != nil {
+			return err
+		}
+
+		handler(message)
+	}
+}
+
 func (c *Client) ProfileCopy(name, newname string, dest *Client) error {
 	st, err := c.ProfileConfig(name)
 	if err != nil {
@@ -1851,19 +2875,37 @@ func (c *Client) ProfileCopy(name, newname string, dest *Client) error {
 	return err
 }
 
-func (c *Client) ImageFromContainer(cname string, public bool, aliases []string, properties map[string]string) (string, error) {
+func (c *Client) ImageFromContainer(cname string, public bool, aliases []string, properties map[string]string, deleteReplaced bool) (string, error) {
 	source := shared.Jmap{"type": "container", "name": cname}
 	if shared.IsSnapshot(cname) {
 		source["type"] = "snapshot"
 	}
 	body := shared.Jmap{"public": public, "source": source, "properties": properties}
 
-	resp, err := c.post("images", body, Sync)
+	// The server repoints the aliases itself, all at once
+	replaceAliases := len(aliases) != 0 && c.HasExtension("image_alias_replace")
+	if replaceAliases {
+		body["aliases"] = aliases
+		body["delete_replaced"] = deleteReplaced
+	} else if deleteReplaced {
+		return "", fmt.Errorf("The server can't replace the images behind aliases")
+	}
+
+	resp, err := c.post("images", body, Async)
 	if err != nil {
 		return "", err
 	}
 
-	jmap, err := resp.MetadataAsMap()
+	op, err := c.WaitFor(resp.Operation)
+	if err != nil {
+		return "", err
+	}
+
+	if op.StatusCode != shared.Success {
+		return "", op.GetError()
+	}
+
+	jmap, err := op.MetadataAsMap()
 	if err != nil {
 		return "", err
 	}
@@ -1873,6 +2915,10 @@ func (c *Client) ImageFromContainer(cname string, public bool, aliases []string,
 		return "", err
 	}
 
+	if replaceAliases {
+		return fingerprint, nil
+	}
+
 	/* add new aliases */
 	for _, alias := range aliases {
 		c.DeleteAlias(alias)
@@ -1884,3 +2930,18 @@ func (c *Client) ImageFromContainer(cname string, public bool, aliases []string,
 
 	return fingerprint, nil
 }
+
+// operationTouches tells whether one of the resources of the operation is
+// the one at url, or part of it.
+func operationTouches(op *shared.Operation, url string) bool {
+	url = strings.TrimSuffix(url, "/")
+	for _, values := range op.Resources {
+		for _, value := range values {
+			if value == url || strings.HasPrefix(value, url+"/") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
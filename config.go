@@ -25,6 +25,14 @@ type Config struct {
 	// The implicit "local" remote is always available and communicates
 	// with the local daemon over a unix socket.
 	Remotes map[string]RemoteConfig `yaml:"remotes"`
+
+	// Aliases maps user defined command names to the command line they
+	// expand to (e.g. "ubuntu" to "launch images:ubuntu/trusty").
+	Aliases map[string]string `yaml:"aliases,omitempty"`
+
+	// ResponseHook is handed to the clients created from this
+	// configuration, see Client.ResponseHook.
+	ResponseHook func(resp *Response) `yaml:"-"`
 }
 
 // RemoteConfig holds details for communication with a remote daemon.
@@ -72,6 +80,9 @@ func LoadConfig() (*Config, error) {
 	if c.Remotes == nil {
 		c.Remotes = make(map[string]RemoteConfig)
 	}
+	if c.Aliases == nil {
+		c.Aliases = make(map[string]string)
+	}
 
 	return &c, nil
 }
@@ -93,11 +104,21 @@ func SaveConfig(c *Config) error {
 	defer os.Remove(fname + ".new")
 
 	data, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("cannot marshal configuration: %v", err)
+	}
+
 	_, err = f.Write(data)
 	if err != nil {
 		return fmt.Errorf("cannot write configuration: %v", err)
 	}
 
+	// Make sure the new file hits the disk before it replaces the old one
+	err = f.Sync()
+	if err != nil {
+		return fmt.Errorf("cannot write configuration: %v", err)
+	}
+
 	f.Close()
 	err = shared.FileMove(fname+".new", fname)
 	if err != nil {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/chai2010/gettext-go/gettext"
 
@@ -13,6 +14,7 @@ import (
 type actionCmd struct {
 	action     shared.ContainerAction
 	hasTimeout bool
+	name       string
 }
 
 func (c *actionCmd) showByDefault() bool {
@@ -23,10 +25,18 @@ var timeout = -1
 var force = false
 
 func (c *actionCmd) usage() string {
+	state := string(c.action)
+	switch c.action {
+	case shared.Freeze:
+		state = "frozen"
+	case shared.Unfreeze:
+		state = "running"
+	}
+
 	return fmt.Sprintf(gettext.Gettext(
 		"Changes one or more containers state to %s.\n"+
 			"\n"+
-			"lxc %s <name> [<name>...]\n"), c.action, c.action)
+			"lxc %s <name> [<name>...]\n"), state, c.name)
 }
 
 func (c *actionCmd) flags() {
@@ -36,7 +46,7 @@ func (c *actionCmd) flags() {
 	}
 }
 
-func (c *actionCmd) run(config *lxd.Config, args []string) error {
+func (c *actionCmd) run(config *lxd.Config, out io.Writer, args []string) error {
 	if len(args) == 0 {
 		return errArgs
 	}
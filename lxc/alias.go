@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/chai2010/gettext-go/gettext"
+	"github.com/olekukonko/tablewriter"
+
+	"github.com/lxc/lxd"
+)
+
+type aliasCmd struct{}
+
+func (c *aliasCmd) showByDefault() bool {
+	return true
+}
+
+func (c *aliasCmd) usage() string {
+	return gettext.Gettext(
+		"Manage command aliases.\n" +
+			"\n" +
+			"lxc alias add <alias> <target>\n" +
+			"lxc alias remove <alias>\n" +
+			"lxc alias list\n" +
+			"\n" +
+			"Aliases are expanded in place of the command name, followed by the\n" +
+			"rest of the arguments. For example, after\n" +
+			"    lxc alias add ubuntu \"launch images:ubuntu/trusty\"\n" +
+			"\"lxc ubuntu c1\" runs \"lxc launch images:ubuntu/trusty c1\".\n")
+}
+
+func (c *aliasCmd) flags() {}
+
+func (c *aliasCmd) run(config *lxd.Config, out io.Writer, args []string) error {
+	if len(args) < 1 {
+		return errArgs
+	}
+
+	switch args[0] {
+	case "add":
+		if len(args) < 3 {
+			return errArgs
+		}
+
+		if _, ok := commands[args[1]]; ok {
+			return fmt.Errorf(gettext.Gettext("%s is a built-in command and can't be aliased"), args[1])
+		}
+
+		if _, ok := config.Aliases[args[1]]; ok {
+			return fmt.Errorf(gettext.Gettext("alias %s already exists"), args[1])
+		}
+
+		if config.Aliases == nil {
+			config.Aliases = map[string]string{}
+		}
+		config.Aliases[args[1]] = strings.Join(args[2:], " ")
+
+	case "remove":
+		if len(args) != 2 {
+			return errArgs
+		}
+
+		if _, ok := config.Aliases[args[1]]; !ok {
+			return fmt.Errorf(gettext.Gettext("alias %s doesn't exist"), args[1])
+		}
+
+		delete(config.Aliases, args[1])
+
+	case "list":
+		data := [][]string{}
+		for alias, target := range config.Aliases {
+			data = append(data, []string{alias, target})
+		}
+
+		table := tablewriter.NewWriter(out)
+		table.SetHeader([]string{"ALIAS", "TARGET"})
+		sort.Sort(ByName(data))
+		table.AppendBulk(data)
+		table.Render()
+
+		return nil
+
+	default:
+		return fmt.Errorf(gettext.Gettext("Unknown alias subcommand %s"), args[0])
+	}
+
+	return lxd.SaveConfig(config)
+}
+
+/*
+ * expandAlias replaces a user defined alias used in place of the command
+ * name by the command line it stands for. This happens before the flags
+ * get parsed, so --config has to be looked for by hand to find the aliases.
+ */
+func expandAlias(args []string) []string {
+	configDir := lxd.ConfigDir
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+
+		if arg == "--config" && i+1 < len(args) {
+			configDir = args[i+1]
+		} else if strings.HasPrefix(arg, "--config=") {
+			configDir = strings.TrimPrefix(arg, "--config=")
+		}
+	}
+
+	defaultDir := lxd.ConfigDir
+	lxd.ConfigDir = configDir
+	config, err := lxd.LoadConfig()
+	lxd.ConfigDir = defaultDir
+	if err != nil {
+		return args
+	}
+
+	target, ok := config.Aliases[args[1]]
+	if !ok {
+		return args
+	}
+
+	expanded := append([]string{args[0]}, strings.Fields(target)...)
+	return append(expanded, args[2:]...)
+}
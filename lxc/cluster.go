@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/chai2010/gettext-go/gettext"
+	"github.com/olekukonko/tablewriter"
+
+	"github.com/lxc/lxd"
+	"github.com/lxc/lxd/shared"
+)
+
+type clusterCmd struct{}
+
+func (c *clusterCmd) showByDefault() bool {
+	return true
+}
+
+func (c *clusterCmd) usage() string {
+	return gettext.Gettext(
+		"Manage the cluster of LXD servers.\n" +
+			"\n" +
+			"lxc cluster list [remote:]                   List the other members of the cluster.\n" +
+			"lxc cluster add [remote:] <remote>           Register the two servers with each other.\n" +
+			"lxc cluster remove [remote:]<member>         Remove a member from the cluster.\n" +
+			"lxc cluster containers [remote:]             List the containers of the whole cluster.\n" +
+			"\n" +
+			"Members of a cluster trust each other, pull the images they don't\n" +
+			"have from one another and can create containers on each other's\n" +
+			"behalf, for instance with: lxc launch ubuntu u1 --target host2\n")
+}
+
+func (c *clusterCmd) flags() {}
+
+// clusterMember returns how the server behind the client is known to the
+// other members of a cluster.
+func clusterMember(d *lxd.Client) (shared.ClusterMember, error) {
+	info, err := d.ClusterInfo()
+	if err != nil {
+		return shared.ClusterMember{}, err
+	}
+
+	addresses, err := d.Addresses()
+	if err != nil {
+		return shared.ClusterMember{}, err
+	}
+
+	member := shared.ClusterMember{
+		Name:        info.Name,
+		Address:     addresses[0],
+		Certificate: info.Certificate,
+	}
+
+	return member, nil
+}
+
+func (c *clusterCmd) run(config *lxd.Config, out io.Writer, args []string) error {
+	if len(args) < 1 {
+		return errArgs
+	}
+
+	switch args[0] {
+	case "add":
+		if len(args) < 2 || len(args) > 3 {
+			return errArgs
+		}
+
+		remote := config.DefaultRemote
+		if len(args) == 3 {
+			remote = config.ParseRemote(args[1])
+		}
+
+		d1, err := lxd.NewClient(config, remote)
+		if err != nil {
+			return err
+		}
+
+		d2, err := lxd.NewClient(config, config.ParseRemote(args[len(args)-1]))
+		if err != nil {
+			return err
+		}
+
+		member1, err := clusterMember(d1)
+		if err != nil {
+			return err
+		}
+
+		member2, err := clusterMember(d2)
+		if err != nil {
+			return err
+		}
+
+		if err := d1.ClusterMemberAdd(member2); err != nil {
+			return err
+		}
+
+		return d2.ClusterMemberAdd(member1)
+
+	case "remove":
+		if len(args) != 2 {
+			return errArgs
+		}
+
+		remote, name := config.ParseRemoteAndContainer(args[1])
+		d, err := lxd.NewClient(config, remote)
+		if err != nil {
+			return err
+		}
+
+		return d.ClusterMemberRemove(name)
+
+	case "list", "containers":
+		if len(args) > 2 {
+			return errArgs
+		}
+
+		remote := config.DefaultRemote
+		if len(args) == 2 {
+			remote = config.ParseRemote(args[1])
+		}
+
+		d, err := lxd.NewClient(config, remote)
+		if err != nil {
+			return err
+		}
+
+		table := tablewriter.NewWriter(out)
+
+		if args[0] == "list" {
+			members, err := d.ClusterMembers()
+			if err != nil {
+				return err
+			}
+
+			table.SetHeader([]string{gettext.Gettext("NAME"), gettext.Gettext("ADDRESS")})
+			for _, member := range members {
+				table.Append([]string{member.Name, member.Address})
+			}
+			table.Render()
+
+			return nil
+		}
+
+		containers, err := d.ClusterContainers()
+		if err != nil {
+			return err
+		}
+
+		table.SetHeader([]string{gettext.Gettext("MEMBER"), gettext.Gettext("NAME"), gettext.Gettext("STATE")})
+		for _, container := range containers.Containers {
+			table.Append([]string{container.Member, container.Container.State.Name, container.Container.State.Status.Status})
+		}
+		table.Render()
+
+		for _, member := range containers.Unreachable {
+			fmt.Fprintf(os.Stderr, gettext.Gettext("Cluster member %s couldn't be reached")+"\n", member)
+		}
+
+		return nil
+
+	default:
+		return fmt.Errorf(gettext.Gettext("Unknown cluster subcommand %s"), args[0])
+	}
+}
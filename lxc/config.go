@@ -4,9 +4,11 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"syscall"
 
@@ -17,10 +19,14 @@ import (
 
 	"github.com/lxc/lxd"
 	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/gnuflag"
 )
 
 type configCmd struct {
-	httpAddr string
+	httpAddr   string
+	expanded   bool
+	readOnly   bool
+	containers string
 }
 
 func (c *configCmd) showByDefault() bool {
@@ -44,6 +50,9 @@ var configEditHelp string = gettext.Gettext(
 		"###     type: disk\n" +
 		"### ephemeral: false\n" +
 		"###\n" +
+		"### Profiles are applied in the order they are listed, later ones\n" +
+		"### overriding the configuration and devices of earlier ones.\n" +
+		"###\n" +
 		"### Note that the name is shown but cannot be changed\n")
 
 func (c *configCmd) usage() string {
@@ -61,11 +70,15 @@ func (c *configCmd) usage() string {
 			"lxc config unset [remote:]<container> key              Unset container configuration key\n" +
 			"lxc config set key value                               Set server configuration key\n" +
 			"lxc config unset key                                   Unset server configuration key\n" +
-			"lxc config show [remote:]<container>                   Show container configuration\n" +
+			"lxc config show [--expanded] [remote:]<container>      Show container configuration\n" +
 			"lxc config trust list [remote]                         List all trusted certs.\n" +
-			"lxc config trust add [remote] <certfile.crt>           Add certfile.crt to trusted hosts.\n" +
-			"lxc config trust remove [remote] [hostname|fingerprint]\n" +
+			"lxc config trust add [remote] <certfile.crt> [--read-only] [--containers=c1,c2]\n" +
+			"               Add certfile.crt to trusted hosts, optionally only allowing\n" +
+			"               read-only access or access to the given containers.\n" +
+			"lxc config trust remove [remote] [name|fingerprint]\n" +
 			"               Remove the cert from trusted hosts.\n" +
+			"lxc config trust token [remote] <name>\n" +
+			"               Create a single-use token to add a client named <name>.\n" +
 			"\n" +
 			"Examples:\n" +
 			"To mount host's /share/c1 onto /opt in the container:\n" +
@@ -78,7 +91,11 @@ func (c *configCmd) usage() string {
 			"\tlxc config set core.trust_password blah\n")
 }
 
-func (c *configCmd) flags() {}
+func (c *configCmd) flags() {
+	gnuflag.BoolVar(&c.expanded, "expanded", false, gettext.Gettext("Whether to show the expanded configuration"))
+	gnuflag.BoolVar(&c.readOnly, "read-only", false, gettext.Gettext("Only allow the certificate to read from the server"))
+	gnuflag.StringVar(&c.containers, "containers", "", gettext.Gettext("Only allow the certificate to access these containers (comma separated)"))
+}
 
 func doSet(config *lxd.Config, args []string) error {
 	if len(args) != 4 {
@@ -97,7 +114,7 @@ func doSet(config *lxd.Config, args []string) error {
 	return d.SetContainerConfig(container, key, value)
 }
 
-func (c *configCmd) run(config *lxd.Config, args []string) error {
+func (c *configCmd) run(config *lxd.Config, out io.Writer, args []string) error {
 	if len(args) < 1 {
 		return errArgs
 	}
@@ -168,10 +185,14 @@ func (c *configCmd) run(config *lxd.Config, args []string) error {
 			}
 
 			data := [][]string{}
-			for _, cert := range trust {
-				fp := cert.Fingerprint[0:12]
+			for _, info := range trust {
+				fp := info.Fingerprint[0:12]
+
+				certBlock, _ := pem.Decode([]byte(info.Certificate))
+				if certBlock == nil {
+					return fmt.Errorf(gettext.Gettext("Invalid certificate"))
+				}
 
-				certBlock, _ := pem.Decode([]byte(cert.Certificate))
 				cert, err := x509.ParseCertificate(certBlock.Bytes)
 				if err != nil {
 					return err
@@ -180,11 +201,18 @@ func (c *configCmd) run(config *lxd.Config, args []string) error {
 				const layout = "Jan 2, 2006 at 3:04pm (MST)"
 				issue := cert.NotBefore.Format(layout)
 				expiry := cert.NotAfter.Format(layout)
-				data = append(data, []string{fp, cert.Subject.CommonName, issue, expiry})
+				if info.Expired {
+					expiry = fmt.Sprintf(gettext.Gettext("%s (expired)"), expiry)
+				}
+				added := ""
+				if info.AddedAt.Unix() > 0 {
+					added = info.AddedAt.Format(layout)
+				}
+				data = append(data, []string{info.Name, fp, cert.Subject.CommonName, issue, expiry, added, trustAccess(info)})
 			}
 
-			table := tablewriter.NewWriter(os.Stdout)
-			table.SetHeader([]string{"FINGERPRINT", "COMMON NAME", "ISSUE DATE", "EXPIRY DATE"})
+			table := tablewriter.NewWriter(out)
+			table.SetHeader([]string{"NAME", "FINGERPRINT", "COMMON NAME", "ISSUE DATE", "EXPIRY DATE", "ADDED DATE", "ACCESS"})
 
 			for _, v := range data {
 				table.Append(v)
@@ -213,7 +241,16 @@ func (c *configCmd) run(config *lxd.Config, args []string) error {
 				return err
 			}
 
-			name, _ := shared.SplitExt(fname)
+			name, _ := shared.SplitExt(filepath.Base(fname))
+			if c.readOnly || c.containers != "" {
+				var containers []string
+				if c.containers != "" {
+					containers = strings.Split(c.containers, ",")
+				}
+
+				return d.CertificateAddLimited(cert, name, c.readOnly, containers)
+			}
+
 			return d.CertificateAdd(cert, name)
 		case "remove":
 			var remote string
@@ -230,7 +267,34 @@ func (c *configCmd) run(config *lxd.Config, args []string) error {
 				return err
 			}
 
-			return d.CertificateRemove(args[len(args)-1])
+			fingerprint, err := trustLookup(d, args[len(args)-1])
+			if err != nil {
+				return err
+			}
+
+			return d.CertificateRemove(fingerprint)
+		case "token":
+			var remote string
+			if len(args) < 3 {
+				return fmt.Errorf(gettext.Gettext("No name specified."))
+			} else if len(args) == 4 {
+				remote = config.ParseRemote(args[2])
+			} else {
+				remote = config.DefaultRemote
+			}
+
+			d, err := lxd.NewClient(config, remote)
+			if err != nil {
+				return err
+			}
+
+			token, err := d.CertificateTokenCreate(args[len(args)-1], 0)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintln(out, token.Token)
+			return nil
 		default:
 			return fmt.Errorf(gettext.Gettext("Unkonwn config trust command %s"), args[1])
 		}
@@ -264,10 +328,15 @@ func (c *configCmd) run(config *lxd.Config, args []string) error {
 			}
 
 			brief := config.BriefState()
+			if c.expanded {
+				brief.Config = config.ExpandedConfig
+				brief.Devices = config.ExpandedDevices
+			}
+
 			data, err = yaml.Marshal(&brief)
 		}
 
-		fmt.Printf("%s", data)
+		fmt.Fprintf(out, "%s", data)
 
 		return nil
 
@@ -286,7 +355,7 @@ func (c *configCmd) run(config *lxd.Config, args []string) error {
 		if err != nil {
 			return err
 		}
-		fmt.Printf("%s: %s\n", args[2], resp.Config[args[2]])
+		fmt.Fprintf(out, "%s: %s\n", args[2], resp.Config[args[2]])
 		return nil
 
 	case "profile":
@@ -296,13 +365,13 @@ func (c *configCmd) run(config *lxd.Config, args []string) error {
 		}
 		switch args[1] {
 		case "list":
-			return deviceList(config, "container", args)
+			return deviceList(config, out, "container", args)
 		case "add":
-			return deviceAdd(config, "container", args)
+			return deviceAdd(config, out, "container", args)
 		case "remove":
-			return deviceRm(config, "container", args)
+			return deviceRm(config, out, "container", args)
 		case "show":
-			return deviceShow(config, "container", args)
+			return deviceShow(config, out, "container", args)
 		default:
 			return errArgs
 		}
@@ -404,7 +473,7 @@ func doConfigEdit(client *lxd.Client, cont string) error {
 	return err
 }
 
-func deviceAdd(config *lxd.Config, which string, args []string) error {
+func deviceAdd(config *lxd.Config, out io.Writer, which string, args []string) error {
 	if len(args) < 5 {
 		return errArgs
 	}
@@ -433,14 +502,14 @@ func deviceAdd(config *lxd.Config, which string, args []string) error {
 	if err != nil {
 		return err
 	}
-	fmt.Printf(gettext.Gettext("Device %s added to %s\n"), devname, name)
+	fmt.Fprintf(out, gettext.Gettext("Device %s added to %s\n"), devname, name)
 	if which == "profile" {
 		return nil
 	}
 	return client.WaitForSuccess(resp.Operation)
 }
 
-func deviceRm(config *lxd.Config, which string, args []string) error {
+func deviceRm(config *lxd.Config, out io.Writer, which string, args []string) error {
 	if len(args) < 4 {
 		return errArgs
 	}
@@ -461,14 +530,14 @@ func deviceRm(config *lxd.Config, which string, args []string) error {
 	if err != nil {
 		return err
 	}
-	fmt.Printf(gettext.Gettext("Device %s removed from %s\n"), devname, name)
+	fmt.Fprintf(out, gettext.Gettext("Device %s removed from %s\n"), devname, name)
 	if which == "profile" {
 		return nil
 	}
 	return client.WaitForSuccess(resp.Operation)
 }
 
-func deviceList(config *lxd.Config, which string, args []string) error {
+func deviceList(config *lxd.Config, out io.Writer, which string, args []string) error {
 	if len(args) < 3 {
 		return errArgs
 	}
@@ -488,12 +557,12 @@ func deviceList(config *lxd.Config, which string, args []string) error {
 	if err != nil {
 		return err
 	}
-	fmt.Printf("%s\n", strings.Join(resp, "\n"))
+	fmt.Fprintf(out, "%s\n", strings.Join(resp, "\n"))
 
 	return nil
 }
 
-func deviceShow(config *lxd.Config, which string, args []string) error {
+func deviceShow(config *lxd.Config, out io.Writer, which string, args []string) error {
 	if len(args) < 3 {
 		return errArgs
 	}
@@ -523,11 +592,56 @@ func deviceShow(config *lxd.Config, which string, args []string) error {
 	}
 
 	for n, d := range devices {
-		fmt.Printf("%s\n", n)
+		fmt.Fprintf(out, "%s\n", n)
 		for attr, val := range d {
-			fmt.Printf("  %s: %s\n", attr, val)
+			fmt.Fprintf(out, "  %s: %s\n", attr, val)
 		}
 	}
 
 	return nil
 }
+
+// trustAccess describes what a trusted certificate is allowed to do.
+func trustAccess(info shared.CertInfo) string {
+	access := []string{}
+	if info.ReadOnly {
+		access = append(access, gettext.Gettext("read-only"))
+	}
+
+	if info.Restricted {
+		access = append(access, fmt.Sprintf(gettext.Gettext("containers: %s"), strings.Join(info.Containers, ",")))
+	}
+
+	if len(access) == 0 {
+		return gettext.Gettext("full")
+	}
+
+	return strings.Join(access, ", ")
+}
+
+/*
+ * trustLookup resolves a trusted certificate name or fingerprint prefix to
+ * the full fingerprint of a single certificate.
+ */
+func trustLookup(d *lxd.Client, nameOrFingerprint string) (string, error) {
+	trust, err := d.CertificateList()
+	if err != nil {
+		return "", err
+	}
+
+	matches := []string{}
+	for _, cert := range trust {
+		if cert.Name == nameOrFingerprint || strings.HasPrefix(cert.Fingerprint, nameOrFingerprint) {
+			matches = append(matches, cert.Fingerprint)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf(gettext.Gettext("No certificate matching %s"), nameOrFingerprint)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf(gettext.Gettext("More than one certificate matches %s"), nameOrFingerprint)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"path"
 	"strings"
 
@@ -10,10 +11,12 @@ import (
 
 	"github.com/lxc/lxd"
 	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/gnuflag"
 )
 
 type copyCmd struct {
-	httpAddr string
+	httpAddr      string
+	containerOnly bool
 }
 
 func (c *copyCmd) showByDefault() bool {
@@ -24,12 +27,17 @@ func (c *copyCmd) usage() string {
 	return gettext.Gettext(
 		"Copy containers within or in between lxd instances.\n" +
 			"\n" +
-			"lxc copy [remote:]<source container> [remote:]<destination container>\n")
+			"lxc copy [remote:]<source container> [remote:]<destination container> [--container-only]\n" +
+			"\n" +
+			"Snapshots of the source container are copied along with it unless\n" +
+			"--container-only is passed.\n")
 }
 
-func (c *copyCmd) flags() {}
+func (c *copyCmd) flags() {
+	gnuflag.BoolVar(&c.containerOnly, "container-only", false, gettext.Gettext("Copy the container without its snapshots"))
+}
 
-func copyContainer(config *lxd.Config, sourceResource string, destResource string, keepVolatile bool) error {
+func copyContainer(config *lxd.Config, out io.Writer, sourceResource string, destResource string, keepVolatile bool, containerOnly bool) error {
 	sourceRemote, sourceName := config.ParseRemoteAndContainer(sourceResource)
 	destRemote, destName := config.ParseRemoteAndContainer(destResource)
 
@@ -77,12 +85,12 @@ func copyContainer(config *lxd.Config, sourceResource string, destResource strin
 			return fmt.Errorf(gettext.Gettext("can't copy to the same container name"))
 		}
 
-		cp, err := source.LocalCopy(sourceName, destName, status.Config, status.Profiles)
+		cp, err := source.LocalCopy(sourceName, destName, status.Config, status.Profiles, containerOnly)
 		if err != nil {
 			return err
 		}
 
-		return source.WaitForSuccess(cp.Operation)
+		return waitWithProgress(out, source, cp.Operation, "")
 	} else {
 		dest, err := lxd.NewClient(config, destRemote)
 		if err != nil {
@@ -123,7 +131,7 @@ func copyContainer(config *lxd.Config, sourceResource string, destResource strin
 				continue
 			}
 
-			if err = dest.WaitForSuccess(migration.Operation); err != nil {
+			if err = waitWithProgress(out, dest, migration.Operation, ""); err != nil {
 				continue
 			}
 
@@ -134,10 +142,10 @@ func copyContainer(config *lxd.Config, sourceResource string, destResource strin
 	}
 }
 
-func (c *copyCmd) run(config *lxd.Config, args []string) error {
+func (c *copyCmd) run(config *lxd.Config, out io.Writer, args []string) error {
 	if len(args) != 2 {
 		return errArgs
 	}
 
-	return copyContainer(config, args[0], args[1], false)
+	return copyContainer(config, out, args[0], args[1], false, c.containerOnly)
 }
@@ -2,14 +2,18 @@ package main
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/chai2010/gettext-go/gettext"
 
 	"github.com/lxc/lxd"
 	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/gnuflag"
 )
 
-type deleteCmd struct{}
+type deleteCmd struct {
+	force bool
+}
 
 func (c *deleteCmd) showByDefault() bool {
 	return true
@@ -19,13 +23,19 @@ func (c *deleteCmd) usage() string {
 	return gettext.Gettext(
 		"Delete containers or container snapshots.\n" +
 			"\n" +
-			"lxc delete [remote:]<container>[/<snapshot>] [remote:][<container>[/<snapshot>]...]\n" +
+			"lxc delete [remote:]<container>[/<snapshot>] [remote:][<container>[/<snapshot>]...] [--force|-f]\n" +
 			"\n" +
 			"Destroy containers or snapshots with any attached data (configuration,\n" +
-			"snapshots, ...).\n")
+			"snapshots, ...).\n" +
+			"\n" +
+			"Running containers are only deleted when --force is passed, in which\n" +
+			"case they get stopped first.\n")
 }
 
-func (c *deleteCmd) flags() {}
+func (c *deleteCmd) flags() {
+	gnuflag.BoolVar(&c.force, "force", false, gettext.Gettext("Force the removal of running containers."))
+	gnuflag.BoolVar(&c.force, "f", false, gettext.Gettext("Force the removal of running containers."))
+}
 
 func doDelete(d *lxd.Client, name string) error {
 	resp, err := d.Delete(name)
@@ -36,7 +46,7 @@ func doDelete(d *lxd.Client, name string) error {
 	return d.WaitForSuccess(resp.Operation)
 }
 
-func (c *deleteCmd) run(config *lxd.Config, args []string) error {
+func (c *deleteCmd) run(config *lxd.Config, out io.Writer, args []string) error {
 	if len(args) == 0 {
 		return errArgs
 	}
@@ -49,14 +59,23 @@ func (c *deleteCmd) run(config *lxd.Config, args []string) error {
 			return err
 		}
 
-		ct, err := d.ContainerStatus(name)
+		if shared.IsSnapshot(name) {
+			if err := doDelete(d, name); err != nil {
+				return err
+			}
+			continue
+		}
 
+		ct, err := d.ContainerStatus(name)
 		if err != nil {
-			// Could be a snapshot
-			return doDelete(d, name)
+			return err
 		}
 
 		if ct.Status.StatusCode != shared.Stopped {
+			if !c.force {
+				return fmt.Errorf(gettext.Gettext("The container is currently running, stop it first or pass --force."))
+			}
+
 			resp, err := d.Action(name, shared.Stop, -1, true)
 			if err != nil {
 				return err
@@ -71,14 +90,15 @@ func (c *deleteCmd) run(config *lxd.Config, args []string) error {
 				return fmt.Errorf(gettext.Gettext("Stopping container failed!"))
 			}
 
+			// Ephemeral containers go away on their own once stopped
 			if ct.Ephemeral == true {
-				return nil
+				continue
 			}
 		}
+
 		if err := doDelete(d, name); err != nil {
 			return err
 		}
 	}
 	return nil
-
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"syscall"
@@ -13,7 +14,9 @@ import (
 	"github.com/lxc/lxd/shared/gnuflag"
 )
 
-type execCmd struct{}
+type execCmd struct {
+	modeFlag string
+}
 
 func (c *execCmd) showByDefault() bool {
 	return true
@@ -23,7 +26,11 @@ func (c *execCmd) usage() string {
 	return gettext.Gettext(
 		"Execute the specified command in a container.\n" +
 			"\n" +
-			"lxc exec [remote:]container [--env EDITOR=/usr/bin/vim]... <command>\n")
+			"lxc exec [remote:]container [--mode=auto|interactive|non-interactive] [--env EDITOR=/usr/bin/vim]... <command>\n" +
+			"\n" +
+			"Mode defaults to non-interactive, where stdin is streamed to the command\n" +
+			"and its stdout and stderr are kept apart, unless stdin and stdout are both\n" +
+			"terminals.\n")
 }
 
 type envFlag []string
@@ -45,13 +52,26 @@ var envArgs envFlag
 
 func (c *execCmd) flags() {
 	gnuflag.Var(&envArgs, "env", "An environment variable of the form HOME=/home/foo")
+	gnuflag.StringVar(&c.modeFlag, "mode", "auto", gettext.Gettext("Override the terminal mode (auto, interactive or non-interactive)"))
 }
 
-func (c *execCmd) run(config *lxd.Config, args []string) error {
+func (c *execCmd) run(config *lxd.Config, out io.Writer, args []string) error {
 	if len(args) < 2 {
 		return errArgs
 	}
 
+	var interactive bool
+	switch c.modeFlag {
+	case "auto":
+		interactive = terminal.IsTerminal(syscall.Stdin) && terminal.IsTerminal(syscall.Stdout)
+	case "interactive":
+		interactive = true
+	case "non-interactive":
+		interactive = false
+	default:
+		return fmt.Errorf(gettext.Gettext("Unknown exec mode %s"), c.modeFlag)
+	}
+
 	remote, name := config.ParseRemoteAndContainer(args[0])
 	d, err := lxd.NewClient(config, remote)
 	if err != nil {
@@ -75,9 +95,10 @@ func (c *execCmd) run(config *lxd.Config, args []string) error {
 		env[pieces[0]] = value
 	}
 
+	// The output of a non-interactive command isn't for a raw terminal
 	cfd := syscall.Stdout
 	var oldttystate *terminal.State
-	if terminal.IsTerminal(cfd) {
+	if interactive && terminal.IsTerminal(cfd) {
 		oldttystate, err = terminal.MakeRaw(cfd)
 		if err != nil {
 			return err
@@ -85,7 +106,7 @@ func (c *execCmd) run(config *lxd.Config, args []string) error {
 		defer terminal.Restore(cfd, oldttystate)
 	}
 
-	ret, err := d.Exec(name, args[1:], env, os.Stdin, os.Stdout, os.Stderr)
+	ret, err := d.Exec(name, args[1:], env, interactive, os.Stdin, os.Stdout, os.Stderr)
 	if err != nil {
 		return err
 	}
@@ -101,7 +122,6 @@ func (c *execCmd) run(config *lxd.Config, args []string) error {
 		terminal.Restore(cfd, oldttystate)
 	}
 
-	/* we get the result of waitpid() here so we need to transform it */
-	os.Exit(ret >> 8)
+	os.Exit(ret)
 	return fmt.Errorf(gettext.Gettext("unreachable return reached"))
 }
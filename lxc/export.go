@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/chai2010/gettext-go/gettext"
+
+	"github.com/lxc/lxd"
+	"github.com/lxc/lxd/shared"
+	"github.com/lxc/lxd/shared/gnuflag"
+)
+
+type exportCmd struct {
+	gzip bool
+}
+
+func (c *exportCmd) showByDefault() bool {
+	return false
+}
+
+func (c *exportCmd) usage() string {
+	return gettext.Gettext(
+		"Export a container or a snapshot as a tarball, without publishing an image.\n" +
+			"\n" +
+			"lxc export [remote:]container[/snapshot] [target] [--gzip]\n" +
+			"\n" +
+			"The tarball is written in the current directory unless a target\n" +
+			"directory or file is given, \"-\" writing it to stdout. A running\n" +
+			"container can only be exported through one of its snapshots.\n")
+}
+
+func (c *exportCmd) flags() {
+	gnuflag.BoolVar(&c.gzip, "gzip", false, gettext.Gettext("Compress the tarball with gzip"))
+}
+
+func (c *exportCmd) run(config *lxd.Config, out io.Writer, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errArgs
+	}
+
+	remote, name := config.ParseRemoteAndContainer(args[0])
+	if name == "" {
+		return errArgs
+	}
+
+	snapshot := ""
+	if fields := strings.SplitN(name, shared.SnapshotDelimiter, 2); len(fields) == 2 {
+		name = fields[0]
+		snapshot = fields[1]
+	}
+
+	target := "."
+	if len(args) > 1 {
+		target = args[1]
+	}
+
+	d, err := lxd.NewClient(config, remote)
+	if err != nil {
+		return err
+	}
+
+	outfile, err := d.ExportContainer(name, snapshot, c.gzip, target)
+	if err != nil {
+		return err
+	}
+
+	if target != "-" {
+		fmt.Fprintf(out, gettext.Gettext("Output is in %s")+"\n", outfile)
+	}
+
+	return nil
+}
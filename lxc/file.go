@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 
 	"github.com/chai2010/gettext-go/gettext"
@@ -19,10 +20,15 @@ import (
 	"github.com/lxc/lxd/shared/gnuflag"
 )
 
+// filePushParallelism is the number of files uploaded at the same time
+// when pushing several files to a container.
+const filePushParallelism = 4
+
 type fileCmd struct {
-	uid  int
-	gid  int
-	mode string
+	uid      int
+	gid      int
+	mode     string
+	preserve bool
 }
 
 func (c *fileCmd) showByDefault() bool {
@@ -33,18 +39,22 @@ func (c *fileCmd) usage() string {
 	return gettext.Gettext(
 		"Manage files on a container.\n" +
 			"\n" +
-			"lxc file pull <source> [<source>...] <target>\n" +
+			"lxc file pull [--preserve] <source> [<source>...] <target>\n" +
 			"lxc file push [--uid=UID] [--gid=GID] [--mode=MODE] <source> [<source>...] <target>\n" +
 			"lxc file edit <file>\n" +
 			"\n" +
 			"<source> in the case of pull, <target> in the case of push and <file> in the case of edit are <container name>/<path>\n" +
+			"When pushing, the mode of the local file is kept unless --mode is passed.\n" +
+			"When pulling with --preserve, the mode (and ownership if run as root) of the remote file is kept.\n" +
 			"This operation is only supported on containers that are currently running\n")
 }
 
 func (c *fileCmd) flags() {
 	gnuflag.IntVar(&c.uid, "uid", -1, gettext.Gettext("Set the file's uid on push"))
 	gnuflag.IntVar(&c.gid, "gid", -1, gettext.Gettext("Set the file's gid on push"))
-	gnuflag.StringVar(&c.mode, "mode", "0644", gettext.Gettext("Set the file's perms on push"))
+	gnuflag.StringVar(&c.mode, "mode", "", gettext.Gettext("Set the file's perms on push"))
+	gnuflag.BoolVar(&c.preserve, "preserve", false, gettext.Gettext("Preserve the file's perms and ownership on pull"))
+	gnuflag.BoolVar(&c.preserve, "p", false, gettext.Gettext("Preserve the file's perms and ownership on pull"))
 }
 
 func (c *fileCmd) push(config *lxd.Config, args []string) error {
@@ -67,7 +77,7 @@ func (c *fileCmd) push(config *lxd.Config, args []string) error {
 		return err
 	}
 
-	mode := os.FileMode(0755)
+	var mode os.FileMode
 	if c.mode != "" {
 		m, err := strconv.ParseInt(c.mode, 0, 0)
 		if err != nil {
@@ -107,21 +117,54 @@ func (c *fileCmd) push(config *lxd.Config, args []string) error {
 		if err != nil {
 			return err
 		}
+		defer file.Close()
 		files = append(files, file)
 	}
 
+	/* The uploads share the client's connection pool, so pushing many
+	 * small files isn't serialized one request at a time. */
+	var wg sync.WaitGroup
+	var errLock sync.Mutex
+	var pushErr error
+	slots := make(chan bool, filePushParallelism)
+
 	for _, f := range files {
 		fpath := targetPath
 		if targetfilename == "" {
 			fpath = path.Join(fpath, path.Base(f.Name()))
 		}
-		err := d.PushFile(container, fpath, gid, uid, mode, f)
-		if err != nil {
-			return err
+
+		fmode := mode
+		if c.mode == "" {
+			fi, err := f.Stat()
+			if err != nil {
+				return err
+			}
+			fmode = fi.Mode().Perm()
 		}
+
+		slots <- true
+		wg.Add(1)
+		go func(f *os.File, fpath string, fmode os.FileMode) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			err := d.PushFile(container, fpath, gid, uid, fmode, f)
+			if err != nil {
+				errLock.Lock()
+				if pushErr == nil {
+					pushErr = fmt.Errorf(gettext.Gettext("Failed to push %s: %v"), f.Name(), err)
+				}
+				errLock.Unlock()
+			}
+		}(f, fpath, fmode)
 	}
 
-	return nil
+	wg.Wait()
+
+	return pushErr
 }
 
 func (c *fileCmd) pull(config *lxd.Config, args []string) error {
@@ -170,7 +213,7 @@ func (c *fileCmd) pull(config *lxd.Config, args []string) error {
 			return err
 		}
 
-		_, _, _, buf, err := d.PullFile(container, pathSpec[1])
+		uid, gid, mode, buf, err := d.PullFile(container, pathSpec[1])
 		if err != nil {
 			return err
 		}
@@ -192,6 +235,21 @@ func (c *fileCmd) pull(config *lxd.Config, args []string) error {
 		if err != nil {
 			return err
 		}
+
+		if c.preserve {
+			err = f.Chmod(mode)
+			if err != nil {
+				return err
+			}
+
+			// Only root is allowed to hand files over to someone else
+			if os.Geteuid() == 0 {
+				err = f.Chown(uid, gid)
+				if err != nil {
+					return err
+				}
+			}
+		}
 	}
 
 	return nil
@@ -245,7 +303,7 @@ func (c *fileCmd) edit(config *lxd.Config, args []string) error {
 	return err
 }
 
-func (c *fileCmd) run(config *lxd.Config, args []string) error {
+func (c *fileCmd) run(config *lxd.Config, out io.Writer, args []string) error {
 	if len(args) < 1 {
 		return errArgs
 	}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/chai2010/gettext-go/gettext"
+	"io"
 
 	"github.com/lxc/lxd"
 )
@@ -23,7 +24,7 @@ func (c *fingerCmd) usage() string {
 
 func (c *fingerCmd) flags() {}
 
-func (c *fingerCmd) run(config *lxd.Config, args []string) error {
+func (c *fingerCmd) run(config *lxd.Config, out io.Writer, args []string) error {
 	if len(args) > 1 {
 		return errArgs
 	}
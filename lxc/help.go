@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"sort"
 	"strings"
@@ -33,7 +34,7 @@ func (c *helpCmd) flags() {
 	gnuflag.BoolVar(&showAll, "all", false, gettext.Gettext("Show all commands (not just interesting ones)"))
 }
 
-func (c *helpCmd) run(_ *lxd.Config, args []string) error {
+func (c *helpCmd) run(_ *lxd.Config, out io.Writer, args []string) error {
 	if len(args) > 0 {
 		for _, name := range args {
 			cmd, ok := commands[name]
@@ -46,7 +47,7 @@ func (c *helpCmd) run(_ *lxd.Config, args []string) error {
 		return nil
 	}
 
-	fmt.Println(gettext.Gettext("Usage: lxc [subcommand] [options]\nAvailable commands:\n"))
+	fmt.Fprintln(out, gettext.Gettext("Usage: lxc [subcommand] [options]\nAvailable commands:\n"))
 	var names []string
 	for name := range commands {
 		names = append(names, name)
@@ -55,16 +56,16 @@ func (c *helpCmd) run(_ *lxd.Config, args []string) error {
 	for _, name := range names {
 		cmd := commands[name]
 		if showAll || cmd.showByDefault() {
-			fmt.Printf("\t%-10s - %s\n", name, summaryLine(cmd.usage()))
+			fmt.Fprintf(out, "\t%-10s - %s\n", name, summaryLine(cmd.usage()))
 		}
 	}
-	fmt.Println()
+	fmt.Fprintln(out)
 	if !showAll {
-		fmt.Println(gettext.Gettext("Options:"))
-		fmt.Println("  --all              " + gettext.Gettext("Print less common commands."))
-		fmt.Println("  --config <config>  " + gettext.Gettext("Use an alternative config path."))
-		fmt.Println("  --debug            " + gettext.Gettext("Print debug information."))
-		fmt.Println("  --verbose          " + gettext.Gettext("Print verbose information."))
+		fmt.Fprintln(out, gettext.Gettext("Options:"))
+		fmt.Fprintln(out, "  --all              "+gettext.Gettext("Print less common commands."))
+		fmt.Fprintln(out, "  --config <config>  "+gettext.Gettext("Use an alternative config path."))
+		fmt.Fprintln(out, "  --debug            "+gettext.Gettext("Print debug information."))
+		fmt.Fprintln(out, "  --verbose          "+gettext.Gettext("Print verbose information."))
 	}
 	return nil
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -38,12 +39,16 @@ func (c *imageCmd) usage() string {
 	return gettext.Gettext(
 		"Manipulate container images\n" +
 			"\n" +
-			"lxc image import <tarball> [rootfs tarball] [target] [--public] [--created-at=ISO-8601] [--expires-at=ISO-8601] [--fingerprint=FINGERPRINT] [prop=value]\n" +
+			"lxc image import <tarball> [rootfs tarball] [target] [--public] [--created-at=ISO-8601] [--expires-at=ISO-8601] [--fingerprint=FINGERPRINT] [--alias=ALIAS].. [prop=value]\n" +
+			"    Passing both a metadata and a rootfs tarball imports a split image.\n" +
 			"\n" +
 			"lxc image copy [remote:]<image> <remote>: [--alias=ALIAS].. [--copy-alias] [--public]\n" +
 			"lxc image delete [remote:]<image>\n" +
 			"lxc image edit [remote:]<image>\n" +
-			"lxc image export [remote:]<image>\n" +
+			"lxc image export [remote:]<image> [target]\n" +
+			"    The output target is optional and defaults to the working directory.\n" +
+			"    Split images are written as meta-<fingerprint> and <fingerprint> in the\n" +
+			"    target directory, or as a tarball of both when the target is \"-\" (stdout).\n" +
 			"lxc image info [remote:]<image>\n" +
 			"lxc image list [remote:] [filter]\n" +
 			"lxc image show [remote:]<image>\n" +
@@ -86,7 +91,7 @@ func (c *imageCmd) flags() {
 	gnuflag.Var(&addAliases, "alias", "New alias to define at target")
 }
 
-func doImageAlias(config *lxd.Config, args []string) error {
+func doImageAlias(config *lxd.Config, out io.Writer, args []string) error {
 	var remote string
 	switch args[1] {
 	case "list":
@@ -106,7 +111,7 @@ func doImageAlias(config *lxd.Config, args []string) error {
 			return err
 		}
 
-		showAliases(resp)
+		showAliases(out, resp)
 
 		return nil
 	case "create":
@@ -139,7 +144,7 @@ func doImageAlias(config *lxd.Config, args []string) error {
 	return errArgs
 }
 
-func (c *imageCmd) run(config *lxd.Config, args []string) error {
+func (c *imageCmd) run(config *lxd.Config, out io.Writer, args []string) error {
 	var remote string
 
 	if len(args) < 1 {
@@ -151,7 +156,7 @@ func (c *imageCmd) run(config *lxd.Config, args []string) error {
 		if len(args) < 2 {
 			return errArgs
 		}
-		return doImageAlias(config, args)
+		return doImageAlias(config, out, args)
 
 	case "copy":
 		/* copy [<remote>:]<image> [<rmeote>:]<image> */
@@ -212,33 +217,33 @@ func (c *imageCmd) run(config *lxd.Config, args []string) error {
 		if err != nil {
 			return err
 		}
-		fmt.Printf(gettext.Gettext("Fingerprint: %s\n"), info.Fingerprint)
+		fmt.Fprintf(out, gettext.Gettext("Fingerprint: %s\n"), info.Fingerprint)
 		public := "no"
 		if info.Public == 1 {
 			public = "yes"
 		}
-		fmt.Printf(gettext.Gettext("Size: %.2vMB\n"), float64(info.Size)/1024.0/1024.0)
+		fmt.Fprintf(out, gettext.Gettext("Size: %.2vMB\n"), float64(info.Size)/1024.0/1024.0)
 		arch, _ := shared.ArchitectureName(info.Architecture)
-		fmt.Printf(gettext.Gettext("Architecture: %s\n"), arch)
-		fmt.Printf(gettext.Gettext("Public: %s\n"), public)
-		fmt.Printf(gettext.Gettext("Timestamps:\n"))
+		fmt.Fprintf(out, gettext.Gettext("Architecture: %s\n"), arch)
+		fmt.Fprintf(out, gettext.Gettext("Public: %s\n"), public)
+		fmt.Fprintf(out, gettext.Gettext("Timestamps:\n"))
 		const layout = "2006/01/02 15:04 UTC"
 		if info.CreationDate != 0 {
-			fmt.Printf("    Created: %s\n", time.Unix(info.CreationDate, 0).UTC().Format(layout))
+			fmt.Fprintf(out, "    Created: %s\n", time.Unix(info.CreationDate, 0).UTC().Format(layout))
 		}
-		fmt.Printf("    Uploaded: %s\n", time.Unix(info.UploadDate, 0).UTC().Format(layout))
+		fmt.Fprintf(out, "    Uploaded: %s\n", time.Unix(info.UploadDate, 0).UTC().Format(layout))
 		if info.ExpiryDate != 0 {
-			fmt.Printf("    Expires: %s\n", time.Unix(info.ExpiryDate, 0).UTC().Format(layout))
+			fmt.Fprintf(out, "    Expires: %s\n", time.Unix(info.ExpiryDate, 0).UTC().Format(layout))
 		} else {
-			fmt.Printf("    Expires: never\n")
+			fmt.Fprintf(out, "    Expires: never\n")
 		}
-		fmt.Printf(gettext.Gettext("Properties:\n"))
+		fmt.Fprintf(out, gettext.Gettext("Properties:\n"))
 		for key, value := range info.Properties {
-			fmt.Printf("    %s: %s\n", key, value)
+			fmt.Fprintf(out, "    %s: %s\n", key, value)
 		}
-		fmt.Printf(gettext.Gettext("Aliases:\n"))
+		fmt.Fprintf(out, gettext.Gettext("Aliases:\n"))
 		for _, alias := range info.Aliases {
-			fmt.Printf("    - %s\n", alias.Name)
+			fmt.Fprintf(out, "    - %s\n", alias.Name)
 		}
 		return nil
 
@@ -259,9 +264,11 @@ func (c *imageCmd) run(config *lxd.Config, args []string) error {
 					remote = config.ParseRemote(arg)
 				} else {
 					if imageFile == "" {
-						imageFile = args[1]
-					} else {
+						imageFile = arg
+					} else if rootfsFile == "" {
 						rootfsFile = arg
+					} else {
+						return errArgs
 					}
 				}
 			} else {
@@ -283,7 +290,7 @@ func (c *imageCmd) run(config *lxd.Config, args []string) error {
 			return err
 		}
 
-		fmt.Printf(gettext.Gettext("Image imported with fingerprint: %s\n"), fingerprint)
+		fmt.Fprintf(out, gettext.Gettext("Image imported with fingerprint: %s\n"), fingerprint)
 
 		return nil
 
@@ -304,7 +311,7 @@ func (c *imageCmd) run(config *lxd.Config, args []string) error {
 			return err
 		}
 
-		return showImages(images)
+		return showImages(out, images)
 
 	case "edit":
 		if len(args) < 2 {
@@ -425,7 +432,7 @@ func (c *imageCmd) run(config *lxd.Config, args []string) error {
 		}
 
 		if target != "-" {
-			fmt.Printf("Output is in %s\n", outfile)
+			fmt.Fprintf(out, "Output is in %s\n", outfile)
 		}
 		return nil
 
@@ -451,7 +458,7 @@ func (c *imageCmd) run(config *lxd.Config, args []string) error {
 		properties := info.Properties
 
 		data, err := yaml.Marshal(&properties)
-		fmt.Printf("%s", data)
+		fmt.Fprintf(out, "%s", data)
 		return err
 
 	default:
@@ -491,7 +498,7 @@ func findDescription(props map[string]string) string {
 	return ""
 }
 
-func showImages(images []shared.ImageInfo) error {
+func showImages(out io.Writer, images []shared.ImageInfo) error {
 	data := [][]string{}
 	for _, image := range images {
 		shortest := shortestAlias(image.Aliases)
@@ -510,7 +517,7 @@ func showImages(images []shared.ImageInfo) error {
 		data = append(data, []string{shortest, fp, public, description, arch, uploaded})
 	}
 
-	table := tablewriter.NewWriter(os.Stdout)
+	table := tablewriter.NewWriter(out)
 	table.SetColWidth(50)
 	table.SetHeader([]string{"ALIAS", "FINGERPRINT", "PUBLIC", "DESCRIPTION", "ARCH", "UPLOAD DATE"})
 	sort.Sort(ByName(data))
@@ -520,14 +527,31 @@ func showImages(images []shared.ImageInfo) error {
 	return nil
 }
 
-func showAliases(aliases []shared.ImageAlias) error {
+func showAliases(out io.Writer, aliases []shared.ImageAliasInfo) error {
 	data := [][]string{}
 	for _, alias := range aliases {
-		data = append(data, []string{alias.Description, alias.Name[0:12]})
+		fingerprint := alias.Target
+		if len(fingerprint) > 12 {
+			fingerprint = fingerprint[0:12]
+		}
+
+		autoUpdate := "no"
+		if alias.AutoUpdate {
+			autoUpdate = "yes"
+		}
+
+		// Servers without image_alias_info only send the name, as the
+		// description
+		name := alias.Name
+		if name == "" {
+			name = alias.Description
+		}
+
+		data = append(data, []string{name, fingerprint, alias.Description, autoUpdate})
 	}
 
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"ALIAS", "FINGERPRINT"})
+	table := tablewriter.NewWriter(out)
+	table.SetHeader([]string{"ALIAS", "FINGERPRINT", "DESCRIPTION", "AUTO UPDATE"})
 
 	for _, v := range data {
 		table.Append(v)
@@ -2,11 +2,15 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
+	"strings"
+	"time"
 
 	"github.com/chai2010/gettext-go/gettext"
 
 	"github.com/lxc/lxd"
+	"github.com/lxc/lxd/shared"
 	"github.com/lxc/lxd/shared/gnuflag"
 )
 
@@ -24,14 +28,17 @@ func (c *infoCmd) usage() string {
 			"\n" +
 			"This will support remotes and images as well, but only containers for now.\n" +
 			"\n" +
-			"lxc info [<remote>:]container [--show-log]\n")
+			"lxc info [<remote>:]container [--show-log]\n" +
+			"\n" +
+			"Shows the state, addresses, resource usage, snapshots and pending\n" +
+			"operations of the container.\n")
 }
 
 func (c *infoCmd) flags() {
 	gnuflag.BoolVar(&c.showLog, "show-log", false, gettext.Gettext("Show the container's last 100 log lines?"))
 }
 
-func (c *infoCmd) run(config *lxd.Config, args []string) error {
+func (c *infoCmd) run(config *lxd.Config, out io.Writer, args []string) error {
 	var remote string
 	var cName string
 	if len(args) == 1 {
@@ -41,7 +48,7 @@ func (c *infoCmd) run(config *lxd.Config, args []string) error {
 		cName = ""
 	}
 	if cName == "" {
-		fmt.Printf(gettext.Gettext("Information about remotes not yet supported\n"))
+		fmt.Fprintf(out, gettext.Gettext("Information about remotes not yet supported\n"))
 		return errArgs
 	}
 
@@ -54,38 +61,95 @@ func (c *infoCmd) run(config *lxd.Config, args []string) error {
 		return err
 	}
 
-	fmt.Printf("Name: %s\n", ct.Name)
-	fmt.Printf("Status: %s\n", ct.Status.Status)
+	const layout = "2006/01/02 15:04 UTC"
+	cUrl := fmt.Sprintf("/%s/containers/%s", shared.APIVersion, cName)
+
+	fmt.Fprintf(out, gettext.Gettext("Name: %s\n"), ct.Name)
+	if ct.CreatedAt.Unix() != 0 {
+		fmt.Fprintf(out, gettext.Gettext("Created: %s\n"), ct.CreatedAt.UTC().Format(layout))
+	}
+	fmt.Fprintf(out, gettext.Gettext("Status: %s\n"), ct.Status.Status)
+	if ct.Ephemeral {
+		fmt.Fprintf(out, gettext.Gettext("Type: ephemeral\n"))
+	} else {
+		fmt.Fprintf(out, gettext.Gettext("Type: persistent\n"))
+	}
+	fmt.Fprintf(out, gettext.Gettext("Profiles: %s\n"), strings.Join(ct.Profiles, ", "))
+
 	if ct.Status.Init != 0 {
-		fmt.Printf("Init: %d\n", ct.Status.Init)
-		fmt.Printf("Ips:\n")
-		foundone := false
+		fmt.Fprintf(out, gettext.Gettext("Pid: %d\n"), ct.Status.Init)
+
+		// Group the addresses per interface
+		ifaces := []string{}
+		ips := map[string][]string{}
 		for _, ip := range ct.Status.Ips {
-			vethStr := ""
+			if _, ok := ips[ip.Interface]; !ok {
+				ifaces = append(ifaces, ip.Interface)
+			}
+
+			entry := fmt.Sprintf("%s\t%s", ip.Protocol, ip.Address)
 			if ip.HostVeth != "" {
-				vethStr = fmt.Sprintf("\t%s", ip.HostVeth)
+				entry = fmt.Sprintf("%s\t%s", entry, ip.HostVeth)
+			}
+			ips[ip.Interface] = append(ips[ip.Interface], entry)
+		}
+
+		fmt.Fprintf(out, gettext.Gettext("Ips:\n"))
+		if len(ifaces) == 0 {
+			fmt.Fprintf(out, "  (none)\n")
+		}
+		for _, iface := range ifaces {
+			fmt.Fprintf(out, "  %s:\n", iface)
+			for _, entry := range ips[iface] {
+				fmt.Fprintf(out, "    %s\n", entry)
 			}
+		}
 
-			fmt.Printf("  %s:\t%s\t%s%s\n", ip.Interface, ip.Protocol, ip.Address, vethStr)
-			foundone = true
+		fmt.Fprintf(out, gettext.Gettext("Resources:\n"))
+		if ct.Status.MemoryUsage >= 0 {
+			fmt.Fprintf(out, gettext.Gettext("  Memory usage: %.2fMB\n"), float64(ct.Status.MemoryUsage)/1024/1024)
 		}
-		if !foundone {
-			fmt.Printf("(none)\n")
+		if ct.Status.CPUUsage >= 0 {
+			fmt.Fprintf(out, gettext.Gettext("  CPU usage: %s\n"), time.Duration(ct.Status.CPUUsage))
 		}
 	}
 
 	// List snapshots
-	first_snapshot := true
-	snaps, err := d.ListSnapshots(cName)
+	snaps, err := d.ListSnapshotsInfo(cName)
 	if err != nil {
-		return nil
+		return err
+	}
+
+	if len(snaps) > 0 {
+		fmt.Fprintf(out, gettext.Gettext("Snapshots:\n"))
 	}
 	for _, snap := range snaps {
-		if first_snapshot {
-			fmt.Printf("Snapshots:\n")
+		fields := []string{snap.Name}
+		if snap.CreatedAt.Unix() != 0 {
+			fields = append(fields, fmt.Sprintf(gettext.Gettext("(taken at %s)"), snap.CreatedAt.UTC().Format(layout)))
+		}
+		if snap.Stateful {
+			fields = append(fields, gettext.Gettext("(stateful)"))
+		}
+		if snap.Size > 0 {
+			fields = append(fields, fmt.Sprintf(gettext.Gettext("(%s)"), shared.GetByteSizeString(snap.Size)))
+		}
+		fmt.Fprintf(out, "  %s\n", strings.Join(fields, " "))
+	}
+
+	// List the operations affecting this container
+	ops, err := d.ListOperations(cUrl)
+	if err != nil {
+		return err
+	}
+
+	first_operation := true
+	for url, op := range ops {
+		if first_operation {
+			fmt.Fprintf(out, gettext.Gettext("Operations:\n"))
+			first_operation = false
 		}
-		fmt.Printf("  %s\n", snap)
-		first_snapshot = false
+		fmt.Fprintf(out, "  %s: %s (%s)\n", url, op.Status, op.CreatedAt.UTC().Format(layout))
 	}
 
 	if c.showLog {
@@ -99,7 +163,7 @@ func (c *infoCmd) run(config *lxd.Config, args []string) error {
 			return err
 		}
 
-		fmt.Printf("\nLog:\n\n%s\n", string(stuff))
+		fmt.Fprintf(out, "\nLog:\n\n%s\n", string(stuff))
 	}
 
 	return nil
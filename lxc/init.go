@@ -2,8 +2,10 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path"
+	"strings"
 
 	"github.com/chai2010/gettext-go/gettext"
 
@@ -14,22 +16,27 @@ import (
 type initCmd struct{}
 
 func (c *initCmd) showByDefault() bool {
-	return false
+	return true
 }
 
 func (c *initCmd) usage() string {
 	return gettext.Gettext(
 		"Initialize a container from a particular image.\n" +
 			"\n" +
-			"lxc init [remote:]<image> [remote:][<name>] [--ephemeral|-e] [--profile|-p <profile>...]\n" +
+			"lxc init [remote:]<image> [remote:][<name>] [--ephemeral|-e] [--profile|-p <profile>...] [-c <key=value>...] [--target <member>]\n" +
 			"\n" +
-			"Initializes a container using the specified image and name.\n" +
+			"Initializes a container using the specified image and name, without\n" +
+			"starting it. This allows for devices and configuration to be set\n" +
+			"before the container's first boot.\n" +
 			"\n" +
 			"Not specifying -p will result in the default profile.\n" +
 			"Specifying \"-p\" with no argument will result in no profile.\n" +
 			"\n" +
+			"--target creates the container on that member of the server's cluster.\n" +
+			"\n" +
 			"Example:\n" +
-			"lxc init ubuntu u1\n")
+			"lxc init ubuntu u1\n" +
+			"lxc init ubuntu u1 -c limits.memory=2GB -c boot.autostart=true\n")
 }
 
 type profileList []string
@@ -51,9 +58,38 @@ func (f *profileList) Set(value string) error {
 	return nil
 }
 
+type configList []string
+
+func (f *configList) String() string {
+	return fmt.Sprint(*f)
+}
+
+func (f *configList) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return fmt.Errorf(gettext.Gettext("Bad key=value pair: %s"), value)
+	}
+
+	*f = append(*f, value)
+	return nil
+}
+
+// configMap turns the key=value pairs passed with -c into a config map,
+// later values for the same key overriding earlier ones.
+func (f configList) configMap() map[string]string {
+	config := map[string]string{}
+	for _, entry := range f {
+		fields := strings.SplitN(entry, "=", 2)
+		config[fields[0]] = fields[1]
+	}
+
+	return config
+}
+
 var profArgs profileList
+var confArgs configList
 var requested_empty_profiles bool = false
 var ephem bool = false
+var target string
 
 func is_ephem(s string) bool {
 	switch s {
@@ -101,13 +137,22 @@ func (c *initCmd) flags() {
 	massage_args()
 	gnuflag.Var(&profArgs, "profile", "Profile to apply to the new container")
 	gnuflag.Var(&profArgs, "p", "Profile to apply to the new container")
+	gnuflag.Var(&confArgs, "c", "Config key/value to apply to the new container")
 	gnuflag.BoolVar(&ephem, "ephemeral", false, gettext.Gettext("Ephemeral container"))
 	gnuflag.BoolVar(&ephem, "e", false, gettext.Gettext("Ephemeral container"))
+	gnuflag.StringVar(&target, "target", "", gettext.Gettext("Cluster member to create the container on"))
+}
+
+func (c *initCmd) run(config *lxd.Config, out io.Writer, args []string) error {
+	_, _, err := c.create(config, out, args)
+	return err
 }
 
-func (c *initCmd) run(config *lxd.Config, args []string) error {
+// create creates (but doesn't start) the container described by args and
+// returns the client it was created through along with its name.
+func (c *initCmd) create(config *lxd.Config, out io.Writer, args []string) (*lxd.Client, string, error) {
 	if len(args) > 2 || len(args) < 1 {
-		return errArgs
+		return nil, "", errArgs
 	}
 
 	iremote, image := config.ParseRemoteAndContainer(args[0])
@@ -123,8 +168,9 @@ func (c *initCmd) run(config *lxd.Config, args []string) error {
 
 	d, err := lxd.NewClient(config, remote)
 	if err != nil {
-		return err
+		return nil, "", err
 	}
+	d.Target = target
 
 	// TODO: implement the syntax for supporting other image types/remotes
 
@@ -138,34 +184,38 @@ func (c *initCmd) run(config *lxd.Config, args []string) error {
 	}
 
 	var resp *lxd.Response
-	if name == "" {
-		fmt.Printf("Creating ")
-	} else {
-		fmt.Printf("Creating %s ", name)
+	prefix := "Creating "
+	if name != "" {
+		prefix = fmt.Sprintf("Creating %s ", name)
 	}
+	fmt.Fprint(out, prefix)
 	if !requested_empty_profiles && len(profiles) == 0 {
-		resp, err = d.Init(name, iremote, image, nil, ephem)
+		resp, err = d.Init(name, iremote, image, nil, confArgs.configMap(), ephem)
 	} else {
-		resp, err = d.Init(name, iremote, image, &profiles, ephem)
+		resp, err = d.Init(name, iremote, image, &profiles, confArgs.configMap(), ephem)
 	}
 
 	if err != nil {
-		return err
+		return nil, "", err
 	}
 
-	err = d.WaitForSuccess(resp.Operation)
+	err = waitWithProgress(out, d, resp.Operation, prefix)
 	if err != nil {
-		fmt.Println("error.")
-		return err
+		fmt.Fprintln(out, "error.")
+		return nil, "", err
+	}
+
+	containers := resp.Resources["containers"]
+	if len(containers) == 1 && name == "" {
+		name = path.Base(containers[0])
+		fmt.Fprintln(out, name, "done.")
 	} else {
-		containers := resp.Resources["containers"]
+		fmt.Fprintln(out, "done.")
+	}
 
-		if len(containers) == 1 && name == "" {
-			cname := path.Base(containers[0])
-			fmt.Println(cname, "done.")
-		} else {
-			fmt.Println("done.")
-		}
+	if name == "" {
+		return nil, "", fmt.Errorf(gettext.Gettext("didn't get any affected image, container or snapshot from server"))
 	}
-	return nil
+
+	return d, name, nil
 }
@@ -2,7 +2,7 @@ package main
 
 import (
 	"fmt"
-	"os"
+	"io"
 	"sort"
 	"strings"
 
@@ -107,7 +107,7 @@ func shouldShow(filters []string, state *shared.ContainerState) bool {
 	return true
 }
 
-func listContainers(cinfos []shared.ContainerInfo, filters []string, listsnaps bool) error {
+func listContainers(out io.Writer, cinfos []shared.ContainerInfo, filters []string, listsnaps bool) error {
 	data := [][]string{}
 
 	for _, cinfo := range cinfos {
@@ -152,7 +152,7 @@ func listContainers(cinfos []shared.ContainerInfo, filters []string, listsnaps b
 		data = append(data, d)
 	}
 
-	table := tablewriter.NewWriter(os.Stdout)
+	table := tablewriter.NewWriter(out)
 	table.SetHeader([]string{"NAME", "STATE", "IPV4", "IPV6", "EPHEMERAL", "SNAPSHOTS"})
 	sort.Sort(ByName(data))
 	table.AppendBulk(data)
@@ -163,16 +163,16 @@ func listContainers(cinfos []shared.ContainerInfo, filters []string, listsnaps b
 		first_snapshot := true
 		for _, snap := range csnaps {
 			if first_snapshot {
-				fmt.Printf("Snapshots:\n")
+				fmt.Fprintf(out, "Snapshots:\n")
 			}
-			fmt.Printf("  %s\n", snap)
+			fmt.Fprintf(out, "  %s\n", snap)
 			first_snapshot = false
 		}
 	}
 	return nil
 }
 
-func (c *listCmd) run(config *lxd.Config, args []string) error {
+func (c *listCmd) run(config *lxd.Config, out io.Writer, args []string) error {
 	var remote string
 	name := ""
 
@@ -212,5 +212,5 @@ func (c *listCmd) run(config *lxd.Config, args []string) error {
 		}
 	}
 
-	return listContainers(cts, filters, len(cts) == 1)
+	return listContainers(out, cts, filters, len(cts) == 1)
 }
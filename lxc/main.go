@@ -1,7 +1,10 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/url"
 	"os"
@@ -53,6 +56,7 @@ func run() error {
 	verbose := gnuflag.Bool("verbose", false, gettext.Gettext("Enables verbose mode."))
 	debug := gnuflag.Bool("debug", false, gettext.Gettext("Enables debug mode."))
 	forceLocal := gnuflag.Bool("force-local", false, gettext.Gettext("Enables debug mode."))
+	format := gnuflag.String("format", "table", gettext.Gettext("Format of the output (table or json)."))
 
 	gnuflag.StringVar(&lxd.ConfigDir, "config", lxd.ConfigDir, gettext.Gettext("Alternate config directory."))
 
@@ -70,19 +74,27 @@ func run() error {
 		os.Args = append(os.Args, "--all")
 	}
 
+	// --version only prints the client's version, without reaching out
+	// to any server
 	if len(os.Args) == 2 && os.Args[1] == "--version" {
-		os.Args[1] = "version"
+		fmt.Println(shared.Version)
+		return nil
 	}
 
 	if len(os.Args) < 2 {
-		commands["help"].run(nil, nil)
+		commands["help"].run(nil, os.Stdout, nil)
 		os.Exit(1)
 	}
+
+	if _, ok := commands[os.Args[1]]; !ok {
+		os.Args = expandAlias(os.Args)
+	}
+
 	name := os.Args[1]
 	cmd, ok := commands[name]
 	if !ok {
 		fmt.Fprintf(os.Stderr, gettext.Gettext("error: unknown command: %s\n"), name)
-		commands["help"].run(nil, nil)
+		commands["help"].run(nil, os.Stdout, nil)
 		os.Exit(1)
 	}
 	cmd.flags()
@@ -94,7 +106,7 @@ func run() error {
 	os.Args = os.Args[1:]
 	gnuflag.Parse(true)
 
-	shared.SetLogger("", "", *verbose, *debug)
+	shared.SetLogger("", "", *verbose, *debug, nil)
 
 	var config *lxd.Config
 	var err error
@@ -123,7 +135,15 @@ func run() error {
 		fmt.Fprintf(os.Stderr, gettext.Gettext("For example: 'lxd-images import ubuntu --alias ubuntu'.\n"))
 	}
 
-	err = cmd.run(config, gnuflag.Args())
+	switch *format {
+	case "table":
+		err = cmd.run(config, os.Stdout, gnuflag.Args())
+	case "json":
+		err = runJSON(os.Stdout, name, cmd, config, gnuflag.Args())
+	default:
+		return fmt.Errorf(gettext.Gettext("Invalid format %q, must be one of table or json"), *format)
+	}
+
 	if err == errArgs {
 		fmt.Fprintf(os.Stderr, gettext.Gettext("error: %v\n%s"), err, cmd.usage())
 		os.Exit(1)
@@ -131,18 +151,50 @@ func run() error {
 	return err
 }
 
+/*
+ * runJSON runs the command with its usual output discarded, then writes the
+ * API responses it received to out as a JSON list, in the order they came in.
+ */
+func runJSON(out io.Writer, name string, cmd command, config *lxd.Config, args []string) error {
+	if name == "exec" || name == "monitor" {
+		return fmt.Errorf(gettext.Gettext("The %s command doesn't support --format=json"), name)
+	}
+
+	responses := []*lxd.Response{}
+	hooked := *config
+	hooked.ResponseHook = func(resp *lxd.Response) {
+		responses = append(responses, resp)
+	}
+
+	err := cmd.run(&hooked, ioutil.Discard, args)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(responses, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "%s\n", data)
+	return nil
+}
+
 type command interface {
 	usage() string
 	flags()
 	showByDefault() bool
-	run(config *lxd.Config, args []string) error
+	run(config *lxd.Config, out io.Writer, args []string) error
 }
 
 var commands = map[string]command{
+	"alias":    &aliasCmd{},
+	"cluster":  &clusterCmd{},
 	"config":   &configCmd{},
 	"copy":     &copyCmd{},
 	"delete":   &deleteCmd{},
 	"exec":     &execCmd{},
+	"export":   &exportCmd{},
 	"file":     &fileCmd{},
 	"finger":   &fingerCmd{},
 	"help":     &helpCmd{},
@@ -151,15 +203,19 @@ var commands = map[string]command{
 	"init":     &initCmd{},
 	"launch":   &launchCmd{},
 	"list":     &listCmd{},
+	"monitor":  &monitorCmd{},
 	"move":     &moveCmd{},
+	"pause":    &actionCmd{shared.Freeze, false, "pause"},
 	"profile":  &profileCmd{},
 	"publish":  &publishCmd{},
+	"query":    &queryCmd{},
 	"remote":   &remoteCmd{},
-	"restart":  &actionCmd{shared.Restart, true},
+	"restart":  &actionCmd{shared.Restart, true, "restart"},
 	"restore":  &restoreCmd{},
+	"resume":   &actionCmd{shared.Unfreeze, false, "resume"},
 	"snapshot": &snapshotCmd{},
-	"start":    &actionCmd{shared.Start, false},
-	"stop":     &actionCmd{shared.Stop, true},
+	"start":    &actionCmd{shared.Start, false, "start"},
+	"stop":     &actionCmd{shared.Stop, true, "stop"},
 	"version":  &versionCmd{},
 }
 
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/chai2010/gettext-go/gettext"
+	"gopkg.in/yaml.v2"
+
+	"github.com/lxc/lxd"
+	"github.com/lxc/lxd/shared/gnuflag"
+)
+
+type typeList []string
+
+func (f *typeList) String() string {
+	return fmt.Sprint(*f)
+}
+
+func (f *typeList) Set(value string) error {
+	if value == "" {
+		return fmt.Errorf(gettext.Gettext("Invalid type"))
+	}
+
+	*f = append(*f, value)
+	return nil
+}
+
+type monitorCmd struct {
+	typeArgs typeList
+}
+
+func (c *monitorCmd) showByDefault() bool {
+	return false
+}
+
+func (c *monitorCmd) usage() string {
+	return gettext.Gettext(
+		"Monitor activity on the LXD server.\n" +
+			"\n" +
+			"lxc monitor [remote:] [--type=TYPE...]\n" +
+			"\n" +
+			"Connects to the monitoring interface of the specified LXD server.\n" +
+			"\n" +
+			"By default will listen to all message types.\n" +
+			"Specific types to listen to can be specified with --type.\n" +
+			"The types are logging, operations and lifecycle.\n" +
+			"\n" +
+			"Example:\n" +
+			"lxc monitor --type=logging\n")
+}
+
+func (c *monitorCmd) flags() {
+	gnuflag.Var(&c.typeArgs, "type", gettext.Gettext("Event type to listen for"))
+}
+
+func (c *monitorCmd) run(config *lxd.Config, out io.Writer, args []string) error {
+	var remote string
+
+	if len(args) > 1 {
+		return errArgs
+	}
+
+	if len(args) == 0 {
+		remote, _ = config.ParseRemoteAndContainer("")
+	} else {
+		remote, _ = config.ParseRemoteAndContainer(args[0])
+	}
+
+	d, err := lxd.NewClient(config, remote)
+	if err != nil {
+		return err
+	}
+
+	handler := func(message interface{}) {
+		render, err := yaml.Marshal(&message)
+		if err != nil {
+			fmt.Fprintf(out, "error: %s\n", err)
+			return
+		}
+
+		fmt.Fprintf(out, "%s\n\n", render)
+	}
+
+	return d.Monitor(c.typeArgs, handler)
+}
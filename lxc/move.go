@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/chai2010/gettext-go/gettext"
+	"io"
 
 	"github.com/lxc/lxd"
 	"github.com/lxc/lxd/shared"
@@ -24,7 +25,7 @@ func (c *moveCmd) usage() string {
 
 func (c *moveCmd) flags() {}
 
-func (c *moveCmd) run(config *lxd.Config, args []string) error {
+func (c *moveCmd) run(config *lxd.Config, out io.Writer, args []string) error {
 	if len(args) != 2 {
 		return errArgs
 	}
@@ -65,9 +66,9 @@ func (c *moveCmd) run(config *lxd.Config, args []string) error {
 
 	// A move is just a copy followed by a delete; however, we want to
 	// keep the volatile entries around since we are moving the container.
-	if err := copyContainer(config, args[0], args[1], true); err != nil {
+	if err := copyContainer(config, out, args[0], args[1], true, false); err != nil {
 		return err
 	}
 
-	return commands["delete"].run(config, args[:1])
+	return commands["delete"].run(config, out, args[:1])
 }
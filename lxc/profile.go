@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -51,17 +52,19 @@ func (c *profileCmd) usage() string {
 			"lxc profile show <profile>                     Show details of a profile\n" +
 			"lxc profile create <profile>                   Create a profile\n" +
 			"lxc profile edit <profile>                     Edit profile in external editor\n" +
-			"lxc profile copy <profile> <remote>            Copy the profile to the specified remote\n" +
+			"lxc profile copy <profile> [remote:]<new-name> Copy the profile, optionally to the specified remote\n" +
+			"lxc profile rename <profile> <new-name>        Rename a profile, updating all containers using it\n" +
 			"lxc profile set <profile> <key> <value>        Set profile configuration\n" +
 			"lxc profile delete <profile>                   Delete a profile\n" +
 			"lxc profile apply <container> <profiles>\n" +
 			"    Apply a comma-separated list of profiles to a container, in order.\n" +
 			"    All profiles passed in this call (and only those) will be applied\n" +
-			"    to the specified container.\n" +
+			"    to the specified container. Later profiles override earlier ones and\n" +
+			"    the effective order is shown by 'lxc config show'.\n" +
 			"    Example: lxc profile apply foo default,bar # Apply default and bar\n" +
 			"             lxc profile apply foo default # Only default is active\n" +
-			"             lxc profile apply '' # no profiles are applied anymore\n" +
-			"             lxc profile apply bar,default # Apply default second now\n" +
+			"             lxc profile apply foo '' # no profiles are applied anymore\n" +
+			"             lxc profile apply foo bar,default # Apply default second now\n" +
 			"\n" +
 			"Devices:\n" +
 			"lxc profile device list <profile>              List devices in the given profile.\n" +
@@ -74,13 +77,13 @@ func (c *profileCmd) usage() string {
 
 func (c *profileCmd) flags() {}
 
-func (c *profileCmd) run(config *lxd.Config, args []string) error {
+func (c *profileCmd) run(config *lxd.Config, out io.Writer, args []string) error {
 	if len(args) < 1 {
 		return errArgs
 	}
 
 	if args[0] == "list" {
-		return doProfileList(config, args)
+		return doProfileList(config, out, args)
 	}
 
 	if len(args) < 2 {
@@ -95,13 +98,13 @@ func (c *profileCmd) run(config *lxd.Config, args []string) error {
 
 	switch args[0] {
 	case "create":
-		return doProfileCreate(client, profile)
+		return doProfileCreate(client, out, profile)
 	case "delete":
-		return doProfileDelete(client, profile)
+		return doProfileDelete(client, out, profile)
 	case "device":
-		return doProfileDevice(config, args)
+		return doProfileDevice(config, out, args)
 	case "edit":
-		return doProfileEdit(client, profile)
+		return doProfileEdit(client, out, profile)
 	case "apply":
 		container := profile
 		switch len(args) {
@@ -112,31 +115,33 @@ func (c *profileCmd) run(config *lxd.Config, args []string) error {
 		default:
 			return errArgs
 		}
-		return doProfileApply(client, container, profile)
+		return doProfileApply(client, out, container, profile)
 	case "get":
-		return doProfileGet(client, profile, args[2:])
+		return doProfileGet(client, out, profile, args[2:])
 	case "set":
-		return doProfileSet(client, profile, args[2:])
+		return doProfileSet(client, out, profile, args[2:])
 	case "unset":
-		return doProfileSet(client, profile, args[2:])
+		return doProfileSet(client, out, profile, args[2:])
 	case "copy":
-		return doProfileCopy(config, client, profile, args[2:])
+		return doProfileCopy(config, client, out, profile, args[2:])
+	case "rename":
+		return doProfileRename(client, out, profile, args[2:])
 	case "show":
-		return doProfileShow(client, profile)
+		return doProfileShow(client, out, profile)
 	default:
 		return fmt.Errorf("unknown profile cmd %s", args[0])
 	}
 }
 
-func doProfileCreate(client *lxd.Client, p string) error {
+func doProfileCreate(client *lxd.Client, out io.Writer, p string) error {
 	err := client.ProfileCreate(p)
 	if err == nil {
-		fmt.Printf(gettext.Gettext("Profile %s created\n"), p)
+		fmt.Fprintf(out, gettext.Gettext("Profile %s created\n"), p)
 	}
 	return err
 }
 
-func doProfileEdit(client *lxd.Client, p string) error {
+func doProfileEdit(client *lxd.Client, out io.Writer, p string) error {
 	if !terminal.IsTerminal(syscall.Stdin) {
 		contents, err := ioutil.ReadAll(os.Stdin)
 		if err != nil {
@@ -204,46 +209,106 @@ func doProfileEdit(client *lxd.Client, p string) error {
 
 			continue
 		}
-		err = client.PutProfile(p, newdata)
+		if newdata.Name != p {
+			return fmt.Errorf(gettext.Gettext("Cannot change profile name"))
+		}
+
+		// Only the changes are sent, leaving the rest as others may
+		// have changed it in the meantime
+		config, devices := profileChanges(*profile, newdata)
+		if len(config) == 0 && len(devices) == 0 {
+			return nil
+		}
+
+		err = client.PatchProfile(p, config, devices)
 		break
 	}
 	return err
 }
 
-func doProfileDelete(client *lxd.Client, p string) error {
+/*
+ * profileChanges returns the config keys and devices which differ between
+ * two versions of a profile, the removed keys being set to an empty value
+ * and the removed devices to nil, as PATCH expects.
+ */
+func profileChanges(old shared.ProfileConfig, edited shared.ProfileConfig) (map[string]string, shared.Devices) {
+	config := map[string]string{}
+	for k, v := range edited.Config {
+		if old.Config[k] != v {
+			config[k] = v
+		}
+	}
+	for k := range old.Config {
+		if _, ok := edited.Config[k]; !ok {
+			config[k] = ""
+		}
+	}
+
+	devices := shared.Devices{}
+	for name, d := range edited.Devices {
+		current, ok := old.Devices[name]
+		if !ok || !profileDeviceEqual(current, d) {
+			devices[name] = d
+		}
+	}
+	for name := range old.Devices {
+		if _, ok := edited.Devices[name]; !ok {
+			devices[name] = nil
+		}
+	}
+
+	return config, devices
+}
+
+func profileDeviceEqual(a shared.Device, b shared.Device) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for k, v := range a {
+		if value, ok := b[k]; !ok || value != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+func doProfileDelete(client *lxd.Client, out io.Writer, p string) error {
 	err := client.ProfileDelete(p)
 	if err == nil {
-		fmt.Printf(gettext.Gettext("Profile %s deleted\n"), p)
+		fmt.Fprintf(out, gettext.Gettext("Profile %s deleted\n"), p)
 	}
 	return err
 }
 
-func doProfileApply(client *lxd.Client, c string, p string) error {
+func doProfileApply(client *lxd.Client, out io.Writer, c string, p string) error {
 	resp, err := client.ApplyProfile(c, p)
 	if err == nil {
 		if p == "" {
-			p = "(none)"
+			fmt.Fprintf(out, gettext.Gettext("All profiles removed from %s\n"), c)
+		} else {
+			fmt.Fprintf(out, gettext.Gettext("Profiles %s applied to %s\n"), strings.Replace(p, ",", ", ", -1), c)
 		}
-		fmt.Printf(gettext.Gettext("Profile %s applied to %s\n"), p, c)
 	} else {
 		return err
 	}
 	return client.WaitForSuccess(resp.Operation)
 }
 
-func doProfileShow(client *lxd.Client, p string) error {
+func doProfileShow(client *lxd.Client, out io.Writer, p string) error {
 	profile, err := client.ProfileConfig(p)
 	if err != nil {
 		return err
 	}
 
 	data, err := yaml.Marshal(&profile)
-	fmt.Printf("%s", data)
+	fmt.Fprintf(out, "%s", data)
 
 	return nil
 }
 
-func doProfileCopy(config *lxd.Config, client *lxd.Client, p string, args []string) error {
+func doProfileCopy(config *lxd.Config, client *lxd.Client, out io.Writer, p string, args []string) error {
 	if len(args) != 1 {
 		return errArgs
 	}
@@ -260,7 +325,19 @@ func doProfileCopy(config *lxd.Config, client *lxd.Client, p string, args []stri
 	return client.ProfileCopy(p, newname, dest)
 }
 
-func doProfileDevice(config *lxd.Config, args []string) error {
+func doProfileRename(client *lxd.Client, out io.Writer, p string, args []string) error {
+	if len(args) != 1 {
+		return errArgs
+	}
+
+	err := client.ProfileRename(p, args[0])
+	if err == nil {
+		fmt.Fprintf(out, gettext.Gettext("Profile %s renamed to %s\n"), p, args[0])
+	}
+	return err
+}
+
+func doProfileDevice(config *lxd.Config, out io.Writer, args []string) error {
 	// device add b1 eth0 nic type=bridged
 	// device list b1
 	// device remove b1 eth0
@@ -269,19 +346,19 @@ func doProfileDevice(config *lxd.Config, args []string) error {
 	}
 	switch args[1] {
 	case "add":
-		return deviceAdd(config, "profile", args)
+		return deviceAdd(config, out, "profile", args)
 	case "remove":
-		return deviceRm(config, "profile", args)
+		return deviceRm(config, out, "profile", args)
 	case "list":
-		return deviceList(config, "profile", args)
+		return deviceList(config, out, "profile", args)
 	case "show":
-		return deviceShow(config, "profile", args)
+		return deviceShow(config, out, "profile", args)
 	default:
 		return errArgs
 	}
 }
 
-func doProfileGet(client *lxd.Client, p string, args []string) error {
+func doProfileGet(client *lxd.Client, out io.Writer, p string, args []string) error {
 	// we shifted @args so so it should read "<key>"
 	if len(args) != 1 {
 		return errArgs
@@ -293,13 +370,13 @@ func doProfileGet(client *lxd.Client, p string, args []string) error {
 	}
 	for k, v := range resp {
 		if k == args[0] {
-			fmt.Printf("%s\n", v)
+			fmt.Fprintf(out, "%s\n", v)
 		}
 	}
 	return nil
 }
 
-func doProfileSet(client *lxd.Client, p string, args []string) error {
+func doProfileSet(client *lxd.Client, out io.Writer, p string, args []string) error {
 	// we shifted @args so so it should read "<key> [<value>]"
 	if len(args) < 1 {
 		return errArgs
@@ -316,7 +393,7 @@ func doProfileSet(client *lxd.Client, p string, args []string) error {
 	return err
 }
 
-func doProfileList(config *lxd.Config, args []string) error {
+func doProfileList(config *lxd.Config, out io.Writer, args []string) error {
 	var remote string
 	if len(args) > 1 {
 		var name string
@@ -337,6 +414,6 @@ func doProfileList(config *lxd.Config, args []string) error {
 	if err != nil {
 		return err
 	}
-	fmt.Printf("%s\n", strings.Join(profiles, "\n"))
+	fmt.Fprintf(out, "%s\n", strings.Join(profiles, "\n"))
 	return nil
 }
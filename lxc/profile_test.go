@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/lxc/lxd/shared"
+)
+
+func TestProfileChanges(t *testing.T) {
+	old := shared.ProfileConfig{
+		Name:   "foo",
+		Config: map[string]string{"limits.cpus": "1", "limits.memory": "1G", "user.foo": "bar"},
+		Devices: shared.Devices{
+			"eth0": shared.Device{"type": "nic", "nictype": "bridged", "parent": "lxcbr0"},
+			"home": shared.Device{"type": "disk", "source": "/home", "path": "/home"},
+			"tmp":  shared.Device{"type": "disk", "source": "/tmp", "path": "/tmp"},
+		},
+	}
+
+	edited := shared.ProfileConfig{
+		Name:   "foo",
+		Config: map[string]string{"limits.cpus": "2", "user.foo": "bar", "user.new": "value"},
+		Devices: shared.Devices{
+			"eth0": shared.Device{"type": "nic", "nictype": "bridged", "parent": "lxdbr0"},
+			"home": shared.Device{"type": "disk", "source": "/home", "path": "/home"},
+			"srv":  shared.Device{"type": "disk", "source": "/srv", "path": "/srv"},
+		},
+	}
+
+	config, devices := profileChanges(old, edited)
+
+	expected := map[string]string{"limits.cpus": "2", "limits.memory": "", "user.new": "value"}
+	if len(config) != len(expected) {
+		t.Errorf("Got %v instead of %v", config, expected)
+	}
+	for k, v := range expected {
+		if value, ok := config[k]; !ok || value != v {
+			t.Errorf("Got %v instead of %v", config, expected)
+		}
+	}
+
+	if len(devices) != 3 || devices["eth0"]["parent"] != "lxdbr0" || devices["srv"] == nil {
+		t.Errorf("The changed and added devices weren't all sent: %v", devices)
+	}
+
+	if d, ok := devices["tmp"]; !ok || d != nil {
+		t.Errorf("The removed device wasn't set to nil: %v", devices)
+	}
+
+	if _, ok := devices["home"]; ok {
+		t.Errorf("The unchanged device was sent: %v", devices)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+
+	"github.com/lxc/lxd"
+	"github.com/lxc/lxd/shared"
+)
+
+// How often a running operation is polled for progress information.
+const progressInterval = 500 * time.Millisecond
+
+/*
+ * waitWithProgress waits for an operation to succeed like WaitForSuccess,
+ * but meanwhile polls it and renders whatever progress the server reports
+ * in its metadata right after prefix (which the caller already printed).
+ * The progress is written to out, and only when stdout is a terminal.
+ */
+func waitWithProgress(out io.Writer, d *lxd.Client, opURL string, prefix string) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- d.WaitForSuccess(opURL)
+	}()
+
+	if !terminal.IsTerminal(syscall.Stdout) {
+		return <-done
+	}
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	width := 0
+	for {
+		select {
+		case err := <-done:
+			if width > 0 {
+				fmt.Fprintf(out, "\r%s%s\r%s", prefix, strings.Repeat(" ", width), prefix)
+			}
+			return err
+		case <-ticker.C:
+			op, err := d.GetOperation(opURL)
+			if err != nil {
+				continue
+			}
+
+			progress := operationProgress(op)
+			if progress == "" {
+				continue
+			}
+
+			// Pad to erase whatever longer line was there before
+			if len(progress) < width {
+				progress += strings.Repeat(" ", width-len(progress))
+			}
+			width = len(progress)
+			fmt.Fprintf(out, "\r%s%s", prefix, progress)
+		}
+	}
+}
+
+/*
+ * operationProgress extracts a printable progress string out of the
+ * "progress" key of the operation's metadata, if any. That's normally a
+ * shared.OperationProgress, but free form strings are printed as is.
+ */
+func operationProgress(op *shared.Operation) string {
+	md := struct {
+		Progress json.RawMessage `json:"progress"`
+	}{}
+
+	if err := json.Unmarshal(op.Metadata, &md); err != nil || md.Progress == nil {
+		return ""
+	}
+
+	var s string
+	if err := json.Unmarshal(md.Progress, &s); err == nil {
+		return s
+	}
+
+	progress := shared.OperationProgress{}
+	if err := json.Unmarshal(md.Progress, &progress); err != nil {
+		return ""
+	}
+
+	return progress.String()
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"strings"
 
 	"github.com/chai2010/gettext-go/gettext"
@@ -20,18 +21,22 @@ func (c *publishCmd) usage() string {
 	return gettext.Gettext(
 		"Publish containers as images.\n" +
 			"\n" +
-			"lxc publish [remote:]container [remote:] [--alias=ALIAS]... [prop-key=prop-value]...\n")
+			"lxc publish [remote:]container [remote:] [--alias=ALIAS]... [--delete-replaced] [prop-key=prop-value]...\n" +
+			"\n" +
+			"--delete-replaced deletes the images the aliases pointed to, once no container uses them.\n")
 }
 
 var pAliases aliasList // aliasList defined in lxc/image.go
 var makePublic bool
+var deleteReplaced bool
 
 func (c *publishCmd) flags() {
 	gnuflag.BoolVar(&makePublic, "public", false, gettext.Gettext("Make the image public"))
 	gnuflag.Var(&pAliases, "alias", "New alias to define at target")
+	gnuflag.BoolVar(&deleteReplaced, "delete-replaced", false, gettext.Gettext("Delete the images the aliases pointed to, once unused"))
 }
 
-func (c *publishCmd) run(config *lxd.Config, args []string) error {
+func (c *publishCmd) run(config *lxd.Config, out io.Writer, args []string) error {
 	var cRemote string
 	var cName string
 	iName := ""
@@ -80,10 +85,10 @@ func (c *publishCmd) run(config *lxd.Config, args []string) error {
 		properties[entry[0]] = entry[1]
 	}
 
-	fp, err := d.ImageFromContainer(cName, makePublic, pAliases, properties)
+	fp, err := d.ImageFromContainer(cName, makePublic, pAliases, properties, deleteReplaced)
 
 	if err == nil {
-		fmt.Printf("Container published with fingerprint %s\n", fp)
+		fmt.Fprintf(out, "Container published with fingerprint %s\n", fp)
 	}
 	return err
 }
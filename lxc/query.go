@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/chai2010/gettext-go/gettext"
+
+	"github.com/lxc/lxd"
+	"github.com/lxc/lxd/shared/gnuflag"
+)
+
+type queryCmd struct {
+	method string
+	data   string
+	wait   bool
+}
+
+func (c *queryCmd) showByDefault() bool {
+	return false
+}
+
+func (c *queryCmd) usage() string {
+	return gettext.Gettext(
+		"Send a raw query to LXD.\n" +
+			"\n" +
+			"lxc query [-X <method>] [-d <data>] [--wait] [remote:]<API path>\n" +
+			"\n" +
+			"The response metadata is printed as indented JSON. With --wait, background\n" +
+			"operations are waited for and the resulting operation is printed instead.\n" +
+			"\n" +
+			"Example:\n" +
+			"lxc query -X DELETE --wait /1.0/containers/c1\n")
+}
+
+func (c *queryCmd) flags() {
+	gnuflag.StringVar(&c.method, "X", "GET", gettext.Gettext("Action (defaults to GET)"))
+	gnuflag.StringVar(&c.data, "d", "", gettext.Gettext("Input data"))
+	gnuflag.BoolVar(&c.wait, "wait", false, gettext.Gettext("Wait for the operation to complete"))
+}
+
+func (c *queryCmd) run(config *lxd.Config, out io.Writer, args []string) error {
+	if len(args) != 1 {
+		return errArgs
+	}
+
+	remote, path := config.ParseRemoteAndContainer(args[0])
+	if !strings.HasPrefix(path, "/") {
+		return fmt.Errorf(gettext.Gettext("Query path must start with /"))
+	}
+
+	var data []byte
+	if c.data != "" {
+		var tmp interface{}
+		if err := json.Unmarshal([]byte(c.data), &tmp); err != nil {
+			return fmt.Errorf(gettext.Gettext("Invalid JSON input: %s"), err)
+		}
+		data = []byte(c.data)
+	}
+
+	d, err := lxd.NewClient(config, remote)
+	if err != nil {
+		return err
+	}
+
+	resp, err := d.Query(strings.ToUpper(c.method), path, data)
+	if err != nil {
+		return err
+	}
+
+	if resp.Type == lxd.Async {
+		if !c.wait {
+			fmt.Fprintln(out, resp.Operation)
+			return nil
+		}
+
+		op, err := d.WaitFor(resp.Operation)
+		if err != nil {
+			return err
+		}
+
+		data, err := json.MarshalIndent(op, "", "    ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(out, string(data))
+		return nil
+	}
+
+	if len(resp.Metadata) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, resp.Metadata, "", "    "); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out, buf.String())
+	return nil
+}
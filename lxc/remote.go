@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"net"
 	"net/url"
 	"os"
@@ -21,6 +22,7 @@ type remoteCmd struct {
 	httpAddr   string
 	acceptCert bool
 	password   string
+	token      string
 	public     bool
 }
 
@@ -32,22 +34,31 @@ func (c *remoteCmd) usage() string {
 	return gettext.Gettext(
 		"Manage remote LXD servers.\n" +
 			"\n" +
-			"lxc remote add <name> <url> [--accept-certificate] [--password=PASSWORD] [--public]    Add the remote <name> at <url>.\n" +
+			"lxc remote add <name> <url> [--accept-certificate] [--password=PASSWORD] [--token=TOKEN] [--public]\n" +
+			"                                                                                       Add the remote <name> at <url>.\n" +
 			"lxc remote remove <name>                                                               Remove the remote <name>.\n" +
 			"lxc remote list                                                                        List all remotes.\n" +
 			"lxc remote rename <old> <new>                                                          Rename remote <old> to <new>.\n" +
-			"lxc remote set-url <name> <url>                                                        Update <name>'s url to <url>.\n" +
+			"lxc remote set-url <name> <url> [--accept-certificate]                                 Update <name>'s url to <url>.\n" +
 			"lxc remote set-default <name>                                                          Set the default remote.\n" +
-			"lxc remote get-default                                                                 Print the default remote.\n")
+			"lxc remote get-default                                                                 Print the default remote.\n" +
+			"\n" +
+			"TOKEN is a single-use token created on the server with \"lxc config trust token\",\n" +
+			"it can be used instead of the server's trust password.\n")
 }
 
 func (c *remoteCmd) flags() {
 	gnuflag.BoolVar(&c.acceptCert, "accept-certificate", false, gettext.Gettext("Accept certificate"))
 	gnuflag.StringVar(&c.password, "password", "", gettext.Gettext("Remote admin password"))
+	gnuflag.StringVar(&c.token, "token", "", gettext.Gettext("Remote join token"))
 	gnuflag.BoolVar(&c.public, "public", false, gettext.Gettext("Public image server"))
 }
 
-func addServer(config *lxd.Config, server string, addr string, acceptCert bool, password string, public bool) error {
+/*
+ * parseRemoteURL turns the user provided remote address into a full URL,
+ * returning it along with its scheme and host.
+ */
+func parseRemoteURL(addr string) (string, string, string, error) {
 	var r_scheme string
 	var r_host string
 	var r_port string
@@ -55,7 +66,7 @@ func addServer(config *lxd.Config, server string, addr string, acceptCert bool,
 	/* Complex remote URL parsing */
 	remote_url, err := url.Parse(addr)
 	if err != nil {
-		return err
+		return "", "", "", err
 	}
 
 	if remote_url.Scheme != "" {
@@ -109,6 +120,15 @@ func addServer(config *lxd.Config, server string, addr string, acceptCert bool,
 		addr = r_scheme + "://" + r_host
 	}
 
+	return addr, r_scheme, host, nil
+}
+
+func addServer(config *lxd.Config, out io.Writer, server string, addr string, acceptCert bool, password string, token string, public bool) error {
+	addr, _, host, err := parseRemoteURL(addr)
+	if err != nil {
+		return err
+	}
+
 	if config.Remotes == nil {
 		config.Remotes = make(map[string]lxd.RemoteConfig)
 	}
@@ -146,22 +166,26 @@ func addServer(config *lxd.Config, server string, addr string, acceptCert bool,
 		return nil
 	}
 
-	if password == "" {
-		fmt.Printf(gettext.Gettext("Admin password for %s: "), server)
-		pwd, err := terminal.ReadPassword(0)
-		if err != nil {
-			/* We got an error, maybe this isn't a terminal, let's try to
-			 * read it as a file */
-			pwd, err = shared.ReadStdin()
+	if token != "" {
+		err = c.AddMyCertToServerWithToken(token)
+	} else {
+		if password == "" {
+			fmt.Printf(gettext.Gettext("Admin password for %s: "), server)
+			pwd, err := terminal.ReadPassword(0)
 			if err != nil {
-				return err
+				/* We got an error, maybe this isn't a terminal, let's try to
+				 * read it as a file */
+				pwd, err = shared.ReadStdin()
+				if err != nil {
+					return err
+				}
 			}
+			fmt.Printf("\n")
+			password = string(pwd)
 		}
-		fmt.Printf("\n")
-		password = string(pwd)
-	}
 
-	err = c.AddMyCertToServer(password)
+		err = c.AddMyCertToServer(password)
+	}
 	if err != nil {
 		return err
 	}
@@ -170,7 +194,45 @@ func addServer(config *lxd.Config, server string, addr string, acceptCert bool,
 		return fmt.Errorf(gettext.Gettext("Server doesn't trust us after adding our cert"))
 	}
 
-	fmt.Println(gettext.Gettext("Client certificate stored at server: "), server)
+	fmt.Fprintln(out, gettext.Gettext("Client certificate stored at server: "), server)
+	return nil
+}
+
+/*
+ * setRemoteURL points an existing remote at a new address, dropping the
+ * certificate we had pinned for the old one and asking the user to accept
+ * the new server's certificate.
+ */
+func setRemoteURL(config *lxd.Config, server string, addr string, acceptCert bool) error {
+	rc := config.Remotes[server]
+
+	addr, r_scheme, host, err := parseRemoteURL(addr)
+	if err != nil {
+		return err
+	}
+
+	// Keep the old certificate around until the new server is accepted
+	certf := lxd.ServerCertPath(server)
+	if err := os.Rename(certf, certf+".old"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	rc.Addr = addr
+	config.Remotes[server] = rc
+
+	if r_scheme == "https" {
+		d, err := lxd.NewClient(config, server)
+		if err == nil {
+			err = d.UserAuthServerCert(host, acceptCert)
+		}
+
+		if err != nil {
+			os.Rename(certf+".old", certf)
+			return err
+		}
+	}
+
+	os.Remove(certf + ".old")
 	return nil
 }
 
@@ -181,7 +243,7 @@ func removeCertificate(remote string) {
 	os.Remove(certf)
 }
 
-func (c *remoteCmd) run(config *lxd.Config, args []string) error {
+func (c *remoteCmd) run(config *lxd.Config, out io.Writer, args []string) error {
 	if len(args) < 1 {
 		return errArgs
 	}
@@ -196,7 +258,7 @@ func (c *remoteCmd) run(config *lxd.Config, args []string) error {
 			return fmt.Errorf(gettext.Gettext("remote %s exists as <%s>"), args[1], rc.Addr)
 		}
 
-		err := addServer(config, args[1], args[2], c.acceptCert, c.password, c.public)
+		err := addServer(config, out, args[1], args[2], c.acceptCert, c.password, c.token, c.public)
 		if err != nil {
 			delete(config.Remotes, args[1])
 			return err
@@ -229,7 +291,7 @@ func (c *remoteCmd) run(config *lxd.Config, args []string) error {
 			}
 		}
 
-		table := tablewriter.NewWriter(os.Stdout)
+		table := tablewriter.NewWriter(out)
 		table.SetHeader([]string{"NAME", "URL", "PUBLIC"})
 		sort.Sort(ByName(data))
 		table.AppendBulk(data)
@@ -254,6 +316,12 @@ func (c *remoteCmd) run(config *lxd.Config, args []string) error {
 		config.Remotes[args[2]] = rc
 		delete(config.Remotes, args[1])
 
+		// Carry the pinned server certificate over to the new name
+		err := os.Rename(lxd.ServerCertPath(args[1]), lxd.ServerCertPath(args[2]))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
 		if config.DefaultRemote == args[1] {
 			config.DefaultRemote = args[2]
 		}
@@ -262,11 +330,16 @@ func (c *remoteCmd) run(config *lxd.Config, args []string) error {
 		if len(args) != 3 {
 			return errArgs
 		}
-		_, ok := config.Remotes[args[1]]
+		rc, ok := config.Remotes[args[1]]
 		if !ok {
 			return fmt.Errorf(gettext.Gettext("remote %s doesn't exist"), args[1])
 		}
-		config.Remotes[args[1]] = lxd.RemoteConfig{Addr: args[2]}
+
+		err := setRemoteURL(config, args[1], args[2], c.acceptCert)
+		if err != nil {
+			config.Remotes[args[1]] = rc
+			return err
+		}
 
 	case "set-default":
 		if len(args) != 2 {
@@ -285,7 +358,7 @@ func (c *remoteCmd) run(config *lxd.Config, args []string) error {
 		if len(args) != 1 {
 			return errArgs
 		}
-		fmt.Println(config.DefaultRemote)
+		fmt.Fprintln(out, config.DefaultRemote)
 		return nil
 	default:
 		return fmt.Errorf(gettext.Gettext("Unknown remote subcommand %s"), args[0])
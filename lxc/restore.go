@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/chai2010/gettext-go/gettext"
 
@@ -29,7 +30,7 @@ func (c *restoreCmd) flags() {
 	gnuflag.BoolVar(&c.stateful, "stateful", false, gettext.Gettext("Whether or not to restore the container's running state from snapshot (if available)"))
 }
 
-func (c *restoreCmd) run(config *lxd.Config, args []string) error {
+func (c *restoreCmd) run(config *lxd.Config, out io.Writer, args []string) error {
 	if len(args) < 2 {
 		return errArgs
 	}
@@ -51,5 +52,5 @@ func (c *restoreCmd) run(config *lxd.Config, args []string) error {
 		return err
 	}
 
-	return d.WaitForSuccess(resp.Operation)
+	return waitWithProgress(out, d, resp.Operation, "")
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/chai2010/gettext-go/gettext"
 
@@ -22,14 +23,23 @@ func (c *snapshotCmd) usage() string {
 	return gettext.Gettext(
 		"Create a read-only snapshot of a container.\n" +
 			"\n" +
-			"lxc snapshot [remote:]<source> <snapshot name> [--stateful]\n")
+			"lxc snapshot [remote:]<source> <snapshot name> [--stateful]\n" +
+			"\n" +
+			"When --stateful is used, LXD attempts to checkpoint the container's\n" +
+			"running state, including process memory state, TCP connections, etc...\n" +
+			"so that it can be restored (via lxc restore) at a later time (very\n" +
+			"useful for long running processes). This requires criu on the host and\n" +
+			"a running container.\n" +
+			"\n" +
+			"Example:\n" +
+			"lxc snapshot u1 snap0 --stateful\n")
 }
 
 func (c *snapshotCmd) flags() {
 	gnuflag.BoolVar(&c.stateful, "stateful", false, gettext.Gettext("Whether or not to snapshot the container's running state"))
 }
 
-func (c *snapshotCmd) run(config *lxd.Config, args []string) error {
+func (c *snapshotCmd) run(config *lxd.Config, out io.Writer, args []string) error {
 	if len(args) < 1 {
 		return errArgs
 	}
@@ -57,5 +67,5 @@ func (c *snapshotCmd) run(config *lxd.Config, args []string) error {
 		return err
 	}
 
-	return d.WaitForSuccess(resp.Operation)
+	return waitWithProgress(out, d, resp.Operation, "")
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/chai2010/gettext-go/gettext"
 
@@ -17,18 +18,49 @@ func (c *versionCmd) showByDefault() bool {
 
 func (c *versionCmd) usage() string {
 	return gettext.Gettext(
-		"Prints the version number of LXD.\n" +
+		"Prints the version number of the LXD client and server.\n" +
 			"\n" +
-			"lxc version\n")
+			"lxc version [remote:]\n" +
+			"\n" +
+			"The server's LXD, LXC and kernel versions are queried from the given\n" +
+			"remote, or from the default one if none is given.\n")
 }
 
 func (c *versionCmd) flags() {
 }
 
-func (c *versionCmd) run(_ *lxd.Config, args []string) error {
-	if len(args) > 0 {
+func (c *versionCmd) run(config *lxd.Config, out io.Writer, args []string) error {
+	if len(args) > 1 {
 		return errArgs
 	}
-	fmt.Println(shared.Version)
+
+	fmt.Fprintf(out, gettext.Gettext("Client version: %s")+"\n", shared.Version)
+
+	remote := config.DefaultRemote
+	if len(args) == 1 {
+		remote = config.ParseRemote(args[0])
+	}
+
+	d, err := lxd.NewClient(config, remote)
+	if err != nil {
+		return err
+	}
+
+	serverStatus, err := d.ServerStatus()
+	if err != nil {
+		return err
+	}
+
+	env := serverStatus.Environment
+	if env.ServerVersion == "" {
+		/* The environment is only sent to trusted clients */
+		fmt.Fprintln(out, gettext.Gettext("Server version: unknown (client not trusted)"))
+		return nil
+	}
+
+	fmt.Fprintf(out, gettext.Gettext("Server version: %s")+"\n", env.ServerVersion)
+	fmt.Fprintf(out, gettext.Gettext("LXC version: %s")+"\n", env.DriverVersion)
+	fmt.Fprintf(out, gettext.Gettext("Kernel version: %s %s (%s)")+"\n", env.Kernel, env.KernelVersion, env.KernelArchitecture)
+
 	return nil
 }
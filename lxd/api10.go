@@ -4,8 +4,11 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"syscall"
 
+	log "gopkg.in/inconshreveable/log15.v2"
 	"gopkg.in/lxc/go-lxc.v2"
 
 	"github.com/lxc/lxd/shared"
@@ -21,6 +24,7 @@ var api10 = []Command{
 	containerSnapshotsCmd,
 	containerSnapshotCmd,
 	containerExecCmd,
+	containerExportCmd,
 	aliasCmd,
 	aliasesCmd,
 	imageCmd,
@@ -33,15 +37,96 @@ var api10 = []Command{
 	operationWebsocket,
 	networksCmd,
 	networkCmd,
+	networkForwardsCmd,
+	networkForwardCmd,
+	networkLeasesCmd,
 	api10Cmd,
+	auditCmd,
+	batchCmd,
 	certificatesCmd,
+	certificateTokensCmd,
+	certificateTokenCmd,
 	certificateFingerprintCmd,
+	clusterCmd,
+	clusterContainersCmd,
+	clusterMembersCmd,
+	clusterMemberCmd,
+	configKeysCmd,
+	databaseCheckCmd,
+	databaseDumpCmd,
+	healthCmd,
+	metricsCmd,
 	profilesCmd,
 	profileCmd,
+	readyCmd,
+	resourcesCmd,
+	shutdownCmd,
+	eventsCmd,
+}
+
+/*
+ * apiExtensions lists the additions to the API since it was frozen, so that
+ * clients can check for them rather than parse the server version. New
+ * ones go at the end and are described in specs/api-extensions.md.
+ */
+var apiExtensions = []string{
+	"profile_rename",
+	"container_only_copy",
+	"lifecycle_events",
+	"operation_progress",
+	"patch",
+	"etag",
+	"error_codes",
+	"rate_limit",
+	"certificate_tokens",
+	"certificate_limits",
+	"audit_log",
+	"config_keys",
+	"resources",
+	"metrics",
+	"health",
+	"database_admin",
+	"cluster",
+	"collection_recursion",
+	"request_id",
+	"websocket_compression",
+	"operation_wait",
+	"cors",
+	"debug_address",
+	"batch",
+	"shutdown",
+	"waitready",
+	"network",
+	"network_dhcp",
+	"nic_static_address",
+	"network_ipv6",
+	"network_firewall",
+	"network_forward",
+	"network_state",
+	"network_leases",
+	"nic_macvlan_physical",
+	"nic_sriov",
+	"network_tunnel",
+	"network_dns_names",
+	"network_rename",
+	"network_acl",
+	"used_by",
+	"profile_live_update",
+	"image_remote_cache",
+	"cpu_allowance",
+	"snapshot_size",
+	"image_alias_replace",
+	"certificate_expiry",
+	"scheduler_hook",
+	"concurrent_operation_limits",
+	"container_export",
+	"operation_resource_filter",
+	"container_create_count",
+	"image_alias_info",
 }
 
 func api10Get(d *Daemon, r *http.Request) Response {
-	body := shared.Jmap{"api_compat": shared.APICompat}
+	body := shared.Jmap{"api_compat": shared.APICompat, "api_extensions": apiExtensions}
 
 	if d.isTrustedClient(r) {
 		body["auth"] = "trusted"
@@ -103,39 +188,53 @@ func api10Get(d *Daemon, r *http.Request) Response {
 
 		body["environment"] = env
 
-		serverConfig, err := d.ConfigValuesGet()
+		config, err := api10Config(d)
 		if err != nil {
 			return InternalError(err)
 		}
 
-		config := shared.Jmap{}
+		body["config"] = config
 
-		for key, value := range serverConfig {
-			if key == "core.trust_password" {
-				config[key] = true
-			} else {
-				config[key] = value
-			}
-		}
+		return SyncResponseETag(true, body, config)
+	}
+
+	body["auth"] = "untrusted"
+
+	return SyncResponse(true, body)
+}
 
-		expiry, err := dbImageExpiryGet(d.db)
-		if err != nil || expiry == "" {
-			expiry = "10"
+// api10Config returns the server configuration, as shown to the clients.
+func api10Config(d *Daemon) (shared.Jmap, error) {
+	serverConfig, err := d.ConfigValuesGet()
+	if err != nil {
+		return nil, err
+	}
+
+	config := shared.Jmap{}
+
+	for key, value := range serverConfig {
+		if key == "core.trust_password" {
+			config[key] = true
+		} else {
+			config[key] = value
 		}
-		config["images.remote_cache_expiry"] = expiry
+	}
 
-		body["config"] = config
-	} else {
-		body["auth"] = "untrusted"
+	expiry, err := dbImageExpiryGet(d.db)
+	if err != nil || expiry == "" {
+		expiry = "10"
 	}
+	config["images.remote_cache_expiry"] = expiry
 
-	return SyncResponse(true, body)
+	return config, nil
 }
 
 type apiPut struct {
 	Config shared.Jmap `json:"config"`
 }
 
+// api10Put only changes the config keys it's given, so it also handles
+// PATCH requests.
 func api10Put(d *Daemon, r *http.Request) Response {
 	req := apiPut{}
 
@@ -143,11 +242,39 @@ func api10Put(d *Daemon, r *http.Request) Response {
 		return BadRequest(err)
 	}
 
+	patchLock.Lock()
+	defer patchLock.Unlock()
+
+	config, err := api10Config(d)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	if err := etagCheck(r, config); err != nil {
+		return PreconditionFailed(err)
+	}
+
 	for key, value := range req.Config {
 		if !d.ConfigKeyIsValid(key) {
 			return BadRequest(fmt.Errorf("Bad server config key: '%s'", key))
 		}
 
+		if key == "core.api_rate_limit" || key == "core.api_rate_burst" || key == "core.shutdown_timeout" || key == "core.concurrent_image_imports" || key == "core.concurrent_migrations" || key == "core.concurrent_container_creations" {
+			if v, err := strconv.Atoi(value.(string)); value != "" && (err != nil || v < 0) {
+				return BadRequest(fmt.Errorf("Bad value for %s: '%s'", key, value))
+			}
+		}
+
+		if (key == "core.metrics" || key == "core.websocket_compression" || key == "core.https_allowed_credentials") && value != "" && value != "true" && value != "false" {
+			return BadRequest(fmt.Errorf("Bad value for %s: '%s'", key, value))
+		}
+
+		if key == "core.log_level" && value != "" {
+			if _, err := log.LvlFromString(value.(string)); err != nil {
+				return BadRequest(fmt.Errorf("Bad value for %s: '%s'", key, value))
+			}
+		}
+
 		if key == "core.trust_password" {
 			err := d.PasswordSet(value.(string))
 			if err != nil {
@@ -156,13 +283,23 @@ func api10Put(d *Daemon, r *http.Request) Response {
 		} else if key == "storage.lvm_vg_name" {
 			err := storageLVMSetVolumeGroupNameConfig(d, value.(string))
 			if err != nil {
-				return InternalError(err)
+				return StorageError(err)
 			}
 			if err = d.SetupStorageDriver(); err != nil {
-				return InternalError(err)
+				return StorageError(err)
 			}
 		} else if key == "storage.lvm_thinpool_name" {
 			err := storageLVMSetThinPoolNameConfig(d, value.(string))
+			if err != nil {
+				return StorageError(err)
+			}
+		} else if key == "core.debug_address" {
+			err := d.UpdateDebugAddress(value.(string))
+			if err != nil {
+				return InternalError(err)
+			}
+
+			err = d.ConfigValueSet(key, value.(string))
 			if err != nil {
 				return InternalError(err)
 			}
@@ -172,7 +309,7 @@ func api10Put(d *Daemon, r *http.Request) Response {
 				return InternalError(err)
 			}
 
-			d.UpdateHTTPsPort(old_address, value.(string))
+			err = d.UpdateHTTPsPort(old_address, value.(string))
 			if err != nil {
 				return InternalError(err)
 			}
@@ -188,6 +325,12 @@ func api10Put(d *Daemon, r *http.Request) Response {
 			}
 			if key == "images.remote_cache_expiry" {
 				d.pruneChan <- true
+			} else if key == "core.log_level" {
+				shared.SetLogLevel(value.(string))
+			} else if strings.HasPrefix(key, "core.concurrent_") {
+				operationsLimitsLoad(d)
+			} else if strings.HasPrefix(key, "core.api_rate_") {
+				rateLimitsLoad(d)
 			}
 		}
 	}
@@ -195,4 +338,4 @@ func api10Put(d *Daemon, r *http.Request) Response {
 	return EmptySyncResponse
 }
 
-var api10Cmd = Command{name: "", untrustedGet: true, get: api10Get, put: api10Put}
+var api10Cmd = Command{name: "", untrustedGet: true, get: api10Get, put: api10Put, patch: api10Put}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+
+	"github.com/lxc/lxd/shared"
+)
+
+// Request bodies are cut to that many bytes in the audit log.
+const auditSummaryMaxSize = 1024
+
+// Request bodies bigger than that aren't read to be summarized.
+const auditBodyMaxSize = 1024 * 1024
+
+// Default number of entries returned by GET /1.0/audit.
+const auditDefaultLimit = 1000
+
+// auditHistoryExpiry is how long entries are kept in the audit log.
+const auditHistoryExpiry = 30 * 24 * time.Hour
+
+// auditEntry records a mutating API request and its result.
+type auditEntry struct {
+	ID         int       `json:"id"`
+	Date       time.Time `json:"date"`
+	Client     string    `json:"client"`
+	Method     string    `json:"method"`
+	URL        string    `json:"url"`
+	Summary    string    `json:"summary"`
+	StatusCode int       `json:"status_code"`
+}
+
+/*
+ * connUidMapper remembers the uid of the processes connected to the unix
+ * socket, the same way pidMapper does for /dev/lxd, so that the audit log
+ * can tell who sent a request.
+ */
+type connUidMapper struct {
+	lock sync.Mutex
+	m    map[*net.UnixConn]uint32
+}
+
+var uidMapper = connUidMapper{m: map[*net.UnixConn]uint32{}}
+
+func (m *connUidMapper) ConnStateHandler(conn net.Conn, state http.ConnState) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return
+	}
+
+	switch state {
+	case http.StateNew:
+		uid, _, _, err := getUcred(extractUnderlyingFd(unixConn))
+		if err != nil {
+			shared.Debugf("Error getting uid for conn %s", err)
+			return
+		}
+
+		m.lock.Lock()
+		m.m[unixConn] = uid
+		m.lock.Unlock()
+	case http.StateHijacked, http.StateClosed:
+		m.lock.Lock()
+		delete(m.m, unixConn)
+		m.lock.Unlock()
+	}
+}
+
+func (m *connUidMapper) uid(w http.ResponseWriter) (uint32, bool) {
+	conn := extractUnderlyingConn(w)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	uid, ok := m.m[conn]
+	return uid, ok
+}
+
+// auditClient identifies who sent a request: the uid of the process for the
+// unix socket, the certificate fingerprint otherwise, as only trusted
+// requests are audited.
+func auditClient(w http.ResponseWriter, r *http.Request) string {
+	if r.RemoteAddr == "@" {
+		uid, ok := uidMapper.uid(w)
+		if !ok {
+			return "unix"
+		}
+
+		return fmt.Sprintf("uid=%d", uid)
+	}
+
+	return rateLimitClient(r, true)
+}
+
+// auditRedact hides the secrets (passwords, tokens) found in a request.
+func auditRedact(data interface{}) interface{} {
+	switch value := data.(type) {
+	case map[string]interface{}:
+		for k, v := range value {
+			key := strings.ToLower(k)
+			if strings.Contains(key, "password") || strings.Contains(key, "token") || strings.Contains(key, "secret") {
+				value[k] = "<redacted>"
+				continue
+			}
+
+			value[k] = auditRedact(v)
+		}
+	case []interface{}:
+		for i, v := range value {
+			value[i] = auditRedact(v)
+		}
+	}
+
+	return data
+}
+
+// auditBody is a request body with its beginning read already.
+type auditBody struct {
+	io.Reader
+	io.Closer
+}
+
+/*
+ * auditSummary reads the request body to describe it in the audit log,
+ * putting it back in place for the handler. Secrets are redacted from JSON
+ * bodies and others (like image or file uploads, or JSON bodies of more
+ * than auditBodyMaxSize bytes) are only described.
+ */
+func auditSummary(r *http.Request) (string, error) {
+	if r.Body == nil {
+		return "", nil
+	}
+
+	if !isJSONRequest(r) {
+		if r.ContentLength > 0 {
+			return fmt.Sprintf("%d bytes", r.ContentLength), nil
+		}
+
+		return "", nil
+	}
+
+	body := &bytes.Buffer{}
+	if _, err := io.Copy(body, io.LimitReader(r.Body, auditBodyMaxSize+1)); err != nil {
+		return "", err
+	}
+	r.Body = auditBody{io.MultiReader(bytes.NewReader(body.Bytes()), r.Body), r.Body}
+
+	if body.Len() > auditBodyMaxSize {
+		return fmt.Sprintf("more than %d bytes", auditBodyMaxSize), nil
+	}
+
+	var data interface{}
+	if err := json.Unmarshal(body.Bytes(), &data); err != nil {
+		return fmt.Sprintf("%d bytes", body.Len()), nil
+	}
+
+	summary, err := json.Marshal(auditRedact(data))
+	if err != nil {
+		return "", err
+	}
+
+	if len(summary) > auditSummaryMaxSize {
+		summary = summary[:auditSummaryMaxSize]
+	}
+
+	return string(summary), nil
+}
+
+// responseStatusCode returns the HTTP status code a response is rendered with.
+func responseStatusCode(resp Response) int {
+	switch resp := resp.(type) {
+	case *asyncResponse:
+		return 202
+	case *ErrorResponse:
+		return resp.code
+	}
+
+	return 200
+}
+
+/*
+ * auditRecord appends a request and its result to the audit log. The
+ * result of a request starting a background operation is the status the
+ * operation ends with, so it's only recorded then.
+ */
+func (d *Daemon) auditRecord(entry *auditEntry, resp Response) {
+	if async, ok := resp.(*asyncResponse); ok && async.id != "" {
+		go func() {
+			if status, ok := operationWaitFinal(async.id); ok {
+				entry.StatusCode = int(status)
+			}
+			d.auditRecordNow(entry)
+		}()
+		return
+	}
+
+	d.auditRecordNow(entry)
+}
+
+func (d *Daemon) auditRecordNow(entry *auditEntry) {
+	err := dbAuditAdd(d.db, entry)
+	if err != nil {
+		shared.Log.Error("failed to record audit log entry",
+			log.Ctx{"method": entry.Method, "url": entry.URL, "err": err})
+	}
+}
+
+func auditGet(d *Daemon, r *http.Request) Response {
+	after := 0
+	if value := r.FormValue("after"); value != "" {
+		var err error
+		after, err = strconv.Atoi(value)
+		if err != nil {
+			return BadRequest(fmt.Errorf("Invalid entry id: %s", value))
+		}
+	}
+
+	limit := auditDefaultLimit
+	if value := r.FormValue("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return BadRequest(fmt.Errorf("Invalid limit: %s", value))
+		}
+	}
+
+	entries, err := dbAuditGet(d.db, after, limit)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	return SyncResponse(true, entries)
+}
+
+var auditCmd = Command{name: "audit", get: auditGet}
+
+// auditPruneStart removes the expired entries of the audit log, now and
+// then every day.
+func auditPruneStart(d *Daemon) {
+	go func() {
+		for {
+			err := dbAuditPrune(d.db, time.Now().Add(-auditHistoryExpiry))
+			if err != nil {
+				shared.Log.Error("Failed to prune the audit log", log.Ctx{"err": err})
+			}
+
+			time.Sleep(24 * time.Hour)
+		}
+	}()
+}
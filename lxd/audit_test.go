@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func Test_audit_summary_redacts_secrets(t *testing.T) {
+	body := `{"config": {"core.trust_password": "foo", "core.https_address": "[::]"}, "token": "bar"}`
+	r, err := http.NewRequest("PUT", "/1.0", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	summary, err := auditSummary(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(summary, "foo") || strings.Contains(summary, "bar") {
+		t.Errorf("Secrets weren't redacted: %s", summary)
+	}
+
+	if !strings.Contains(summary, "core.https_address") {
+		t.Errorf("Missing request content: %s", summary)
+	}
+
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(content) != body {
+		t.Errorf("The request body wasn't restored: %s", content)
+	}
+}
+
+func Test_audit_summary_only_describes_uploads(t *testing.T) {
+	r, err := http.NewRequest("POST", "/1.0/images", bytes.NewReader(make([]byte, 4096)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/octet-stream")
+
+	summary, err := auditSummary(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary != "4096 bytes" {
+		t.Errorf("Wrong summary for an upload: %s", summary)
+	}
+}
+
+func Test_audit_summary_doesnt_read_big_bodies(t *testing.T) {
+	body := `{"description": "` + strings.Repeat("a", auditBodyMaxSize) + `"}`
+	r, err := http.NewRequest("PUT", "/1.0/images/foo", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	summary, err := auditSummary(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(summary, "more than") {
+		t.Errorf("Wrong summary for a big body: %.40s", summary)
+	}
+
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(content) != body {
+		t.Errorf("The request body wasn't restored")
+	}
+}
+
+func Test_responseStatusCode(t *testing.T) {
+	if code := responseStatusCode(EmptySyncResponse); code != 200 {
+		t.Errorf("Wrong status for a sync response: %d", code)
+	}
+
+	if code := responseStatusCode(AsyncResponse(nil, nil)); code != 202 {
+		t.Errorf("Wrong status for an async response: %d", code)
+	}
+
+	if code := responseStatusCode(Forbidden); code != 403 {
+		t.Errorf("Wrong status for an error: %d", code)
+	}
+}
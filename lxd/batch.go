@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lxc/lxd"
+	"github.com/lxc/lxd/shared"
+)
+
+type batchPostReq struct {
+	Action      string               `json:"action"`
+	Prefix      string               `json:"prefix"`
+	Count       int                  `json:"count"`
+	Concurrency int                  `json:"concurrency"`
+	Source      containerImageSource `json:"source"`
+	Config      map[string]string    `json:"config"`
+	Profiles    []string             `json:"profiles"`
+	Ephemeral   bool                 `json:"ephemeral"`
+}
+
+// batchContainers returns the existing containers of a batch, the ones
+// named <prefix>-<something>.
+func batchContainers(d *Daemon, prefix string) ([]string, error) {
+	names, err := dbContainersList(d.db, cTypeRegular)
+	if err != nil {
+		return nil, err
+	}
+
+	containers := []string{}
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix+"-") {
+			containers = append(containers, name)
+		}
+	}
+
+	return containers, nil
+}
+
+/*
+ * batchRun applies the action to the containers, concurrency at a time,
+ * and reports how long it took. It goes on when the action fails on some
+ * containers, but not once the canceller is cancelled.
+ */
+func batchRun(action string, containers []string, concurrency int,
+	do func(name string) error, canceller *operationCanceller, progress *operationProgress) shared.BatchResult {
+
+	result := shared.BatchResult{Action: action, Containers: []string{}, Failures: map[string]string{}}
+	resultLock := sync.Mutex{}
+
+	names := make(chan string)
+	wg := sync.WaitGroup{}
+	start := time.Now()
+	total := 0.0
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				containerStart := time.Now()
+				err := errOperationCancelled
+				if !canceller.Cancelled() {
+					err = do(name)
+				}
+				duration := time.Since(containerStart).Seconds()
+
+				resultLock.Lock()
+				if err != nil {
+					result.Failures[name] = err.Error()
+				} else {
+					result.Containers = append(result.Containers, name)
+					total += duration
+					if duration > result.Slowest {
+						result.Slowest = duration
+					}
+				}
+				resultLock.Unlock()
+
+				progress.Add(1)
+			}
+		}()
+	}
+
+	for _, name := range containers {
+		names <- name
+	}
+	close(names)
+	wg.Wait()
+
+	result.Duration = time.Since(start).Seconds()
+	if len(result.Containers) > 0 {
+		result.Average = total / float64(len(result.Containers))
+	}
+	sort.Strings(result.Containers)
+
+	return result
+}
+
+/*
+ * batchPost creates, launches, starts, stops or deletes a batch of
+ * containers in parallel, for load testing or bulk provisioning. The
+ * containers are named <prefix>-<n>.
+ */
+func batchPost(d *Daemon, r *http.Request) Response {
+	req := batchPostReq{}
+	if err := shared.ReadToJSON(r.Body, &req); err != nil {
+		return BadRequest(err)
+	}
+
+	// Deleting the batch of another user by mistake is to be avoided
+	if req.Prefix == "" && req.Action == "delete" {
+		return BadRequest(fmt.Errorf("The prefix of the containers to delete must be given"))
+	}
+
+	if req.Prefix == "" {
+		req.Prefix = "batch"
+	}
+
+	if strings.Contains(req.Prefix, shared.SnapshotDelimiter) {
+		return BadRequest(fmt.Errorf("Invalid prefix: '%s' is reserved for snapshots", shared.SnapshotDelimiter))
+	}
+
+	if req.Concurrency <= 0 {
+		req.Concurrency = runtime.NumCPU()
+	}
+
+	var containers []string
+	var do func(name string) error
+	canceller := &operationCanceller{}
+
+	switch req.Action {
+	case "create", "launch":
+		if req.Count <= 0 {
+			return BadRequest(fmt.Errorf("The number of containers to create must be positive"))
+		}
+
+		if d.IdmapSet == nil {
+			return BadRequest(fmt.Errorf("The containers can't be created without subuids for the daemon's user"))
+		}
+
+		// Only local images, a remote one is to be copied over first
+		if req.Source.Type != "image" || req.Source.Server != "" {
+			return BadRequest(fmt.Errorf("Batches can only be created from a local image"))
+		}
+
+		hash := req.Source.Fingerprint
+		if req.Source.Alias != "" {
+			var err error
+			hash, err = dbImageAliasGet(d.db, req.Source.Alias)
+			if err != nil {
+				return SmartError(err)
+			}
+		}
+
+		imgInfo, err := dbImageGet(d.db, hash, false, false)
+		if err != nil {
+			return SmartError(err)
+		}
+
+		for i := 1; i <= req.Count; i++ {
+			containers = append(containers, fmt.Sprintf("%s-%d", req.Prefix, i))
+		}
+
+		do = func(name string) error {
+			one := containerPostReq{
+				Name:      name,
+				Source:    req.Source,
+				Config:    req.Config,
+				Profiles:  req.Profiles,
+				Ephemeral: req.Ephemeral,
+			}
+			if err := containerSchedulerAsk(d, &one); err != nil {
+				return err
+			}
+
+			args := containerLXDArgs{
+				Ctype:        cTypeRegular,
+				Config:       req.Config,
+				Profiles:     req.Profiles,
+				Ephemeral:    req.Ephemeral,
+				BaseImage:    imgInfo.Fingerprint,
+				Architecture: imgInfo.Architecture,
+			}
+
+			// Each creation counts against core.concurrent_container_creations
+			if !operationClassAcquire(operationClassContainerCreation, canceller) {
+				return errOperationCancelled
+			}
+			c, err := containerLXDCreateFromImage(d, name, args, imgInfo.Fingerprint)
+			operationClassRelease(operationClassContainerCreation)
+			if err != nil {
+				return err
+			}
+
+			if req.Action == "launch" {
+				return c.Start()
+			}
+
+			return nil
+		}
+
+	case "start", "stop", "delete":
+		var err error
+		containers, err = batchContainers(d, req.Prefix)
+		if err != nil {
+			return InternalError(err)
+		}
+
+		do = func(name string) error {
+			c, err := containerLXDLoad(d, name)
+			if err != nil {
+				return err
+			}
+
+			switch req.Action {
+			case "start":
+				return c.Start()
+			case "stop":
+				return c.Stop()
+			}
+
+			if c.IsRunning() {
+				if err := c.Stop(); err != nil {
+					return err
+				}
+			}
+
+			return c.Delete()
+		}
+
+	default:
+		return BadRequest(fmt.Errorf("Unknown batch action: '%s'", req.Action))
+	}
+
+	progress := &operationProgress{}
+	run := func() shared.OperationResult {
+		progress.Stage(fmt.Sprintf("Running %s on %d containers", req.Action, len(containers)), int64(len(containers)))
+		result := batchRun(req.Action, containers, req.Concurrency, do, canceller, progress)
+
+		metadata, err := json.Marshal(result)
+		if err != nil {
+			return shared.OperationError(err)
+		}
+
+		return shared.OperationResult{Metadata: metadata}
+	}
+
+	resources := map[string][]string{"containers": containers}
+
+	return &asyncResponse{run: run, cancel: canceller.Cancel, resources: resources, progress: progress}
+}
+
+var batchCmd = Command{name: "batch", post: batchPost}
+
+/*
+ * cmdBatch implements "lxd batch", which runs an action on a batch of
+ * containers of the running daemon and prints how long it took, for
+ * benchmarking.
+ */
+func cmdBatch(args []string) error {
+	usage := fmt.Errorf("Usage: lxd batch <create|launch> <image> <count> [<prefix>]\n       lxd batch <start|stop> [<prefix>]\n       lxd batch delete <prefix>")
+	if len(args) < 2 {
+		return usage
+	}
+
+	action := args[1]
+	image := ""
+	count := 0
+	prefix := ""
+
+	switch action {
+	case "create", "launch":
+		if len(args) < 4 || len(args) > 5 {
+			return usage
+		}
+
+		var err error
+		image = args[2]
+		count, err = strconv.Atoi(args[3])
+		if err != nil {
+			return usage
+		}
+
+		if len(args) == 5 {
+			prefix = args[4]
+		}
+	case "start", "stop", "delete":
+		if len(args) > 3 || (action == "delete" && len(args) != 3) {
+			return usage
+		}
+
+		if len(args) == 3 {
+			prefix = args[2]
+		}
+	default:
+		return usage
+	}
+
+	c, err := lxd.NewClient(&lxd.DefaultConfig, "local")
+	if err != nil {
+		return err
+	}
+
+	result, err := c.Batch(action, prefix, image, count, *concurrency)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range result.Containers {
+		fmt.Println(name)
+	}
+
+	failed := []string{}
+	for name := range result.Failures {
+		failed = append(failed, name)
+	}
+	sort.Strings(failed)
+
+	for _, name := range failed {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", name, result.Failures[name])
+	}
+
+	fmt.Printf("%s: %d containers in %.2fs (%.2fs on average, %.2fs for the slowest)\n",
+		action, len(result.Containers), result.Duration, result.Average, result.Slowest)
+
+	if len(failed) > 0 {
+		return fmt.Errorf("The action failed on %d containers", len(failed))
+	}
+
+	return nil
+}
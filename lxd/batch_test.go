@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+func Test_batch_run_reports_successes_and_failures(t *testing.T) {
+	running := int32(0)
+	maxRunning := int32(0)
+	do := func(name string) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+				break
+			}
+		}
+
+		if name == "batch-3" {
+			return fmt.Errorf("failed")
+		}
+		return nil
+	}
+
+	containers := []string{"batch-1", "batch-2", "batch-3", "batch-4", "batch-5"}
+	result := batchRun("create", containers, 2, do, &operationCanceller{}, nil)
+
+	if len(result.Containers) != 4 || result.Containers[0] != "batch-1" {
+		t.Errorf("Wrong containers: %v", result.Containers)
+	}
+
+	if result.Failures["batch-3"] != "failed" || len(result.Failures) != 1 {
+		t.Errorf("Wrong failures: %v", result.Failures)
+	}
+
+	if maxRunning > 2 {
+		t.Errorf("%d containers were handled at once", maxRunning)
+	}
+}
+
+func Test_batch_run_stops_once_cancelled(t *testing.T) {
+	canceller := &operationCanceller{}
+	canceller.Cancel()
+
+	do := func(name string) error {
+		t.Errorf("%s was handled after the cancel", name)
+		return nil
+	}
+
+	result := batchRun("delete", []string{"batch-1", "batch-2"}, 1, do, canceller, nil)
+	if len(result.Failures) != 2 {
+		t.Errorf("Wrong failures: %v", result.Failures)
+	}
+}
@@ -1,15 +1,25 @@
 package main
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/base64"
 	"encoding/pem"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	log "gopkg.in/inconshreveable/log15.v2"
 
 	"github.com/lxc/lxd/shared"
 )
@@ -29,37 +39,124 @@ func certificatesGet(d *Daemon, r *http.Request) Response {
 			return SmartError(err)
 		}
 		for _, baseCert := range baseCerts {
-			resp := shared.CertInfo{}
-			resp.Fingerprint = baseCert.Fingerprint
-			resp.Certificate = baseCert.Certificate
-			if baseCert.Type == 1 {
-				resp.Type = "client"
-			} else {
-				resp.Type = "unknown"
-			}
-			certResponses = append(certResponses, resp)
+			certResponses = append(certResponses, certInfo(baseCert))
 		}
 		return SyncResponse(true, certResponses)
 	}
 
+	clientCerts, _ := d.clientCertsGet()
+
 	body := []string{}
-	for _, cert := range d.clientCerts {
+	for _, cert := range clientCerts {
 		fingerprint := certGenerateFingerprint(&cert)
-		body = append(body, fingerprint)
+		body = append(body, fmt.Sprintf("/%s/certificates/%s", shared.APIVersion, fingerprint))
 	}
 
 	return SyncResponse(true, body)
 }
 
 type certificatesPostBody struct {
-	Type        string `json:"type"`
-	Certificate string `json:"certificate"`
-	Name        string `json:"name"`
-	Password    string `json:"password"`
+	Type        string   `json:"type"`
+	Certificate string   `json:"certificate"`
+	Name        string   `json:"name"`
+	Password    string   `json:"password"`
+	Token       string   `json:"token"`
+	ReadOnly    bool     `json:"read_only"`
+	Restricted  bool     `json:"restricted"`
+	Containers  []string `json:"containers"`
+}
+
+type certificatePutBody struct {
+	Name       string   `json:"name"`
+	ReadOnly   bool     `json:"read_only"`
+	Restricted bool     `json:"restricted"`
+	Containers []string `json:"containers"`
+}
+
+// certLimitsValidate checks the access limits requested for a certificate.
+func certLimitsValidate(restricted bool, containers []string) error {
+	if !restricted && len(containers) > 0 {
+		return fmt.Errorf("Containers can only be set for restricted certificates")
+	}
+
+	for _, name := range containers {
+		if name == "" || strings.Contains(name, shared.SnapshotDelimiter) {
+			return fmt.Errorf("Invalid container name '%s'", name)
+		}
+	}
+
+	return nil
+}
+
+/*
+ * certLimitsCheckUpdate refuses the changes a restricted client could use
+ * to escape its containers: it can't change their profiles, their
+ * security.privileged and raw.* keys, nor add or change their disk and
+ * unix-char/unix-block devices, which give access to the host.
+ */
+func certLimitsCheckUpdate(limits *dbCertInfo, state *shared.ContainerState, config map[string]string, devices shared.Devices, profiles []string) error {
+	if limits == nil || !limits.Restricted {
+		return nil
+	}
+
+	if !reflect.DeepEqual(profiles, state.Profiles) && !(len(profiles) == 0 && len(state.Profiles) == 0) {
+		return fmt.Errorf("Restricted clients can't change the profiles of a container")
+	}
+
+	keys := map[string]bool{}
+	for key := range config {
+		keys[key] = true
+	}
+	for key := range state.Config {
+		keys[key] = true
+	}
+
+	for key := range keys {
+		if key != "security.privileged" && !strings.HasPrefix(key, "raw.") {
+			continue
+		}
+
+		if config[key] != state.Config[key] {
+			return fmt.Errorf("Restricted clients can't change %s", key)
+		}
+	}
+
+	for name, device := range devices {
+		switch device["type"] {
+		case "disk", "unix-char", "unix-block":
+		default:
+			continue
+		}
+
+		if !reflect.DeepEqual(device, state.Devices[name]) {
+			return fmt.Errorf("Restricted clients can't add or change %s devices", device["type"])
+		}
+	}
+
+	return nil
+}
+
+// clientCertsGet returns the trusted certificates and the limits of those
+// which have some.
+func (d *Daemon) clientCertsGet() ([]x509.Certificate, map[string]*dbCertInfo) {
+	d.clientCertsLock.RLock()
+	defer d.clientCertsLock.RUnlock()
+
+	return d.clientCerts, d.clientLimits
 }
 
+// readSavedClientCAList loads the trust store, replacing the certificates
+// and limits in use once it's read.
 func readSavedClientCAList(d *Daemon) {
-	d.clientCerts = []x509.Certificate{}
+	clientCerts := []x509.Certificate{}
+	clientLimits := map[string]*dbCertInfo{}
+
+	defer func() {
+		d.clientCertsLock.Lock()
+		d.clientCerts = clientCerts
+		d.clientLimits = clientLimits
+		d.clientCertsLock.Unlock()
+	}()
 
 	dbCerts, err := dbCertsGet(d.db)
 	if err != nil {
@@ -74,20 +171,289 @@ func readSavedClientCAList(d *Daemon) {
 			shared.Logf("Error reading certificate for %s: %s", dbCert.Name, err)
 			continue
 		}
-		d.clientCerts = append(d.clientCerts, *cert)
+		clientCerts = append(clientCerts, *cert)
+
+		if dbCert.ReadOnly || dbCert.Restricted {
+			clientLimits[dbCert.Fingerprint] = dbCert
+		}
+	}
+}
+
+/*
+ * trustedClientLimits returns the trust store entry limiting what the
+ * client can do, or nil if it has full access. That's the case for local
+ * clients, clients signed by the CA and clients whose certificate isn't
+ * limited. As for the trust, only the first certificate the client sent,
+ * the one it has the key of, counts.
+ */
+func (d *Daemon) trustedClientLimits(r *http.Request) *dbCertInfo {
+	if r.RemoteAddr == "@" || r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+
+	_, clientLimits := d.clientCertsGet()
+
+	return clientLimits[certGenerateFingerprint(r.TLS.PeerCertificates[0])]
+}
+
+// certContainerAllowed tells whether a client with the given limits can
+// access a container.
+func certContainerAllowed(limits *dbCertInfo, name string) bool {
+	if limits == nil || !limits.Restricted {
+		return true
 	}
+
+	return shared.StringInSlice(name, limits.Containers)
 }
 
-func saveCert(d *Daemon, host string, cert *x509.Certificate) error {
+/*
+ * clientAllowed checks a trusted client's request against the limits of its
+ * certificate. Read-only clients can only do GET requests, and restricted
+ * clients can only see the server information, their containers and the
+ * operations on those.
+ */
+func (d *Daemon) clientAllowed(r *http.Request, c Command) bool {
+	limits := d.trustedClientLimits(r)
+	if limits == nil {
+		return true
+	}
+
+	if limits.ReadOnly && r.Method != "GET" {
+		return false
+	}
+
+	// The database holds the whole server configuration and trust store
+	if strings.HasPrefix(c.name, "database/") {
+		return false
+	}
+
+	if !limits.Restricted {
+		return true
+	}
+
+	switch {
+	case c.name == "" || c.name == "containers":
+		// Container creation isn't allowed, the list is filtered
+		return r.Method == "GET"
+	case c.name == "health":
+		return true
+	case strings.HasPrefix(c.name, "containers/{name}"):
+		return certContainerAllowed(limits, mux.Vars(r)["name"])
+	case strings.HasPrefix(c.name, "operations/{id}"):
+		lock.Lock()
+		op, ok := operations[shared.OperationsURL(mux.Vars(r)["id"])]
+		lock.Unlock()
+		if !ok || op.Resources == nil {
+			return false
+		}
+
+		containers, ok := op.Resources["containers"]
+		if !ok || len(containers) == 0 {
+			return false
+		}
+
+		for _, url := range containers {
+			if !certContainerAllowed(limits, path.Base(url)) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+/*
+ * readClientCA sets up the PKI mode: when a server.ca file exists in LXD's
+ * directory, any client certificate signed by one of the CAs it contains
+ * is trusted, unless it's revoked by the server.crl list (if any).
+ */
+func readClientCA(d *Daemon) error {
+	d.clientCA = nil
+	d.clientCRL = nil
+
+	caPath := shared.VarPath("server.ca")
+	if !shared.PathExists(caPath) {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(caPath)
+	if err != nil {
+		return err
+	}
+
+	pool := x509.NewCertPool()
+	cas := []*x509.Certificate{}
+	for {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			break
+		}
+
+		ca, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("Invalid CA certificate in %s: %s", caPath, err)
+		}
+
+		pool.AddCert(ca)
+		cas = append(cas, ca)
+	}
+
+	if len(cas) == 0 {
+		return fmt.Errorf("No CA certificate found in %s", caPath)
+	}
+
+	crlPath := shared.VarPath("server.crl")
+	if shared.PathExists(crlPath) {
+		content, err := ioutil.ReadFile(crlPath)
+		if err != nil {
+			return err
+		}
+
+		crl, err := x509.ParseCRL(content)
+		if err != nil {
+			return fmt.Errorf("Invalid certificate revocation list %s: %s", crlPath, err)
+		}
+
+		signed := false
+		for _, ca := range cas {
+			if ca.CheckCRLSignature(crl) == nil {
+				signed = true
+				break
+			}
+		}
+
+		if !signed {
+			return fmt.Errorf("The certificate revocation list %s isn't signed by the CA", crlPath)
+		}
+
+		if crl.HasExpired(time.Now()) {
+			shared.Log.Warn("The certificate revocation list has expired", log.Ctx{"path": crlPath})
+		}
+
+		d.clientCRL = crl
+	}
+
+	d.clientCA = pool
+	shared.Log.Info("Trusting the client certificates signed by the CA", log.Ctx{"path": caPath})
+
+	return nil
+}
+
+// certExpired returns whether cert is past its expiry date.
+func certExpired(cert *x509.Certificate) bool {
+	return time.Now().After(cert.NotAfter)
+}
+
+/*
+ * expiredClientCert returns the certificate of the trust store which the
+ * client sent if it expired, so that it can be told why it isn't trusted
+ * anymore.
+ */
+func (d *Daemon) expiredClientCert(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+
+	cert := r.TLS.PeerCertificates[0]
+	if certExpired(cert) && d.CheckTrustState(*cert) {
+		return cert
+	}
+
+	return nil
+}
+
+/*
+ * certRevoked returns whether cert is listed in the revocation list. Serial
+ * numbers are only unique for a given issuer, so the list only applies to
+ * the certificates issued by the CA which signed it.
+ */
+func (d *Daemon) certRevoked(cert *x509.Certificate) bool {
+	if d.clientCRL == nil {
+		return false
+	}
+
+	var issuer pkix.Name
+	issuer.FillFromRDNSequence(&d.clientCRL.TBSCertList.Issuer)
+	if !certSameName(issuer, cert.Issuer) {
+		return false
+	}
+
+	for _, revoked := range d.clientCRL.TBSCertList.RevokedCertificates {
+		if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// certSameName tells whether two distinguished names are the same, whatever
+// the string types they were encoded with.
+func certSameName(a pkix.Name, b pkix.Name) bool {
+	aDer, err := asn1.Marshal(a.ToRDNSequence())
+	if err != nil {
+		return false
+	}
+
+	bDer, err := asn1.Marshal(b.ToRDNSequence())
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(aDer, bDer)
+}
+
+/*
+ * certSignedByCA returns whether cert was issued by the CA of PKI mode,
+ * intermediates being the other certificates sent by the client, which
+ * are only used to build the chain to the CA.
+ */
+func (d *Daemon) certSignedByCA(cert *x509.Certificate, intermediates []*x509.Certificate) bool {
+	if d.clientCA == nil {
+		return false
+	}
+
+	pool := x509.NewCertPool()
+	for _, intermediate := range intermediates {
+		if intermediate != cert {
+			pool.AddCert(intermediate)
+		}
+	}
+
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         d.clientCA,
+		Intermediates: pool,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	return err == nil
+}
+
+/*
+ * saveCert trusts cert from now on. With a tokenHash, the join token it
+ * matches gets used up as the certificate is added, and names it if it was
+ * created with a name.
+ */
+func saveCert(d *Daemon, host string, cert *x509.Certificate, readOnly bool, restricted bool, containers []string, tokenHash string) error {
 
 	baseCert := new(dbCertInfo)
 	baseCert.Fingerprint = certGenerateFingerprint(cert)
 	baseCert.Type = 1
 	baseCert.Name = host
+	baseCert.ReadOnly = readOnly
+	baseCert.Restricted = restricted
+	baseCert.Containers = containers
 	baseCert.Certificate = string(
 		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
 	)
 
+	if tokenHash != "" {
+		return dbCertTokenUse(d.db, tokenHash, baseCert)
+	}
+
 	return dbCertSave(d.db, baseCert)
 }
 
@@ -102,6 +468,10 @@ func certificatesPost(d *Daemon, r *http.Request) Response {
 		return BadRequest(fmt.Errorf("Unknown request type %s", req.Type))
 	}
 
+	if err := certLimitsValidate(req.Restricted, req.Containers); err != nil {
+		return BadRequest(err)
+	}
+
 	var cert *x509.Certificate
 	var name string
 	if req.Certificate != "" {
@@ -122,7 +492,8 @@ func certificatesPost(d *Daemon, r *http.Request) Response {
 		if len(r.TLS.PeerCertificates) < 1 {
 			return BadRequest(fmt.Errorf("No client certificate provided"))
 		}
-		cert = r.TLS.PeerCertificates[len(r.TLS.PeerCertificates)-1]
+		// The one the client proved it has the key of, not the CAs
+		cert = r.TLS.PeerCertificates[0]
 
 		remoteHost, _, err := net.SplitHostPort(r.RemoteAddr)
 		if err != nil {
@@ -134,35 +505,161 @@ func certificatesPost(d *Daemon, r *http.Request) Response {
 		return BadRequest(fmt.Errorf("Can't use TLS data on non-TLS link"))
 	}
 
+	clientCerts, _ := d.clientCertsGet()
+
 	fingerprint := certGenerateFingerprint(cert)
-	for _, existingCert := range d.clientCerts {
+	for _, existingCert := range clientCerts {
 		if fingerprint == certGenerateFingerprint(&existingCert) {
 			return EmptySyncResponse
 		}
 	}
 
+	tokenHash := ""
 	if !d.isTrustedClient(r) && !d.PasswordCheck(req.Password) {
+		if req.Token == "" {
+			return Forbidden
+		}
+
+		tokenHash = certTokenHash(req.Token)
+	}
+
+	err := saveCert(d, name, cert, req.ReadOnly, req.Restricted, req.Containers, tokenHash)
+	if err == NoSuchObjectError {
 		return Forbidden
+	} else if err != nil {
+		return SmartError(err)
+	}
+
+	readSavedClientCAList(d)
+
+	return EmptySyncResponse
+}
+
+var certificatesCmd = Command{
+	name:          "certificates",
+	untrustedPost: true,
+	get:           certificatesGet,
+	post:          certificatesPost,
+}
+
+// certTokenHash is what's stored in the database for a join token, so that
+// a leaked database can't be used to add certificates.
+func certTokenHash(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
+
+type certificateTokensPostBody struct {
+	Name   string `json:"name"`
+	Expiry int    `json:"expiry"`
+}
+
+// certTokenDefaultExpiry is how long a join token is valid for, in seconds,
+// when the request doesn't say otherwise.
+const certTokenDefaultExpiry = 3600
+
+func certificateTokensGet(d *Daemon, r *http.Request) Response {
+	tokens, err := dbCertTokensGet(d.db)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	if !d.isRecursionRequest(r) {
+		body := []string{}
+		for _, token := range tokens {
+			body = append(body, fmt.Sprintf("/%s/certificates/tokens/%d", shared.APIVersion, token.ID))
+		}
+
+		return SyncResponse(true, body)
+	}
+
+	body := []shared.CertTokenInfo{}
+	for _, token := range tokens {
+		body = append(body, token.info())
+	}
+
+	return SyncResponse(true, body)
+}
+
+func certificateTokensPost(d *Daemon, r *http.Request) Response {
+	req := certificateTokensPostBody{}
+
+	if err := shared.ReadToJSON(r.Body, &req); err != nil {
+		return BadRequest(err)
+	}
+
+	if req.Expiry < 0 {
+		return BadRequest(fmt.Errorf("Invalid token expiry %d", req.Expiry))
+	}
+
+	if req.Expiry == 0 {
+		req.Expiry = certTokenDefaultExpiry
+	}
+
+	token, err := shared.RandomCryptoString()
+	if err != nil {
+		return InternalError(err)
 	}
 
-	err := saveCert(d, name, cert)
+	createdAt := time.Now().UTC()
+	expiresAt := createdAt.Add(time.Duration(req.Expiry) * time.Second)
+	id, err := dbCertTokenAdd(d.db, certTokenHash(token), req.Name, expiresAt)
 	if err != nil {
 		return SmartError(err)
 	}
 
-	d.clientCerts = append(d.clientCerts, *cert)
+	return SyncResponse(true, shared.CertTokenInfo{
+		ID:        id,
+		Name:      req.Name,
+		Token:     token,
+		CreatedAt: createdAt,
+		ExpiresAt: expiresAt,
+	})
+}
+
+var certificateTokensCmd = Command{
+	name: "certificates/tokens",
+	get:  certificateTokensGet,
+	post: certificateTokensPost,
+}
+
+func certificateTokenGet(d *Daemon, r *http.Request) Response {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return NotFound
+	}
+
+	tokens, err := dbCertTokensGet(d.db)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	for _, token := range tokens {
+		if token.ID == id {
+			return SyncResponse(true, token.info())
+		}
+	}
+
+	return NotFound
+}
+
+func certificateTokenDelete(d *Daemon, r *http.Request) Response {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return NotFound
+	}
+
+	err = dbCertTokenDelete(d.db, id)
+	if err != nil {
+		return SmartError(err)
+	}
 
 	return EmptySyncResponse
 }
 
-var certificatesCmd = Command{
-	"certificates",
-	false,
-	true,
-	certificatesGet,
-	nil,
-	certificatesPost,
-	nil,
+var certificateTokenCmd = Command{
+	name:   "certificates/tokens/{id}",
+	get:    certificateTokenGet,
+	delete: certificateTokenDelete,
 }
 
 func certificateFingerprintGet(d *Daemon, r *http.Request) Response {
@@ -177,22 +674,68 @@ func certificateFingerprintGet(d *Daemon, r *http.Request) Response {
 }
 
 func doCertificateGet(d *Daemon, fingerprint string) (shared.CertInfo, error) {
-	resp := shared.CertInfo{}
-
 	dbCertInfo, err := dbCertGet(d.db, fingerprint)
 	if err != nil {
-		return resp, err
+		return shared.CertInfo{}, err
 	}
 
-	resp.Fingerprint = dbCertInfo.Fingerprint
-	resp.Certificate = dbCertInfo.Certificate
-	if dbCertInfo.Type == 1 {
+	return certInfo(dbCertInfo), nil
+}
+
+// certInfo returns the API representation of a trusted certificate.
+func certInfo(cert *dbCertInfo) shared.CertInfo {
+	resp := shared.CertInfo{}
+	resp.Fingerprint = cert.Fingerprint
+	resp.Certificate = cert.Certificate
+	resp.Name = cert.Name
+	resp.ReadOnly = cert.ReadOnly
+	resp.Restricted = cert.Restricted
+	resp.Containers = cert.Containers
+	resp.AddedAt = cert.AddedDate
+	if cert.Type == 1 {
 		resp.Type = "client"
 	} else {
 		resp.Type = "unknown"
 	}
 
-	return resp, nil
+	if block, _ := pem.Decode([]byte(cert.Certificate)); block != nil {
+		if x509Cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			resp.ExpiresAt = x509Cert.NotAfter
+			resp.Expired = certExpired(x509Cert)
+		}
+	}
+
+	return resp
+}
+
+func certificateFingerprintPut(d *Daemon, r *http.Request) Response {
+	fingerprint := mux.Vars(r)["fingerprint"]
+
+	certInfo, err := dbCertGet(d.db, fingerprint)
+	if err != nil {
+		return NotFound
+	}
+
+	req := certificatePutBody{}
+	if err := shared.ReadToJSON(r.Body, &req); err != nil {
+		return BadRequest(err)
+	}
+
+	if err := certLimitsValidate(req.Restricted, req.Containers); err != nil {
+		return BadRequest(err)
+	}
+
+	if req.Name == "" {
+		req.Name = certInfo.Name
+	}
+
+	err = dbCertUpdate(d.db, certInfo.ID, req.Name, req.ReadOnly, req.Restricted, req.Containers)
+	if err != nil {
+		return SmartError(err)
+	}
+	readSavedClientCAList(d)
+
+	return EmptySyncResponse
 }
 
 func certificateFingerprintDelete(d *Daemon, r *http.Request) Response {
@@ -213,11 +756,8 @@ func certificateFingerprintDelete(d *Daemon, r *http.Request) Response {
 }
 
 var certificateFingerprintCmd = Command{
-	"certificates/{fingerprint}",
-	false,
-	false,
-	certificateFingerprintGet,
-	nil,
-	nil,
-	certificateFingerprintDelete,
+	name:   "certificates/{fingerprint}",
+	get:    certificateFingerprintGet,
+	put:    certificateFingerprintPut,
+	delete: certificateFingerprintDelete,
 }
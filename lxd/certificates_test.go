@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+)
+
+func testCertificate(t *testing.T, serial int64, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+
+	if isCA {
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+		parent = template
+		parentKey = key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+func Test_pki_trusts_certificates_signed_by_the_ca(t *testing.T) {
+	ca, caKey := testCertificate(t, 1, true, nil, nil)
+	signed, _ := testCertificate(t, 2, false, ca, caKey)
+	revoked, _ := testCertificate(t, 3, false, ca, caKey)
+	other, _ := testCertificate(t, 2, true, nil, nil)
+
+	d := &Daemon{}
+	if d.certSignedByCA(signed, nil) {
+		t.Fatal("No certificate should be trusted outside of PKI mode")
+	}
+
+	d.clientCA = x509.NewCertPool()
+	d.clientCA.AddCert(ca)
+
+	der, err := ca.CreateCRL(rand.Reader, caKey, []pkix.RevokedCertificate{
+		{SerialNumber: revoked.SerialNumber, RevocationTime: time.Now()}},
+		time.Now(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.clientCRL, err = x509.ParseCRL(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !d.certSignedByCA(signed, nil) || d.certRevoked(signed) {
+		t.Error("A certificate signed by the CA should be trusted")
+	}
+
+	if !d.certRevoked(revoked) {
+		t.Error("A revoked certificate shouldn't be trusted")
+	}
+
+	if d.certSignedByCA(other, nil) {
+		t.Error("A certificate not signed by the CA shouldn't be trusted")
+	}
+
+	// The serial numbers of the list are those of the CA's certificates
+	otherIssuer := *revoked
+	otherIssuer.Issuer = pkix.Name{CommonName: "another CA"}
+	if d.certRevoked(&otherIssuer) {
+		t.Error("A certificate of another issuer was revoked")
+	}
+
+	// Only the certificate the client has the key of counts
+	r := &http.Request{RemoteAddr: "127.0.0.1:1234", TLS: &tls.ConnectionState{}}
+	r.TLS.PeerCertificates = []*x509.Certificate{signed, ca}
+	if !d.isTrustedClient(r) {
+		t.Error("A client with a certificate signed by the CA isn't trusted")
+	}
+
+	r.TLS.PeerCertificates = []*x509.Certificate{other, signed}
+	if d.isTrustedClient(r) {
+		t.Error("A client was trusted for a certificate it doesn't have the key of")
+	}
+
+	r.TLS.PeerCertificates = []*x509.Certificate{revoked, signed}
+	if d.isTrustedClient(r) {
+		t.Error("A client with a revoked certificate was trusted")
+	}
+}
+
+func Test_restricted_certificates_only_access_their_containers(t *testing.T) {
+	if !certContainerAllowed(nil, "c1") {
+		t.Error("A client without limits should access all containers")
+	}
+
+	readOnly := &dbCertInfo{ReadOnly: true}
+	if !certContainerAllowed(readOnly, "c1") {
+		t.Error("A read-only client should see all containers")
+	}
+
+	restricted := &dbCertInfo{Restricted: true, Containers: []string{"c1"}}
+	if !certContainerAllowed(restricted, "c1") {
+		t.Error("A restricted client should access its containers")
+	}
+
+	if certContainerAllowed(restricted, "c2") {
+		t.Error("A restricted client shouldn't access other containers")
+	}
+
+	if certContainerAllowed(&dbCertInfo{Restricted: true}, "c1") {
+		t.Error("A restricted client without containers shouldn't access any")
+	}
+
+	// Sending an unrestricted certificate along doesn't lift the limits
+	limited, _ := testCertificate(t, 1, true, nil, nil)
+	unlimited, _ := testCertificate(t, 2, true, nil, nil)
+	d := &Daemon{clientLimits: map[string]*dbCertInfo{certGenerateFingerprint(limited): restricted}}
+	r := &http.Request{RemoteAddr: "127.0.0.1:1234", TLS: &tls.ConnectionState{}}
+	r.TLS.PeerCertificates = []*x509.Certificate{limited, unlimited}
+	if d.trustedClientLimits(r) != restricted {
+		t.Error("The limits of the client's certificate weren't applied")
+	}
+}
+
+func Test_certLimitsValidate(t *testing.T) {
+	if err := certLimitsValidate(false, []string{"c1"}); err == nil {
+		t.Error("Containers were accepted for an unrestricted certificate")
+	}
+
+	if err := certLimitsValidate(true, []string{"c1/snap0"}); err == nil {
+		t.Error("A snapshot was accepted as a container")
+	}
+
+	if err := certLimitsValidate(true, []string{"c1", "c2"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_certLimitsCheckUpdate(t *testing.T) {
+	restricted := &dbCertInfo{Restricted: true, Containers: []string{"c1"}}
+	state := &shared.ContainerState{
+		Profiles: []string{"default"},
+		Config:   map[string]string{"security.privileged": "true", "limits.cpus": "1"},
+		Devices:  shared.Devices{"data": shared.Device{"type": "disk", "source": "/srv", "path": "/srv"}},
+	}
+
+	// Leaving what the admin set alone is fine
+	err := certLimitsCheckUpdate(restricted, state, map[string]string{"security.privileged": "true", "limits.cpus": "2"}, state.Devices, state.Profiles)
+	if err != nil {
+		t.Error(err)
+	}
+
+	escapes := map[string]func() error{
+		"profiles": func() error {
+			return certLimitsCheckUpdate(restricted, state, state.Config, state.Devices, []string{"default", "priv"})
+		},
+		"security.privileged": func() error {
+			return certLimitsCheckUpdate(restricted, state, map[string]string{"limits.cpus": "1"}, state.Devices, state.Profiles)
+		},
+		"raw.lxc": func() error {
+			config := map[string]string{"security.privileged": "true", "raw.lxc": "lxc.aa_profile=unconfined"}
+			return certLimitsCheckUpdate(restricted, state, config, state.Devices, state.Profiles)
+		},
+		"disk": func() error {
+			devices := shared.Devices{"data": shared.Device{"type": "disk", "source": "/", "path": "/srv"}}
+			return certLimitsCheckUpdate(restricted, state, state.Config, devices, state.Profiles)
+		},
+		"unix-block": func() error {
+			devices := shared.Devices{"sda": shared.Device{"type": "unix-block", "path": "/dev/sda"}}
+			return certLimitsCheckUpdate(restricted, state, state.Config, devices, state.Profiles)
+		},
+	}
+
+	for name, escape := range escapes {
+		if escape() == nil {
+			t.Errorf("A restricted client could change %s", name)
+		}
+	}
+
+	if err := certLimitsCheckUpdate(nil, state, nil, nil, nil); err != nil {
+		t.Errorf("A client without limits was refused: %s", err)
+	}
+}
+
+func Test_certInfo_flags_expired_certificates(t *testing.T) {
+	valid, _ := testCertificate(t, 1, true, nil, nil)
+	if certExpired(valid) {
+		t.Error("A valid certificate was reported as expired")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-2 * time.Hour),
+		NotAfter:     time.Now().Add(-time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !certExpired(expired) {
+		t.Error("An expired certificate wasn't reported as such")
+	}
+
+	added := time.Unix(1431547176, 0)
+	info := certInfo(&dbCertInfo{
+		Type:        1,
+		Name:        "old",
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		AddedDate:   added,
+	})
+
+	if !info.Expired || !info.ExpiresAt.Equal(expired.NotAfter) {
+		t.Errorf("Wrong expiry: %v (expired: %v)", info.ExpiresAt, info.Expired)
+	}
+
+	if !info.AddedAt.Equal(added) || info.Type != "client" {
+		t.Errorf("Wrong certificate info: %+v", info)
+	}
+
+	// The list of trusted certificates shows them by name
+	if info.Name != "old" {
+		t.Errorf("The certificate's name is missing: %+v", info)
+	}
+}
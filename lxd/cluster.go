@@ -0,0 +1,537 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/lxc/lxd"
+	"github.com/lxc/lxd/shared"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+/*
+ * A cluster is a set of daemons which registered with each other. Members
+ * trust each other's server certificate, pull the images they don't have
+ * from one another and create containers on each other's behalf. There's
+ * no shared state besides that: each daemon keeps its own list of members.
+ */
+
+// clusterRequestTimeout is how long a member has to answer a request.
+const clusterRequestTimeout = 30 * time.Second
+
+// clusterWaitTimeout is how long, in seconds, a member is asked to wait for
+// an operation per request, below clusterRequestTimeout.
+const clusterWaitTimeout = 20
+
+// clusterName is the name the daemon goes by in the cluster, its hostname.
+func clusterName() (string, error) {
+	return os.Hostname()
+}
+
+func clusterMemberURL(name string) string {
+	return fmt.Sprintf("/%s/cluster/members/%s", shared.APIVersion, name)
+}
+
+func (m *dbClusterMember) certificate() (*x509.Certificate, error) {
+	certBlock, _ := pem.Decode([]byte(m.Certificate))
+	if certBlock == nil {
+		return nil, fmt.Errorf("Invalid certificate for cluster member %s", m.Name)
+	}
+
+	return x509.ParseCertificate(certBlock.Bytes)
+}
+
+func (m *dbClusterMember) info() (shared.ClusterMember, error) {
+	cert, err := m.certificate()
+	if err != nil {
+		return shared.ClusterMember{}, err
+	}
+
+	return shared.ClusterMember{
+		Name:        m.Name,
+		Address:     m.Address,
+		Certificate: base64.StdEncoding.EncodeToString(cert.Raw),
+	}, nil
+}
+
+/*
+ * clusterClient returns an HTTP client for the member. The connections are
+ * made straight to the member, without going through a proxy, and fail
+ * unless it presents the certificate it was registered with. They aren't
+ * kept alive since the client only serves for one request.
+ */
+func (d *Daemon) clusterClient(member *dbClusterMember) (*http.Client, error) {
+	cert, err := member.certificate()
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := shared.GetTLSConfig(d.certf, d.keyf)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	dial := func(network string, addr string) (net.Conn, error) {
+		conn, err := tls.DialWithDialer(dialer, network, addr, config)
+		if err != nil {
+			return nil, err
+		}
+
+		peers := conn.ConnectionState().PeerCertificates
+		if len(peers) == 0 || !bytes.Equal(peers[0].Raw, cert.Raw) {
+			conn.Close()
+			return nil, fmt.Errorf("Cluster member %s presented an unexpected certificate", member.Name)
+		}
+
+		return conn, nil
+	}
+
+	transport := &http.Transport{DialTLS: dial, DisableKeepAlives: true}
+
+	return &http.Client{Transport: transport, Timeout: clusterRequestTimeout}, nil
+}
+
+// clusterDo makes an API request to the member, uri being the URL path
+// like "/1.0/containers", and returns its response, errors included.
+func (d *Daemon) clusterDo(member *dbClusterMember, method string, uri string, body io.Reader, header http.Header) (*lxd.Response, error) {
+	client, err := d.clusterClient(member)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(method, "https://"+member.Address+uri, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	req.Header.Set("User-Agent", shared.UserAgent)
+	req.Header.Set("Content-Type", "application/json")
+
+	raw, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return lxd.ParseResponse(raw)
+}
+
+// clusterRequest makes an API request to the member, failing if it returns
+// an error.
+func (d *Daemon) clusterRequest(member *dbClusterMember, method string, uri string, body interface{}) (*lxd.Response, error) {
+	buf := bytes.Buffer{}
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := d.clusterDo(member, method, uri, &buf, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Type == lxd.Error {
+		return nil, fmt.Errorf("Cluster member %s: %s", member.Name, resp.Error)
+	}
+
+	return resp, nil
+}
+
+/*
+ * clusterImageSource looks for an image the daemon doesn't have on the
+ * members of the cluster. It returns the URL of the first member having it
+ * and its full fingerprint, or sql.ErrNoRows if none has. ImageDownload
+ * checks what it gets from the member against that fingerprint.
+ */
+func clusterImageSource(d *Daemon, alias string, fingerprint string) (string, string, error) {
+	members, err := dbClusterMembersGet(d.db)
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, member := range members {
+		hash := fingerprint
+		if alias != "" {
+			resp, err := d.clusterRequest(&member, "GET", fmt.Sprintf("/%s/images/aliases/%s", shared.APIVersion, alias), nil)
+			if err != nil {
+				continue
+			}
+
+			result := shared.ImageAliasInfo{}
+			if err := json.Unmarshal(resp.Metadata, &result); err != nil {
+				continue
+			}
+			hash = result.Target
+		}
+
+		resp, err := d.clusterRequest(&member, "GET", fmt.Sprintf("/%s/images/%s", shared.APIVersion, hash), nil)
+		if err != nil {
+			continue
+		}
+
+		info := shared.ImageInfo{}
+		if err := json.Unmarshal(resp.Metadata, &info); err != nil {
+			continue
+		}
+
+		return "https://" + member.Address, info.Fingerprint, nil
+	}
+
+	return "", "", sql.ErrNoRows
+}
+
+/*
+ * clusterForward sends a container request with a "target" to the member
+ * of the cluster it names, returning nil if that's this daemon. Its
+ * response is passed along, background operations being followed by a
+ * local one which cancels them when cancelled. Only the requests exchanging
+ * JSON are forwarded, not the file transfers nor the exec websockets.
+ */
+func clusterForward(d *Daemon, c Command, r *http.Request) Response {
+	target := r.URL.Query().Get("target")
+	if target == "" || !strings.HasPrefix(c.name, "containers") {
+		return nil
+	}
+
+	name, err := clusterName()
+	if err != nil {
+		return InternalError(err)
+	}
+
+	if target == name {
+		return nil
+	}
+
+	// The member would apply none of the client's limits
+	if d.trustedClientLimits(r) != nil {
+		return Forbidden
+	}
+
+	switch c.name {
+	case "containers/{name}/exec", "containers/{name}/files", "containers/{name}/logs", "containers/{name}/logs/{file}", "containers/{name}/export":
+		return BadRequest(fmt.Errorf("%s requests can't be sent to another cluster member", r.URL.Path))
+	}
+
+	member, err := dbClusterMemberGet(d.db, target)
+	if err == sql.ErrNoRows {
+		return BadRequest(fmt.Errorf("Unknown cluster member %s", target))
+	} else if err != nil {
+		return InternalError(err)
+	}
+
+	query := r.URL.Query()
+	query.Del("target")
+	uri := r.URL.Path
+	if len(query) > 0 {
+		uri += "?" + query.Encode()
+	}
+
+	// The member checks the ETag the client got from it
+	header := http.Header{}
+	if match := r.Header.Get("If-Match"); match != "" {
+		header.Set("If-Match", match)
+	}
+
+	resp, err := d.clusterDo(member, r.Method, uri, r.Body, header)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	switch resp.Type {
+	case lxd.Error:
+		details := shared.ErrorDetails{}
+		json.Unmarshal(resp.Metadata, &details)
+		return &ErrorResponse{resp.Code, details.Code, resp.Error}
+	case lxd.Async:
+		return clusterOperation(d, member, resp)
+	}
+
+	return SyncResponse(true, resp.Metadata)
+}
+
+// clusterOperation returns an operation waiting for one of the member.
+func clusterOperation(d *Daemon, member *dbClusterMember, remote *lxd.Response) Response {
+	canceller := &operationCanceller{}
+	run := shared.OperationWrap(func() error {
+		done := canceller.OnCancel(func() {
+			d.clusterRequest(member, "DELETE", remote.Operation, nil)
+		})
+		defer done()
+
+		// A bit at a time, each request having to finish in time
+		var op *shared.Operation
+		for op == nil || !op.StatusCode.IsFinal() {
+			resp, err := d.clusterRequest(member, "GET", fmt.Sprintf("%s/wait?timeout=%d", remote.Operation, clusterWaitTimeout), nil)
+			if err != nil {
+				return err
+			}
+
+			op, err = resp.MetadataAsOperation()
+			if err != nil {
+				return err
+			}
+		}
+
+		if op.StatusCode != shared.Success {
+			err := op.GetError()
+			if err == nil {
+				err = fmt.Errorf("Operation is %s", op.Status)
+			}
+
+			return fmt.Errorf("Cluster member %s: %s", member.Name, err)
+		}
+
+		return nil
+	})
+
+	// Those are URLs, the names are expected
+	resources := make(map[string][]string)
+	for key, urls := range remote.Resources {
+		for _, url := range urls {
+			resources[key] = append(resources[key], path.Base(url))
+		}
+	}
+
+	return &asyncResponse{run: run, cancel: canceller.Cancel, resources: resources}
+}
+
+func clusterGet(d *Daemon, r *http.Request) Response {
+	name, err := clusterName()
+	if err != nil {
+		return InternalError(err)
+	}
+
+	cert, err := shared.ReadCert(d.certf)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return SyncResponse(true, shared.ClusterInfo{
+		Name:        name,
+		Certificate: base64.StdEncoding.EncodeToString(cert.Raw),
+	})
+}
+
+var clusterCmd = Command{name: "cluster", get: clusterGet}
+
+func clusterMembersGet(d *Daemon, r *http.Request) Response {
+	members, err := dbClusterMembersGet(d.db)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	recursion := d.isRecursionRequest(r)
+
+	resultString := []string{}
+	resultMap := []shared.ClusterMember{}
+	for _, member := range members {
+		if !recursion {
+			resultString = append(resultString, clusterMemberURL(member.Name))
+			continue
+		}
+
+		info, err := member.info()
+		if err != nil {
+			return InternalError(err)
+		}
+		resultMap = append(resultMap, info)
+	}
+
+	if !recursion {
+		return SyncResponse(true, resultString)
+	}
+
+	return SyncResponse(true, resultMap)
+}
+
+/*
+ * clusterMembersPost registers a member. Its certificate is added to the
+ * trust store, so that it can pull images and create containers here.
+ */
+func clusterMembersPost(d *Daemon, r *http.Request) Response {
+	req := shared.ClusterMember{}
+	if err := shared.ReadToJSON(r.Body, &req); err != nil {
+		return BadRequest(err)
+	}
+
+	if req.Name == "" || strings.Contains(req.Name, "/") {
+		return BadRequest(fmt.Errorf("Invalid cluster member name '%s'", req.Name))
+	}
+
+	addresses := httpsAddresses(req.Address)
+	if len(addresses) != 1 {
+		return BadRequest(fmt.Errorf("A cluster member needs a single address"))
+	}
+
+	data, err := base64.StdEncoding.DecodeString(req.Certificate)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	cert, err := x509.ParseCertificate(data)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	if _, err := dbClusterMemberGet(d.db, req.Name); err == nil {
+		return Conflict
+	} else if err != sql.ErrNoRows {
+		return InternalError(err)
+	}
+
+	// A certificate trusted before the join stays trusted after the member
+	// leaves
+	trusted := false
+	clientCerts, _ := d.clientCertsGet()
+	fingerprint := certGenerateFingerprint(cert)
+	for _, existingCert := range clientCerts {
+		if fingerprint == certGenerateFingerprint(&existingCert) {
+			trusted = true
+		}
+	}
+
+	member := dbClusterMember{
+		Name:    req.Name,
+		Address: addresses[0],
+		Certificate: string(
+			pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+		),
+		TrustedByJoin: !trusted,
+	}
+
+	if err := dbClusterMemberAdd(d.db, member); err != nil {
+		return InternalError(err)
+	}
+
+	if trusted {
+		return EmptySyncResponse
+	}
+
+	if err := saveCert(d, req.Name, cert, false, false, nil, ""); err != nil {
+		return InternalError(err)
+	}
+
+	readSavedClientCAList(d)
+
+	return EmptySyncResponse
+}
+
+var clusterMembersCmd = Command{name: "cluster/members", get: clusterMembersGet, post: clusterMembersPost}
+
+func clusterMemberGet(d *Daemon, r *http.Request) Response {
+	member, err := dbClusterMemberGet(d.db, mux.Vars(r)["name"])
+	if err != nil {
+		return SmartError(err)
+	}
+
+	info, err := member.info()
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return SyncResponse(true, info)
+}
+
+// clusterMemberDelete removes a member, and its certificate from the trust
+// store if its join added it there.
+func clusterMemberDelete(d *Daemon, r *http.Request) Response {
+	member, err := dbClusterMemberGet(d.db, mux.Vars(r)["name"])
+	if err != nil {
+		return SmartError(err)
+	}
+
+	if err := dbClusterMemberDelete(d.db, member.Name); err != nil {
+		return InternalError(err)
+	}
+
+	if !member.TrustedByJoin {
+		return EmptySyncResponse
+	}
+
+	cert, err := member.certificate()
+	if err != nil {
+		return InternalError(err)
+	}
+
+	err = dbCertDelete(d.db, certGenerateFingerprint(cert))
+	if err != nil && err != sql.ErrNoRows {
+		return InternalError(err)
+	}
+
+	readSavedClientCAList(d)
+
+	return EmptySyncResponse
+}
+
+var clusterMemberCmd = Command{name: "cluster/members/{name}", get: clusterMemberGet, delete: clusterMemberDelete}
+
+/*
+ * clusterContainersGet lists the containers of the whole cluster. The
+ * members are queried one after the other, those which can't be reached
+ * being reported as such rather than failing the request.
+ */
+func clusterContainersGet(d *Daemon, r *http.Request) Response {
+	name, err := clusterName()
+	if err != nil {
+		return InternalError(err)
+	}
+
+	local, err := doContainersGet(d, true, nil)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	result := shared.ClusterContainers{Containers: []shared.ClusterContainer{}, Unreachable: []string{}}
+	for _, container := range local.(shared.ContainerInfoList) {
+		result.Containers = append(result.Containers, shared.ClusterContainer{Member: name, Container: container})
+	}
+
+	members, err := dbClusterMembersGet(d.db)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	for _, member := range members {
+		containers := shared.ContainerInfoList{}
+
+		resp, err := d.clusterRequest(&member, "GET", fmt.Sprintf("/%s/containers?recursion=1", shared.APIVersion), nil)
+		if err == nil {
+			err = json.Unmarshal(resp.Metadata, &containers)
+		}
+
+		if err != nil {
+			requestLog(r).Warn("Failed to list the containers of a cluster member", log.Ctx{"member": member.Name, "err": err})
+			result.Unreachable = append(result.Unreachable, member.Name)
+			continue
+		}
+
+		for _, container := range containers {
+			result.Containers = append(result.Containers, shared.ClusterContainer{Member: member.Name, Container: container})
+		}
+	}
+
+	return SyncResponse(true, result)
+}
+
+var clusterContainersCmd = Command{name: "cluster/containers", get: clusterContainersGet}
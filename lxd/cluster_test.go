@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func Test_clusterForward_local_requests(t *testing.T) {
+	d := &Daemon{IsMock: true}
+
+	name, err := clusterName()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := map[string]Command{
+		"/1.0/containers":                containersCmd,
+		"/1.0/containers?target=" + name: containersCmd,
+		"/1.0/profiles?target=host2":     profilesCmd,
+	}
+
+	for url, c := range requests {
+		r, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp := clusterForward(d, c, r); resp != nil {
+			t.Errorf("%s was forwarded: %v", url, resp)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/lxc/lxd/shared"
+)
+
+/*
+ * serverConfigKeys, containerConfigKeys and networkConfigKeys are the
+ * configuration keys LXD knows about. They're what the server configuration is validated against
+ * and what GET /1.0/config_keys returns, so they must be kept in sync with
+ * specs/configuration.md.
+ */
+var serverConfigKeys = []shared.ConfigKeyInfo{
+	{Name: "core.https_address", Type: "string", Default: "", Description: "Address to bind for the remote API (or comma separated list of addresses)", LiveUpdate: true},
+	{Name: "core.trust_password", Type: "string", Default: "", Description: "Password to be provided by clients to setup a trust", LiveUpdate: true},
+	{Name: "core.https_allowed_origin", Type: "string", Default: "", Description: "Origins of the web pages allowed to use the API (comma separated list, or * for any)", LiveUpdate: true},
+	{Name: "core.https_allowed_methods", Type: "string", Default: "GET, POST, PUT, PATCH, DELETE, OPTIONS", Description: "Access-Control-Allow-Methods sent to the allowed origins", LiveUpdate: true},
+	{Name: "core.https_allowed_headers", Type: "string", Default: "Content-Type, If-Match", Description: "Access-Control-Allow-Headers sent to the allowed origins", LiveUpdate: true},
+	{Name: "core.https_allowed_credentials", Type: "boolean", Default: "false", Description: "Whether to let the allowed origins send credentials, such as a client certificate", LiveUpdate: true},
+	{Name: "core.debug_address", Type: "string", Default: "", Description: "Address to serve the Go profiling endpoints (/debug/pprof) on, unauthenticated and over plain HTTP", LiveUpdate: true},
+	{Name: "core.proxy_https", Type: "string", Default: "", Description: "https proxy to use for outbound connections, if any (falls back to the HTTPS_PROXY environment variable when no proxy is set)", LiveUpdate: true},
+	{Name: "core.proxy_http", Type: "string", Default: "", Description: "http proxy to use for outbound connections, if any (falls back to the HTTP_PROXY environment variable when no proxy is set)", LiveUpdate: true},
+	{Name: "core.proxy_ignore_hosts", Type: "string", Default: "", Description: "Comma separated list of hosts (or domains) for which no proxy is used", LiveUpdate: true},
+	{Name: "core.api_rate_limit", Type: "integer", Default: "", Description: "Number of requests per second each remote client is allowed on the API, no limit if unset", LiveUpdate: true},
+	{Name: "core.api_rate_burst", Type: "integer", Default: "core.api_rate_limit", Description: "Number of requests a remote client can send in a row before being limited by core.api_rate_limit", LiveUpdate: true},
+	{Name: "core.log_level", Type: "string", Default: "info", Description: "Level of the messages sent to syslog, the log file and stderr (debug, info, warn, error or crit)", LiveUpdate: true},
+	{Name: "core.shutdown_timeout", Type: "integer", Default: "300", Description: "Number of seconds to wait for the running operations to finish when the daemon is asked to exit", LiveUpdate: true},
+	{Name: "core.websocket_compression", Type: "boolean", Default: "true", Description: "Whether to compress the exec and migration control websockets when the other end supports it", LiveUpdate: true},
+	{Name: "core.metrics", Type: "boolean", Default: "false", Description: "Whether to export the daemon and container metrics on /1.0/metrics in the Prometheus text format", LiveUpdate: true},
+	{Name: "core.concurrent_image_imports", Type: "integer", Default: "", Description: "Number of images which can be downloaded from a remote at once, the others waiting as pending operations (no limit if unset)", LiveUpdate: true},
+	{Name: "core.concurrent_migrations", Type: "integer", Default: "", Description: "Number of containers which can be received from another host at once, the others waiting as pending operations (no limit if unset)", LiveUpdate: true},
+	{Name: "core.concurrent_container_creations", Type: "integer", Default: "", Description: "Number of containers which can be created from an image, a copy or nothing at once, the others waiting as pending operations (no limit if unset)", LiveUpdate: true},
+	{Name: "core.scheduler_hook", Type: "string", Default: "", Description: "Executable or http(s) URL asked whether each new container can be created, given its requested limits", LiveUpdate: true},
+	{Name: "storage.lvm_vg_name", Type: "string", Default: "", Description: "LVM Volume Group name to be used for container and image storage", LiveUpdate: true},
+	{Name: "storage.lvm_thinpool_name", Type: "string", Default: "LXDPool", Description: "LVM Thin Pool to use within the Volume Group specified in storage.lvm_vg_name", LiveUpdate: true},
+	{Name: "images.remote_cache_expiry", Type: "integer", Default: "10", Description: "Number of days after which an unused cached remote image will be flushed, and between checks of the remote aliases cached images were spawned from", LiveUpdate: true},
+}
+
+var containerConfigKeys = []shared.ConfigKeyInfo{
+	{Name: "boot.autostart", Type: "boolean", Default: "false", Description: "Always start the container when LXD starts", LiveUpdate: true},
+	{Name: "boot.autostart.delay", Type: "integer", Default: "0", Description: "Number of seconds to wait after the container started before starting the next one", LiveUpdate: true},
+	{Name: "boot.autostart.priority", Type: "integer", Default: "0", Description: "What order to start the containers in (starting with highest)", LiveUpdate: true},
+	{Name: "environment.*", Type: "string", Default: "", Description: "key/value environment variables to export to the container's init at start and set on exec", LiveUpdate: false},
+	{Name: "limits.cpu.allowance", Type: "string", Default: "", Description: "Percentage of the host's CPU time the container can use (e.g. 10%), also its share when the CPUs are contended", LiveUpdate: true},
+	{Name: "limits.cpus", Type: "integer", Default: "0", Description: "Number of CPUs to expose to the container (0 for all)", LiveUpdate: false},
+	{Name: "limits.memory", Type: "integer", Default: "0", Description: "Size in MB of the memory allocation for the container (0 for all)", LiveUpdate: false},
+	{Name: "raw.apparmor", Type: "blob", Default: "", Description: "Apparmor profile entries to be appended to the generated profile", LiveUpdate: false},
+	{Name: "raw.lxc", Type: "blob", Default: "", Description: "Raw LXC configuration to be appended to the generated one", LiveUpdate: false},
+	{Name: "security.privileged", Type: "boolean", Default: "false", Description: "Runs the container in privileged mode", LiveUpdate: false},
+	{Name: "user.*", Type: "string", Default: "", Description: "Free form user key/value storage (can be used in search)", LiveUpdate: true},
+	{Name: "volatile.<name>.hwaddr", Type: "string", Default: "", Description: "Unique MAC address for a given interface (generated and set by LXD when the hwaddr field of a nic device isn't set)", LiveUpdate: false},
+	{Name: "volatile.base_image", Type: "string", Default: "", Description: "The hash of the image the container was created from, if any", LiveUpdate: false},
+	{Name: "volatile.last_state.idmap", Type: "string", Default: "", Description: "Serialized container uid/gid map", LiveUpdate: false},
+	{Name: "volatile.last_state.power", Type: "string", Default: "", Description: "Container state as of last host shutdown", LiveUpdate: false},
+}
+
+var networkConfigKeys = []shared.ConfigKeyInfo{
+	{Name: "acl.rules", Type: "string", Default: "", Description: "Comma separated list of rules (allow|deny <source> <destination> [tcp|udp [port]]) for the traffic between the containers, the first matching one applying", LiveUpdate: true},
+	{Name: "bridge.isolation", Type: "boolean", Default: "false", Description: "Whether to drop the traffic between the containers which acl.rules doesn't allow", LiveUpdate: true},
+	{Name: "dns.domain", Type: "string", Default: "lxd", Description: "Domain the containers' names are resolved in", LiveUpdate: true},
+	{Name: "dns.mode", Type: "string", Default: "managed", Description: "DNS server of the network: managed (resolving the containers' names) or none", LiveUpdate: true},
+	{Name: "ipv4.address", Type: "string", Default: "", Description: "IPv4 address of the bridge in CIDR notation (e.g. 10.0.3.1/24), its subnet being the one of the containers (none if unset)", LiveUpdate: true},
+	{Name: "ipv4.dhcp", Type: "boolean", Default: "true", Description: "Whether to hand out addresses of the IPv4 subnet with DHCP", LiveUpdate: true},
+	{Name: "ipv4.dhcp.ranges", Type: "string", Default: "", Description: "Comma separated list of IPv4 ranges (start-end) to hand out with DHCP (all of the subnet by default)", LiveUpdate: true},
+	{Name: "ipv4.firewall", Type: "boolean", Default: "true", Description: "Whether to add the firewall rules letting the containers reach DHCP and DNS and have their traffic forwarded", LiveUpdate: true},
+	{Name: "ipv4.nat", Type: "boolean", Default: "false", Description: "Whether to masquerade the IPv4 traffic leaving the subnet", LiveUpdate: true},
+	{Name: "ipv6.address", Type: "string", Default: "", Description: "IPv6 address of the bridge in CIDR notation (e.g. fd42::1/64), its subnet being the one of the containers (none if unset)", LiveUpdate: true},
+	{Name: "ipv6.dhcp", Type: "boolean", Default: "true", Description: "Whether to send router advertisements and answer DHCPv6 requests on the IPv6 subnet", LiveUpdate: true},
+	{Name: "ipv6.dhcp.ranges", Type: "string", Default: "", Description: "Comma separated list of IPv6 ranges (start-end) to hand out with stateful DHCPv6 (all of the subnet by default)", LiveUpdate: true},
+	{Name: "ipv6.dhcp.stateful", Type: "boolean", Default: "false", Description: "Whether to hand out IPv6 addresses with DHCPv6 rather than have the containers pick theirs (SLAAC)", LiveUpdate: true},
+	{Name: "ipv6.firewall", Type: "boolean", Default: "true", Description: "Whether to add the firewall rules letting the containers reach DHCP and DNS and have their traffic forwarded", LiveUpdate: true},
+	{Name: "ipv6.nat", Type: "boolean", Default: "false", Description: "Whether to masquerade the IPv6 traffic leaving the subnet", LiveUpdate: true},
+	{Name: "tunnel.<name>.protocol", Type: "string", Default: "", Description: "Protocol of the tunnel: gre or vxlan", LiveUpdate: true},
+	{Name: "tunnel.<name>.local", Type: "string", Default: "", Description: "Local address of the tunnel (needed by GRE)", LiveUpdate: true},
+	{Name: "tunnel.<name>.remote", Type: "string", Default: "", Description: "Remote address of the tunnel (needed by GRE, unicast VXLAN otherwise multicast)", LiveUpdate: true},
+	{Name: "tunnel.<name>.group", Type: "string", Default: "239.0.0.1", Description: "Multicast group of a VXLAN tunnel without a remote", LiveUpdate: true},
+	{Name: "tunnel.<name>.interface", Type: "string", Default: "", Description: "Interface a multicast VXLAN tunnel goes through", LiveUpdate: true},
+	{Name: "tunnel.<name>.id", Type: "integer", Default: "0", Description: "VXLAN network identifier, the same on all the hosts", LiveUpdate: true},
+	{Name: "tunnel.<name>.port", Type: "integer", Default: "4789", Description: "UDP port of a VXLAN tunnel", LiveUpdate: true},
+	{Name: "tunnel.<name>.ttl", Type: "integer", Default: "", Description: "TTL of the packets of a VXLAN tunnel", LiveUpdate: true},
+}
+
+// configKeyLookup returns the description of a key, if it's in keys.
+func configKeyLookup(keys []shared.ConfigKeyInfo, name string) (shared.ConfigKeyInfo, bool) {
+	for _, key := range keys {
+		if key.Match(name) {
+			return key, true
+		}
+	}
+
+	return shared.ConfigKeyInfo{}, false
+}
+
+func configKeysGet(d *Daemon, r *http.Request) Response {
+	return SyncResponse(true, shared.ConfigKeys{
+		Server:    serverConfigKeys,
+		Container: containerConfigKeys,
+		Network:   networkConfigKeys,
+	})
+}
+
+var configKeysCmd = Command{name: "config_keys", get: configKeysGet}
@@ -18,9 +18,11 @@ import (
 	"reflect"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
+	"unicode"
 
 	"gopkg.in/flosch/pongo2.v3"
 	"gopkg.in/lxc/go-lxc.v2"
@@ -75,6 +77,26 @@ func generateMacAddr(template string) (string, error) {
 	return ret.String(), nil
 }
 
+// macMatchesTemplate tells whether a MAC address could be generated from a
+// template like 00:16:3e:xx:xx:xx.
+func macMatchesTemplate(template string, mac string) bool {
+	if len(mac) != len(template) {
+		return false
+	}
+
+	for i := range template {
+		if template[i] == 'x' {
+			if !strings.ContainsRune("0123456789abcdefABCDEF", rune(mac[i])) {
+				return false
+			}
+		} else if !strings.EqualFold(template[i:i+1], mac[i:i+1]) {
+			return false
+		}
+	}
+
+	return true
+}
+
 func containerPathGet(name string, isSnapshot bool) string {
 	if isSnapshot {
 		return shared.VarPath("snapshots", name)
@@ -93,6 +115,7 @@ type containerLXDArgs struct {
 	BaseImage    string
 	Architecture int
 	Devices      shared.Devices
+	CreationDate time.Time // Set by the database on creation.
 }
 
 type containerLXD struct {
@@ -107,6 +130,7 @@ type containerLXD struct {
 	ephemeral    bool
 	idmapset     *shared.IdmapSet
 	cType        containerType
+	creationDate time.Time
 
 	baseConfig  map[string]string
 	baseDevices shared.Devices
@@ -123,7 +147,9 @@ type container interface {
 	Stop() error
 	Unfreeze() error
 	Delete() error
-	Restore(sourceContainer container) error
+	Restore(sourceContainer container, stateful bool) error
+	Checkpoint() error
+	RestoreCheckpoint() error
 	Rename(newName string) error
 	ConfigReplace(newConfig containerLXDArgs) error
 
@@ -138,6 +164,7 @@ type container interface {
 
 	IDGet() int
 	NameGet() string
+	CreationDateGet() time.Time
 	ArchitectureGet() int
 	ConfigGet() map[string]string
 	ConfigKeySet(key string, value string) error
@@ -206,7 +233,8 @@ func containerLXDCreateFromImage(d *Daemon, name string,
 }
 
 func containerLXDCreateAsCopy(d *Daemon, name string,
-	args containerLXDArgs, sourceContainer container) (container, error) {
+	args containerLXDArgs, sourceContainer container,
+	canceller *operationCanceller) (container, error) {
 
 	c, err := containerLXDCreateInternal(d, name, args)
 	if err != nil {
@@ -218,18 +246,67 @@ func containerLXDCreateAsCopy(d *Daemon, name string,
 		return nil, err
 	}
 
-	if err := c.Storage.ContainerCopy(c, sourceContainer); err != nil {
+	if err := c.Storage.ContainerCopy(c, sourceContainer, canceller); err != nil {
 		c.Delete()
+		if canceller.Cancelled() {
+			return nil, errOperationCancelled
+		}
 		return nil, err
 	}
 
 	return c, nil
 }
 
+/*
+ * containerLXDCopySnapshots copies all the snapshots of sourceContainer over
+ * to c, keeping their names, until the canceller gets cancelled.
+ */
+func containerLXDCopySnapshots(d *Daemon, c container, sourceContainer container, canceller *operationCanceller) error {
+	snaps, err := dbContainerGetSnapshots(d.db, sourceContainer.NameGet())
+	if err != nil {
+		return err
+	}
+
+	for _, sname := range snaps {
+		if canceller.Cancelled() {
+			return errOperationCancelled
+		}
+
+		sc, err := containerLXDLoad(d, sname)
+		if err != nil {
+			return err
+		}
+
+		fields := strings.SplitN(sname, shared.SnapshotDelimiter, 2)
+		newName := c.NameGet() + shared.SnapshotDelimiter + fields[1]
+
+		config := sc.ConfigGet()
+		args := containerLXDArgs{
+			Ctype:        cTypeSnapshot,
+			Config:       config,
+			Profiles:     sc.ProfilesGet(),
+			Ephemeral:    sc.IsEphemeral(),
+			BaseImage:    config["volatile.base_image"],
+			Architecture: sc.ArchitectureGet(),
+			Devices:      sc.DevicesGet(),
+		}
+
+		if _, err := containerLXDCreateAsSnapshot(d, newName, args, sc, false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func containerLXDCreateAsSnapshot(d *Daemon, name string,
 	args containerLXDArgs, sourceContainer container,
 	stateful bool) (container, error) {
 
+	if stateful && !sourceContainer.IsRunning() {
+		return nil, fmt.Errorf("Container not running\n")
+	}
+
 	c, err := containerLXDCreateInternal(d, name, args)
 	if err != nil {
 		return nil, err
@@ -249,12 +326,8 @@ func containerLXDCreateAsSnapshot(d *Daemon, name string,
 			return nil, err
 		}
 
-		// TODO - shouldn't we freeze for the duration of rootfs snapshot below?
-		if !sourceContainer.IsRunning() {
-			c.Delete()
-			return nil, fmt.Errorf("Container not running\n")
-		}
-		opts := lxc.CheckpointOptions{Directory: stateDir, Stop: true, Verbose: true}
+		// Leave the container running once its state has been dumped
+		opts := lxc.CheckpointOptions{Directory: stateDir, Stop: false, Verbose: true}
 		source, err := sourceContainer.LXContainerGet()
 		if err != nil {
 			c.Delete()
@@ -273,9 +346,26 @@ func containerLXDCreateAsSnapshot(d *Daemon, name string,
 		}
 	}
 
+	// Asking the storage backend each time the snapshot is listed is slow
+	size, err := c.Storage.ContainerSnapshotUsage(c)
+	if err != nil {
+		shared.Log.Warn("Failed to get the snapshot's usage", log.Ctx{"snapshot": name, "err": err})
+		size = -1
+	}
+
+	if err := dbContainerSnapshotSizeSet(d.db, c.IDGet(), size); err != nil {
+		shared.Log.Warn("Failed to record the snapshot's usage", log.Ctx{"snapshot": name, "err": err})
+	}
+
 	return c, nil
 }
 
+/*
+ * validContainerName checks that name can be used for a container. It
+ * becomes the container's hostname so, past the snapshot delimiter, it must
+ * be a valid one: letters, digits and hyphens, 63 characters at most, not
+ * starting with a digit or a hyphen nor ending with a hyphen.
+ */
 func validContainerName(name string) error {
 	if strings.Contains(name, shared.SnapshotDelimiter) {
 		return fmt.Errorf(
@@ -283,6 +373,54 @@ func validContainerName(name string) error {
 			shared.SnapshotDelimiter)
 	}
 
+	if name == "" {
+		return fmt.Errorf("Container name can't be empty")
+	}
+
+	if len(name) > 63 {
+		return fmt.Errorf("Container name too long, %d characters where 63 are allowed: '%s'", len(name), name)
+	}
+
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '-' {
+			return fmt.Errorf("Invalid character %q in container name '%s', only letters, digits and hyphens are allowed", r, name)
+		}
+	}
+
+	if name[0] == '-' || (name[0] >= '0' && name[0] <= '9') {
+		return fmt.Errorf("Container name can't start with a digit or a hyphen: '%s'", name)
+	}
+
+	if strings.HasSuffix(name, "-") {
+		return fmt.Errorf("Container name can't end with a hyphen: '%s'", name)
+	}
+
+	return nil
+}
+
+/*
+ * validSnapshotName checks that name can be used for a snapshot, which is
+ * a directory of its container's.
+ */
+func validSnapshotName(name string) error {
+	if name == "" || name == "." || name == ".." {
+		return fmt.Errorf("Invalid snapshot name: '%s'", name)
+	}
+
+	if len(name) > 255 {
+		return fmt.Errorf("Snapshot name too long, %d characters where 255 are allowed: '%s'", len(name), name)
+	}
+
+	if strings.Contains(name, shared.SnapshotDelimiter) {
+		return fmt.Errorf("Invalid snapshot name, '%s' isn't allowed: '%s'", shared.SnapshotDelimiter, name)
+	}
+
+	for _, r := range name {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return fmt.Errorf("Invalid character %q in snapshot name '%s'", r, name)
+		}
+	}
+
 	return nil
 }
 
@@ -299,6 +437,12 @@ func containerLXDCreateInternal(
 		if err := validContainerName(name); err != nil {
 			return nil, err
 		}
+
+		// The personality of the 32-bit ones is set in init
+		if args.Architecture != shared.ARCH_UNKNOWN && !shared.IntInSlice(args.Architecture, d.architectures) {
+			archName, _ := shared.ArchitectureName(args.Architecture)
+			return nil, fmt.Errorf("Requested architecture isn't supported by this host: %s", archName)
+		}
 	}
 
 	path := containerPathGet(name, args.Ctype == cTypeSnapshot)
@@ -381,6 +525,8 @@ func containerLXDCreateInternal(
 		return nil, err
 	}
 
+	eventSendLifecycle(c, "created", nil)
+
 	return c, nil
 }
 
@@ -411,6 +557,7 @@ func containerLXDLoad(d *Daemon, name string) (container, error) {
 		profiles:     args.Profiles,
 		devices:      args.Devices,
 		cType:        args.Ctype,
+		creationDate: args.CreationDate,
 		baseConfig:   baseConfig,
 		baseDevices:  baseDevices}
 
@@ -497,26 +644,24 @@ func (c *containerLXD) init() error {
 		return err
 	}
 
-	for _, p := range c.profiles {
-		if err := c.applyProfile(p); err != nil {
-			return err
-		}
+	profiles, err := dbProfilesExpansion(c.daemon.db, c.profiles)
+	if err != nil {
+		return err
 	}
 
-	// base per-container config should override profile config, so we apply it second
-	if err := c.applyConfig(c.baseConfig); err != nil {
+	// The devices are copies, so that the hwaddr of the nics isn't set in
+	// baseDevices below
+	config, devices := containerExpand(profiles, c.baseConfig, c.baseDevices)
+	c.config = map[string]string{}
+	if err := c.applyConfig(config); err != nil {
 		return err
 	}
+	c.devices = devices
 
 	if err := c.setupMacAddresses(); err != nil {
 		return err
 	}
 
-	// Allow overwrites of devices
-	for k, v := range c.baseDevices {
-		c.devices[k] = v
-	}
-
 	/* now add the lxc.* entries for the configured devices */
 	if err := c.applyDevices(); err != nil {
 		return err
@@ -552,10 +697,13 @@ func (c *containerLXD) RenderState() (*shared.ContainerState, error) {
 		pid, _ := c.InitPidGet()
 		status.Init = pid
 		status.Ips = c.iPsGet()
+		status.MemoryUsage = c.cgroupItemInt("memory.usage_in_bytes")
+		status.CPUUsage = c.cgroupItemInt("cpuacct.usage")
 	}
 
 	return &shared.ContainerState{
 		Name:            c.name,
+		CreatedAt:       c.creationDate,
 		Profiles:        c.profiles,
 		Config:          c.baseConfig,
 		ExpandedConfig:  c.config,
@@ -567,6 +715,22 @@ func (c *containerLXD) RenderState() (*shared.ContainerState, error) {
 	}, nil
 }
 
+// cgroupItemInt returns the integer value of a cgroup item for a running
+// container, or -1 if it isn't available.
+func (c *containerLXD) cgroupItemInt(key string) int64 {
+	value := c.c.CgroupItem(key)
+	if len(value) != 1 {
+		return -1
+	}
+
+	result, err := strconv.ParseInt(value[0], 10, 64)
+	if err != nil {
+		return -1
+	}
+
+	return result
+}
+
 func (c *containerLXD) Start() error {
 	if c.IsRunning() {
 		return fmt.Errorf("the container is already running")
@@ -590,6 +754,19 @@ func (c *containerLXD) Start() error {
 		return err
 	}
 
+	vfs, err := c.setupSRIOV()
+	if err != nil {
+		c.StorageStop()
+		return err
+	}
+
+	// Once started, the container holds its VFs, they're off the host
+	defer func() {
+		for _, vf := range vfs {
+			sriovReleaseVF(vf)
+		}
+	}()
+
 	f, err := ioutil.TempFile("", "lxd_lxc_startconfig_")
 	if err != nil {
 		c.StorageStop()
@@ -664,6 +841,11 @@ func (c *containerLXD) Start() error {
 		return err
 	}
 
+	if err := networkUpdateHosts(c.daemon, c.name, c.devices, true); err != nil {
+		c.StorageStop()
+		return err
+	}
+
 	/* Actually start the container */
 	err = exec.Command(
 		os.Args[0],
@@ -686,6 +868,11 @@ func (c *containerLXD) Start() error {
 		containerWatchEphemeral(c.daemon, c)
 	}
 
+	if err == nil {
+		eventSendLifecycle(c, "started", nil)
+		c.daemon.cpuScheduleTrigger()
+	}
+
 	return err
 }
 
@@ -694,7 +881,12 @@ func (c *containerLXD) Reboot() error {
 }
 
 func (c *containerLXD) Freeze() error {
-	return c.c.Freeze()
+	if err := c.c.Freeze(); err != nil {
+		return err
+	}
+
+	eventSendLifecycle(c, "paused", nil)
+	return nil
 }
 
 func (c *containerLXD) IsPrivileged() bool {
@@ -718,6 +910,16 @@ func (c *containerLXD) Shutdown(timeout time.Duration) error {
 		return err
 	}
 
+	// Its name is only in the DNS of the managed networks while it runs
+	if err := networkUpdateHosts(c.daemon, c.name, c.devices, false); err != nil {
+		shared.Log.Error("Failed to unregister the container's name", log.Ctx{"container": c.name, "err": err})
+	} else if err := networkReleaseLeases(c.daemon, c.devices); err != nil {
+		shared.Log.Error("Failed to release the container's leases", log.Ctx{"container": c.name, "err": err})
+	}
+
+	eventSendLifecycle(c, "stopped", nil)
+	c.daemon.cpuScheduleTrigger()
+
 	// Stop the storage for this container
 	if err := c.StorageStop(); err != nil {
 		return err
@@ -737,6 +939,16 @@ func (c *containerLXD) Stop() error {
 		return err
 	}
 
+	// Its name is only in the DNS of the managed networks while it runs
+	if err := networkUpdateHosts(c.daemon, c.name, c.devices, false); err != nil {
+		shared.Log.Error("Failed to unregister the container's name", log.Ctx{"container": c.name, "err": err})
+	} else if err := networkReleaseLeases(c.daemon, c.devices); err != nil {
+		shared.Log.Error("Failed to release the container's leases", log.Ctx{"container": c.name, "err": err})
+	}
+
+	eventSendLifecycle(c, "stopped", nil)
+	c.daemon.cpuScheduleTrigger()
+
 	// Stop the storage for this container
 	if err := c.StorageStop(); err != nil {
 		return err
@@ -750,7 +962,12 @@ func (c *containerLXD) Stop() error {
 }
 
 func (c *containerLXD) Unfreeze() error {
-	return c.c.Unfreeze()
+	if err := c.c.Unfreeze(); err != nil {
+		return err
+	}
+
+	eventSendLifecycle(c, "resumed", nil)
+	return nil
 }
 
 func (c *containerLXD) StorageFromImage(hash string) error {
@@ -773,16 +990,20 @@ func (c *containerLXD) StorageGet() storage {
 	return c.Storage
 }
 
-func (c *containerLXD) Restore(sourceContainer container) error {
+func (c *containerLXD) Restore(sourceContainer container, stateful bool) error {
 	/*
 	 * restore steps:
 	 * 1. stop container if already running
 	 * 2. copy snapshot rootfs to container
 	 * 3. overwrite existing config with snapshot config
+	 * 4. restore the running state if asked to
 	 */
 
+	if stateful && !shared.PathExists(sourceContainer.StateDirGet()) {
+		return fmt.Errorf("snapshot %s has no running state", sourceContainer.NameGet())
+	}
+
 	// Stop the container
-	// TODO: stateful restore ?
 	wasRunning := false
 	if c.IsRunning() {
 		wasRunning = true
@@ -830,6 +1051,12 @@ func (c *containerLXD) Restore(sourceContainer container) error {
 		return err
 	}
 
+	eventSendLifecycle(c, "restored", shared.Jmap{"snapshot": sourceContainer.NameGet()})
+
+	if stateful {
+		return c.startFromState(sourceContainer.StateDirGet())
+	}
+
 	if wasRunning {
 		c.Start()
 	}
@@ -837,6 +1064,106 @@ func (c *containerLXD) Restore(sourceContainer container) error {
 	return nil
 }
 
+/*
+ * Checkpoint dumps the running state of the container into its state
+ * directory and stops it, so that RestoreCheckpoint can bring it back as
+ * it was.
+ */
+func (c *containerLXD) Checkpoint() error {
+	stateDir := c.StateDirGet()
+	os.RemoveAll(stateDir)
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return err
+	}
+
+	opts := lxc.CheckpointOptions{Directory: stateDir, Stop: true, Verbose: true}
+	err := c.c.Checkpoint(opts)
+	err2 := migration.CollectCRIULogFile(c.c, stateDir, "checkpoint", "dump")
+	if err2 != nil {
+		shared.Log.Warn("failed to collect criu log file", log.Ctx{"error": err2})
+	}
+
+	if err != nil {
+		os.RemoveAll(stateDir)
+		return err
+	}
+
+	eventSendLifecycle(c, "stopped", nil)
+	c.daemon.cpuScheduleTrigger()
+
+	if err := c.StorageStop(); err != nil {
+		return err
+	}
+
+	return AAUnloadProfile(c)
+}
+
+// RestoreCheckpoint starts the container again from the state dumped by
+// Checkpoint, which is removed either way.
+func (c *containerLXD) RestoreCheckpoint() error {
+	stateDir := c.StateDirGet()
+	if !shared.PathExists(stateDir) {
+		return fmt.Errorf("Container %s has no checkpoint", c.name)
+	}
+	defer os.RemoveAll(stateDir)
+
+	if err := c.startFromState(stateDir); err != nil {
+		return err
+	}
+
+	eventSendLifecycle(c, "started", nil)
+	return nil
+}
+
+/*
+ * startFromState brings the container back up from a CRIU dump, the same way
+ * live migration does on the receiving end.
+ */
+func (c *containerLXD) startFromState(stateDir string) error {
+	if err := c.StorageStart(); err != nil {
+		return err
+	}
+
+	if err := AALoadProfile(c); err != nil {
+		c.StorageStop()
+		return err
+	}
+
+	f, err := ioutil.TempFile("", "lxd_lxc_restoreconfig_")
+	if err != nil {
+		c.StorageStop()
+		return err
+	}
+	configPath := f.Name()
+	if err = f.Chmod(0600); err != nil {
+		f.Close()
+		os.Remove(configPath)
+		c.StorageStop()
+		return err
+	}
+	f.Close()
+
+	if err := c.c.SaveConfigFile(configPath); err != nil {
+		os.Remove(configPath)
+		c.StorageStop()
+		return err
+	}
+
+	err = exec.Command(
+		os.Args[0],
+		"forkmigrate",
+		c.name,
+		c.daemon.lxcpath,
+		configPath,
+		stateDir).Run()
+	if err != nil {
+		c.StorageStop()
+		return fmt.Errorf("restore failed:\n%s", migration.GetCRIULogErrors(stateDir, "restore"))
+	}
+
+	return nil
+}
+
 func (c *containerLXD) Delete() error {
 	shared.Log.Debug("containerLXD.Delete", log.Ctx{"c.name": c.NameGet(), "type": c.cType})
 
@@ -861,9 +1188,17 @@ func (c *containerLXD) Delete() error {
 		return err
 	}
 
+	if c.cType == cTypeRegular {
+		if err := networkClearHosts(c.daemon, c.NameGet()); err != nil {
+			return err
+		}
+	}
+
 	AADeleteProfile(c)
 	SeccompDeleteProfile(c)
 
+	eventSendLifecycle(c, "deleted", nil)
+
 	return nil
 }
 
@@ -886,6 +1221,13 @@ func (c *containerLXD) Rename(newName string) error {
 		return err
 	}
 
+	// The dhcp-host entries are written again on the next start
+	if !c.IsSnapshot() {
+		if err := networkClearHosts(c.daemon, c.NameGet()); err != nil {
+			return err
+		}
+	}
+
 	results, err := dbContainerGetSnapshots(c.daemon.db, c.NameGet())
 	if err != nil {
 		return err
@@ -909,12 +1251,15 @@ func (c *containerLXD) Rename(newName string) error {
 		}
 	}
 
+	oldName := c.name
 	c.name = newName
 
 	// Recreate the LX Container
 	c.c = nil
 	c.init()
 
+	eventSendLifecycle(c, "renamed", shared.Jmap{"old_name": oldName})
+
 	return nil
 }
 
@@ -934,6 +1279,10 @@ func (c *containerLXD) NameGet() string {
 	return c.name
 }
 
+func (c *containerLXD) CreationDateGet() time.Time {
+	return c.creationDate
+}
+
 func (c *containerLXD) ArchitectureGet() int {
 	return c.architecture
 }
@@ -1032,105 +1381,141 @@ func (c *containerLXD) ConfigReplace(newContainerArgs containerLXDArgs) error {
 		return err
 	}
 
-	if err := c.applyConfig(newContainerArgs.Config); err != nil {
+	if err := c.keepMacAddresses(&newContainerArgs); err != nil {
 		return err
 	}
 
-	tx, err := dbBegin(c.daemon.db)
-	if err != nil {
+	if err := c.applyConfig(newContainerArgs.Config); err != nil {
 		return err
 	}
 
-	/* Update config or profiles */
-	if err = dbContainerConfigClear(tx, c.id); err != nil {
-		shared.Log.Debug(
-			"Error clearing configuration for container",
-			log.Ctx{"name": c.NameGet()})
-		tx.Rollback()
-		return err
-	}
+	running := c.IsRunning()
+	err := dbTx(c.daemon.db, func(tx *sql.Tx) error {
+		/* Update config or profiles */
+		if err := dbContainerConfigClear(tx, c.id); err != nil {
+			shared.Log.Debug(
+				"Error clearing configuration for container",
+				log.Ctx{"name": c.NameGet()})
+			return err
+		}
 
-	if err = dbContainerConfigInsert(tx, c.id, newContainerArgs.Config); err != nil {
-		shared.Debugf("Error inserting configuration for container %s", c.NameGet())
-		tx.Rollback()
-		return err
-	}
+		if err := dbContainerConfigInsert(tx, c.id, newContainerArgs.Config); err != nil {
+			shared.Debugf("Error inserting configuration for container %s", c.NameGet())
+			return err
+		}
+
+		/* handle profiles */
+		if emptyProfile(newContainerArgs.Profiles) {
+			_, err := tx.Exec("DELETE from containers_profiles where container_id=?", c.id)
+			if err != nil {
+				return err
+			}
+		} else {
+			if err := dbContainerProfilesInsert(tx, c.id, newContainerArgs.Profiles); err != nil {
+				return err
+			}
+		}
 
-	/* handle profiles */
-	if emptyProfile(newContainerArgs.Profiles) {
-		_, err := tx.Exec("DELETE from containers_profiles where container_id=?", c.id)
+		err := dbDevicesAdd(tx, "container", int64(c.id), newContainerArgs.Devices)
 		if err != nil {
-			tx.Rollback()
 			return err
 		}
-	} else {
-		if err := dbContainerProfilesInsert(tx, c.id, newContainerArgs.Profiles); err != nil {
 
-			tx.Rollback()
+		if err := c.applyPostDeviceConfig(); err != nil {
 			return err
 		}
-	}
 
-	err = dbDevicesAdd(tx, "container", int64(c.id), newContainerArgs.Devices)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
+		c.baseConfig = newContainerArgs.Config
+		c.baseDevices = newContainerArgs.Devices
+
+		/* Let's try to load the apparmor profile again, in case the
+		 * raw.apparmor config was changed (or deleted). Make sure we do
+		 * this before commit, in case it fails because the user screwed
+		 * something up so we can roll back and not hose their container.
+		 *
+		 * For containers that aren't running, we just want to parse the
+		 * new profile; this is because this code is called during the
+		 * start process after the profile is loaded but before the
+		 * container starts, which will cause a container start to fail.
+		 * If the container is running, we /do/ want to reload the
+		 * profile, because we want the changes to take effect
+		 * immediately.
+		 */
+		if !running {
+			AAParseProfile(c)
+			return nil
+		}
 
-	if err := c.applyPostDeviceConfig(); err != nil {
+		return AALoadProfile(c)
+	})
+	if err != nil || !running {
 		return err
 	}
 
-	c.baseConfig = newContainerArgs.Config
-	c.baseDevices = newContainerArgs.Devices
-
-	/* Let's try to load the apparmor profile again, in case the
-	 * raw.apparmor config was changed (or deleted). Make sure we do this
-	 * before commit, in case it fails because the user screwed something
-	 * up so we can roll back and not hose their container.
-	 *
-	 * For containers that aren't running, we just want to parse the new
-	 * profile; this is because this code is called during the start
-	 * process after the profile is loaded but before the container starts,
-	 * which will cause a container start to fail. If the container is
-	 * running, we /do/ want to reload the profile, because we want the
-	 * changes to take effect immediately.
-	 */
-	if !c.IsRunning() {
-		AAParseProfile(c)
-		return txCommit(tx)
+	profiles := []shared.ProfileConfig{}
+	if !emptyProfile(newContainerArgs.Profiles) {
+		profiles, err = dbProfilesExpansion(c.daemon.db, newContainerArgs.Profiles)
+		if err != nil {
+			return err
+		}
 	}
 
-	if err := AALoadProfile(c); err != nil {
-		tx.Rollback()
+	// The nics only differing by their generated hwaddr are left alone
+	_, expandedDevices := containerExpand(profiles, newContainerArgs.Config, newContainerArgs.Devices)
+	postDevList := shared.Devices{}
+	postDevList.ExtendFromProfile(preDevList, expandedDevices)
+
+	err = dbTx(c.daemon.db, func(tx *sql.Tx) error {
+		return devicesApplyDeltaLive(tx, c, preDevList, postDevList)
+	})
+	if err != nil {
 		return err
 	}
 
-	if err := txCommit(tx); err != nil {
-		return err
+	c.daemon.cpuScheduleTrigger()
+	return nil
+}
+
+/*
+ * keepMacAddresses carries the generated MAC addresses (the
+ * volatile.<name>.hwaddr keys) over to a new configuration lacking them,
+ * as long as their nic is still there, so that they survive the updates
+ * of clients which don't send the volatile keys back.
+ */
+func (c *containerLXD) keepMacAddresses(args *containerLXDArgs) error {
+	nics := []string{}
+	for name, d := range args.Devices {
+		if d["type"] == "nic" {
+			nics = append(nics, name)
+		}
 	}
 
-	// add devices from new profile list to the desired goal set
-	for _, p := range c.profiles {
-		profileDevs, err := dbDevicesGet(c.daemon.db, p, true)
+	for _, p := range args.Profiles {
+		devices, err := dbDevicesGet(c.daemon.db, p, true)
 		if err != nil {
-			return fmt.Errorf("Error reading devices from profile '%s': %v", p, err)
+			return err
 		}
 
-		newContainerArgs.Devices.ExtendFromProfile(preDevList, profileDevs)
+		for name, d := range devices {
+			if d["type"] == "nic" {
+				nics = append(nics, name)
+			}
+		}
 	}
 
-	tx, err = dbBegin(c.daemon.db)
-	if err != nil {
-		return err
+	if args.Config == nil {
+		args.Config = map[string]string{}
 	}
 
-	if err := devicesApplyDeltaLive(tx, c, preDevList, newContainerArgs.Devices); err != nil {
-		return err
-	}
+	for key, value := range c.baseConfig {
+		name, err := extractInterfaceFromConfigName(key)
+		if err != nil || !shared.StringInSlice(name, nics) {
+			continue
+		}
 
-	if err := txCommit(tx); err != nil {
-		return err
+		if _, ok := args.Config[key]; !ok {
+			args.Config[key] = value
+		}
 	}
 
 	return nil
@@ -1412,42 +1797,107 @@ func (c *containerLXD) AttachMount(m shared.Device) error {
 	return nil
 }
 
+/*
+ * limitCgroupItem returns the cgroup item a limits.* key sets and its
+ * value, or an empty item for the other keys.
+ */
+func limitCgroupItem(key string, value string) (string, string, error) {
+	switch key {
+	case "limits.cpus":
+		// TODO - Come up with a way to choose cpus for multiple
+		// containers
+		var vint int
+		count, err := fmt.Sscanf(value, "%d", &vint)
+		if err != nil {
+			return "", "", err
+		}
+		if count != 1 || vint < 0 || vint > 65000 {
+			return "", "", fmt.Errorf("Bad cpu limit: %s\n", value)
+		}
+		return "cpuset.cpus", fmt.Sprintf("0-%d", vint-1), nil
+	case "limits.memory":
+		return "memory.limit_in_bytes", value, nil
+	}
+
+	return "", "", nil
+}
+
 func (c *containerLXD) applyConfig(config map[string]string) error {
-	var err error
 	for k, v := range config {
-		switch k {
-		case "limits.cpus":
-			// TODO - Come up with a way to choose cpus for multiple
-			// containers
-			var vint int
-			count, err := fmt.Sscanf(v, "%d", &vint)
-			if err != nil {
-				return err
-			}
-			if count != 1 || vint < 0 || vint > 65000 {
-				return fmt.Errorf("Bad cpu limit: %s\n", v)
-			}
-			cpuset := fmt.Sprintf("0-%d", vint-1)
-			err = c.c.SetConfigItem("lxc.cgroup.cpuset.cpus", cpuset)
-		case "limits.memory":
-			err = c.c.SetConfigItem("lxc.cgroup.memory.limit_in_bytes", v)
-
-		default:
-			if strings.HasPrefix(k, "environment.") {
-				c.c.SetConfigItem("lxc.environment", fmt.Sprintf("%s=%s", strings.TrimPrefix(k, "environment."), v))
+		item, value, err := limitCgroupItem(k, v)
+		if err == nil && item != "" {
+			err = c.c.SetConfigItem("lxc.cgroup."+item, value)
+		} else if k == "limits.cpu.allowance" {
+			// Set by cpuSchedule once the container runs
+			_, err = cpuAllowanceParse(v)
+		} else if strings.HasPrefix(k, "environment.") {
+			// Exported to init, and so to the whole container, at start
+			if strings.ContainsAny(v, "\n\x00") {
+				err = fmt.Errorf("Environment variables can't contain newlines or NUL characters")
+			} else {
+				err = c.c.SetConfigItem("lxc.environment", fmt.Sprintf("%s=%s", strings.TrimPrefix(k, "environment."), v))
 			}
-
-			/* Things like security.privileged need to be propagated */
-			c.config[k] = v
 		}
+
 		if err != nil {
 			shared.Debugf("Error setting %s: %q", k, err)
 			return err
 		}
+
+		/* Things like security.privileged need to be propagated */
+		c.config[k] = v
 	}
 	return nil
 }
 
+/*
+ * containerApplyConfigLive applies the changes from oldConfig to the
+ * current expanded config of a running container: the keys which can be
+ * updated live are left alone and the limits set in its cgroups. It
+ * returns whether the container needs a restart for the others.
+ */
+func containerApplyConfigLive(c container, oldConfig map[string]string) bool {
+	newConfig := c.ConfigGet()
+
+	keys := []string{}
+	for k := range oldConfig {
+		keys = append(keys, k)
+	}
+	for k := range newConfig {
+		keys = append(keys, k)
+	}
+
+	restart := false
+	for _, k := range keys {
+		if oldConfig[k] == newConfig[k] {
+			continue
+		}
+
+		if info, ok := configKeyLookup(containerConfigKeys, k); ok && info.LiveUpdate {
+			continue
+		}
+
+		// Unset limits would need the host's defaults to be known
+		item, value, err := limitCgroupItem(k, newConfig[k])
+		if err == nil && item != "" && newConfig[k] != "" {
+			lxContainer, err := c.LXContainerGet()
+			if err == nil {
+				err = lxContainer.SetCgroupItem(item, value)
+			}
+
+			if err == nil {
+				continue
+			}
+
+			shared.Log.Warn("Failed to set a limit live", log.Ctx{"container": c.NameGet(), "key": k, "err": err})
+		}
+
+		restart = true
+	}
+
+	return restart
+}
+
 func (c *containerLXD) applyPostDeviceConfig() error {
 	// applies config that must be delayed until after devices are
 	// instantiated, see bug #588 and fix #635
@@ -1477,105 +1927,61 @@ func (c *containerLXD) applyPostDeviceConfig() error {
 	return nil
 }
 
-func (c *containerLXD) applyProfile(p string) error {
-	q := `SELECT key, value FROM profiles_config
-		JOIN profiles ON profiles.id=profiles_config.profile_id
-		WHERE profiles.name=?`
-	var k, v string
-	inargs := []interface{}{p}
-	outfmt := []interface{}{k, v}
-	result, err := dbQueryScan(c.daemon.db, q, inargs, outfmt)
-
+func (c *containerLXD) updateContainerHWAddr(k, v string) {
+	name, err := extractInterfaceFromConfigName(k)
 	if err != nil {
-		return err
+		return
 	}
 
-	config := map[string]string{}
-	for _, r := range result {
-		k = r[0].(string)
-		v = r[1].(string)
-
-		config[k] = v
-	}
-
-	newdevs, err := dbDevicesGet(c.daemon.db, p, true)
-	if err != nil {
-		return err
-	}
-	for k, v := range newdevs {
-		c.devices[k] = v
+	if d, ok := c.devices[name]; ok && d["type"] == "nic" {
+		d["hwaddr"] = v
 	}
 
-	return c.applyConfig(config)
-}
-
-func (c *containerLXD) updateContainerHWAddr(k, v string) {
-	for name, d := range c.devices {
-		if d["type"] != "nic" {
-			continue
-		}
-
-		for key := range c.config {
-			device, err := extractInterfaceFromConfigName(key)
-			if err == nil && device == name {
-				d["hwaddr"] = v
-				c.config[key] = v
-				return
-			}
-		}
-	}
+	c.config[k] = v
+	c.baseConfig[k] = v
 }
 
 func (c *containerLXD) setupMacAddresses() error {
 	newConfigEntries := map[string]string{}
+	templates := map[string]string{}
 
 	for name, d := range c.devices {
-		if d["type"] != "nic" {
+		// Physical nics keep their own address
+		if d["type"] != "nic" || d["nictype"] == "physical" {
 			continue
 		}
 
-		found := false
-
-		for key, val := range c.config {
-			device, err := extractInterfaceFromConfigName(key)
-			if err == nil && device == name {
-				found = true
-				d["hwaddr"] = val
-			}
+		// An explicit address is used as is
+		if d["hwaddr"] != "" && !strings.Contains(d["hwaddr"], "x") {
+			continue
 		}
 
-		if !found {
-			var hwaddr string
-			var err error
-			if d["hwaddr"] != "" {
-				hwaddr, err = generateMacAddr(d["hwaddr"])
-				if err != nil {
-					return err
-				}
-			} else {
-				hwaddr, err = generateMacAddr("00:16:3e:xx:xx:xx")
-				if err != nil {
-					return err
-				}
-			}
-
-			if hwaddr != d["hwaddr"] {
-				d["hwaddr"] = hwaddr
-				key := fmt.Sprintf("volatile.%s.hwaddr", name)
-				c.config[key] = hwaddr
-				c.baseConfig[key] = hwaddr
-				newConfigEntries[key] = hwaddr
-			}
+		template := d["hwaddr"]
+		if template == "" {
+			template = "00:16:3e:xx:xx:xx"
 		}
-	}
 
-	if len(newConfigEntries) > 0 {
+		// The one generated earlier is kept, unless the template changed
+		key := fmt.Sprintf("volatile.%s.hwaddr", name)
+		if macMatchesTemplate(template, c.config[key]) {
+			d["hwaddr"] = c.config[key]
+			continue
+		}
 
-		tx, err := dbBegin(c.daemon.db)
+		hwaddr, err := generateMacAddr(template)
 		if err != nil {
 			return err
 		}
 
+		d["hwaddr"] = hwaddr
+		c.config[key] = hwaddr
+		c.baseConfig[key] = hwaddr
+		newConfigEntries[key] = hwaddr
+		templates[key] = template
+	}
+
+	if len(newConfigEntries) > 0 {
+
 		/*
 		 * My logic may be flawed here, but it seems to me that one of
 		 * the following must be true:
@@ -1584,63 +1990,59 @@ func (c *containerLXD) setupMacAddresses() error {
 		 * 2. The current database entry is different from what we had
 		 *    stored.  Someone updated it since we last grabbed the
 		 *    container configuration.  So either
-		 *    a. it contains 'x' and is a template.  We have generated
-		 *       a real mac, so our update takes precedence
-		 *    b. it contains no 'x' and is an hwaddr, not template.  We
-		 *       defer to the racer's update since it may be actually
-		 *       starting the container.
+		 *    a. it doesn't match the device's template (it was made
+		 *       from an older one).  Our update takes precedence
+		 *    b. it matches the template.  We defer to the racer's
+		 *       update since it may be actually starting the
+		 *       container.
 		 */
-		str := "INSERT INTO containers_config (container_id, key, value) values (?, ?, ?)"
-		stmt, err := tx.Prepare(str)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-		defer stmt.Close()
-
-		ustr := "UPDATE containers_config SET value=? WHERE container_id=? AND key=?"
-		ustmt, err := tx.Prepare(ustr)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-		defer ustmt.Close()
+		err := dbTx(c.daemon.db, func(tx *sql.Tx) error {
+			str := "INSERT INTO containers_config (container_id, key, value) values (?, ?, ?)"
+			stmt, err := tx.Prepare(str)
+			if err != nil {
+				return err
+			}
+			defer stmt.Close()
 
-		qstr := "SELECT value FROM containers_config WHERE container_id=? AND key=?"
-		qstmt, err := tx.Prepare(qstr)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-		defer qstmt.Close()
+			ustr := "UPDATE containers_config SET value=? WHERE container_id=? AND key=?"
+			ustmt, err := tx.Prepare(ustr)
+			if err != nil {
+				return err
+			}
+			defer ustmt.Close()
 
-		for k, v := range newConfigEntries {
-			var racer string
-			err := qstmt.QueryRow(c.id, k).Scan(&racer)
-			if err == sql.ErrNoRows {
-				_, err = stmt.Exec(c.id, k, v)
-				if err != nil {
-					shared.Debugf("Error adding mac address to container")
-					tx.Rollback()
-					return err
-				}
-			} else if err != nil {
-				tx.Rollback()
+			qstr := "SELECT value FROM containers_config WHERE container_id=? AND key=?"
+			qstmt, err := tx.Prepare(qstr)
+			if err != nil {
 				return err
-			} else if strings.Contains(racer, "x") {
-				_, err = ustmt.Exec(v, c.id, k)
-				if err != nil {
-					shared.Debugf("Error updating mac address to container")
-					tx.Rollback()
+			}
+			defer qstmt.Close()
+
+			for k, v := range newConfigEntries {
+				var racer string
+				err := qstmt.QueryRow(c.id, k).Scan(&racer)
+				if err == sql.ErrNoRows {
+					_, err = stmt.Exec(c.id, k, v)
+					if err != nil {
+						shared.Debugf("Error adding mac address to container")
+						return err
+					}
+				} else if err != nil {
 					return err
+				} else if !macMatchesTemplate(templates[k], racer) {
+					_, err = ustmt.Exec(v, c.id, k)
+					if err != nil {
+						shared.Debugf("Error updating mac address to container")
+						return err
+					}
+				} else {
+					// we accept the racing task's update
+					c.updateContainerHWAddr(k, racer)
 				}
-			} else {
-				// we accept the racing task's update
-				c.updateContainerHWAddr(k, v)
 			}
-		}
 
-		err = txCommit(tx)
+			return nil
+		})
 		if err != nil {
 			fmt.Printf("setupMacAddresses: (TxCommit) error %s\n", err)
 		}
@@ -1677,10 +2079,16 @@ func (c *containerLXD) applyDevices() error {
 			continue
 		}
 
-		configs, err := deviceToLxc(d)
+		// Their VF is only allocated as the container starts
+		if d["type"] == "nic" && d["nictype"] == "sriov" {
+			continue
+		}
+
+		configs, err := c.deviceConfig(d)
 		if err != nil {
 			return fmt.Errorf("Failed configuring device %s: %s\n", name, err)
 		}
+
 		for _, line := range configs {
 			err := c.c.SetConfigItem(line[0], line[1])
 			if err != nil {
@@ -1691,6 +2099,85 @@ func (c *containerLXD) applyDevices() error {
 	return nil
 }
 
+// deviceConfig returns the lxc.* entries of a device.
+func (c *containerLXD) deviceConfig(d shared.Device) ([][]string, error) {
+	configs, err := deviceToLxc(d)
+	if err != nil {
+		return nil, err
+	}
+
+	// On managed networks, the addresses of bridged nics are DHCP
+	// reservations
+	if d["type"] == "nic" && d["parent"] != "" {
+		_, err := dbNetworkIDGet(c.daemon.db, d["parent"])
+		if err == NoSuchObjectError || !networkBridged(d) {
+			if d["ipv4.address"] != "" {
+				configs = append(configs, []string{"lxc.network.ipv4", d["ipv4.address"]})
+			}
+			if d["ipv6.address"] != "" {
+				configs = append(configs, []string{"lxc.network.ipv6", d["ipv6.address"]})
+			}
+		}
+	}
+
+	return configs, nil
+}
+
+/*
+ * setupSRIOV allocates a free VF of their parent to each of the sriov nics
+ * and adds them to the container's configuration, as physical nics. It
+ * returns the VFs, which are to be released once the container started.
+ */
+func (c *containerLXD) setupSRIOV() (vfs []string, err error) {
+	var keys []string
+	for k := range c.devices {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	defer func() {
+		if err != nil {
+			for _, vf := range vfs {
+				sriovReleaseVF(vf)
+			}
+			vfs = nil
+		}
+	}()
+
+	for _, name := range keys {
+		d := c.devices[name]
+		if d["type"] != "nic" || d["nictype"] != "sriov" {
+			continue
+		}
+
+		vf, err := setupSRIOVNic(d)
+		if err != nil {
+			return vfs, fmt.Errorf("Failed configuring device %s: %s\n", name, err)
+		}
+		vfs = append(vfs, vf)
+
+		physical := shared.Device{}
+		for k, v := range d {
+			physical[k] = v
+		}
+		physical["nictype"] = "physical"
+		physical["parent"] = vf
+
+		configs, err := c.deviceConfig(physical)
+		if err != nil {
+			return vfs, fmt.Errorf("Failed configuring device %s: %s\n", name, err)
+		}
+
+		for _, line := range configs {
+			if err := c.c.SetConfigItem(line[0], line[1]); err != nil {
+				return vfs, fmt.Errorf("Failed configuring device %s: %s\n", name, err)
+			}
+		}
+	}
+
+	return vfs, nil
+}
+
 func (c *containerLXD) iPsGet() []shared.Ip {
 	ips := []shared.Ip{}
 	names, err := c.c.Interfaces()
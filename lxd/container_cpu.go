@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// The CFS period the quotas of limits.cpu.allowance are computed for
+const cpuPeriod = 100000
+
+// cpuAllowanceParse returns the percentage of limits.cpu.allowance, 0 if
+// it's unset.
+func cpuAllowanceParse(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	percent, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+	if err != nil || !strings.HasSuffix(value, "%") || percent < 1 || percent > 100 {
+		return 0, fmt.Errorf("Bad value for limits.cpu.allowance: '%s'", value)
+	}
+
+	return percent, nil
+}
+
+/*
+ * cpuAllowanceShares splits the cpu.shares of the running containers,
+ * which have the given allowances (0 for none), 1024 each on average. Those
+ * with an allowance get its percentage of the total, scaled down if they
+ * add up to more than 100%, and the others split what's left.
+ */
+func cpuAllowanceShares(allowances map[string]int) map[string]int {
+	total := 1024 * len(allowances)
+
+	sum := 0
+	others := 0
+	for _, allowance := range allowances {
+		sum += allowance
+		if allowance == 0 {
+			others++
+		}
+	}
+
+	scale := 100
+	if sum > scale {
+		scale = sum
+	}
+
+	left := total
+	shares := map[string]int{}
+	for name, allowance := range allowances {
+		if allowance != 0 {
+			shares[name] = allowance * total / scale
+			left -= shares[name]
+		}
+	}
+
+	for name, allowance := range allowances {
+		if allowance == 0 {
+			shares[name] = left / others
+		}
+
+		// The kernel's minimum
+		if shares[name] < 2 {
+			shares[name] = 2
+		}
+	}
+
+	return shares
+}
+
+// cpuAllowanceQuota returns the cpu.cfs_quota_us of an allowance, -1 (no
+// quota) for none.
+func cpuAllowanceQuota(allowance int, cpus int) int {
+	if allowance == 0 {
+		return -1
+	}
+
+	return allowance * cpus * cpuPeriod / 100
+}
+
+/*
+ * cpuSchedule sets the cpu.shares and quota of the running containers for
+ * their limits.cpu.allowance. As the shares depend on which containers run,
+ * it's done again whenever one starts or stops.
+ */
+func cpuSchedule(d *Daemon) {
+	names, err := dbContainersList(d.db, cTypeRegular)
+	if err != nil {
+		shared.Log.Error("Failed to list the containers", log.Ctx{"err": err})
+		return
+	}
+
+	containers := map[string]container{}
+	allowances := map[string]int{}
+	for _, name := range names {
+		c, err := containerLXDLoad(d, name)
+		if err != nil || !c.IsRunning() {
+			continue
+		}
+
+		allowance, err := cpuAllowanceParse(c.ConfigGet()["limits.cpu.allowance"])
+		if err != nil {
+			shared.Log.Warn("Ignoring the CPU allowance", log.Ctx{"container": name, "err": err})
+		}
+
+		containers[name] = c
+		allowances[name] = allowance
+	}
+
+	shares := cpuAllowanceShares(allowances)
+	for name, c := range containers {
+		lxContainer, err := c.LXContainerGet()
+		if err == nil {
+			err = lxContainer.SetCgroupItem("cpu.shares", strconv.Itoa(shares[name]))
+		}
+
+		if err == nil {
+			err = lxContainer.SetCgroupItem("cpu.cfs_period_us", strconv.Itoa(cpuPeriod))
+		}
+
+		if err == nil {
+			err = lxContainer.SetCgroupItem("cpu.cfs_quota_us", strconv.Itoa(cpuAllowanceQuota(allowances[name], runtime.NumCPU())))
+		}
+
+		if err != nil {
+			shared.Log.Warn("Failed to set the CPU allowance", log.Ctx{"container": name, "err": err})
+		}
+	}
+}
+
+/*
+ * cpuScheduleStart runs cpuSchedule when triggered and every minute, to
+ * catch the containers which stopped on their own.
+ */
+func cpuScheduleStart(d *Daemon) {
+	d.cpuScheduleChan = make(chan bool, 1)
+	go func() {
+		for {
+			select {
+			case <-d.cpuScheduleChan:
+			case <-time.After(time.Minute):
+			}
+
+			cpuSchedule(d)
+		}
+	}()
+}
+
+// cpuScheduleTrigger has the CPU allowances recomputed, without waiting.
+func (d *Daemon) cpuScheduleTrigger() {
+	select {
+	case d.cpuScheduleChan <- true:
+	default:
+		// Already pending
+	}
+}
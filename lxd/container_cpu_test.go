@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_cpu_allowance_parse(t *testing.T) {
+	for value, expected := range map[string]int{"": 0, "10%": 10, "100%": 100} {
+		percent, err := cpuAllowanceParse(value)
+		if err != nil || percent != expected {
+			t.Errorf("Wrong allowance for '%s': %d (%v)", value, percent, err)
+		}
+	}
+
+	for _, value := range []string{"10", "0%", "101%", "ten%", "%"} {
+		if _, err := cpuAllowanceParse(value); err == nil {
+			t.Errorf("Accepted a bad allowance: '%s'", value)
+		}
+	}
+}
+
+func Test_cpu_allowance_shares(t *testing.T) {
+	shares := cpuAllowanceShares(map[string]int{"a": 10, "b": 0, "c": 0})
+	if shares["a"] != 307 {
+		t.Errorf("Wrong shares for 10%% of 3 containers: %d", shares["a"])
+	}
+
+	if shares["b"] != 1382 || shares["c"] != 1382 {
+		t.Errorf("The others didn't split what's left: %v", shares)
+	}
+
+	// The same allowance is the same fraction, whichever number runs
+	shares = cpuAllowanceShares(map[string]int{"a": 10, "b": 0})
+	if shares["a"] != 204 || shares["b"] != 1844 {
+		t.Errorf("Not a tenth of the shares: %v", shares)
+	}
+
+	shares = cpuAllowanceShares(map[string]int{"a": 100, "b": 100, "c": 0})
+	if shares["a"] != 1536 || shares["b"] != 1536 || shares["c"] != 2 {
+		t.Errorf("Allowances above 100%% weren't scaled down: %v", shares)
+	}
+}
+
+func Test_cpu_allowance_quota(t *testing.T) {
+	if quota := cpuAllowanceQuota(0, 4); quota != -1 {
+		t.Errorf("A container without allowance got a quota: %d", quota)
+	}
+
+	if quota := cpuAllowanceQuota(10, 4); quota != 40000 {
+		t.Errorf("Wrong quota for 10%% of 4 CPUs: %d", quota)
+	}
+}
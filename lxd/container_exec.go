@@ -8,6 +8,8 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
@@ -15,6 +17,14 @@ import (
 	"gopkg.in/lxc/go-lxc.v2"
 )
 
+// How long the pty of an interactive exec session is kept around for the
+// client to reattach once its websocket dropped.
+const execReconnectTimeout = 30 * time.Second
+
+// How long an exec session waits for the client to connect its websockets,
+// after which the client is considered gone and the command isn't run.
+const execConnectTimeout = 30 * time.Second
+
 func runCommand(container *lxc.Container, command []string, options lxc.AttachOptions) shared.OperationResult {
 	status, err := container.RunCommandStatus(command, options)
 	if err != nil {
@@ -22,7 +32,20 @@ func runCommand(container *lxc.Container, command []string, options lxc.AttachOp
 		return shared.OperationError(err)
 	}
 
-	metadata, err := json.Marshal(shared.Jmap{"return": status})
+	/*
+	 * RunCommandStatus gives us the raw waitpid() status, turn it into an
+	 * exit code the same way a shell would (128 + signal number when the
+	 * process got killed).
+	 */
+	exitCode := -1
+	ws := syscall.WaitStatus(status)
+	if ws.Exited() {
+		exitCode = ws.ExitStatus()
+	} else if ws.Signaled() {
+		exitCode = 128 + int(ws.Signal())
+	}
+
+	metadata, err := json.Marshal(shared.Jmap{"return": status, "exit_code": exitCode})
 	if err != nil {
 		return shared.OperationError(err)
 	}
@@ -46,15 +69,47 @@ func (s *execWs) Metadata() interface{} {
 func (s *execWs) Connect(secret string, r *http.Request, w http.ResponseWriter) error {
 	for fd, fdSecret := range s.fds {
 		if secret == fdSecret {
-			conn, err := shared.WebsocketUpgrader.Upgrade(w, r, nil)
+			/*
+			 * An interactive session which lost its client gets
+			 * reattached to through the same secret, as long as
+			 * the session is still waiting for it.
+			 */
+			reattach := s.interactive && fd == 0 && s.conns[0] != nil
+			if reattach {
+				select {
+				case <-s.mirrorDone:
+					return fmt.Errorf("exec session is over")
+				default:
+				}
+			}
+
+			upgrader := shared.WebsocketUpgrader
+			if s.compress {
+				upgrader = shared.WebsocketCompressedUpgrader
+			}
+
+			conn, err := upgrader.Upgrade(w, r, nil)
 			if err != nil {
 				return err
 			}
 
+			if reattach {
+				select {
+				case s.reconnect <- conn:
+				default:
+					conn.Close()
+				}
+				return nil
+			}
+
 			s.conns[fd] = conn
 
 			if fd == -1 {
-				s.controlConnected <- true
+				select {
+				case s.controlConnected <- conn:
+				default:
+					conn.Close()
+				}
 				return nil
 			}
 
@@ -73,8 +128,170 @@ func (s *execWs) Connect(secret string, r *http.Request, w http.ResponseWriter)
 	return os.ErrPermission
 }
 
+/*
+ * controlLoop handles the control messages sent by the client until the
+ * control websocket goes away.
+ */
+func (s *execWs) controlLoop(control *websocket.Conn, pty *os.File) {
+	for {
+		mt, r, err := control.NextReader()
+		if mt == websocket.CloseMessage {
+			break
+		}
+
+		if err != nil {
+			shared.Debugf("Got error getting next reader %s", err)
+			break
+		}
+
+		buf, err := ioutil.ReadAll(r)
+		if err != nil {
+			shared.Debugf("Failed to read message %s", err)
+			break
+		}
+
+		command := shared.ContainerExecControl{}
+
+		if err := json.Unmarshal(buf, &command); err != nil {
+			shared.Debugf("Failed to unmarshal control socket command: %s", err)
+			continue
+		}
+
+		if command.Command == "window-resize" {
+			winchWidth, err := strconv.Atoi(command.Args["width"])
+			if err != nil {
+				shared.Debugf("Unable to extract window width: %s", err)
+				continue
+			}
+
+			winchHeight, err := strconv.Atoi(command.Args["height"])
+			if err != nil {
+				shared.Debugf("Unable to extract window height: %s", err)
+				continue
+			}
+
+			err = shared.SetSize(int(pty.Fd()), winchWidth, winchHeight)
+			if err != nil {
+				shared.Debugf("Failed to set window size to: %dx%d", winchWidth, winchHeight)
+				continue
+			}
+		}
+	}
+}
+
+/*
+ * mirrorPty shuffles data between the pty and the client's websocket. When
+ * the websocket drops while the command is still running, the session is
+ * kept alive for execReconnectTimeout waiting for the client to reattach,
+ * after which the pty gets hung up. It ends right away if the command exits
+ * in the meantime.
+ */
+func (s *execWs) mirrorPty(pty *os.File) {
+	defer close(s.mirrorDone)
+
+	out := shared.ReaderToChannel(pty)
+	conn := s.conns[0]
+
+	// Output read from the pty that couldn't be delivered yet
+	pending := [][]byte{}
+
+	for {
+		dropped := make(chan bool, 1)
+		hangup := make(chan bool, 1)
+		go func(conn *websocket.Conn) {
+			for {
+				mt, r, err := conn.NextReader()
+				if mt == websocket.CloseMessage {
+					hangup <- true
+					return
+				}
+
+				if err != nil {
+					shared.Debugf("Exec websocket went away: %s", err)
+					dropped <- true
+					return
+				}
+
+				buf, err := ioutil.ReadAll(r)
+				if err != nil {
+					dropped <- true
+					return
+				}
+
+				if _, err := pty.Write(buf); err != nil {
+					return
+				}
+			}
+		}(conn)
+
+		for len(pending) > 0 {
+			if err := conn.WriteMessage(websocket.BinaryMessage, pending[0]); err != nil {
+				break
+			}
+			pending = pending[1:]
+		}
+
+	forward:
+		for len(pending) == 0 {
+			select {
+			case buf, ok := <-out:
+				if !ok {
+					closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+					conn.WriteMessage(websocket.CloseMessage, closeMsg)
+					conn.Close()
+					return
+				}
+
+				if err := conn.WriteMessage(websocket.BinaryMessage, buf); err != nil {
+					pending = append(pending, buf)
+				}
+			case <-dropped:
+				break forward
+			case <-hangup:
+				// The client is done with the session
+				conn.Close()
+				pty.Close()
+				return
+			}
+		}
+		conn.Close()
+
+		shared.Debugf("Waiting for the exec client to reattach")
+		expired := time.After(execReconnectTimeout)
+	wait:
+		for {
+			select {
+			case conn = <-s.reconnect:
+				shared.Debugf("Exec client reattached")
+				break wait
+			case buf, ok := <-out:
+				if !ok {
+					// The pty is closed once the command exits
+					shared.Debugf("Exec command exited while waiting for the client")
+					return
+				}
+
+				pending = append(pending, buf)
+			case <-expired:
+				shared.Debugf("Exec client didn't reattach, hanging up")
+				pty.Close()
+				return
+			}
+		}
+	}
+}
+
 func (s *execWs) Do() shared.OperationResult {
-	<-s.allConnected
+	select {
+	case <-s.allConnected:
+	case <-time.After(execConnectTimeout):
+		for _, conn := range s.conns {
+			if conn != nil {
+				conn.Close()
+			}
+		}
+		return shared.OperationError(fmt.Errorf("The client didn't connect to the exec session"))
+	}
 
 	var err error
 	var ttys []*os.File
@@ -101,71 +318,26 @@ func (s *execWs) Do() shared.OperationResult {
 		s.options.StderrFd = ttys[2].Fd()
 	}
 
-	controlExit := make(chan bool)
+	controlExit := make(chan bool, 1)
 	stdEOF := make(chan bool)
 
 	if s.interactive {
 		go func() {
-			select {
-			case <-s.controlConnected:
-				break
-
-			case <-controlExit:
-				return
-			}
-
 			for {
-				mt, r, err := s.conns[-1].NextReader()
-				if mt == websocket.CloseMessage {
-					break
-				}
-
-				if err != nil {
-					shared.Debugf("Got error getting next reader %s", err)
-					break
-				}
-
-				buf, err := ioutil.ReadAll(r)
-				if err != nil {
-					shared.Debugf("Failed to read message %s", err)
+				var control *websocket.Conn
+				select {
+				case control = <-s.controlConnected:
 					break
-				}
-
-				command := shared.ContainerExecControl{}
 
-				if err := json.Unmarshal(buf, &command); err != nil {
-					shared.Debugf("Failed to unmarshal control socket command: %s", err)
-					continue
+				case <-controlExit:
+					return
 				}
 
-				if command.Command == "window-resize" {
-					winchWidth, err := strconv.Atoi(command.Args["width"])
-					if err != nil {
-						shared.Debugf("Unable to extract window width: %s", err)
-						continue
-					}
-
-					winchHeight, err := strconv.Atoi(command.Args["height"])
-					if err != nil {
-						shared.Debugf("Unable to extract window height: %s", err)
-						continue
-					}
-
-					err = shared.SetSize(int(ptys[0].Fd()), winchWidth, winchHeight)
-					if err != nil {
-						shared.Debugf("Failed to set window size to: %dx%d", winchWidth, winchHeight)
-						continue
-					}
-				}
-
-				if err != nil {
-					shared.Debugf("Got error writing to writer %s", err)
-					break
-				}
+				s.controlLoop(control, ptys[0])
 			}
 		}()
 
-		shared.WebsocketMirror(s.conns[0], ptys[0], ptys[0])
+		go s.mirrorPty(ptys[0])
 	} else {
 		for i := 0; i < len(ttys); i++ {
 			go func(i int) {
@@ -202,7 +374,7 @@ func (s *execWs) Do() shared.OperationResult {
 		pty.Close()
 	}
 
-	if s.interactive && s.conns[-1] == nil {
+	if s.interactive {
 		controlExit <- true
 	}
 
@@ -264,8 +436,11 @@ func containerExecPost(d *Daemon, r *http.Request) Response {
 			ws.conns[2] = nil
 		}
 		ws.allConnected = make(chan bool, 1)
-		ws.controlConnected = make(chan bool, 1)
+		ws.controlConnected = make(chan *websocket.Conn, 1)
+		ws.reconnect = make(chan *websocket.Conn, 1)
+		ws.mirrorDone = make(chan bool)
 		ws.interactive = post.Interactive
+		ws.compress = d.websocketCompression()
 		ws.done = make(chan shared.OperationResult, 1)
 		ws.options = opts
 		for i := -1; i < len(ws.conns)-1; i++ {
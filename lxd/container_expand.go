@@ -0,0 +1,62 @@
+package main
+
+import (
+	"database/sql"
+
+	"github.com/lxc/lxd/shared"
+)
+
+// dbProfilesExpansion returns the config and devices of profiles, in order.
+func dbProfilesExpansion(db *sql.DB, names []string) ([]shared.ProfileConfig, error) {
+	profiles := []shared.ProfileConfig{}
+
+	for _, name := range names {
+		config, err := dbProfileConfigGet(db, name)
+		if err != nil {
+			return nil, err
+		}
+
+		devices, err := dbDevicesGet(db, name, true)
+		if err != nil {
+			return nil, err
+		}
+
+		profiles = append(profiles, shared.ProfileConfig{Name: name, Config: config, Devices: devices})
+	}
+
+	return profiles, nil
+}
+
+/*
+ * containerExpand returns the expanded config and devices of a container.
+ * Its profiles are applied in order, a later one overriding the keys and
+ * devices of the earlier ones, and its own config and devices go on top.
+ * Devices are matched by name only: a definition replaces the whole entry
+ * of the same name rather than being merged into it, so that a device of
+ * type none masks the one of a profile. The devices are copies.
+ */
+func containerExpand(profiles []shared.ProfileConfig, config map[string]string, devices shared.Devices) (map[string]string, shared.Devices) {
+	expandedConfig := map[string]string{}
+	expandedDevices := shared.Devices{}
+
+	add := func(config map[string]string, devices shared.Devices) {
+		for k, v := range config {
+			expandedConfig[k] = v
+		}
+
+		for name, d := range devices {
+			dev := shared.Device{}
+			for k, v := range d {
+				dev[k] = v
+			}
+			expandedDevices[name] = dev
+		}
+	}
+
+	for _, profile := range profiles {
+		add(profile.Config, profile.Devices)
+	}
+	add(config, devices)
+
+	return expandedConfig, expandedDevices
+}
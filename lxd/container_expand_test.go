@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/lxc/lxd/shared"
+)
+
+func Test_container_expand_precedence(t *testing.T) {
+	profiles := []shared.ProfileConfig{
+		{
+			Name:   "first",
+			Config: map[string]string{"limits.cpus": "1", "limits.memory": "1G"},
+			Devices: shared.Devices{
+				"eth0": shared.Device{"type": "nic", "nictype": "bridged", "parent": "lxcbr0", "mtu": "9000"},
+				"data": shared.Device{"type": "disk", "source": "/srv", "path": "/srv"},
+			},
+		},
+		{
+			Name:   "second",
+			Config: map[string]string{"limits.cpus": "2"},
+			Devices: shared.Devices{
+				"eth0": shared.Device{"type": "nic", "nictype": "macvlan", "parent": "eth0"},
+			},
+		},
+	}
+
+	config, devices := containerExpand(profiles,
+		map[string]string{"limits.memory": "2G"},
+		shared.Devices{"data": shared.Device{"type": "none"}})
+
+	if config["limits.cpus"] != "2" {
+		t.Errorf("The last profile didn't win: %s", config["limits.cpus"])
+	}
+
+	if config["limits.memory"] != "2G" {
+		t.Errorf("The container's config didn't win: %s", config["limits.memory"])
+	}
+
+	if _, ok := devices["eth0"]["mtu"]; ok || devices["eth0"]["nictype"] != "macvlan" {
+		t.Errorf("The device wasn't replaced as a whole: %v", devices["eth0"])
+	}
+
+	if devices["data"]["type"] != "none" || devices["data"]["source"] != "" {
+		t.Errorf("The container's device didn't mask the profile's: %v", devices["data"])
+	}
+
+	devices["eth0"]["hwaddr"] = "00:16:3e:00:00:01"
+	if _, ok := profiles[1].Devices["eth0"]["hwaddr"]; ok {
+		t.Errorf("The expanded devices aren't copies")
+	}
+}
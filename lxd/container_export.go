@@ -0,0 +1,128 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lxc/lxd/shared"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+/*
+ * containerExportGet streams a stopped container, or one of its snapshots
+ * (?snapshot=<name>), as the tarball an image published from it would be
+ * made of, without registering an image. ?compression=gzip compresses it.
+ */
+func containerExportGet(d *Daemon, r *http.Request) Response {
+	name := mux.Vars(r)["name"]
+	snapshot := r.FormValue("snapshot")
+
+	compression := r.FormValue("compression")
+	if compression != "" && compression != "none" && compression != "gzip" {
+		return BadRequest(fmt.Errorf("Bad compression: '%s'", compression))
+	}
+
+	fullName := name
+	if snapshot != "" {
+		fullName = name + shared.SnapshotDelimiter + snapshot
+	}
+
+	c, err := containerLXDLoad(d, fullName)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	if snapshot == "" && c.IsRunning() {
+		return BadRequest(fmt.Errorf("Can't export a running container, stop it or export one of its snapshots"))
+	}
+
+	filename := strings.Replace(fullName, shared.SnapshotDelimiter, "-", -1) + ".tar"
+	if compression == "gzip" {
+		filename += ".gz"
+	}
+
+	/*
+	 * The rootfs is unshifted while being exported, which is done to a
+	 * temporary file, as for publishing, so that it's shifted back
+	 * whatever pace the client downloads it at.
+	 */
+	tarfile, err := ioutil.TempFile(shared.VarPath("images"), "lxd_export_")
+	if err != nil {
+		return InternalError(err)
+	}
+
+	var out io.Writer = tarfile
+	var gz *gzip.Writer
+	if compression == "gzip" {
+		gz = gzip.NewWriter(tarfile)
+		out = gz
+	}
+
+	// Closing the file makes the export fail, if the client goes away
+	canceller := requestCanceller(r)
+	done := canceller.OnCancel(func() { tarfile.Close() })
+	err = c.ExportToTar(snapshot, out)
+	if err == nil && gz != nil {
+		err = gz.Close()
+	}
+	done()
+	tarfile.Close()
+	if err == nil && canceller.Cancelled() {
+		err = errOperationCancelled
+	}
+	if err != nil {
+		os.Remove(tarfile.Name())
+		return InternalError(err)
+	}
+
+	return &containerExportResponse{c: c, path: tarfile.Name(), filename: filename, gzip: compression == "gzip"}
+}
+
+var containerExportCmd = Command{name: "containers/{name}/export", get: containerExportGet}
+
+type containerExportResponse struct {
+	c        container
+	path     string
+	filename string
+	gzip     bool
+}
+
+// Render sends the exported tarball and removes it.
+func (r *containerExportResponse) Render(w http.ResponseWriter) error {
+	defer os.Remove(r.path)
+
+	f, err := os.Open(r.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	ctype := "application/x-tar"
+	if r.gzip {
+		ctype = "application/gzip"
+	}
+
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment;filename=%s", r.filename))
+	w.Header().Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, f); err != nil {
+		shared.Log.Error("Failed to send the container export", log.Ctx{"container": r.c.NameGet(), "err": err})
+	}
+
+	return nil
+}
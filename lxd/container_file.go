@@ -38,21 +38,22 @@ func containerFileHandler(d *Daemon, r *http.Request) Response {
 		return InternalError(err)
 	}
 
+	idmapset, err := c.LastIdmapSetGet()
+	if err != nil {
+		return InternalError(err)
+	}
+
 	switch r.Method {
 	case "GET":
-		return containerFileGet(initPid, r, targetPath)
+		return containerFileGet(initPid, r, targetPath, idmapset)
 	case "POST":
-		idmapset, err := c.LastIdmapSetGet()
-		if err != nil {
-			return InternalError(err)
-		}
 		return containerFilePut(initPid, r, targetPath, idmapset)
 	default:
 		return NotFound
 	}
 }
 
-func containerFileGet(pid int, r *http.Request, path string) Response {
+func containerFileGet(pid int, r *http.Request, path string, idmapset *shared.IdmapSet) Response {
 	/*
 	 * Copy out of the ns to a temporary file, and then use that to serve
 	 * the request from. This prevents us from having to worry about stuff
@@ -88,9 +89,14 @@ func containerFileGet(pid int, r *http.Request, path string) Response {
 	 * https://groups.google.com/forum/#!topic/golang-nuts/ywS7xQYJkHY
 	 */
 	sb := fi.Sys().(*syscall.Stat_t)
+	uid, gid := int(sb.Uid), int(sb.Gid)
+	if idmapset != nil {
+		uid, gid = idmapset.ShiftFromNs(uid, gid)
+	}
+
 	headers := map[string]string{
-		"X-LXD-uid":  strconv.FormatUint(uint64(sb.Uid), 10),
-		"X-LXD-gid":  strconv.FormatUint(uint64(sb.Gid), 10),
+		"X-LXD-uid":  strconv.Itoa(uid),
+		"X-LXD-gid":  strconv.Itoa(gid),
 		"X-LXD-mode": fmt.Sprintf("%04o", fi.Mode()&os.ModePerm),
 	}
 
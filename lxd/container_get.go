@@ -4,6 +4,8 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
+
+	"github.com/lxc/lxd/shared"
 )
 
 func containerGet(d *Daemon, r *http.Request) Response {
@@ -18,5 +20,11 @@ func containerGet(d *Daemon, r *http.Request) Response {
 		return InternalError(err)
 	}
 
-	return SyncResponse(true, state)
+	return SyncResponseETag(true, state, containerETag(state))
+}
+
+// containerETag returns what a container's ETag is computed from: the
+// configuration a PUT can change.
+func containerETag(state *shared.ContainerState) interface{} {
+	return []interface{}{state.Ephemeral, state.Profiles, state.Config, state.Devices}
 }
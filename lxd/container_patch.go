@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/lxc/lxd/shared"
+)
+
+/*
+ * containerPatchReq is a partial container update: only the config keys
+ * and devices listed are changed, a config key set to "" or a device set
+ * to null being removed. Profiles, when set, replace the current list.
+ */
+type containerPatchReq struct {
+	Profiles *[]string         `json:"profiles"`
+	Config   map[string]string `json:"config"`
+	Devices  shared.Devices    `json:"devices"`
+}
+
+// Merge the requested changes into the container's configuration
+func containerPatch(d *Daemon, r *http.Request) Response {
+	name := mux.Vars(r)["name"]
+	c, err := containerLXDLoad(d, name)
+	if err != nil {
+		return NotFound
+	}
+
+	req := containerPatchReq{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return BadRequest(err)
+	}
+
+	if req.Profiles != nil {
+		if err := validProfiles(d, *req.Profiles); err != nil {
+			return BadRequest(err)
+		}
+	}
+
+	limits := d.trustedClientLimits(r)
+	if limits != nil {
+		state, err := c.RenderState()
+		if err != nil {
+			return InternalError(err)
+		}
+
+		profiles := state.Profiles
+		if req.Profiles != nil {
+			profiles = *req.Profiles
+		}
+
+		err = certLimitsCheckUpdate(limits, state, patchConfig(state.Config, req.Config), patchDevices(state.Devices, req.Devices), profiles)
+		if err != nil {
+			return &ErrorResponse{http.StatusForbidden, shared.ForbiddenCode, err.Error()}
+		}
+	}
+
+	do := func() error {
+		patchLock.Lock()
+		defer patchLock.Unlock()
+
+		// Reload the container so the changes apply to its latest state
+		c, err := containerLXDLoad(d, name)
+		if err != nil {
+			return err
+		}
+
+		state, err := c.RenderState()
+		if err != nil {
+			return err
+		}
+
+		args := containerLXDArgs{
+			Config:   patchConfig(state.Config, req.Config),
+			Devices:  patchDevices(state.Devices, req.Devices),
+			Profiles: state.Profiles}
+
+		if req.Profiles != nil {
+			args.Profiles = *req.Profiles
+		}
+
+		return c.ConfigReplace(args)
+	}
+
+	return AsyncResponse(shared.OperationWrap(do), nil)
+}
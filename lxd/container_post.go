@@ -38,12 +38,21 @@ func containerPost(d *Daemon, r *http.Request) Response {
 			return InternalError(err)
 		}
 
-		ws, err := migration.NewMigrationSource(lxc, idmapset)
+		// Cancelling the operation closes the migration connections
+		canceller := &operationCanceller{}
+		cancel := make(chan bool)
+		canceller.OnCancel(func() { close(cancel) })
+
+		ws, err := migration.NewMigrationSource(lxc, idmapset, d.websocketCompression(), cancel)
 		if err != nil {
 			return InternalError(err)
 		}
 
-		return AsyncResponseWithWs(ws, nil)
+		return AsyncResponseWithWs(ws, canceller.Cancel)
+	}
+
+	if err := validContainerName(body.Name); err != nil {
+		return BadRequest(err)
 	}
 
 	run := func() error {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 
 	"github.com/gorilla/mux"
@@ -21,6 +22,15 @@ func containerPut(d *Daemon, r *http.Request) Response {
 		return NotFound
 	}
 
+	state, err := c.RenderState()
+	if err != nil {
+		return InternalError(err)
+	}
+
+	if err := etagCheck(r, containerETag(state)); err != nil {
+		return PreconditionFailed(err)
+	}
+
 	configRaw := containerConfigReq{}
 	if err := json.NewDecoder(r.Body).Decode(&configRaw); err != nil {
 		return BadRequest(err)
@@ -29,8 +39,35 @@ func containerPut(d *Daemon, r *http.Request) Response {
 	var do = func() error { return nil }
 
 	if configRaw.Restore == "" {
+		if err := validProfiles(d, configRaw.Profiles); err != nil {
+			return BadRequest(err)
+		}
+
+		err := certLimitsCheckUpdate(d.trustedClientLimits(r), state, configRaw.Config, configRaw.Devices, configRaw.Profiles)
+		if err != nil {
+			return &ErrorResponse{http.StatusForbidden, shared.ForbiddenCode, err.Error()}
+		}
+
 		// Update container configuration
 		do = func() error {
+			patchLock.Lock()
+			defer patchLock.Unlock()
+
+			// The container may have changed since the request came in
+			c, err := containerLXDLoad(d, name)
+			if err != nil {
+				return err
+			}
+
+			state, err := c.RenderState()
+			if err != nil {
+				return err
+			}
+
+			if err := etagCheck(r, containerETag(state)); err != nil {
+				return err
+			}
+
 			args := containerLXDArgs{
 				Config:   configRaw.Config,
 				Devices:  configRaw.Devices,
@@ -45,14 +82,14 @@ func containerPut(d *Daemon, r *http.Request) Response {
 	} else {
 		// Snapshot Restore
 		do = func() error {
-			return containerSnapRestore(d, name, configRaw.Restore)
+			return containerSnapRestore(d, name, configRaw.Restore, configRaw.Stateful)
 		}
 	}
 
 	return AsyncResponse(shared.OperationWrap(do), nil)
 }
 
-func containerSnapRestore(d *Daemon, name string, snap string) error {
+func containerSnapRestore(d *Daemon, name string, snap string, stateful bool) error {
 	// normalize snapshot name
 	if !shared.IsSnapshot(snap) {
 		snap = name + shared.SnapshotDelimiter + snap
@@ -62,7 +99,8 @@ func containerSnapRestore(d *Daemon, name string, snap string) error {
 		"RESTORE => Restoring snapshot",
 		log.Ctx{
 			"snapshot":  snap,
-			"container": name})
+			"container": name,
+			"stateful":  stateful})
 
 	c, err := containerLXDLoad(d, name)
 	if err != nil {
@@ -80,13 +118,42 @@ func containerSnapRestore(d *Daemon, name string, snap string) error {
 		return err
 	}
 
-	if err := c.Restore(source); err != nil {
+	if err := c.Restore(source, stateful); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+/*
+ * validProfiles checks that an ordered list of profiles only references
+ * existing profiles and doesn't list any of them more than once.
+ */
+func validProfiles(d *Daemon, profiles []string) error {
+	if emptyProfile(profiles) {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	for _, p := range profiles {
+		if seen[p] {
+			return fmt.Errorf("Duplicate profile: %s", p)
+		}
+		seen[p] = true
+
+		id, err := dbProfileIDGet(d.db, p)
+		if err != nil {
+			return err
+		}
+
+		if id == -1 {
+			return fmt.Errorf("No such profile: %s", p)
+		}
+	}
+
+	return nil
+}
+
 func emptyProfile(l []string) bool {
 	if len(l) == 0 {
 		return true
@@ -4,7 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strconv"
+	"os/exec"
 	"strings"
 
 	"github.com/gorilla/mux"
@@ -15,15 +15,11 @@ import (
 )
 
 func containerSnapshotsGet(d *Daemon, r *http.Request) Response {
-	recursionStr := r.FormValue("recursion")
-	recursion, err := strconv.Atoi(recursionStr)
-	if err != nil {
-		recursion = 0
-	}
+	recursion := d.isRecursionRequest(r)
 
 	cname := mux.Vars(r)["name"]
 	// Makes sure the requested container exists.
-	_, err = containerLXDLoad(d, cname)
+	_, err := containerLXDLoad(d, cname)
 	if err != nil {
 		return SmartError(err)
 	}
@@ -51,16 +47,15 @@ func containerSnapshotsGet(d *Daemon, r *http.Request) Response {
 		}
 
 		snapName := strings.TrimLeft(name, regexp)
-		if recursion == 0 {
+		if !recursion {
 			url := fmt.Sprintf("/%s/containers/%s/snapshots/%s", shared.APIVersion, cname, snapName)
 			resultString = append(resultString, url)
 		} else {
-			body := shared.Jmap{"name": snapName, "stateful": shared.PathExists(sc.StateDirGet())}
-			resultMap = append(resultMap, body)
+			resultMap = append(resultMap, snapshotInfo(d, sc, snapName))
 		}
 	}
 
-	if recursion == 0 {
+	if !recursion {
 		return SyncResponse(true, resultString)
 	}
 
@@ -129,11 +124,28 @@ func containerSnapshotsPost(d *Daemon, r *http.Request) Response {
 		snapshotName = fmt.Sprintf("snap%d", i)
 	}
 
-	stateful, err := raw.GetBool("stateful")
-	if err != nil {
+	if err := validSnapshotName(snapshotName); err != nil {
 		return BadRequest(err)
 	}
 
+	stateful := false
+	if _, ok := raw["stateful"]; ok {
+		stateful, err = raw.GetBool("stateful")
+		if err != nil {
+			return BadRequest(err)
+		}
+	}
+
+	if stateful {
+		if !c.IsRunning() {
+			return BadRequest(fmt.Errorf("Container not running, can't take a stateful snapshot"))
+		}
+
+		if _, err := exec.LookPath("criu"); err != nil {
+			return BadRequest(fmt.Errorf("Unable to take a stateful snapshot, criu isn't installed"))
+		}
+	}
+
 	fullName := name +
 		shared.SnapshotDelimiter +
 		snapshotName
@@ -176,7 +188,7 @@ func snapshotHandler(d *Daemon, r *http.Request) Response {
 
 	switch r.Method {
 	case "GET":
-		return snapshotGet(sc, snapshotName)
+		return snapshotGet(d, sc, snapshotName)
 	case "POST":
 		return snapshotPost(r, sc, containerName)
 	case "DELETE":
@@ -186,9 +198,27 @@ func snapshotHandler(d *Daemon, r *http.Request) Response {
 	}
 }
 
-func snapshotGet(sc container, name string) Response {
-	body := shared.Jmap{"name": name, "stateful": shared.PathExists(sc.StateDirGet())}
-	return SyncResponse(true, body)
+/*
+ * snapshotInfo returns what's known of a snapshot: its creation date and
+ * the space it used once taken, -1 when unknown.
+ */
+func snapshotInfo(d *Daemon, sc container, name string) shared.Jmap {
+	size, err := dbContainerSnapshotSizeGet(d.db, sc.IDGet())
+	if err != nil {
+		shared.Log.Warn("Failed to get the snapshot's usage", log.Ctx{"snapshot": sc.NameGet(), "err": err})
+		size = -1
+	}
+
+	return shared.Jmap{
+		"name":       name,
+		"created_at": sc.CreationDateGet(),
+		"stateful":   shared.PathExists(sc.StateDirGet()),
+		"size":       size,
+	}
+}
+
+func snapshotGet(d *Daemon, sc container, name string) Response {
+	return SyncResponse(true, snapshotInfo(d, sc, name))
 }
 
 func snapshotPost(r *http.Request, sc container, containerName string) Response {
@@ -202,6 +232,10 @@ func snapshotPost(r *http.Request, sc container, containerName string) Response
 		return BadRequest(err)
 	}
 
+	if err := validSnapshotName(newName); err != nil {
+		return BadRequest(err)
+	}
+
 	rename := func() error {
 		return sc.Rename(containerName + shared.SnapshotDelimiter + newName)
 	}
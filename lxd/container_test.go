@@ -1,9 +1,66 @@
 package main
 
 import (
+	"strings"
+	"testing"
+
 	"github.com/lxc/lxd/shared"
 )
 
+func Test_valid_container_name(t *testing.T) {
+	for _, name := range []string{"foo", "Foo-2", "a", strings.Repeat("a", 63)} {
+		if err := validContainerName(name); err != nil {
+			t.Errorf("%s was rejected: %s", name, err)
+		}
+	}
+
+	for _, name := range []string{"", "foo/snap0", "foo_bar", "foo.bar", "foo bar", "1foo", "-foo", "foo-", "é", strings.Repeat("a", 64)} {
+		if err := validContainerName(name); err == nil {
+			t.Errorf("%s was accepted", name)
+		}
+	}
+}
+
+func Test_valid_snapshot_name(t *testing.T) {
+	for _, name := range []string{"snap0", "before_upgrade", "2016.06.01"} {
+		if err := validSnapshotName(name); err != nil {
+			t.Errorf("%s was rejected: %s", name, err)
+		}
+	}
+
+	for _, name := range []string{"", ".", "..", "a/b", "a b", strings.Repeat("a", 256)} {
+		if err := validSnapshotName(name); err == nil {
+			t.Errorf("%s was accepted", name)
+		}
+	}
+}
+
+func Test_mac_matches_template(t *testing.T) {
+	matching := map[string]string{
+		"00:16:3e:xx:xx:xx": "00:16:3e:2c:89:D9",
+		"00:16:3e:12:xx:xx": "00:16:3E:12:ab:cd",
+	}
+
+	for template, mac := range matching {
+		if !macMatchesTemplate(template, mac) {
+			t.Errorf("%s doesn't match %s", mac, template)
+		}
+	}
+
+	mismatching := map[string]string{
+		"00:16:3e:xx:xx:xx": "00:16:3f:2c:89:d9",
+		"00:16:3e:12:xx:xx": "00:16:3e:2c:89:d9",
+		"00:16:3e:xx:xx:xy": "",
+		"00:16:3e:xx:xx:xz": "00:16:3e:2c:89:zz",
+	}
+
+	for template, mac := range mismatching {
+		if macMatchesTemplate(template, mac) {
+			t.Errorf("%s matches %s", mac, template)
+		}
+	}
+}
+
 func (suite *lxdTestSuite) TestContainer_ProfilesDefault() {
 	args := containerLXDArgs{
 		Ctype:     cTypeRegular,
@@ -202,3 +259,97 @@ func (suite *lxdTestSuite) TestContainer_Rename() {
 	suite.Req.Nil(c.Rename("testFoo2"), "Failed to rename the container.")
 	suite.Req.Equal(shared.VarPath("containers", "testFoo2"), c.PathGet(""))
 }
+
+func (suite *lxdTestSuite) TestContainer_MacAddressStable() {
+	args := containerLXDArgs{
+		Ctype:     cTypeRegular,
+		Ephemeral: false,
+		Devices: shared.Devices{
+			"eth1": shared.Device{
+				"type":    "nic",
+				"nictype": "bridged",
+				"parent":  "unknownbr0"}},
+	}
+
+	c, err := containerLXDCreateInternal(suite.d, "testFoo", args)
+	suite.Req.Nil(err)
+	defer c.Delete()
+
+	hwaddr := c.ConfigGet()["volatile.eth1.hwaddr"]
+	suite.Req.True(macMatchesTemplate("00:16:3e:xx:xx:xx", hwaddr), "No MAC address was generated.")
+	suite.Req.Equal(hwaddr, c.DevicesGet()["eth1"]["hwaddr"])
+
+	// Updates without the volatile keys keep it
+	args.Config = map[string]string{"user.foo": "bar"}
+	suite.Req.Nil(c.ConfigReplace(args))
+
+	c2, err := containerLXDLoad(suite.d, "testFoo")
+	suite.Req.Nil(err)
+	suite.Req.Equal(hwaddr, c2.DevicesGet()["eth1"]["hwaddr"], "The MAC address changed.")
+
+	// An explicit one takes precedence
+	args.Devices["eth1"]["hwaddr"] = "00:16:3e:12:34:56"
+	suite.Req.Nil(c2.ConfigReplace(args))
+
+	c3, err := containerLXDLoad(suite.d, "testFoo")
+	suite.Req.Nil(err)
+	suite.Req.Equal("00:16:3e:12:34:56", c3.DevicesGet()["eth1"]["hwaddr"])
+}
+
+func (suite *lxdTestSuite) TestContainer_ProfileLimitsExpanded() {
+	_, err := dbProfileCreate(
+		suite.d.db,
+		"limited",
+		map[string]string{"limits.memory": "100M", "limits.cpus": "1"},
+		shared.Devices{})
+
+	suite.Req.Nil(err, "Failed to create the limited profile.")
+	defer func() {
+		dbProfileDelete(suite.d.db, "limited")
+	}()
+
+	args := containerLXDArgs{
+		Ctype:     cTypeRegular,
+		Ephemeral: false,
+		Profiles:  []string{"default", "limited"},
+		Config:    map[string]string{"limits.cpus": "2"},
+	}
+
+	c, err := containerLXDCreateInternal(suite.d, "testFoo", args)
+	suite.Req.Nil(err)
+	defer c.Delete()
+
+	config := c.ConfigGet()
+	suite.Equal("100M", config["limits.memory"], "The profile's limit isn't in the expanded config.")
+	suite.Equal("2", config["limits.cpus"], "The container's limit didn't override the profile's.")
+
+	suite.False(
+		containerApplyConfigLive(c, map[string]string{"limits.cpus": "2", "limits.memory": "100M", "user.foo": "bar"}),
+		"A restart is needed for the removal of a user key.")
+	suite.True(
+		containerApplyConfigLive(c, map[string]string{"limits.cpus": "2", "limits.memory": "100M", "security.privileged": "true"}),
+		"No restart is needed for a change of security.privileged.")
+}
+
+func (suite *lxdTestSuite) TestContainer_UnsupportedArchitecture() {
+	architecture := shared.ARCH_UNKNOWN
+	for _, arch := range []int{shared.ARCH_64BIT_INTEL_X86, shared.ARCH_64BIT_ARMV8_LITTLE_ENDIAN, shared.ARCH_64BIT_POWERPC_LITTLE_ENDIAN} {
+		if !shared.IntInSlice(arch, suite.d.architectures) {
+			architecture = arch
+			break
+		}
+	}
+
+	args := containerLXDArgs{
+		Ctype:        cTypeRegular,
+		Ephemeral:    false,
+		Architecture: architecture,
+	}
+
+	c, err := containerLXDCreateInternal(suite.d, "testFoo", args)
+	if err == nil {
+		c.Delete()
+	}
+
+	suite.Req.NotNil(err, "A container of an architecture the host doesn't support was created.")
+}
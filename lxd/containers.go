@@ -25,8 +25,11 @@ type execWs struct {
 	options          lxc.AttachOptions
 	conns            map[int]*websocket.Conn
 	allConnected     chan bool
-	controlConnected chan bool
+	controlConnected chan *websocket.Conn
+	reconnect        chan *websocket.Conn
+	mirrorDone       chan bool
 	interactive      bool
+	compress         bool
 	done             chan shared.OperationResult
 	fds              map[int]string
 }
@@ -43,6 +46,7 @@ type containerConfigReq struct {
 	Config   map[string]string `json:"config"`
 	Devices  shared.Devices    `json:"devices"`
 	Restore  string            `json:"restore"`
+	Stateful bool              `json:"stateful"`
 }
 
 type containerStatePutReq struct {
@@ -62,6 +66,9 @@ type containerPostReq struct {
 	Config    map[string]string    `json:"config"`
 	Profiles  []string             `json:"profiles"`
 	Ephemeral bool                 `json:"ephemeral"`
+
+	// How many containers to create, Name being a pattern with a %d
+	Count int `json:"count"`
 }
 
 type containerImageSource struct {
@@ -88,7 +95,8 @@ type containerImageSource struct {
 	Websockets map[string]string `json:"secrets"`
 
 	/* for "copy" type */
-	Source string `json:"source"`
+	Source        string `json:"source"`
+	ContainerOnly bool   `json:"container_only"`
 }
 
 var containersCmd = Command{
@@ -101,6 +109,7 @@ var containerCmd = Command{
 	name:   "containers/{name}",
 	get:    containerGet,
 	put:    containerPut,
+	patch:  containerPatch,
 	delete: containerDelete,
 	post:   containerPost,
 }
@@ -187,7 +196,7 @@ func containersWatch(d *Daemon) error {
 }
 
 func containersRestart(d *Daemon) error {
-	containers, err := doContainersGet(d, true)
+	containers, err := doContainersGet(d, true, nil)
 
 	if err != nil {
 		return err
@@ -202,7 +211,7 @@ func containersRestart(d *Daemon) error {
 		autoStart := container.State.ExpandedConfig["boot.autostart"]
 		autoStartDelay := container.State.ExpandedConfig["boot.autostart.delay"]
 
-		if lastState == "RUNNING" || autoStart == "true" {
+		if lastState == "RUNNING" || lastState == "CHECKPOINTED" || autoStart == "true" {
 			c, err := containerLXDLoad(d, container.State.Name)
 			if err != nil {
 				return err
@@ -212,7 +221,13 @@ func containersRestart(d *Daemon) error {
 				continue
 			}
 
-			c.Start()
+			if lastState != "CHECKPOINTED" {
+				c.Start()
+			} else if err := c.RestoreCheckpoint(); err != nil {
+				shared.Log.Warn("Failed to restore the container from its checkpoint, starting it",
+					log.Ctx{"container": c.NameGet(), "err": err})
+				c.Start()
+			}
 
 			autoStartDelayInt, err := strconv.Atoi(autoStartDelay)
 			if err == nil {
@@ -229,34 +244,76 @@ func containersRestart(d *Daemon) error {
 	return nil
 }
 
-func containersShutdown(d *Daemon) error {
-	results, err := dbContainersList(d.db, cTypeRegular)
+// How long each container is given to shut down cleanly by default when
+// the daemon shuts down.
+const containersShutdownTimeout = 30 * time.Second
+
+/*
+ * containersShutdown stops the running containers in the reverse order of
+ * boot.autostart.priority, those of a same priority in parallel. Each gets
+ * timeout to shut down cleanly before being killed, unless it's stateful
+ * and its running state can be checkpointed instead. What state they were
+ * in is recorded for containersRestart to bring them back.
+ */
+func containersShutdown(d *Daemon, stateful bool, timeout time.Duration) error {
+	containers, err := doContainersGet(d, true, nil)
 	if err != nil {
 		return err
 	}
 
-	var wg sync.WaitGroup
+	containerInfo := containers.(shared.ContainerInfoList)
+	sort.Sort(containerInfo)
 
-	for _, r := range results {
-		c, err := containerLXDLoad(d, r)
+	// The running containers, by decreasing priority
+	batches := [][]container{}
+	lastPriority := 0
+	for _, info := range containerInfo {
+		c, err := containerLXDLoad(d, info.State.Name)
 		if err != nil {
 			return err
 		}
 
 		err = c.ConfigKeySet("volatile.last_state.power", c.StateGet())
-
 		if err != nil {
 			return err
 		}
 
-		if c.IsRunning() {
+		if !c.IsRunning() {
+			continue
+		}
+
+		priority, _ := strconv.Atoi(info.State.ExpandedConfig["boot.autostart.priority"])
+		if len(batches) == 0 || priority != lastPriority {
+			batches = append(batches, []container{})
+			lastPriority = priority
+		}
+		batches[len(batches)-1] = append(batches[len(batches)-1], c)
+	}
+
+	for i := len(batches) - 1; i >= 0; i-- {
+		var wg sync.WaitGroup
+
+		for _, c := range batches[i] {
 			wg.Add(1)
-			go func() {
-				c.Shutdown(time.Second * 30)
+			go func(c container) {
+				defer wg.Done()
+
+				if stateful {
+					err := c.Checkpoint()
+					if err == nil {
+						c.ConfigKeySet("volatile.last_state.power", "CHECKPOINTED")
+						return
+					}
+
+					shared.Log.Warn("Failed to checkpoint the container, shutting it down",
+						log.Ctx{"container": c.NameGet(), "err": err})
+				}
+
+				c.Shutdown(timeout)
 				c.Stop()
-				wg.Done()
-			}()
+			}(c)
 		}
+
 		wg.Wait()
 	}
 
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/lxc/lxd/shared"
@@ -10,7 +11,7 @@ import (
 
 func containersGet(d *Daemon, r *http.Request) Response {
 	for {
-		result, err := doContainersGet(d, d.isRecursionRequest(r))
+		result, err := doContainersGet(d, d.isRecursionRequest(r), d.trustedClientLimits(r))
 		if err == nil {
 			return SyncResponse(true, result)
 		}
@@ -26,7 +27,7 @@ func containersGet(d *Daemon, r *http.Request) Response {
 	}
 }
 
-func doContainersGet(d *Daemon, recursion bool) (interface{}, error) {
+func doContainersGet(d *Daemon, recursion bool, limits *dbCertInfo) (interface{}, error) {
 	result, err := dbContainersList(d.db, cTypeRegular)
 	if err != nil {
 		return nil, err
@@ -38,6 +39,10 @@ func doContainersGet(d *Daemon, recursion bool) (interface{}, error) {
 		return []string{}, err
 	}
 	for _, container := range result {
+		if !certContainerAllowed(limits, container) {
+			continue
+		}
+
 		if !recursion {
 			url := fmt.Sprintf("/%s/containers/%s", shared.APIVersion, container)
 			resultString = append(resultString, url)
@@ -57,6 +62,16 @@ func doContainersGet(d *Daemon, recursion bool) (interface{}, error) {
 	return resultMap, nil
 }
 
+// containerURL returns the URL of a container or snapshot.
+func containerURL(name string) string {
+	if shared.IsSnapshot(name) {
+		fields := strings.SplitN(name, shared.SnapshotDelimiter, 2)
+		return fmt.Sprintf("/%s/containers/%s/snapshots/%s", shared.APIVersion, fields[0], fields[1])
+	}
+
+	return fmt.Sprintf("/%s/containers/%s", shared.APIVersion, name)
+}
+
 func doContainerGet(d *Daemon, cname string) (shared.ContainerInfo, Response) {
 	c, err := containerLXDLoad(d, cname)
 	if err != nil {
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"runtime"
 	"strings"
 
 	"github.com/dustinkirkland/golang-petname"
@@ -14,20 +16,62 @@ import (
 	log "gopkg.in/inconshreveable/log15.v2"
 )
 
-func createFromImage(d *Daemon, req *containerPostReq) Response {
+/*
+ * containerNamesFromPattern returns the names of count containers to create
+ * from a pattern with a single %d in it, numbering them with the lowest
+ * numbers from 1 not giving the name of a container in taken.
+ */
+func containerNamesFromPattern(pattern string, count int, taken []string) ([]string, error) {
+	if strings.Count(pattern, "%d") != 1 || strings.Count(pattern, "%") != 1 {
+		return nil, fmt.Errorf("The name pattern must contain a single %%d: '%s'", pattern)
+	}
+
+	names := []string{}
+	for i := 1; len(names) < count; i++ {
+		name := fmt.Sprintf(pattern, i)
+		if shared.StringInSlice(name, taken) {
+			continue
+		}
+
+		if err := validContainerName(name); err != nil {
+			return nil, err
+		}
+
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+/*
+ * createFromImage creates req.Name from an image, or the containers named
+ * in names if it isn't nil, reporting then which ones got created the way
+ * a batch does.
+ */
+func createFromImage(d *Daemon, req *containerPostReq, names []string) Response {
 	var hash string
 	var err error
 	var run func() shared.OperationResult
 
+	// Whether the remote was asked what its alias points to
+	checked := false
+
 	if req.Source.Alias != "" {
 		if req.Source.Mode == "pull" && req.Source.Server != "" {
-			hash, err = remoteGetImageFingerprint(d, req.Source.Server, req.Source.Alias)
+			hash, checked, err = remoteCachedImageFingerprint(d, req.Source.Server, req.Source.Alias)
 			if err != nil {
 				return InternalError(err)
 			}
 		} else {
 
 			hash, err = dbImageAliasGet(d.db, req.Source.Alias)
+			if err == NoSuchObjectError {
+				// Maybe another member of the cluster has it
+				req.Source.Server, hash, err = clusterImageSource(d, req.Source.Alias, "")
+				if err == sql.ErrNoRows {
+					return NotFound
+				}
+			}
 			if err != nil {
 				return InternalError(err)
 			}
@@ -38,37 +82,107 @@ func createFromImage(d *Daemon, req *containerPostReq) Response {
 		return BadRequest(fmt.Errorf("must specify one of alias or fingerprint for init from image"))
 	}
 
-	if req.Source.Server != "" {
-		err := d.ImageDownload(req.Source.Server, hash, req.Source.Secret, true)
-		if err != nil {
-			return InternalError(err)
+	if req.Source.Server == "" {
+		if _, err := dbImageGet(d.db, hash, false, false); err == sql.ErrNoRows {
+			// Pull it from another member of the cluster, if any has it
+			req.Source.Server, hash, err = clusterImageSource(d, "", hash)
+			if err != nil {
+				return SmartError(err)
+			}
+		} else if err != nil {
+			return SmartError(err)
 		}
 	}
 
-	imgInfo, err := dbImageGet(d.db, hash, false, false)
-	if err != nil {
-		return SmartError(err)
-	}
-	hash = imgInfo.Fingerprint
+	canceller := &operationCanceller{}
+	progress := &operationProgress{}
+	image := func() (*shared.ImageBaseInfo, error) {
+		/* The image download is part of the operation, so that it
+		 * can be cancelled. */
+		if req.Source.Server != "" {
+			err := d.ImageDownload(req.Source.Server, hash, req.Source.Secret, true, canceller, progress)
+			if err != nil {
+				return nil, err
+			}
+		}
 
-	args := containerLXDArgs{
-		Ctype:        cTypeRegular,
-		Config:       req.Config,
-		Profiles:     req.Profiles,
-		Ephemeral:    req.Ephemeral,
-		BaseImage:    hash,
-		Architecture: imgInfo.Architecture,
+		if checked {
+			err := dbImageSourceSet(d.db, hash, req.Source.Server, req.Source.Alias)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		imgInfo, err := dbImageGet(d.db, hash, false, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if canceller.Cancelled() {
+			return nil, errOperationCancelled
+		}
+
+		return imgInfo, nil
 	}
 
-	run = shared.OperationWrap(func() error {
-		_, err := containerLXDCreateFromImage(d, req.Name, args, hash)
+	create := func(imgInfo *shared.ImageBaseInfo, name string) error {
+		args := containerLXDArgs{
+			Ctype:        cTypeRegular,
+			Config:       req.Config,
+			Profiles:     req.Profiles,
+			Ephemeral:    req.Ephemeral,
+			BaseImage:    imgInfo.Fingerprint,
+			Architecture: imgInfo.Architecture,
+		}
+
+		_, err := containerLXDCreateFromImage(d, name, args, imgInfo.Fingerprint)
 		return err
-	})
+	}
+
+	class := operationClassContainerCreation
+	if names == nil {
+		names = []string{req.Name}
+		run = shared.OperationWrap(func() error {
+			imgInfo, err := image()
+			if err != nil {
+				return err
+			}
+
+			progress.Stage("Creating container", 0)
+			return create(imgInfo, req.Name)
+		})
+	} else {
+		// Each creation counts against core.concurrent_container_creations
+		class = ""
+		run = func() shared.OperationResult {
+			imgInfo, err := image()
+			if err != nil {
+				return shared.OperationError(err)
+			}
+
+			progress.Stage(fmt.Sprintf("Creating %d containers", len(names)), int64(len(names)))
+			result := batchRun("create", names, runtime.NumCPU(), func(name string) error {
+				if !operationClassAcquire(operationClassContainerCreation, canceller) {
+					return errOperationCancelled
+				}
+				defer operationClassRelease(operationClassContainerCreation)
+
+				return create(imgInfo, name)
+			}, canceller, progress)
+
+			metadata, err := json.Marshal(result)
+			if err != nil {
+				return shared.OperationError(err)
+			}
+
+			return shared.OperationResult{Metadata: metadata}
+		}
+	}
 
 	resources := make(map[string][]string)
-	resources["containers"] = []string{req.Name}
+	resources["containers"] = names
 
-	return &asyncResponse{run: run, resources: resources}
+	return &asyncResponse{run: run, cancel: canceller.Cancel, resources: resources, progress: progress, class: class}
 }
 
 func createFromNone(d *Daemon, req *containerPostReq) Response {
@@ -87,7 +201,7 @@ func createFromNone(d *Daemon, req *containerPostReq) Response {
 	resources := make(map[string][]string)
 	resources["containers"] = []string{req.Name}
 
-	return &asyncResponse{run: run, resources: resources}
+	return &asyncResponse{run: run, resources: resources, class: operationClassContainerCreation}
 }
 
 func createFromMigration(d *Daemon, req *containerPostReq) Response {
@@ -95,7 +209,10 @@ func createFromMigration(d *Daemon, req *containerPostReq) Response {
 		return NotImplemented
 	}
 
+	canceller := &operationCanceller{}
+	progress := &operationProgress{}
 	run := func() shared.OperationResult {
+		progress.Stage("Creating container", 0)
 		createArgs := containerLXDArgs{
 			Ctype:     cTypeRegular,
 			Config:    req.Config,
@@ -139,10 +256,12 @@ func createFromMigration(d *Daemon, req *containerPostReq) Response {
 			Url: req.Source.Operation,
 			Dialer: websocket.Dialer{
 				TLSClientConfig: config,
-				NetDial:         shared.RFC3493Dialer},
+				NetDial:         shared.RFC3493Dialer,
+				Proxy:           d.proxy},
 			Container: lxContainer,
 			Secrets:   req.Source.Websockets,
 			IdMapSet:  idmapset,
+			Cancel:    make(chan bool),
 		}
 
 		sink, err := migration.NewMigrationSink(&args)
@@ -155,13 +274,18 @@ func createFromMigration(d *Daemon, req *containerPostReq) Response {
 		c.StorageStart()
 		defer c.StorageStop()
 
-		// And finaly run the migration.
+		// And finaly run the migration, which a cancel interrupts by
+		// closing its connections.
+		progress.Stage("Receiving container", 0)
+		done := canceller.OnCancel(func() { close(args.Cancel) })
 		err = sink()
+		done()
 		if err != nil {
 			c.Delete()
 			return shared.OperationError(err)
 		}
 
+		progress.Stage("Applying templates", 0)
 		err = c.TemplateApply("copy")
 		if err != nil {
 			return shared.OperationError(err)
@@ -173,7 +297,7 @@ func createFromMigration(d *Daemon, req *containerPostReq) Response {
 	resources := make(map[string][]string)
 	resources["containers"] = []string{req.Name}
 
-	return &asyncResponse{run: run, resources: resources}
+	return &asyncResponse{run: run, cancel: canceller.Cancel, resources: resources, progress: progress, class: operationClassMigration}
 }
 
 func createFromCopy(d *Daemon, req *containerPostReq) Response {
@@ -213,23 +337,37 @@ func createFromCopy(d *Daemon, req *containerPostReq) Response {
 		BaseImage: req.Source.BaseImage,
 	}
 
+	// Snapshots come along unless asked otherwise
+	withSnapshots := !req.Source.ContainerOnly && !shared.IsSnapshot(req.Source.Source)
+
+	canceller := &operationCanceller{}
+	progress := &operationProgress{}
 	run := func() shared.OperationResult {
-		_, err := containerLXDCreateAsCopy(d, req.Name, args, source)
+		progress.Stage("Copying container", 0)
+		c, err := containerLXDCreateAsCopy(d, req.Name, args, source, canceller)
 		if err != nil {
 			return shared.OperationError(err)
 		}
 
+		if withSnapshots {
+			progress.Stage("Copying snapshots", 0)
+			if err := containerLXDCopySnapshots(d, c, source, canceller); err != nil {
+				c.Delete()
+				return shared.OperationError(err)
+			}
+		}
+
 		return shared.OperationSuccess
 	}
 
 	resources := make(map[string][]string)
 	resources["containers"] = []string{req.Name, req.Source.Source}
 
-	return &asyncResponse{run: run, resources: resources}
+	return &asyncResponse{run: run, cancel: canceller.Cancel, resources: resources, progress: progress, class: operationClassContainerCreation}
 }
 
 func containersPost(d *Daemon, r *http.Request) Response {
-	shared.Debugf("Responding to container create")
+	requestLog(r).Debug("Responding to container create")
 
 	if d.IdmapSet == nil {
 		return BadRequest(fmt.Errorf("shared's user has no subuids"))
@@ -240,18 +378,26 @@ func containersPost(d *Daemon, r *http.Request) Response {
 		return BadRequest(err)
 	}
 
+	if req.Count != 0 {
+		return containersPostMany(d, &req)
+	}
+
 	if req.Name == "" {
 		req.Name = strings.ToLower(petname.Generate(2, "-"))
-		shared.Debugf("No name provided, creating %s", req.Name)
+		requestLog(r).Debug("No name provided, generated one", log.Ctx{"container": req.Name})
 	}
 
-	if strings.Contains(req.Name, shared.SnapshotDelimiter) {
-		return BadRequest(fmt.Errorf("Invalid container name: '%s' is reserved for snapshots", shared.SnapshotDelimiter))
+	if err := validContainerName(req.Name); err != nil {
+		return BadRequest(err)
+	}
+
+	if resp := containerSchedulerCheck(d, &req); resp != nil {
+		return resp
 	}
 
 	switch req.Source.Type {
 	case "image":
-		return createFromImage(d, &req)
+		return createFromImage(d, &req, nil)
 	case "none":
 		return createFromNone(d, &req)
 	case "migration":
@@ -263,3 +409,35 @@ func containersPost(d *Daemon, r *http.Request) Response {
 	}
 
 }
+
+/*
+ * containersPostMany creates req.Count containers from an image in a single
+ * operation, naming them after the pattern in req.Name (e.g. web-%d).
+ */
+func containersPostMany(d *Daemon, req *containerPostReq) Response {
+	if req.Count < 0 {
+		return BadRequest(fmt.Errorf("The number of containers to create must be positive"))
+	}
+
+	if req.Source.Type != "image" {
+		return BadRequest(fmt.Errorf("Only containers created from an image can be created in bulk"))
+	}
+
+	taken, err := dbContainersList(d.db, cTypeRegular)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	names, err := containerNamesFromPattern(req.Name, req.Count, taken)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	// The hook is asked about all of them at once, so that it can tell
+	// whether there's room for the lot
+	if resp := containerSchedulerCheck(d, req); resp != nil {
+		return resp
+	}
+
+	return createFromImage(d, req, names)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_container_names_from_pattern(t *testing.T) {
+	names, err := containerNamesFromPattern("web-%d", 3, []string{"web-2", "db-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(names) != 3 || names[0] != "web-1" || names[1] != "web-3" || names[2] != "web-4" {
+		t.Errorf("Wrong names: %v", names)
+	}
+
+	for _, pattern := range []string{"web", "web-%d-%d", "web-%s", "web-%d%%", "%d-web"} {
+		if _, err := containerNamesFromPattern(pattern, 2, nil); err == nil {
+			t.Errorf("Accepted a bad pattern: '%s'", pattern)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// How long the scheduler hook gets to answer, after which the creation fails
+const schedulerHookTimeout = 30 * time.Second
+
+// What the scheduler hook is given about the container to create, Count
+// of them when they're created in bulk (Name being their pattern then)
+type schedulerHookRequest struct {
+	Name      string            `json:"name"`
+	Count     int               `json:"count"`
+	Source    string            `json:"source"`
+	Profiles  []string          `json:"profiles"`
+	Ephemeral bool              `json:"ephemeral"`
+	Limits    map[string]string `json:"limits"`
+}
+
+/*
+ * schedulerHookRun asks core.scheduler_hook whether a container can be
+ * created, returning the reason it gave when it can't. An http:// or
+ * https:// hook is POSTed the request and a 2xx status lets the container
+ * be created, the body of others being the reason. Any other hook is an
+ * executable given the request on stdin, exiting with 0 to let the
+ * container be created, its output being the reason otherwise.
+ */
+func schedulerHookRun(hook string, req schedulerHookRequest) (bool, string, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return false, "", err
+	}
+
+	var output []byte
+	allowed := false
+	if strings.HasPrefix(hook, "http://") || strings.HasPrefix(hook, "https://") {
+		client := http.Client{Timeout: schedulerHookTimeout}
+		resp, err := client.Post(hook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return false, "", err
+		}
+		defer resp.Body.Close()
+
+		output, err = ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return false, "", err
+		}
+
+		allowed = resp.StatusCode >= 200 && resp.StatusCode < 300
+		if !allowed && len(output) == 0 {
+			output = []byte(resp.Status)
+		}
+	} else {
+		cmd := exec.Command(hook)
+		cmd.Stdin = bytes.NewReader(body)
+
+		stdout := bytes.Buffer{}
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stdout
+		if err := cmd.Start(); err != nil {
+			return false, "", err
+		}
+
+		timer := time.AfterFunc(schedulerHookTimeout, func() { cmd.Process.Kill() })
+		err := cmd.Wait()
+		if !timer.Stop() {
+			return false, "", fmt.Errorf("The scheduler hook didn't answer within %s", schedulerHookTimeout)
+		}
+
+		if _, ok := err.(*exec.ExitError); err != nil && !ok {
+			return false, "", err
+		}
+
+		output = stdout.Bytes()
+		allowed = err == nil
+	}
+
+	if allowed {
+		return true, "", nil
+	}
+
+	reason := strings.TrimSpace(string(output))
+	if reason == "" {
+		reason = "no reason given"
+	}
+
+	return false, reason, nil
+}
+
+/*
+ * schedulerHookLimits returns the limits the container to create would
+ * have, those of its profiles and config, a copy also getting those of its
+ * source.
+ */
+func schedulerHookLimits(d *Daemon, req *containerPostReq) ([]string, map[string]string, error) {
+	config := map[string]string{}
+	profiles := req.Profiles
+
+	if req.Source.Type == "copy" {
+		source, err := containerLXDLoad(d, req.Source.Source)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for key, value := range source.ConfigGet() {
+			config[key] = value
+		}
+
+		if profiles == nil {
+			profiles = source.ProfilesGet()
+		}
+	}
+
+	for key, value := range req.Config {
+		config[key] = value
+	}
+
+	if profiles == nil {
+		profiles = []string{"default"}
+	}
+
+	expansion, err := dbProfilesExpansion(d.db, profiles)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	expanded, _ := containerExpand(expansion, config, nil)
+	limits := map[string]string{}
+	for key, value := range expanded {
+		if strings.HasPrefix(key, "limits.") {
+			limits[key] = value
+		}
+	}
+
+	return profiles, limits, nil
+}
+
+/*
+ * containerSchedulerCheck lets core.scheduler_hook refuse the creation of
+ * a container, returning nil when it can go ahead. The creation also fails
+ * when the hook can't be run, as a capacity manager which can't be asked
+ * can't agree either.
+ */
+func containerSchedulerCheck(d *Daemon, req *containerPostReq) Response {
+	err := containerSchedulerAsk(d, req)
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := err.(schedulerRefusedError); ok {
+		return &ErrorResponse{http.StatusForbidden, shared.ForbiddenCode, err.Error()}
+	}
+
+	return SmartError(err)
+}
+
+// schedulerRefusedError is the reason the scheduler hook gave for refusing
+// a container.
+type schedulerRefusedError string
+
+func (e schedulerRefusedError) Error() string {
+	return fmt.Sprintf("The scheduler hook refused the container: %s", string(e))
+}
+
+/*
+ * containerSchedulerAsk is containerSchedulerCheck for the creations which
+ * are part of a larger operation, returning a schedulerRefusedError if the
+ * hook refused the container.
+ */
+func containerSchedulerAsk(d *Daemon, req *containerPostReq) error {
+	hook, err := d.ConfigValueGet("core.scheduler_hook")
+	if err != nil {
+		return err
+	}
+
+	if hook == "" {
+		return nil
+	}
+
+	profiles, limits, err := schedulerHookLimits(d, req)
+	if err != nil {
+		return err
+	}
+
+	count := req.Count
+	if count < 1 {
+		count = 1
+	}
+
+	allowed, reason, err := schedulerHookRun(hook, schedulerHookRequest{
+		Name:      req.Name,
+		Count:     count,
+		Source:    req.Source.Type,
+		Profiles:  profiles,
+		Ephemeral: req.Ephemeral,
+		Limits:    limits,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to run the scheduler hook: %s", err)
+	}
+
+	if !allowed {
+		shared.Log.Info("The scheduler hook refused a container", log.Ctx{"container": req.Name, "reason": reason})
+		return schedulerRefusedError(reason)
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_scheduler_hook_exec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lxd_test_scheduler_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hook := path.Join(dir, "hook")
+	script := "#!/bin/sh\ngrep -q '\"limits.memory\":\"4096\"' && { echo 'Not enough memory'; exit 1; }\nexit 0\n"
+	if err := ioutil.WriteFile(hook, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	req := schedulerHookRequest{Name: "c1", Source: "image", Profiles: []string{"default"}, Limits: map[string]string{"limits.memory": "512"}}
+	allowed, reason, err := schedulerHookRun(hook, req)
+	if err != nil || !allowed {
+		t.Errorf("The container was refused: %s (%v)", reason, err)
+	}
+
+	req.Limits["limits.memory"] = "4096"
+	allowed, reason, err = schedulerHookRun(hook, req)
+	if err != nil || allowed || reason != "Not enough memory" {
+		t.Errorf("The container wasn't refused with the hook's reason: %s (%v)", reason, err)
+	}
+
+	if _, _, err := schedulerHookRun(path.Join(dir, "missing"), req); err == nil {
+		t.Errorf("A missing hook didn't fail")
+	}
+}
+
+func Test_scheduler_hook_http(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := schedulerHookRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if req.Ephemeral {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte("No ephemeral containers here\n"))
+		}
+	}))
+	defer server.Close()
+
+	req := schedulerHookRequest{Name: "c1", Source: "none"}
+	allowed, reason, err := schedulerHookRun(server.URL, req)
+	if err != nil || !allowed {
+		t.Errorf("The container was refused: %s (%v)", reason, err)
+	}
+
+	req.Ephemeral = true
+	allowed, reason, err = schedulerHookRun(server.URL, req)
+	if err != nil || allowed || reason != "No ephemeral containers here" {
+		t.Errorf("The container wasn't refused with the hook's reason: %s (%v)", reason, err)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// The response headers browser based clients are allowed to read.
+const corsExposedHeaders = "ETag, Location, Retry-After, X-LXD-request-id"
+
+/*
+ * corsHeaders adds the CORS headers to the response to a request sent from
+ * a web page, if its origin is listed in core.https_allowed_origin. It
+ * returns whether the origin is allowed.
+ */
+func (d *Daemon) corsHeaders(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+
+	values, err := d.ConfigValuesGet()
+	if err != nil {
+		return false
+	}
+
+	listed := false
+	wildcard := false
+	for _, entry := range strings.Split(values["core.https_allowed_origin"], ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == origin {
+			listed = true
+			break
+		}
+
+		if entry == "*" {
+			wildcard = true
+		}
+	}
+
+	if !listed && !wildcard {
+		return false
+	}
+
+	methods := values["core.https_allowed_methods"]
+	if methods == "" {
+		methods = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+	}
+
+	headers := values["core.https_allowed_headers"]
+	if headers == "" {
+		headers = "Content-Type, If-Match"
+	}
+
+	w.Header().Set("Access-Control-Allow-Methods", methods)
+	w.Header().Set("Access-Control-Allow-Headers", headers)
+	w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+
+	/*
+	 * Any origin only gets a literal "*", which browsers never send
+	 * credentials with. Those are only allowed for the origins listed by
+	 * name, which are echoed back, so caches must tell origins apart.
+	 */
+	if !listed {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return true
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Add("Vary", "Origin")
+	if values["core.https_allowed_credentials"] == "true" {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	return true
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_cors_headers_only_go_to_allowed_origins(t *testing.T) {
+	d := &Daemon{configValues: map[string]string{
+		"core.https_allowed_origin":      "https://dashboard.example.com, https://other.example.com",
+		"core.https_allowed_credentials": "true",
+	}}
+
+	r, err := http.NewRequest("OPTIONS", "/1.0/containers", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	if d.corsHeaders(w, r) {
+		t.Error("A request without an origin isn't a CORS request")
+	}
+
+	r.Header.Set("Origin", "https://evil.example.com")
+	if d.corsHeaders(w, r) || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("An unknown origin was allowed")
+	}
+
+	r.Header.Set("Origin", "https://other.example.com")
+	if !d.corsHeaders(w, r) {
+		t.Fatal("A listed origin wasn't allowed")
+	}
+
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://other.example.com" {
+		t.Errorf("Wrong allowed origin: %s", w.Header().Get("Access-Control-Allow-Origin"))
+	}
+
+	if w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Error("Credentials weren't allowed")
+	}
+
+	if w.Header().Get("Access-Control-Allow-Methods") == "" {
+		t.Error("The default methods weren't sent")
+	}
+}
+
+func Test_cors_any_origin_gets_no_credentials(t *testing.T) {
+	d := &Daemon{configValues: map[string]string{
+		"core.https_allowed_origin":      "*",
+		"core.https_allowed_credentials": "true",
+	}}
+
+	r, err := http.NewRequest("GET", "/1.0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Header.Set("Origin", "https://evil.example.com")
+	w := httptest.NewRecorder()
+	if !d.corsHeaders(w, r) {
+		t.Fatal("Any origin wasn't allowed")
+	}
+
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("The origin was echoed: %s", w.Header().Get("Access-Control-Allow-Origin"))
+	}
+
+	if w.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Error("Credentials were allowed for any origin")
+	}
+}
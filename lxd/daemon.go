@@ -5,17 +5,20 @@ import (
 	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"database/sql"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -23,6 +26,7 @@ import (
 
 	"github.com/gorilla/mux"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/satori/go.uuid"
 	"github.com/stgraber/lxd-go-systemd/activation"
 	"gopkg.in/tomb.v2"
 
@@ -37,6 +41,10 @@ const (
 	pwHashBytes = 64
 )
 
+// shutdownDefaultTimeout is how long, in seconds, the daemon waits for the
+// running operations on shutdown when core.shutdown_timeout isn't set.
+const shutdownDefaultTimeout = 300
+
 type Socket struct {
 	Socket      net.Listener
 	CloseOnExit bool
@@ -48,6 +56,9 @@ type Daemon struct {
 	BackingFs     string
 	certf         string
 	clientCerts   []x509.Certificate
+	clientLimits  map[string]*dbCertInfo
+	clientCA      *x509.CertPool
+	clientCRL     *pkix.CertificateList
 	db            *sql.DB
 	IdmapSet      *shared.IdmapSet
 	keyf          string
@@ -56,11 +67,14 @@ type Daemon struct {
 	tomb          tomb.Tomb
 	pruneChan     chan bool
 
+	cpuScheduleChan chan bool
+
 	Storage storage
 
 	Sockets []Socket
 
-	tlsconfig *tls.Config
+	tlsconfig  *tls.Config
+	serverCert serverCertificate
 
 	devlxd *net.UnixListener
 
@@ -70,6 +84,31 @@ type Daemon struct {
 
 	imagesDownloading     map[string]chan bool
 	imagesDownloadingLock sync.RWMutex
+
+	apiLimiter rateLimiter
+	apiMetrics apiMetrics
+
+	// readyChan is closed once the daemon is fully initialized
+	readyChan chan bool
+
+	// shutdownChan is closed once the daemon starts shutting down, from
+	// then on only requests following the running operations are served
+	shutdownChan chan bool
+	shutdownOnce sync.Once
+
+	// exitChan is closed when the daemon is asked to exit over the API
+	exitChan chan bool
+	exitOnce sync.Once
+
+	// Number of requests changing something being handled
+	mutations int32
+
+	// The listener of the profiling endpoints, see core.debug_address
+	debugListener     net.Listener
+	debugListenerLock sync.Mutex
+
+	// Guards clientCerts and clientLimits, see clientCertsGet
+	clientCertsLock sync.RWMutex
 }
 
 // Command is the basic structure for every API call.
@@ -79,10 +118,26 @@ type Command struct {
 	untrustedPost bool
 	get           func(d *Daemon, r *http.Request) Response
 	put           func(d *Daemon, r *http.Request) Response
+	patch         func(d *Daemon, r *http.Request) Response
 	post          func(d *Daemon, r *http.Request) Response
 	delete        func(d *Daemon, r *http.Request) Response
 }
 
+// proxy returns the proxy to use for an outbound request, based on the
+// core.proxy_* keys or on the environment when those aren't set.
+func (d *Daemon) proxy(req *http.Request) (*url.URL, error) {
+	values, err := d.ConfigValuesGet()
+	if err != nil {
+		return nil, err
+	}
+
+	return shared.ProxyFromConfig(
+		values["core.proxy_https"],
+		values["core.proxy_http"],
+		values["core.proxy_ignore_hosts"],
+	)(req)
+}
+
 func (d *Daemon) httpGetSync(url string) (*lxd.Response, error) {
 	var err error
 	if d.tlsconfig == nil {
@@ -94,6 +149,7 @@ func (d *Daemon) httpGetSync(url string) (*lxd.Response, error) {
 	tr := &http.Transport{
 		TLSClientConfig: d.tlsconfig,
 		Dial:            shared.RFC3493Dialer,
+		Proxy:           d.proxy,
 	}
 	myhttp := http.Client{
 		Transport: tr,
@@ -134,6 +190,7 @@ func (d *Daemon) httpGetFile(url string) (*http.Response, error) {
 	tr := &http.Transport{
 		TLSClientConfig: d.tlsconfig,
 		Dial:            shared.RFC3493Dialer,
+		Proxy:           d.proxy,
 	}
 	myhttp := http.Client{
 		Transport: tr,
@@ -178,15 +235,24 @@ func (d *Daemon) isTrustedClient(r *http.Request) bool {
 		// Unix socket
 		return true
 	}
-	if r.TLS == nil {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
 		return false
 	}
-	for i := range r.TLS.PeerCertificates {
-		if d.CheckTrustState(*r.TLS.PeerCertificates[i]) {
-			return true
-		}
+
+	/*
+	 * The handshake only proves that the client holds the key of the
+	 * first certificate, the others can only be intermediates to the CA.
+	 */
+	cert := r.TLS.PeerCertificates[0]
+	if d.certRevoked(cert) {
+		return false
 	}
-	return false
+
+	if d.CheckTrustState(*cert) && !certExpired(cert) {
+		return true
+	}
+
+	return d.certSignedByCA(cert, r.TLS.PeerCertificates[1:])
 }
 
 func isJSONRequest(r *http.Request) bool {
@@ -210,6 +276,26 @@ func (d *Daemon) isRecursionRequest(r *http.Request) bool {
 	return recursion == 1
 }
 
+// requestIDs holds the ID of the requests being handled.
+var requestIDs = map[*http.Request]string{}
+var requestIDsLock sync.Mutex
+
+/*
+ * requestLog returns the logger for what's done on behalf of a request,
+ * tagging the lines with the request's ID when it's being handled.
+ */
+func requestLog(r *http.Request) log.Logger {
+	requestIDsLock.Lock()
+	id, ok := requestIDs[r]
+	requestIDsLock.Unlock()
+
+	if !ok {
+		return shared.Log
+	}
+
+	return shared.Log.New("request", id)
+}
+
 func (d *Daemon) createCmd(version string, c Command) {
 	var uri string
 	if c.name == "" {
@@ -219,29 +305,101 @@ func (d *Daemon) createCmd(version string, c Command) {
 	}
 
 	d.mux.HandleFunc(uri, func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
 		w.Header().Set("Content-Type", "application/json")
 
-		if d.isTrustedClient(r) {
-			shared.Log.Info(
-				"handling",
-				log.Ctx{"method": r.Method, "url": r.URL.RequestURI(), "ip": r.RemoteAddr})
+		// Sent back to the client, errors included, and logged with
+		// everything about the request
+		requestID := uuid.NewV4().String()
+		w.Header().Set("X-LXD-request-id", requestID)
+
+		requestIDsLock.Lock()
+		requestIDs[r] = requestID
+		requestIDsLock.Unlock()
+		defer func() {
+			requestIDsLock.Lock()
+			delete(requestIDs, r)
+			requestIDsLock.Unlock()
+		}()
+
+		/*
+		 * Browsers check with an OPTIONS request whether they may send
+		 * the actual one, the answer only depends on its origin.
+		 */
+		if d.corsHeaders(w, r) && r.Method == "OPTIONS" {
+			EmptySyncResponse.Render(w)
+			return
+		}
+
+		if d.rateLimited(r) {
+			shared.Log.Warn(
+				"rejecting request over the rate limit",
+				log.Ctx{"request": requestID, "method": r.Method, "url": r.URL.RequestURI(), "ip": r.RemoteAddr})
+			w.Header().Set("Retry-After", "1")
+			TooManyRequests.Render(w)
+			return
+		}
+
+		// Those are waited for when shutting down
+		if r.Method != "GET" {
+			atomic.AddInt32(&d.mutations, 1)
+			defer atomic.AddInt32(&d.mutations, -1)
+		}
+
+		if d.shuttingDown() && !strings.HasPrefix(c.name, "operations") && c.name != "health" {
+			ServiceUnavailable(fmt.Errorf("LXD is shutting down")).Render(w)
+			return
+		}
+
+		var resp Response
+		if cert := d.expiredClientCert(r); cert != nil && !d.isTrustedClient(r) {
+			shared.Log.Warn(
+				"rejecting request with an expired client certificate",
+				log.Ctx{"request": requestID, "method": r.Method, "url": r.URL.RequestURI(), "ip": r.RemoteAddr, "expiry": cert.NotAfter})
+			resp = &ErrorResponse{http.StatusForbidden, shared.ForbiddenCode,
+				fmt.Sprintf("The client certificate expired on %s", cert.NotAfter.UTC().Format(time.RFC3339))}
+		} else if d.isTrustedClient(r) {
+			if !d.clientAllowed(r, c) {
+				shared.Log.Warn(
+					"rejecting request outside of the client's limits",
+					log.Ctx{"request": requestID, "method": r.Method, "url": r.URL.RequestURI(), "ip": r.RemoteAddr})
+				resp = Forbidden
+			}
 		} else if r.Method == "GET" && c.untrustedGet {
 			shared.Log.Info(
 				"allowing untrusted GET",
-				log.Ctx{"url": r.URL.RequestURI(), "ip": r.RemoteAddr})
+				log.Ctx{"request": requestID, "url": r.URL.RequestURI(), "ip": r.RemoteAddr})
 		} else if r.Method == "POST" && c.untrustedPost {
 			shared.Log.Info(
 				"allowing untrusted POST",
-				log.Ctx{"url": r.URL.RequestURI(), "ip": r.RemoteAddr})
+				log.Ctx{"request": requestID, "url": r.URL.RequestURI(), "ip": r.RemoteAddr})
 		} else {
 			shared.Log.Warn(
 				"rejecting request from untrusted client",
-				log.Ctx{"ip": r.RemoteAddr})
-			Forbidden.Render(w)
-			return
+				log.Ctx{"request": requestID, "method": r.Method, "url": r.URL.RequestURI(), "ip": r.RemoteAddr})
+			resp = Forbidden
 		}
 
-		if *debug && r.Method != "GET" && isJSONRequest(r) {
+		// Every request of a trusted client changing something ends up
+		// in the audit log
+		var audit *auditEntry
+		if resp == nil && r.Method != "GET" && d.isTrustedClient(r) {
+			summary, err := auditSummary(r)
+			if err != nil {
+				InternalError(err).Render(w)
+				return
+			}
+
+			audit = &auditEntry{
+				Date:    time.Now().UTC(),
+				Client:  auditClient(w, r),
+				Method:  r.Method,
+				URL:     r.URL.RequestURI(),
+				Summary: summary,
+			}
+		}
+
+		if resp == nil && *debug && r.Method != "GET" && isJSONRequest(r) {
 			newBody := &bytes.Buffer{}
 			captured := &bytes.Buffer{}
 			multiW := io.MultiWriter(newBody, captured)
@@ -254,37 +412,77 @@ func (d *Daemon) createCmd(version string, c Command) {
 			shared.DebugJson(captured)
 		}
 
-		var resp Response
-		resp = NotImplemented
+		// Container requests can target another member of the cluster
+		if resp == nil {
+			resp = clusterForward(d, c, r)
+		}
 
-		switch r.Method {
-		case "GET":
-			if c.get != nil {
-				resp = c.get(d, r)
-			}
-		case "PUT":
-			if c.put != nil {
-				resp = c.put(d, r)
-			}
-		case "POST":
-			if c.post != nil {
-				resp = c.post(d, r)
-			}
-		case "DELETE":
-			if c.delete != nil {
-				resp = c.delete(d, r)
+		if resp == nil {
+			resp = NotImplemented
+
+			// Handlers can give up once the client is gone
+			release := requestCancellerStart(w, r)
+			defer release()
+
+			switch r.Method {
+			case "GET":
+				if c.get != nil {
+					resp = c.get(d, r)
+				}
+			case "PUT":
+				if c.put != nil {
+					resp = c.put(d, r)
+				}
+			case "PATCH":
+				if c.patch != nil {
+					resp = c.patch(d, r)
+				}
+			case "POST":
+				if c.post != nil {
+					resp = c.post(d, r)
+				}
+			case "DELETE":
+				if c.delete != nil {
+					resp = c.delete(d, r)
+				}
+			default:
+				resp = NotFound
 			}
-		default:
-			resp = NotFound
+		}
+
+		// Record which container background operations are about, so
+		// that clients restricted to that container can follow them.
+		if async, ok := resp.(*asyncResponse); ok && async.resources == nil && strings.HasPrefix(c.name, "containers/{name}") {
+			async.resources = map[string][]string{"containers": []string{mux.Vars(r)["name"]}}
+		}
+
+		// The operation's log lines carry the ID of the request too
+		if async, ok := resp.(*asyncResponse); ok {
+			async.requestID = requestID
 		}
 
 		if err := resp.Render(w); err != nil {
+			shared.Log.Error("failed to render the response", log.Ctx{"request": requestID, "err": err})
 			err := InternalError(err).Render(w)
 			if err != nil {
-				shared.Log.Error("Failed writing error for error, giving up")
+				shared.Log.Error("Failed writing error for error, giving up", log.Ctx{"request": requestID})
 			}
 		}
 
+		duration := time.Since(start)
+		status := responseStatusCode(resp)
+		d.apiMetrics.record(uri, r.Method, status, duration)
+
+		shared.Log.Info(
+			"handled",
+			log.Ctx{"request": requestID, "method": r.Method, "url": r.URL.RequestURI(),
+				"client": auditClient(w, r), "duration": duration, "status": status})
+
+		if audit != nil {
+			audit.StatusCode = status
+			d.auditRecord(audit, resp)
+		}
+
 		/*
 		 * When we create a new lxc.Container, it adds a finalizer (via
 		 * SetFinalizer) that frees the struct. However, it sometimes
@@ -347,6 +545,30 @@ func setupSharedMounts() error {
 	return nil
 }
 
+/*
+ * httpsAddresses splits the value of core.https_address, a comma separated
+ * list of addresses, adding the default port to those which have none.
+ */
+func httpsAddresses(value string) []string {
+	addresses := []string{}
+	for _, address := range strings.Split(value, ",") {
+		address = strings.TrimSpace(address)
+		if address == "" {
+			continue
+		}
+
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			address = net.JoinHostPort(strings.Trim(address, "[]"), shared.DefaultPort)
+		}
+
+		if !shared.StringInSlice(address, addresses) {
+			addresses = append(addresses, address)
+		}
+	}
+
+	return addresses
+}
+
 func (d *Daemon) ListenAddresses() ([]string, error) {
 	addresses := make([]string, 0)
 
@@ -355,93 +577,108 @@ func (d *Daemon) ListenAddresses() ([]string, error) {
 		return addresses, err
 	}
 
-	localHost, localPort, err := net.SplitHostPort(value)
-	if err != nil {
-		localHost = value
-		localPort = shared.DefaultPort
-	}
-
-	if localHost == "0.0.0.0" || localHost == "::" {
-		ifaces, err := net.Interfaces()
+	for _, address := range httpsAddresses(value) {
+		localHost, localPort, err := net.SplitHostPort(address)
 		if err != nil {
-			return addresses, err
+			continue
 		}
 
-		for _, i := range ifaces {
-			addrs, err := i.Addrs()
+		if localHost == "0.0.0.0" || localHost == "::" {
+			ifaces, err := net.Interfaces()
 			if err != nil {
-				continue
+				return addresses, err
 			}
 
-			for _, addr := range addrs {
-				var ip net.IP
-				switch v := addr.(type) {
-				case *net.IPNet:
-					ip = v.IP
-				case *net.IPAddr:
-					ip = v.IP
-				}
-
-				if !ip.IsGlobalUnicast() {
+			for _, i := range ifaces {
+				addrs, err := i.Addrs()
+				if err != nil {
 					continue
 				}
 
-				if ip.To4() == nil {
-					if localHost == "0.0.0.0" {
+				for _, addr := range addrs {
+					var ip net.IP
+					switch v := addr.(type) {
+					case *net.IPNet:
+						ip = v.IP
+					case *net.IPAddr:
+						ip = v.IP
+					}
+
+					if !ip.IsGlobalUnicast() {
 						continue
 					}
-					addresses = append(addresses, fmt.Sprintf("[%s]:%s", ip, localPort))
-				} else {
-					addresses = append(addresses, fmt.Sprintf("%s:%s", ip, localPort))
+
+					if ip.To4() == nil {
+						if localHost == "0.0.0.0" {
+							continue
+						}
+						addresses = append(addresses, fmt.Sprintf("[%s]:%s", ip, localPort))
+					} else {
+						addresses = append(addresses, fmt.Sprintf("%s:%s", ip, localPort))
+					}
 				}
 			}
-		}
-	} else {
-		ip := net.ParseIP(localHost)
-		if ip != nil && ip.IsGlobalUnicast() {
-			addresses = append(addresses, value)
+		} else {
+			ip := net.ParseIP(localHost)
+			if ip != nil && ip.IsGlobalUnicast() {
+				addresses = append(addresses, address)
+			}
 		}
 	}
 
 	return addresses, nil
 }
 
+/*
+ * UpdateHTTPsPort moves the HTTPS listeners from the addresses in
+ * oldAddress to those in newAddress. The new addresses are bound before
+ * the old ones are released, so that a failure leaves the listeners
+ * untouched. Connections already established, like those of exec sessions,
+ * are kept.
+ */
 func (d *Daemon) UpdateHTTPsPort(oldAddress string, newAddress string) error {
-	var sockets []Socket
+	oldAddresses := httpsAddresses(oldAddress)
+	newAddresses := httpsAddresses(newAddress)
 
-	if oldAddress != "" {
-		_, _, err := net.SplitHostPort(oldAddress)
-		if err != nil {
-			oldAddress = fmt.Sprintf("%s:%s", oldAddress, shared.DefaultPort)
+	var listeners []net.Listener
+	fail := func(err error) error {
+		for _, listener := range listeners {
+			listener.Close()
 		}
 
-		for _, socket := range d.Sockets {
-			if socket.Socket.Addr().String() == oldAddress {
-				socket.Socket.Close()
-			} else {
-				sockets = append(sockets, socket)
-			}
-		}
-	} else {
-		sockets = d.Sockets
+		return err
 	}
 
-	if newAddress != "" {
-		_, _, err := net.SplitHostPort(newAddress)
-		if err != nil {
-			newAddress = fmt.Sprintf("%s:%s", newAddress, shared.DefaultPort)
+	for _, address := range newAddresses {
+		if shared.StringInSlice(address, oldAddresses) {
+			continue
 		}
 
-		tlsConfig, err := shared.GetTLSConfig(d.certf, d.keyf)
+		tlsConfig, err := d.listenerTLSConfig()
 		if err != nil {
-			return err
+			return fail(err)
 		}
 
-		tcpl, err := tls.Listen("tcp", newAddress, tlsConfig)
+		tcpl, err := tls.Listen("tcp", address, tlsConfig)
 		if err != nil {
-			return fmt.Errorf("cannot listen on https socket: %v", err)
+			return fail(fmt.Errorf("cannot listen on https socket: %v", err))
 		}
 
+		listeners = append(listeners, tcpl)
+	}
+
+	var sockets []Socket
+	for _, socket := range d.Sockets {
+		address := socket.Socket.Addr().String()
+		if shared.StringInSlice(address, oldAddresses) && !shared.StringInSlice(address, newAddresses) {
+			socket.Socket.Close()
+		} else {
+			sockets = append(sockets, socket)
+		}
+	}
+
+	for _, listener := range listeners {
+		tcpl := listener
 		d.tomb.Go(func() error { return http.Serve(tcpl, d.mux) })
 		sockets = append(sockets, Socket{Socket: tcpl, CloseOnExit: true})
 	}
@@ -494,10 +731,27 @@ func startDaemon() (*Daemon, error) {
 	return d, nil
 }
 
+/*
+ * kernelSupportsPersonality tells whether the kernel can run the binaries
+ * of a 32-bit personality of its architecture. x86_64 kernels can only do
+ * so with IA32 emulation, which provides abi.vsyscall32.
+ */
+func kernelSupportsPersonality(architecture int, personality int) bool {
+	if architecture == shared.ARCH_64BIT_INTEL_X86 && personality == shared.ARCH_32BIT_INTEL_X86 {
+		return shared.PathExists("/proc/sys/abi/vsyscall32")
+	}
+
+	return true
+}
+
 func (d *Daemon) Init() error {
+	d.readyChan = make(chan bool)
+	d.shutdownChan = make(chan bool)
+	d.exitChan = make(chan bool)
+
 	/* Setup logging */
 	if shared.Log == nil {
-		shared.SetLogger("", "", true, true)
+		shared.SetLogger("", "", true, true, nil)
 	}
 
 	if !d.IsMock {
@@ -535,6 +789,10 @@ func (d *Daemon) Init() error {
 		return err
 	}
 	for _, personality := range personalities {
+		if !kernelSupportsPersonality(architecture, personality) {
+			shared.Log.Info("The kernel can't run containers of this architecture", log.Ctx{"architecture": personality})
+			continue
+		}
 		architectures = append(architectures, personality)
 	}
 	d.architectures = architectures
@@ -576,6 +834,7 @@ func (d *Daemon) Init() error {
 	}
 
 	/* Initialize the database */
+	firstStart := !shared.PathExists(shared.VarPath("lxd.db"))
 	if !d.IsMock {
 		err = initializeDbObject(d, shared.VarPath("lxd.db"))
 	} else {
@@ -585,6 +844,27 @@ func (d *Daemon) Init() error {
 		return err
 	}
 
+	/* Apply the configured log level, unless debugging was asked for */
+	if !*debug {
+		logLevel, err := d.ConfigValueGet("core.log_level")
+		if err != nil {
+			return err
+		}
+
+		if err := shared.SetLogLevel(logLevel); err != nil {
+			shared.Log.Warn("Invalid log level, ignoring", log.Ctx{"level": logLevel, "err": err})
+		}
+	}
+
+	/* Load the operations left by the previous run */
+	if err := operationsInit(d); err != nil {
+		return err
+	}
+	operationsLimitsLoad(d)
+	rateLimitsLoad(d)
+
+	auditPruneStart(d)
+
 	/* Prune images */
 	d.pruneChan = make(chan bool)
 	go func() {
@@ -614,6 +894,9 @@ func (d *Daemon) Init() error {
 		}
 	}()
 
+	/* Balance the CPU allowances of the running containers */
+	cpuScheduleStart(d)
+
 	/* Setup /dev/lxd */
 	d.devlxd, err = createAndBindDevLxd()
 	if err != nil {
@@ -631,6 +914,15 @@ func (d *Daemon) Init() error {
 			return fmt.Errorf("Failed to setup storage: %s", err)
 		}
 
+		/* Bring up the managed networks, before their containers */
+		networkStartup(d)
+
+		if firstStart {
+			if err := networkDefaultSetup(d); err != nil {
+				shared.Log.Error("Failed to set up the default network", log.Ctx{"err": err})
+			}
+		}
+
 		/* Restart containers */
 		containersRestart(d)
 		containersWatch(d)
@@ -642,9 +934,14 @@ func (d *Daemon) Init() error {
 		}
 		d.certf = certf
 		d.keyf = keyf
+		d.serverCert.certf = certf
+		d.serverCert.keyf = keyf
 		readSavedClientCAList(d)
+		if err := readClientCA(d); err != nil {
+			return err
+		}
 
-		tlsConfig, err = shared.GetTLSConfig(d.certf, d.keyf)
+		tlsConfig, err = d.listenerTLSConfig()
 		if err != nil {
 			return err
 		}
@@ -689,13 +986,20 @@ func (d *Daemon) Init() error {
 	} else {
 		shared.Log.Info("LXD isn't socket activated")
 
-		localSocketPath := shared.VarPath("unix.socket")
+		localSocketPath := shared.UnixSocketPath()
+		if *socketFlag != "" {
+			localSocketPath = *socketFlag
+		}
+
+		mode, err := strconv.ParseUint(*socketMode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("invalid socket mode '%s': %v", *socketMode, err)
+		}
 
 		// If the socket exists, let's try to connect to it and see if there's
 		// a lxd running.
 		if shared.PathExists(localSocketPath) {
-			c := &lxd.Config{Remotes: map[string]lxd.RemoteConfig{}}
-			_, err := lxd.NewClient(c, "")
+			conn, err := net.Dial("unix", localSocketPath)
 			if err != nil {
 				shared.Log.Debug("Detected stale unix socket, deleting")
 				// Connecting failed, so let's delete the socket and
@@ -704,6 +1008,8 @@ func (d *Daemon) Init() error {
 				if err != nil {
 					return err
 				}
+			} else {
+				conn.Close()
 			}
 		}
 
@@ -717,7 +1023,7 @@ func (d *Daemon) Init() error {
 			return fmt.Errorf("cannot listen on unix socket: %v", err)
 		}
 
-		if err := os.Chmod(localSocketPath, 0660); err != nil {
+		if err := os.Chmod(localSocketPath, os.FileMode(mode)); err != nil {
 			return err
 		}
 
@@ -738,13 +1044,8 @@ func (d *Daemon) Init() error {
 		return err
 	}
 
-	if listenAddr != "" {
-		_, _, err := net.SplitHostPort(listenAddr)
-		if err != nil {
-			listenAddr = fmt.Sprintf("%s:%s", listenAddr, shared.DefaultPort)
-		}
-
-		tcpl, err := tls.Listen("tcp", listenAddr, tlsConfig)
+	for _, address := range httpsAddresses(listenAddr) {
+		tcpl, err := tls.Listen("tcp", address, tlsConfig)
 		if err != nil {
 			return fmt.Errorf("cannot listen on https socket: %v", err)
 		}
@@ -754,6 +1055,15 @@ func (d *Daemon) Init() error {
 
 	if !d.IsMock {
 		d.Sockets = sockets
+
+		debugAddress, err := d.ConfigValueGet("core.debug_address")
+		if err != nil {
+			return err
+		}
+
+		if err := d.UpdateDebugAddress(debugAddress); err != nil {
+			return err
+		}
 	} else {
 		d.Sockets = []Socket{}
 	}
@@ -763,6 +1073,15 @@ func (d *Daemon) Init() error {
 		for _, socket := range d.Sockets {
 			shared.Log.Info(" - binding socket", log.Ctx{"socket": socket.Socket.Addr()})
 			current_socket := socket
+			if current_socket.Socket.Addr().Network() == "unix" {
+				// Track the uid of local clients for the audit log
+				d.tomb.Go(func() error {
+					server := http.Server{Handler: d.mux, ConnState: uidMapper.ConnStateHandler}
+					return server.Serve(current_socket.Socket)
+				})
+				continue
+			}
+
 			d.tomb.Go(func() error { return http.Serve(current_socket.Socket, d.mux) })
 		}
 
@@ -773,12 +1092,16 @@ func (d *Daemon) Init() error {
 		return nil
 	})
 
+	close(d.readyChan)
+
 	return nil
 }
 
 // CheckTrustState returns True if the client is trusted else false.
 func (d *Daemon) CheckTrustState(cert x509.Certificate) bool {
-	for k, v := range d.clientCerts {
+	clientCerts, _ := d.clientCertsGet()
+
+	for k, v := range clientCerts {
 		if bytes.Compare(cert.Raw, v.Raw) == 0 {
 			shared.Log.Debug("Found cert", log.Ctx{"k": k})
 			return true
@@ -811,6 +1134,63 @@ func (d *Daemon) numRunningContainers() (int, error) {
 
 var errStop = fmt.Errorf("requested stop")
 
+// shuttingDown tells whether the daemon started shutting down.
+func (d *Daemon) shuttingDown() bool {
+	select {
+	case <-d.shutdownChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// websocketCompression tells whether the exec and migration control
+// websockets should be compressed, see core.websocket_compression.
+func (d *Daemon) websocketCompression() bool {
+	value, err := d.ConfigValueGet("core.websocket_compression")
+	return err == nil && value != "false"
+}
+
+/*
+ * Drain stops the daemon from accepting new requests, apart from those
+ * following the running operations, and waits for up to
+ * core.shutdown_timeout seconds for the operations and the requests
+ * changing something to be done.
+ */
+func (d *Daemon) Drain() {
+	d.shutdownOnce.Do(func() { close(d.shutdownChan) })
+
+	timeout := shutdownDefaultTimeout
+	value, err := d.ConfigValueGet("core.shutdown_timeout")
+	if err == nil && value != "" {
+		if t, err := strconv.Atoi(value); err == nil {
+			timeout = t
+		}
+	}
+
+	pending := func() int {
+		return operationsActive() + int(atomic.LoadInt32(&d.mutations))
+	}
+
+	if pending() == 0 {
+		return
+	}
+
+	shared.Log.Info("Waiting for the running operations to finish",
+		log.Ctx{"count": pending(), "timeout": timeout})
+
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+	for pending() > 0 {
+		if time.Now().After(deadline) {
+			shared.Log.Warn("Giving up on the operations still running",
+				log.Ctx{"count": pending()})
+			return
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
 // Stop stops the shared daemon.
 func (d *Daemon) Stop() error {
 	forceStop := false
@@ -842,6 +1222,8 @@ func (d *Daemon) Stop() error {
 	shared.Log.Debug("Stopping /dev/lxd handler")
 	d.devlxd.Close()
 
+	d.UpdateDebugAddress("")
+
 	if d.IsMock || forceStop {
 		return nil
 	}
@@ -856,20 +1238,8 @@ func (d *Daemon) Stop() error {
 
 // ConfigKeyIsValid returns if the given key is a known config value.
 func (d *Daemon) ConfigKeyIsValid(key string) bool {
-	switch key {
-	case "core.https_address":
-		return true
-	case "core.trust_password":
-		return true
-	case "storage.lvm_vg_name":
-		return true
-	case "storage.lvm_thinpool_name":
-		return true
-	case "images.remote_cache_expiry":
-		return true
-	}
-
-	return false
+	_, ok := configKeyLookup(serverConfigKeys, key)
+	return ok
 }
 
 // ConfigValueGet returns a config value from the memory,
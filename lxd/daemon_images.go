@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -8,6 +9,7 @@ import (
 	"mime/multipart"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/lxc/lxd/shared"
 
@@ -15,9 +17,12 @@ import (
 )
 
 // ImageDownload checks if we have that Image Fingerprint else
-// downloads the image from a remote server.
+// downloads the image from a remote server. The download is interrupted
+// and its partial files removed if the canceller gets cancelled, and its
+// progress is reported through progress.
 func (d *Daemon) ImageDownload(
-	server, fp string, secret string, forContainer bool) error {
+	server, fp string, secret string, forContainer bool,
+	canceller *operationCanceller, progress *operationProgress) error {
 
 	if _, err := dbImageGet(d.db, fp, false, false); err == nil {
 		shared.Log.Debug("Image already exists in the db", log.Ctx{"image": fp})
@@ -105,6 +110,10 @@ func (d *Daemon) ImageDownload(
 		return err
 	}
 
+	if len(info.Fingerprint) != 64 || !strings.HasPrefix(info.Fingerprint, fp) {
+		return fmt.Errorf("The server sent image %s instead of %s", info.Fingerprint, fp)
+	}
+
 	/* now grab the actual file from /1.0/images/%s/export */
 	var exporturl string
 	if secret != "" {
@@ -126,12 +135,31 @@ func (d *Daemon) ImageDownload(
 		return err
 	}
 
+	// Closing the body interrupts the copies below
+	done := canceller.OnCancel(func() { raw.Body.Close() })
+	defer done()
+
 	destDir := shared.VarPath("images")
 	destName := filepath.Join(destDir, fp)
 	if shared.PathExists(destName) {
 		d.Storage.ImageDelete(fp)
 	}
 
+	success := false
+	defer func() {
+		if !success {
+			os.Remove(filepath.Join(destDir, info.Fingerprint))
+			os.Remove(filepath.Join(destDir, info.Fingerprint+".rootfs"))
+		}
+	}()
+
+	progress.Stage("Downloading image", raw.ContentLength)
+	body := progress.Reader(raw.Body)
+
+	// The fingerprint of the image is checked against what was received,
+	// whichever server sent it
+	hash := sha256.New()
+
 	ctype, ctypeParams, err := mime.ParseMediaType(raw.Header.Get("Content-Type"))
 	if err != nil {
 		ctype = "application/octet-stream"
@@ -139,7 +167,7 @@ func (d *Daemon) ImageDownload(
 
 	if ctype == "multipart/form-data" {
 		// Parse the POST data
-		mr := multipart.NewReader(raw.Body, ctypeParams["boundary"])
+		mr := multipart.NewReader(body, ctypeParams["boundary"])
 
 		// Get the metadata tarball
 		part, err := mr.NextPart()
@@ -169,7 +197,7 @@ func (d *Daemon) ImageDownload(
 			return err
 		}
 
-		_, err = io.Copy(f, part)
+		_, err = io.Copy(io.MultiWriter(f, hash), part)
 		f.Close()
 
 		if err != nil {
@@ -206,7 +234,7 @@ func (d *Daemon) ImageDownload(
 			return err
 		}
 
-		_, err = io.Copy(f, part)
+		_, err = io.Copy(io.MultiWriter(f, hash), part)
 		f.Close()
 
 		if err != nil {
@@ -227,7 +255,7 @@ func (d *Daemon) ImageDownload(
 			return err
 		}
 
-		_, err = io.Copy(f, raw.Body)
+		_, err = io.Copy(io.MultiWriter(f, hash), body)
 		f.Close()
 
 		if err != nil {
@@ -238,7 +266,19 @@ func (d *Daemon) ImageDownload(
 		}
 	}
 
-	_, err = imageBuildFromInfo(d, info)
+	if canceller.Cancelled() {
+		return errOperationCancelled
+	}
+
+	if fingerprint := fmt.Sprintf("%x", hash.Sum(nil)); fingerprint != info.Fingerprint {
+		shared.Log.Error(
+			"Corrupted image",
+			log.Ctx{"image": fp, "fingerprint": fingerprint})
+		return fmt.Errorf("Image fingerprint mismatch: got %s expected %s", fingerprint, info.Fingerprint)
+	}
+
+	progress.Stage("Unpacking image", 0)
+	_, err = imageBuildFromInfo(d, info, canceller)
 	if err != nil {
 		shared.Log.Error(
 			"Failed to create image",
@@ -246,6 +286,7 @@ func (d *Daemon) ImageDownload(
 
 		return err
 	}
+	success = true
 
 	shared.Log.Info(
 		"Download succeeded",
@@ -67,3 +67,36 @@ func Test_config_value_set_empty_removes_val(t *testing.T) {
 	}
 
 }
+
+func Test_drain_refuses_new_requests(t *testing.T) {
+	d := &Daemon{configValues: map[string]string{}, shutdownChan: make(chan bool)}
+	if d.shuttingDown() {
+		t.Fatal("The daemon shouldn't be shutting down yet")
+	}
+
+	d.Drain()
+	d.Drain()
+
+	if !d.shuttingDown() {
+		t.Error("The daemon should be shutting down")
+	}
+}
+
+func Test_https_addresses(t *testing.T) {
+	addresses := httpsAddresses(" 127.0.0.1, 10.0.0.1:9443,,[::1],::,127.0.0.1:8443")
+	expected := []string{"127.0.0.1:8443", "10.0.0.1:9443", "[::1]:8443", "[::]:8443"}
+
+	if len(addresses) != len(expected) {
+		t.Fatalf("Wrong addresses: %v", addresses)
+	}
+
+	for i := range expected {
+		if addresses[i] != expected[i] {
+			t.Errorf("Wrong address %d: %s", i, addresses[i])
+		}
+	}
+
+	if len(httpsAddresses("")) != 0 {
+		t.Error("No address should be listened on")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/lxc/lxd"
+	"github.com/lxc/lxd/shared"
+)
+
+func databaseDumpGet(d *Daemon, r *http.Request) Response {
+	dump, err := dbDump(d.db)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return SyncResponse(true, shared.DatabaseDump{Dump: dump})
+}
+
+var databaseDumpCmd = Command{name: "database/dump", get: databaseDumpGet}
+
+func databaseCheckGet(d *Daemon, r *http.Request) Response {
+	check, err := dbCheck(d.db)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return SyncResponse(true, check)
+}
+
+var databaseCheckCmd = Command{name: "database/check", get: databaseCheckGet}
+
+/*
+ * cmdDatabase implements "lxd database dump", writing the database of the
+ * running daemon to stdout as SQL, and "lxd database check", which fails
+ * if the database is inconsistent.
+ */
+func cmdDatabase(args []string) error {
+	if len(args) != 2 || (args[1] != "dump" && args[1] != "check") {
+		return fmt.Errorf("Usage: lxd database <dump|check>")
+	}
+
+	c, err := lxd.NewClient(&lxd.DefaultConfig, "local")
+	if err != nil {
+		return err
+	}
+
+	if args[1] == "dump" {
+		dump, err := c.DatabaseDump()
+		if err != nil {
+			return err
+		}
+
+		fmt.Print(dump)
+		return nil
+	}
+
+	check, err := c.DatabaseCheck()
+	if err != nil {
+		return err
+	}
+
+	for _, problem := range append(check.Integrity, check.ForeignKeys...) {
+		fmt.Println(problem)
+	}
+
+	if !check.OK {
+		return fmt.Errorf("The database is inconsistent")
+	}
+
+	return nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/mattn/go-sqlite3"
@@ -36,18 +37,53 @@ type Profile struct {
 // Profiles will contain a list of all Profiles.
 type Profiles []Profile
 
-const DB_CURRENT_VERSION int = 17
+const DB_CURRENT_VERSION int = 30
 
 // CURRENT_SCHEMA contains the current SQLite SQL Schema.
 const CURRENT_SCHEMA string = `
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    date DATETIME NOT NULL,
+    client VARCHAR(255) NOT NULL,
+    method VARCHAR(255) NOT NULL,
+    url TEXT NOT NULL,
+    summary TEXT NOT NULL,
+    status_code INTEGER NOT NULL
+);
 CREATE TABLE IF NOT EXISTS certificates (
     id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
     fingerprint VARCHAR(255) NOT NULL,
     type INTEGER NOT NULL,
     name VARCHAR(255) NOT NULL,
     certificate TEXT NOT NULL,
+    read_only INTEGER NOT NULL DEFAULT 0,
+    restricted INTEGER NOT NULL DEFAULT 0,
+    added_date DATETIME NOT NULL DEFAULT 0,
     UNIQUE (fingerprint)
 );
+CREATE TABLE IF NOT EXISTS certificates_containers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    certificate_id INTEGER NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    FOREIGN KEY (certificate_id) REFERENCES certificates (id) ON DELETE CASCADE,
+    UNIQUE (certificate_id, name)
+);
+CREATE TABLE IF NOT EXISTS certificates_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    token_hash VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    UNIQUE (token_hash)
+);
+CREATE TABLE IF NOT EXISTS cluster_members (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    address VARCHAR(255) NOT NULL,
+    certificate TEXT NOT NULL,
+    trusted_by_join INTEGER NOT NULL DEFAULT 0,
+    UNIQUE (name)
+);
 CREATE TABLE IF NOT EXISTS config (
     id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
     key VARCHAR(255) NOT NULL,
@@ -60,6 +96,8 @@ CREATE TABLE IF NOT EXISTS containers (
     architecture INTEGER NOT NULL,
     type INTEGER NOT NULL,
     ephemeral INTEGER NOT NULL DEFAULT 0,
+    creation_date DATETIME NOT NULL DEFAULT 0,
+    snapshot_size INTEGER NOT NULL DEFAULT -1,
     UNIQUE (name)
 );
 CREATE TABLE IF NOT EXISTS containers_config (
@@ -125,6 +163,51 @@ CREATE TABLE IF NOT EXISTS images_properties (
     value TEXT,
     FOREIGN KEY (image_id) REFERENCES images (id) ON DELETE CASCADE
 );
+CREATE TABLE IF NOT EXISTS images_source (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    image_id INTEGER NOT NULL,
+    server TEXT NOT NULL,
+    alias VARCHAR(255) NOT NULL,
+    last_check_date DATETIME NOT NULL,
+    UNIQUE (server, alias),
+    FOREIGN KEY (image_id) REFERENCES images (id) ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS networks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    UNIQUE (name)
+);
+CREATE TABLE IF NOT EXISTS networks_config (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    network_id INTEGER NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    value TEXT,
+    UNIQUE (network_id, key),
+    FOREIGN KEY (network_id) REFERENCES networks (id) ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS networks_forwards (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    network_id INTEGER NOT NULL,
+    protocol VARCHAR(255) NOT NULL,
+    listen_address VARCHAR(255) NOT NULL DEFAULT '',
+    listen_port INTEGER NOT NULL,
+    target_address VARCHAR(255) NOT NULL,
+    target_port INTEGER NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    UNIQUE (protocol, listen_address, listen_port),
+    FOREIGN KEY (network_id) REFERENCES networks (id) ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS operations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    url VARCHAR(255) NOT NULL,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    status_code INTEGER NOT NULL,
+    resources TEXT,
+    metadata TEXT,
+    may_cancel INTEGER NOT NULL DEFAULT 0,
+    UNIQUE (url)
+);
 CREATE TABLE IF NOT EXISTS profiles (
     id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
     name VARCHAR(255) NOT NULL,
@@ -201,6 +284,20 @@ func dbGetSchema(db *sql.DB) (v int) {
 	return v
 }
 
+func init() {
+	sql.Register("sqlite3_with_fk", &sqlite3.SQLiteDriver{ConnectHook: dbConnectHook})
+}
+
+/*
+ * dbConnectHook is run on every new connection of the pool, PRAGMA
+ * statements being *per-connection*.
+ */
+func dbConnectHook(conn *sqlite3.SQLiteConn) error {
+	// This allows us to use ON DELETE CASCADE
+	_, err := conn.Exec("PRAGMA foreign_keys=ON;", nil)
+	return err
+}
+
 // Create a database connection object and return it.
 func initializeDbObject(d *Daemon, path string) (err error) {
 	var openPath string
@@ -212,20 +309,27 @@ func initializeDbObject(d *Daemon, path string) (err error) {
 	openPath = fmt.Sprintf("%s?_busy_timeout=%d&_txlock=exclusive", path, timeout*1000)
 
 	// Open the database. If the file doesn't exist it is created.
-	d.db, err = sql.Open("sqlite3", openPath)
+	d.db, err = sql.Open("sqlite3_with_fk", openPath)
 	if err != nil {
 		return err
 	}
 
+	/*
+	 * With a write-ahead log, readers don't block the writer nor the
+	 * other way around. This is recorded in the database file, so only
+	 * needs to be done once, but is cheap.
+	 */
+	_, err = d.db.Exec("PRAGMA journal_mode=WAL;")
+	if err != nil {
+		return fmt.Errorf("Error enabling the write-ahead log: %s", err)
+	}
+
 	// Table creation is indempotent, run it every time
 	err = createDb(d.db)
 	if err != nil {
 		return fmt.Errorf("Error creating database: %s\n", err)
 	}
 
-	// Run PRAGMA statements now since they are *per-connection*.
-	d.db.Exec("PRAGMA foreign_keys=ON;") // This allows us to use ON DELETE CASCADE
-
 	v := dbGetSchema(d.db)
 
 	if v != DB_CURRENT_VERSION {
@@ -245,6 +349,11 @@ func isDbLockedError(err error) bool {
 	if err == sqlite3.ErrLocked || err == sqlite3.ErrBusy {
 		return true
 	}
+	if sqliteErr, ok := err.(sqlite3.Error); ok {
+		if sqliteErr.Code == sqlite3.ErrLocked || sqliteErr.Code == sqlite3.ErrBusy {
+			return true
+		}
+	}
 	if err.Error() == "database is locked" {
 		return true
 	}
@@ -277,6 +386,34 @@ func dbBegin(db *sql.DB) (*sql.Tx, error) {
 	}
 }
 
+/*
+ * dbWriteLock serializes the writes going through dbTx and dbExec. SQLite
+ * only allows one writer at a time, waiting on each other here is more
+ * reliable than polling the database lock.
+ */
+var dbWriteLock sync.Mutex
+
+/*
+ * dbTx runs f in a transaction, committing it if f succeeds and rolling it
+ * back otherwise. f must only use tx to access the database.
+ */
+func dbTx(db *sql.DB, f func(tx *sql.Tx) error) error {
+	dbWriteLock.Lock()
+	defer dbWriteLock.Unlock()
+
+	tx, err := dbBegin(db)
+	if err != nil {
+		return err
+	}
+
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return txCommit(tx)
+}
+
 func txCommit(tx *sql.Tx) error {
 	for {
 		err := tx.Commit()
@@ -402,6 +539,9 @@ func dbQueryScan(db *sql.DB, q string, inargs []interface{}, outfmt []interface{
 }
 
 func dbExec(db *sql.DB, q string, args ...interface{}) (sql.Result, error) {
+	dbWriteLock.Lock()
+	defer dbWriteLock.Unlock()
+
 	for {
 		result, err := db.Exec(q, args...)
 		if err == nil {
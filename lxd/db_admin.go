@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+)
+
+// dbDumpValue formats a value the way sqlite3's .dump does.
+func dbDumpValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return fmt.Sprintf("%d", v)
+	case float64:
+		return fmt.Sprintf("%v", v)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case []byte:
+		return fmt.Sprintf("X'%s'", hex.EncodeToString(v))
+	case string:
+		return fmt.Sprintf("'%s'", strings.Replace(v, "'", "''", -1))
+	case time.Time:
+		// The format the sqlite3 driver stores dates with
+		return fmt.Sprintf("'%s'", v.Format("2006-01-02 15:04:05.999999999-07:00"))
+	}
+
+	return fmt.Sprintf("'%s'", strings.Replace(fmt.Sprintf("%v", value), "'", "''", -1))
+}
+
+func dbDumpTable(tx *sql.Tx, buf *bytes.Buffer, table string) error {
+	rows, err := tx.Query(fmt.Sprintf(`SELECT * FROM "%s"`, table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+
+		formatted := make([]string, len(values))
+		for i, value := range values {
+			formatted[i] = dbDumpValue(value)
+		}
+
+		fmt.Fprintf(buf, "INSERT INTO \"%s\" VALUES(%s);\n", table, strings.Join(formatted, ","))
+	}
+
+	return rows.Err()
+}
+
+/*
+ * dbDump returns the schema and content of the database as SQL statements,
+ * which can be loaded back with the sqlite3 tool. Writes are held off for
+ * the duration of the dump, so that it's consistent.
+ */
+func dbDump(db *sql.DB) (string, error) {
+	buf := &bytes.Buffer{}
+
+	err := dbTx(db, func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT type, name, sql FROM sqlite_master
+			WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%'
+			ORDER BY type='table' DESC, rowid`)
+		if err != nil {
+			return err
+		}
+
+		type entry struct {
+			kind string
+			name string
+			sql  string
+		}
+
+		entries := []entry{}
+		for rows.Next() {
+			e := entry{}
+			if err := rows.Scan(&e.kind, &e.name, &e.sql); err != nil {
+				rows.Close()
+				return err
+			}
+			entries = append(entries, e)
+		}
+		rows.Close()
+
+		buf.WriteString("PRAGMA foreign_keys=OFF;\nBEGIN TRANSACTION;\n")
+		for _, e := range entries {
+			fmt.Fprintf(buf, "%s;\n", strings.TrimSpace(e.sql))
+			if e.kind != "table" {
+				continue
+			}
+
+			if err := dbDumpTable(tx, buf, e.name); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("COMMIT;\n")
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// dbCheck runs SQLite's integrity and foreign key checks.
+func dbCheck(db *sql.DB) (shared.DatabaseCheck, error) {
+	check := shared.DatabaseCheck{Integrity: []string{}, ForeignKeys: []string{}}
+
+	rows, err := dbQuery(db, "PRAGMA integrity_check")
+	if err != nil {
+		return check, err
+	}
+
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			rows.Close()
+			return check, err
+		}
+		check.Integrity = append(check.Integrity, result)
+	}
+	rows.Close()
+
+	rows, err = dbQuery(db, "PRAGMA foreign_key_check")
+	if err != nil {
+		return check, err
+	}
+
+	for rows.Next() {
+		var table, parent string
+		var rowid sql.NullInt64
+		var fkid int
+		if err := rows.Scan(&table, &rowid, &parent, &fkid); err != nil {
+			rows.Close()
+			return check, err
+		}
+		check.ForeignKeys = append(check.ForeignKeys,
+			fmt.Sprintf("%s row %d references a missing %s row", table, rowid.Int64, parent))
+	}
+	rows.Close()
+
+	check.OK = len(check.Integrity) == 1 && check.Integrity[0] == "ok" && len(check.ForeignKeys) == 0
+
+	return check, nil
+}
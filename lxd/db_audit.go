@@ -0,0 +1,49 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// dbAuditAdd appends an entry to the audit log.
+func dbAuditAdd(db *sql.DB, entry *auditEntry) error {
+	str := `INSERT INTO audit_log (date, client, method, url, summary, status_code)
+	    VALUES (?, ?, ?, ?, ?, ?)`
+	_, err := dbExec(db, str, entry.Date, entry.Client, entry.Method, entry.URL,
+		entry.Summary, entry.StatusCode)
+	return err
+}
+
+// dbAuditGet returns at most limit audit log entries recorded after the one
+// with the given id, oldest first.
+func dbAuditGet(db *sql.DB, after int, limit int) ([]auditEntry, error) {
+	q := `SELECT id, date, client, method, url, summary, status_code
+	    FROM audit_log WHERE id > ? ORDER BY id LIMIT ?`
+	rows, err := dbQuery(db, q, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []auditEntry{}
+	for rows.Next() {
+		entry := auditEntry{}
+		err := rows.Scan(&entry.ID, &entry.Date, &entry.Client, &entry.Method,
+			&entry.URL, &entry.Summary, &entry.StatusCode)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// dbAuditPrune removes the audit log entries recorded before the given date.
+func dbAuditPrune(db *sql.DB, before time.Time) error {
+	_, err := dbExec(db, "DELETE FROM audit_log WHERE date < ?", before)
+	return err
+}
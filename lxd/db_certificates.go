@@ -2,8 +2,11 @@ package main
 
 import (
 	"database/sql"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/lxc/lxd/shared"
 )
 
 // dbCertInfo is here to pass the certificates content
@@ -14,20 +17,22 @@ type dbCertInfo struct {
 	Type        int
 	Name        string
 	Certificate string
+	ReadOnly    bool
+	Restricted  bool
+	Containers  []string
+	AddedDate   time.Time // Set by the database when it's added
 }
 
 // dbCertsGet returns all certificates from the DB as CertBaseInfo objects.
 func dbCertsGet(db *sql.DB) (certs []*dbCertInfo, err error) {
 	rows, err := dbQuery(
 		db,
-		"SELECT id, fingerprint, type, name, certificate FROM certificates",
+		"SELECT id, fingerprint, type, name, certificate, read_only, restricted, added_date FROM certificates",
 	)
 	if err != nil {
 		return certs, err
 	}
 
-	defer rows.Close()
-
 	for rows.Next() {
 		cert := new(dbCertInfo)
 		rows.Scan(
@@ -36,13 +41,44 @@ func dbCertsGet(db *sql.DB) (certs []*dbCertInfo, err error) {
 			&cert.Type,
 			&cert.Name,
 			&cert.Certificate,
+			&cert.ReadOnly,
+			&cert.Restricted,
+			&cert.AddedDate,
 		)
 		certs = append(certs, cert)
 	}
+	rows.Close()
+
+	for _, cert := range certs {
+		cert.Containers, err = dbCertContainersGet(db, cert.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return certs, nil
 }
 
+// dbCertContainersGet returns the containers a restricted certificate can
+// access.
+func dbCertContainersGet(db *sql.DB, id int) ([]string, error) {
+	var name string
+	query := "SELECT name FROM certificates_containers WHERE certificate_id=? ORDER BY name"
+	inargs := []interface{}{id}
+	outfmt := []interface{}{name}
+	results, err := dbQueryScan(db, query, inargs, outfmt)
+	if err != nil {
+		return nil, err
+	}
+
+	containers := []string{}
+	for _, r := range results {
+		containers = append(containers, r[0].(string))
+	}
+
+	return containers, nil
+}
+
 // dbCertGet gets an CertBaseInfo object from the database.
 // The argument fingerprint will be queried with a LIKE query, means you can
 // pass a shortform and will get the full fingerprint.
@@ -58,11 +94,14 @@ func dbCertGet(db *sql.DB, fingerprint string) (cert *dbCertInfo, err error) {
 		&cert.Type,
 		&cert.Name,
 		&cert.Certificate,
+		&cert.ReadOnly,
+		&cert.Restricted,
+		&cert.AddedDate,
 	}
 
 	query := `
 		SELECT
-			id, fingerprint, type, name, certificate
+			id, fingerprint, type, name, certificate, read_only, restricted, added_date
 		FROM
 			certificates
 		WHERE fingerprint LIKE ?`
@@ -71,41 +110,94 @@ func dbCertGet(db *sql.DB, fingerprint string) (cert *dbCertInfo, err error) {
 		return nil, err
 	}
 
+	cert.Containers, err = dbCertContainersGet(db, cert.ID)
+	if err != nil {
+		return nil, err
+	}
+
 	return cert, err
 }
 
 // dbCertSave stores a CertBaseInfo object in the db,
 // it will ignore the ID field from the dbCertInfo.
 func dbCertSave(db *sql.DB, cert *dbCertInfo) error {
-	tx, err := dbBegin(db)
-	if err != nil {
-		return err
-	}
+	return dbTx(db, func(tx *sql.Tx) error {
+		return dbCertInsert(tx, cert)
+	})
+}
+
+// dbCertInsert is dbCertSave within an existing transaction.
+func dbCertInsert(tx *sql.Tx, cert *dbCertInfo) error {
 	stmt, err := tx.Prepare(`
-			INSERT INTO certificates (
-				fingerprint,
-				type,
-				name,
-				certificate
-			) VALUES (?, ?, ?, ?)`,
+		INSERT INTO certificates (
+			fingerprint,
+			type,
+			name,
+			certificate,
+			read_only,
+			restricted,
+			added_date
+		) VALUES (?, ?, ?, ?, ?, ?, strftime("%s"))`,
 	)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(
+	result, err := stmt.Exec(
 		cert.Fingerprint,
 		cert.Type,
 		cert.Name,
 		cert.Certificate,
+		cert.ReadOnly,
+		cert.Restricted,
 	)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
 
-	return txCommit(tx)
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	return dbCertContainersAdd(tx, int(id), cert.Containers)
+}
+
+// dbCertUpdate changes the name and the access limits of a certificate.
+func dbCertUpdate(db *sql.DB, id int, name string, readOnly bool, restricted bool, containers []string) error {
+	return dbTx(db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			"UPDATE certificates SET name=?, read_only=?, restricted=? WHERE id=?",
+			name, readOnly, restricted, id,
+		)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("DELETE FROM certificates_containers WHERE certificate_id=?", id)
+		if err != nil {
+			return err
+		}
+
+		return dbCertContainersAdd(tx, id, containers)
+	})
+}
+
+func dbCertContainersAdd(tx *sql.Tx, id int, containers []string) error {
+	stmt, err := tx.Prepare("INSERT INTO certificates_containers (certificate_id, name) VALUES (?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, name := range containers {
+		_, err = stmt.Exec(id, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // dbCertDelete deletes a certificate from the db.
@@ -118,3 +210,114 @@ func dbCertDelete(db *sql.DB, fingerprint string) error {
 
 	return err
 }
+
+// dbCertTokenInfo describes a join token, without the token itself.
+type dbCertTokenInfo struct {
+	ID        int
+	Name      string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+func (t *dbCertTokenInfo) info() shared.CertTokenInfo {
+	return shared.CertTokenInfo{
+		ID:        t.ID,
+		Name:      t.Name,
+		CreatedAt: t.CreatedAt,
+		ExpiresAt: t.ExpiresAt,
+	}
+}
+
+// dbCertTokenAdd records a join token, of which only the hash is kept.
+func dbCertTokenAdd(db *sql.DB, hash string, name string, expiresAt time.Time) (int, error) {
+	result, err := dbExec(
+		db,
+		"INSERT INTO certificates_tokens (token_hash, name, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		hash, name, time.Now().UTC(), expiresAt.UTC(),
+	)
+	if err != nil {
+		return -1, err
+	}
+
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// dbCertTokensGet returns the join tokens which haven't expired yet.
+func dbCertTokensGet(db *sql.DB) ([]*dbCertTokenInfo, error) {
+	rows, err := dbQuery(
+		db,
+		"SELECT id, name, created_at, expires_at FROM certificates_tokens WHERE expires_at > ?",
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*dbCertTokenInfo{}
+	for rows.Next() {
+		token := new(dbCertTokenInfo)
+		if err := rows.Scan(&token.ID, &token.Name, &token.CreatedAt, &token.ExpiresAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+/*
+ * dbCertTokenUse consumes the join token matching hash and stores cert,
+ * named after the token if it was created with a name, in the same
+ * transaction, so that the token is only used up if the certificate gets
+ * added. It fails with NoSuchObjectError if there's no such token or it
+ * expired, so that a token can only be used once.
+ */
+func dbCertTokenUse(db *sql.DB, hash string, cert *dbCertInfo) error {
+	return dbTx(db, func(tx *sql.Tx) error {
+		name := ""
+		// Expired tokens are of no use anymore
+		_, err := tx.Exec("DELETE FROM certificates_tokens WHERE expires_at <= ?", time.Now().UTC())
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRow("SELECT name FROM certificates_tokens WHERE token_hash=?", hash).Scan(&name)
+		if err == sql.ErrNoRows {
+			return NoSuchObjectError
+		} else if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("DELETE FROM certificates_tokens WHERE token_hash=?", hash)
+		if err != nil {
+			return err
+		}
+
+		if name != "" {
+			cert.Name = name
+		}
+
+		return dbCertInsert(tx, cert)
+	})
+}
+
+// dbCertTokenDelete revokes a join token.
+func dbCertTokenDelete(db *sql.DB, id int) error {
+	result, err := dbExec(db, "DELETE FROM certificates_tokens WHERE id=?", id)
+	if err != nil {
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return NoSuchObjectError
+	}
+
+	return nil
+}
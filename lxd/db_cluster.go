@@ -0,0 +1,73 @@
+package main
+
+import (
+	"database/sql"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// dbClusterMember is another daemon this one shares its images and
+// placement with.
+type dbClusterMember struct {
+	ID          int
+	Name        string
+	Address     string
+	Certificate string
+
+	// Whether its certificate was added to the trust store by its join
+	TrustedByJoin bool
+}
+
+// dbClusterMembersGet returns the members of the cluster, sorted by name.
+func dbClusterMembersGet(db *sql.DB) ([]dbClusterMember, error) {
+	var id, trustedByJoin int
+	var name, address, certificate string
+	query := "SELECT id, name, address, certificate, trusted_by_join FROM cluster_members ORDER BY name"
+	inargs := []interface{}{}
+	outfmt := []interface{}{id, name, address, certificate, trustedByJoin}
+	results, err := dbQueryScan(db, query, inargs, outfmt)
+	if err != nil {
+		return nil, err
+	}
+
+	members := []dbClusterMember{}
+	for _, r := range results {
+		members = append(members, dbClusterMember{
+			ID:            r[0].(int),
+			Name:          r[1].(string),
+			Address:       r[2].(string),
+			Certificate:   r[3].(string),
+			TrustedByJoin: r[4].(int) == 1,
+		})
+	}
+
+	return members, nil
+}
+
+// dbClusterMemberGet returns the member with the given name, or
+// sql.ErrNoRows.
+func dbClusterMemberGet(db *sql.DB, name string) (*dbClusterMember, error) {
+	member := dbClusterMember{}
+
+	inargs := []interface{}{name}
+	outfmt := []interface{}{&member.ID, &member.Name, &member.Address, &member.Certificate, &member.TrustedByJoin}
+	query := "SELECT id, name, address, certificate, trusted_by_join FROM cluster_members WHERE name=?"
+
+	if err := dbQueryRowScan(db, query, inargs, outfmt); err != nil {
+		return nil, err
+	}
+
+	return &member, nil
+}
+
+func dbClusterMemberAdd(db *sql.DB, member dbClusterMember) error {
+	_, err := dbExec(db,
+		"INSERT INTO cluster_members (name, address, certificate, trusted_by_join) VALUES (?, ?, ?, ?)",
+		member.Name, member.Address, member.Certificate, member.TrustedByJoin)
+	return err
+}
+
+func dbClusterMemberDelete(db *sql.DB, name string) error {
+	_, err := dbExec(db, "DELETE FROM cluster_members WHERE name=?", name)
+	return err
+}
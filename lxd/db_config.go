@@ -26,36 +26,23 @@ func dbConfigValuesGet(db *sql.DB) (map[string]string, error) {
 }
 
 func dbConfigValueSet(db *sql.DB, key string, value string) error {
-	tx, err := dbBegin(db)
-	if err != nil {
-		return err
-	}
+	return dbTx(db, func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM config WHERE key=?", key)
+		if err != nil {
+			return err
+		}
 
-	_, err = tx.Exec("DELETE FROM config WHERE key=?", key)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
+		if value == "" {
+			return nil
+		}
 
-	if value != "" {
 		str := `INSERT INTO config (key, value) VALUES (?, ?);`
 		stmt, err := tx.Prepare(str)
 		if err != nil {
-			tx.Rollback()
 			return err
 		}
 		defer stmt.Close()
 		_, err = stmt.Exec(key, value)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-	}
-
-	err = txCommit(tx)
-	if err != nil {
 		return err
-	}
-
-	return nil
+	})
 }
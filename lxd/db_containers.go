@@ -22,6 +22,23 @@ func dbContainerRemove(db *sql.DB, name string) error {
 	return err
 }
 
+// dbContainerSnapshotSizeSet records the space a snapshot used once taken.
+func dbContainerSnapshotSizeSet(db *sql.DB, id int, size int64) error {
+	_, err := dbExec(db, "UPDATE containers SET snapshot_size=? WHERE id=?", size, id)
+	return err
+}
+
+// dbContainerSnapshotSizeGet returns the space a snapshot used once taken,
+// -1 if unknown.
+func dbContainerSnapshotSizeGet(db *sql.DB, id int) (int64, error) {
+	q := "SELECT snapshot_size FROM containers WHERE id=?"
+	size := int64(-1)
+	arg1 := []interface{}{id}
+	arg2 := []interface{}{&size}
+	err := dbQueryRowScan(db, q, arg1, arg2)
+	return size, err
+}
+
 func dbContainerIDGet(db *sql.DB, name string) (int, error) {
 	q := "SELECT id FROM containers WHERE name=?"
 	id := -1
@@ -37,9 +54,9 @@ func dbContainerGet(db *sql.DB, name string) (*containerLXDArgs, error) {
 	}
 
 	ephemInt := -1
-	q := "SELECT id, architecture, type, ephemeral FROM containers WHERE name=?"
+	q := "SELECT id, architecture, type, ephemeral, creation_date FROM containers WHERE name=?"
 	arg1 := []interface{}{name}
-	arg2 := []interface{}{&args.ID, &args.Architecture, &args.Ctype, &ephemInt}
+	arg2 := []interface{}{&args.ID, &args.Architecture, &args.Ctype, &ephemInt, &args.CreationDate}
 	err := dbQueryRowScan(db, q, arg1, arg2)
 	if err != nil {
 		return nil, err
@@ -83,51 +100,44 @@ func dbContainerCreate(db *sql.DB, name string, args containerLXDArgs) (int, err
 		return 0, DbErrAlreadyDefined
 	}
 
-	tx, err := dbBegin(db)
-	if err != nil {
-		return 0, err
-	}
 	ephemInt := 0
 	if args.Ephemeral == true {
 		ephemInt = 1
 	}
 
-	str := fmt.Sprintf("INSERT INTO containers (name, architecture, type, ephemeral) VALUES (?, ?, ?, ?)")
-	stmt, err := tx.Prepare(str)
-	if err != nil {
-		tx.Rollback()
-		return 0, err
-	}
-	defer stmt.Close()
-	result, err := stmt.Exec(name, args.Architecture, args.Ctype, ephemInt)
-	if err != nil {
-		tx.Rollback()
-		return 0, err
-	}
+	err = dbTx(db, func(tx *sql.Tx) error {
+		str := fmt.Sprintf(`INSERT INTO containers (name, architecture, type, ephemeral, creation_date) VALUES (?, ?, ?, ?, strftime("%%s"))`)
+		stmt, err := tx.Prepare(str)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		result, err := stmt.Exec(name, args.Architecture, args.Ctype, ephemInt)
+		if err != nil {
+			return err
+		}
 
-	id64, err := result.LastInsertId()
-	if err != nil {
-		tx.Rollback()
-		return 0, fmt.Errorf("Error inserting %s into database", name)
-	}
-	// TODO: is this really int64? we should fix it everywhere if so
-	id = int(id64)
-	if err := dbContainerConfigInsert(tx, id, args.Config); err != nil {
-		tx.Rollback()
-		return 0, err
-	}
+		id64, err := result.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("Error inserting %s into database", name)
+		}
+		// TODO: is this really int64? we should fix it everywhere if so
+		id = int(id64)
+		if err := dbContainerConfigInsert(tx, id, args.Config); err != nil {
+			return err
+		}
 
-	if err := dbContainerProfilesInsert(tx, id, args.Profiles); err != nil {
-		tx.Rollback()
-		return 0, err
-	}
+		if err := dbContainerProfilesInsert(tx, id, args.Profiles); err != nil {
+			return err
+		}
 
-	if err := dbDevicesAdd(tx, "container", int64(id), args.Devices); err != nil {
-		tx.Rollback()
+		return dbDevicesAdd(tx, "container", int64(id), args.Devices)
+	})
+	if err != nil {
 		return 0, err
 	}
 
-	return id, txCommit(tx)
+	return id, nil
 }
 
 func dbContainerConfigClear(tx *sql.Tx, id int) error {
@@ -276,31 +286,23 @@ func dbContainersList(db *sql.DB, cType containerType) ([]string, error) {
 }
 
 func dbContainerRename(db *sql.DB, oldName string, newName string) error {
-	tx, err := dbBegin(db)
-	if err != nil {
-		return err
-	}
-
-	str := fmt.Sprintf("UPDATE containers SET name = ? WHERE name = ?")
-	stmt, err := tx.Prepare(str)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-	defer stmt.Close()
-
-	shared.Log.Debug(
-		"Calling SQL Query",
-		log.Ctx{
-			"query":   "UPDATE containers SET name = ? WHERE name = ?",
-			"oldName": oldName,
-			"newName": newName})
-	if _, err := stmt.Exec(newName, oldName); err != nil {
-		tx.Rollback()
+	return dbTx(db, func(tx *sql.Tx) error {
+		str := fmt.Sprintf("UPDATE containers SET name = ? WHERE name = ?")
+		stmt, err := tx.Prepare(str)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		shared.Log.Debug(
+			"Calling SQL Query",
+			log.Ctx{
+				"query":   "UPDATE containers SET name = ? WHERE name = ?",
+				"oldName": oldName,
+				"newName": newName})
+		_, err = stmt.Exec(newName, oldName)
 		return err
-	}
-
-	return txCommit(tx)
+	})
 }
 
 func dbContainerGetSnapshots(db *sql.DB, name string) ([]string, error) {
@@ -323,6 +325,28 @@ func dbContainerGetSnapshots(db *sql.DB, name string) ([]string, error) {
 	return result, nil
 }
 
+// validEnvironmentName returns whether name can be that of an environment
+// variable: letters, digits and underscores, not starting with a digit.
+func validEnvironmentName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i, r := range name {
+		if r == '_' || (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') {
+			continue
+		}
+
+		if r >= '0' && r <= '9' && i > 0 {
+			continue
+		}
+
+		return false
+	}
+
+	return true
+}
+
 // ValidContainerConfigKey returns if the given config key is a known/valid key.
 func ValidContainerConfigKey(k string) bool {
 	switch k {
@@ -332,6 +356,8 @@ func ValidContainerConfigKey(k string) bool {
 		return true
 	case "boot.autostart.priority":
 		return true
+	case "limits.cpu.allowance":
+		return true
 	case "limits.cpus":
 		return true
 	case "limits.memory":
@@ -355,7 +381,7 @@ func ValidContainerConfigKey(k string) bool {
 	}
 
 	if strings.HasPrefix(k, "environment.") {
-		return true
+		return validEnvironmentName(strings.TrimPrefix(k, "environment."))
 	}
 
 	if strings.HasPrefix(k, "user.") {
@@ -99,20 +99,14 @@ func dbImageGet(db *sql.DB, fingerprint string, public bool, strictMatching bool
 }
 
 func dbImageDelete(db *sql.DB, id int) error {
-	tx, err := dbBegin(db)
-	if err != nil {
-		return err
-	}
-
-	_, _ = tx.Exec("DELETE FROM images_aliases WHERE image_id=?", id)
-	_, _ = tx.Exec("DELETE FROM images_properties WHERE image_id?", id)
-	_, _ = tx.Exec("DELETE FROM images WHERE id=?", id)
-
-	if err := txCommit(tx); err != nil {
-		return err
-	}
-
-	return nil
+	return dbTx(db, func(tx *sql.Tx) error {
+		_, _ = tx.Exec("DELETE FROM images_aliases WHERE image_id=?", id)
+		_, _ = tx.Exec("DELETE FROM images_properties WHERE image_id?", id)
+		_, _ = tx.Exec("DELETE FROM images_source WHERE image_id=?", id)
+		_, _ = tx.Exec("DELETE FROM images WHERE id=?", id)
+
+		return nil
+	})
 }
 
 // Get an image's fingerprint for a given alias name.
@@ -150,6 +144,66 @@ func dbImageSetPublic(db *sql.DB, id int, public bool) error {
 	return err
 }
 
+/*
+ * dbImageAliasesReplace points aliases to an image in one transaction,
+ * adding those which don't exist, and returns the fingerprints of the
+ * images they pointed to.
+ */
+func dbImageAliasesReplace(db *sql.DB, fingerprint string, aliases []string) ([]string, error) {
+	imgInfo, err := dbImageGet(db, fingerprint, false, true)
+	if err != nil {
+		return nil, err
+	}
+
+	oldImages := []string{}
+	err = dbTx(db, func(tx *sql.Tx) error {
+		for _, alias := range aliases {
+			var old string
+			err := tx.QueryRow(`SELECT images.fingerprint FROM images_aliases
+				JOIN images ON images_aliases.image_id=images.id
+				WHERE images_aliases.name=?`, alias).Scan(&old)
+			switch err {
+			case nil:
+				if !shared.StringInSlice(old, oldImages) {
+					oldImages = append(oldImages, old)
+				}
+				_, err = tx.Exec("UPDATE images_aliases SET image_id=? WHERE name=?", imgInfo.Id, alias)
+			case sql.ErrNoRows:
+				_, err = tx.Exec("INSERT INTO images_aliases (name, image_id, description) VALUES (?, ?, '')", alias, imgInfo.Id)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return oldImages, nil
+}
+
+// dbImageIsUsed tells whether an image has aliases or containers created
+// from it.
+func dbImageIsUsed(db *sql.DB, fingerprint string) (bool, error) {
+	q := `SELECT (SELECT COUNT(*) FROM images_aliases
+			JOIN images ON images_aliases.image_id=images.id
+			WHERE images.fingerprint=?)
+		+ (SELECT COUNT(*) FROM containers_config
+			WHERE key='volatile.base_image' AND value=?)`
+	var count int
+	arg1 := []interface{}{fingerprint, fingerprint}
+	arg2 := []interface{}{&count}
+	if err := dbQueryRowScan(db, q, arg1, arg2); err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
 // Insert an alias into the database.
 func dbImageAliasAdd(db *sql.DB, name string, imageID int, desc string) error {
 	stmt := `INSERT into images_aliases (name, image_id, description) values (?, ?, ?)`
@@ -169,6 +223,36 @@ func dbImageLastAccessInit(db *sql.DB, fingerprint string) error {
 	return err
 }
 
+/*
+ * dbImageSourceGet returns the fingerprint of the image cached for an alias
+ * of a remote server, and whether that server was checked for the alias in
+ * the last expiry days.
+ */
+func dbImageSourceGet(db *sql.DB, server string, alias string, expiry string) (string, bool, error) {
+	q := `SELECT images.fingerprint, images_source.last_check_date > strftime('%s', 'now', ?)
+		FROM images_source JOIN images ON images_source.image_id=images.id
+		WHERE images_source.server=? AND images_source.alias=?`
+	var fingerprint string
+	var fresh bool
+	arg1 := []interface{}{"-" + expiry + " day", server, alias}
+	arg2 := []interface{}{&fingerprint, &fresh}
+	err := dbQueryRowScan(db, q, arg1, arg2)
+	if err != nil {
+		return "", false, err
+	}
+
+	return fingerprint, fresh, nil
+}
+
+// dbImageSourceSet records that an alias of a remote server was just found
+// to point to an image, replacing what was known of that alias.
+func dbImageSourceSet(db *sql.DB, fingerprint string, server string, alias string) error {
+	stmt := `INSERT OR REPLACE INTO images_source (image_id, server, alias, last_check_date)
+		SELECT id, ?, ?, strftime("%s") FROM images WHERE fingerprint=?`
+	_, err := dbExec(db, stmt, server, alias, fingerprint)
+	return err
+}
+
 func dbImageExpiryGet(db *sql.DB) (string, error) {
 	q := `SELECT value FROM config WHERE key='images.remote_cache_expiry'`
 	arg1 := []interface{}{}
@@ -184,3 +268,19 @@ func dbImageExpiryGet(db *sql.DB) (string, error) {
 		return "", err
 	}
 }
+
+// dbImagesCacheSize returns the number and total size of the images which
+// were cached from a remote.
+func dbImagesCacheSize(db *sql.DB) (int, int64, error) {
+	q := `SELECT COUNT(*), COALESCE(SUM(size), 0) FROM images WHERE cached=1`
+	var count int
+	var size int64
+	arg1 := []interface{}{}
+	arg2 := []interface{}{&count, &size}
+	err := dbQueryRowScan(db, q, arg1, arg2)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return count, size, nil
+}
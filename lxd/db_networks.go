@@ -0,0 +1,273 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/lxc/lxd/shared"
+)
+
+// dbNetworks returns the names of the managed networks.
+func dbNetworks(db *sql.DB) ([]string, error) {
+	var name string
+	query := "SELECT name FROM networks ORDER BY name"
+	inargs := []interface{}{}
+	outfmt := []interface{}{name}
+	results, err := dbQueryScan(db, query, inargs, outfmt)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, r := range results {
+		names = append(names, r[0].(string))
+	}
+
+	return names, nil
+}
+
+// dbNetworkIDGet returns the ID of a managed network, or NoSuchObjectError.
+func dbNetworkIDGet(db *sql.DB, name string) (int64, error) {
+	id := int64(-1)
+
+	err := dbQueryRowScan(db, "SELECT id FROM networks WHERE name=?", []interface{}{name}, []interface{}{&id})
+	if err == sql.ErrNoRows {
+		return -1, NoSuchObjectError
+	}
+
+	return id, err
+}
+
+// dbNetworkConfigGet returns the configuration of a managed network, or
+// NoSuchObjectError.
+func dbNetworkConfigGet(db *sql.DB, name string) (map[string]string, error) {
+	id, err := dbNetworkIDGet(db, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var key, value string
+	query := "SELECT key, value FROM networks_config WHERE network_id=?"
+	inargs := []interface{}{id}
+	outfmt := []interface{}{key, value}
+	results, err := dbQueryScan(db, query, inargs, outfmt)
+	if err != nil {
+		return nil, err
+	}
+
+	config := map[string]string{}
+	for _, r := range results {
+		config[r[0].(string)] = r[1].(string)
+	}
+
+	return config, nil
+}
+
+func dbNetworkCreate(db *sql.DB, name string, config map[string]string) (int64, error) {
+	id := int64(-1)
+
+	err := dbTx(db, func(tx *sql.Tx) error {
+		result, err := tx.Exec("INSERT INTO networks (name) VALUES (?)", name)
+		if err != nil {
+			return err
+		}
+
+		id, err = result.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		return dbNetworkConfigAdd(tx, id, config)
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	return id, nil
+}
+
+// dbNetworkConfigSet replaces the configuration of a managed network.
+func dbNetworkConfigSet(db *sql.DB, name string, config map[string]string) error {
+	id, err := dbNetworkIDGet(db, name)
+	if err != nil {
+		return err
+	}
+
+	return dbTx(db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec("DELETE FROM networks_config WHERE network_id=?", id); err != nil {
+			return err
+		}
+
+		return dbNetworkConfigAdd(tx, id, config)
+	})
+}
+
+func dbNetworkConfigAdd(tx *sql.Tx, id int64, config map[string]string) error {
+	stmt, err := tx.Prepare("INSERT INTO networks_config (network_id, key, value) VALUES (?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for key, value := range config {
+		if value == "" {
+			continue
+		}
+
+		if _, err := stmt.Exec(id, key, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func dbNetworkRename(db *sql.DB, oldName string, newName string) error {
+	_, err := dbExec(db, "UPDATE networks SET name=? WHERE name=?", newName, oldName)
+	return err
+}
+
+func dbNetworkDelete(db *sql.DB, name string) error {
+	_, err := dbExec(db, "DELETE FROM networks WHERE name=?", name)
+	return err
+}
+
+/*
+ * dbNetworkUsers returns the URLs of the containers and profiles with a nic
+ * device whose parent is the given network.
+ */
+func dbNetworkUsers(db *sql.DB, name string) ([]string, error) {
+	nicType, err := deviceTypeToDbType("nic")
+	if err != nil {
+		return nil, err
+	}
+
+	users := []string{}
+	for _, kind := range []string{"container", "profile"} {
+		var user string
+		query := fmt.Sprintf(`SELECT DISTINCT %[1]ss.name FROM %[1]ss
+			JOIN %[1]ss_devices ON %[1]ss_devices.%[1]s_id=%[1]ss.id
+			JOIN %[1]ss_devices_config ON %[1]ss_devices_config.%[1]s_device_id=%[1]ss_devices.id
+			WHERE %[1]ss_devices.type=? AND %[1]ss_devices_config.key='parent' AND %[1]ss_devices_config.value=?
+			ORDER BY %[1]ss.name`, kind)
+		inargs := []interface{}{nicType, name}
+		outfmt := []interface{}{user}
+		results, err := dbQueryScan(db, query, inargs, outfmt)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range results {
+			if kind == "container" {
+				users = append(users, containerURL(r[0].(string)))
+			} else {
+				users = append(users, fmt.Sprintf("/%s/profiles/%s", shared.APIVersion, r[0].(string)))
+			}
+		}
+	}
+
+	return users, nil
+}
+
+/*
+ * dbNetworkDeviceAddresses returns the static ipv4.address and ipv6.address
+ * of the nic devices of containers and profiles on the given network.
+ */
+func dbNetworkDeviceAddresses(db *sql.DB, name string) ([]string, error) {
+	nicType, err := deviceTypeToDbType("nic")
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := []string{}
+	for _, kind := range []string{"container", "profile"} {
+		var address string
+		query := fmt.Sprintf(`SELECT address.value FROM %[1]ss_devices
+			JOIN %[1]ss_devices_config AS parent ON parent.%[1]s_device_id=%[1]ss_devices.id
+			JOIN %[1]ss_devices_config AS address ON address.%[1]s_device_id=%[1]ss_devices.id
+			WHERE %[1]ss_devices.type=? AND parent.key='parent' AND parent.value=?
+			AND address.key IN ('ipv4.address', 'ipv6.address')`, kind)
+		inargs := []interface{}{nicType, name}
+		outfmt := []interface{}{address}
+		results, err := dbQueryScan(db, query, inargs, outfmt)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range results {
+			addresses = append(addresses, r[0].(string))
+		}
+	}
+
+	return addresses, nil
+}
+
+// dbNetworkForwardsGet returns the port forwards of a managed network.
+func dbNetworkForwardsGet(db *sql.DB, name string) ([]shared.NetworkForward, error) {
+	var protocol, listenAddress, targetAddress, description string
+	var listenPort, targetPort int
+	query := `SELECT protocol, listen_address, listen_port, target_address, target_port, description
+		FROM networks_forwards JOIN networks ON networks_forwards.network_id=networks.id
+		WHERE networks.name=? ORDER BY protocol, listen_port`
+	inargs := []interface{}{name}
+	outfmt := []interface{}{protocol, listenAddress, listenPort, targetAddress, targetPort, description}
+	results, err := dbQueryScan(db, query, inargs, outfmt)
+	if err != nil {
+		return nil, err
+	}
+
+	forwards := []shared.NetworkForward{}
+	for _, r := range results {
+		forwards = append(forwards, shared.NetworkForward{
+			Protocol:      r[0].(string),
+			ListenAddress: r[1].(string),
+			ListenPort:    r[2].(int),
+			TargetAddress: r[3].(string),
+			TargetPort:    r[4].(int),
+			Description:   r[5].(string),
+		})
+	}
+
+	return forwards, nil
+}
+
+func dbNetworkForwardAdd(db *sql.DB, name string, forward shared.NetworkForward) error {
+	id, err := dbNetworkIDGet(db, name)
+	if err != nil {
+		return err
+	}
+
+	_, err = dbExec(db, `INSERT INTO networks_forwards
+		(network_id, protocol, listen_address, listen_port, target_address, target_port, description)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		id, forward.Protocol, forward.ListenAddress, forward.ListenPort,
+		forward.TargetAddress, forward.TargetPort, forward.Description)
+	return err
+}
+
+// dbNetworkForwardDelete removes a port forward, or fails with
+// NoSuchObjectError.
+func dbNetworkForwardDelete(db *sql.DB, name string, protocol string, address string, port int) error {
+	id, err := dbNetworkIDGet(db, name)
+	if err != nil {
+		return err
+	}
+
+	result, err := dbExec(db, "DELETE FROM networks_forwards WHERE network_id=? AND protocol=? AND listen_address=? AND listen_port=?", id, protocol, address, port)
+	if err != nil {
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return NoSuchObjectError
+	}
+
+	return nil
+}
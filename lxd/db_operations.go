@@ -0,0 +1,77 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/lxc/lxd/shared"
+)
+
+// dbOperationSave records the current state of an operation, creating its
+// entry the first time.
+func dbOperationSave(db *sql.DB, url string, op *shared.Operation) error {
+	resources, err := json.Marshal(op.Resources)
+	if err != nil {
+		return err
+	}
+
+	mayCancel := 0
+	if op.MayCancel {
+		mayCancel = 1
+	}
+
+	str := `INSERT OR REPLACE INTO operations
+	    (url, created_at, updated_at, status_code, resources, metadata, may_cancel)
+	    VALUES (?, ?, ?, ?, ?, ?, ?)`
+	_, err = dbExec(db, str, url, op.CreatedAt, op.UpdatedAt, int(op.StatusCode),
+		string(resources), string(op.Metadata), mayCancel)
+	return err
+}
+
+// dbOperationsGet returns all the recorded operations, indexed by URL.
+func dbOperationsGet(db *sql.DB) (map[string]*shared.Operation, error) {
+	q := `SELECT url, created_at, updated_at, status_code, resources, metadata, may_cancel
+	    FROM operations`
+	rows, err := dbQuery(db, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := map[string]*shared.Operation{}
+
+	for rows.Next() {
+		var url, resources, metadata string
+		var statusCode, mayCancel int
+		op := shared.Operation{}
+
+		err := rows.Scan(&url, &op.CreatedAt, &op.UpdatedAt, &statusCode, &resources, &metadata, &mayCancel)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal([]byte(resources), &op.Resources); err != nil {
+			return nil, err
+		}
+
+		op.Status = shared.StatusCode(statusCode).String()
+		op.StatusCode = shared.StatusCode(statusCode)
+		if metadata != "" {
+			op.Metadata = json.RawMessage(metadata)
+		}
+		op.MayCancel = mayCancel == 1
+		results[url] = &op
+	}
+
+	return results, rows.Err()
+}
+
+// dbOperationsPrune removes the operations which were last updated before
+// the given date.
+func dbOperationsPrune(db *sql.DB, before time.Time) error {
+	_, err := dbExec(db, "DELETE FROM operations WHERE updated_at < ?", before)
+	return err
+}
@@ -49,34 +49,24 @@ func dbProfilesGet(db *sql.DB) ([]string, error) {
 func dbProfileCreate(db *sql.DB, profile string, config map[string]string,
 	devices shared.Devices) (int64, error) {
 
-	tx, err := dbBegin(db)
-	if err != nil {
-		return -1, err
-	}
-	result, err := tx.Exec("INSERT INTO profiles (name) VALUES (?)", profile)
-	if err != nil {
-		tx.Rollback()
-		return -1, err
-	}
-	id, err := result.LastInsertId()
-	if err != nil {
-		tx.Rollback()
-		return -1, err
-	}
-
-	err = dbProfileConfigAdd(tx, id, config)
-	if err != nil {
-		tx.Rollback()
-		return -1, err
-	}
+	var id int64
+	err := dbTx(db, func(tx *sql.Tx) error {
+		result, err := tx.Exec("INSERT INTO profiles (name) VALUES (?)", profile)
+		if err != nil {
+			return err
+		}
+		id, err = result.LastInsertId()
+		if err != nil {
+			return err
+		}
 
-	err = dbDevicesAdd(tx, "profile", id, devices)
-	if err != nil {
-		tx.Rollback()
-		return -1, err
-	}
+		err = dbProfileConfigAdd(tx, id, config)
+		if err != nil {
+			return err
+		}
 
-	err = txCommit(tx)
+		return dbDevicesAdd(tx, "profile", id, devices)
+	})
 	if err != nil {
 		return -1, err
 	}
@@ -95,8 +85,12 @@ func dbProfileCreateDefault(db *sql.DB) error {
 		return nil
 	}
 
-	// TODO: We should the scan for bridges and use the best available as default.
+	// networkDefaultSetup moves the nic to a managed bridge on first
+	// start if lxcbr0 isn't there.
 	devices := shared.Devices{
+		"root": shared.Device{
+			"type": "disk",
+			"path": "/"},
 		"eth0": shared.Device{
 			"type":    "nic",
 			"nictype": "bridged",
@@ -177,19 +171,44 @@ func dbProfileConfigGet(db *sql.DB, name string) (map[string]string, error) {
 }
 
 func dbProfileDelete(db *sql.DB, name string) error {
-	tx, err := dbBegin(db)
-	if err != nil {
-		return err
-	}
-	_, err = tx.Exec("DELETE FROM profiles WHERE name=?", name)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
+	_, err := dbExec(db, "DELETE FROM profiles WHERE name=?", name)
+	return err
+}
 
-	err = txCommit(tx)
+/*
+ * dbProfileRename renames a profile, failing with NoSuchObjectError if it
+ * doesn't exist and DbErrAlreadyDefined if the new name is taken. The
+ * containers reference it by ID, so they all follow within the same
+ * transaction.
+ */
+func dbProfileRename(db *sql.DB, name string, newName string) error {
+	return dbTx(db, func(tx *sql.Tx) error {
+		var count int
+		err := tx.QueryRow("SELECT COUNT(*) FROM profiles WHERE name=?", newName).Scan(&count)
+		if err != nil {
+			return err
+		}
 
-	return err
+		if count != 0 {
+			return DbErrAlreadyDefined
+		}
+
+		result, err := tx.Exec("UPDATE profiles SET name=? WHERE name=?", newName, name)
+		if err != nil {
+			return err
+		}
+
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if n == 0 {
+			return NoSuchObjectError
+		}
+
+		return nil
+	})
 }
 
 func dbProfileConfigClear(tx *sql.Tx, id int64) error {
@@ -4,6 +4,9 @@ import (
 	"database/sql"
 	"fmt"
 	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
 
 	"github.com/lxc/lxd/shared"
 )
@@ -27,7 +30,7 @@ const DB_FIXTURES string = `
 func createTestDb(t *testing.T) (db *sql.DB) {
 	// Setup logging if main() hasn't been called/when testing
 	if shared.Log == nil {
-		shared.SetLogger("", "", true, true)
+		shared.SetLogger("", "", true, true, nil)
 	}
 
 	var err error
@@ -497,6 +500,79 @@ func Test_dbImageAliasAdd(t *testing.T) {
 	}
 }
 
+func Test_dbImageAliasesReplace(t *testing.T) {
+	db := createTestDb(t)
+	defer db.Close()
+
+	_, err := db.Exec("INSERT INTO images (fingerprint, filename, size, architecture, upload_date) VALUES ('newfingerprint', 'filename', 1024, 0, 1431547176)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := dbImageAliasesReplace(db, "newfingerprint", []string{"somealias", "newalias"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(old) != 1 || old[0] != "fingerprint" {
+		t.Fatalf("Wrong replaced images: %v", old)
+	}
+
+	for _, alias := range []string{"somealias", "newalias"} {
+		if fingerprint, err := dbImageAliasGet(db, alias); err != nil || fingerprint != "newfingerprint" {
+			t.Errorf("Alias %s not pointed to the new image: %s (%v)", alias, fingerprint, err)
+		}
+	}
+
+	if used, err := dbImageIsUsed(db, "fingerprint"); err != nil || used {
+		t.Errorf("The replaced image is still used: %v", err)
+	}
+
+	if used, err := dbImageIsUsed(db, "newfingerprint"); err != nil || !used {
+		t.Errorf("The new image isn't used: %v", err)
+	}
+}
+
+func Test_dbImageSource(t *testing.T) {
+	db := createTestDb(t)
+	defer db.Close()
+
+	if _, _, err := dbImageSourceGet(db, "https://images", "ubuntu/trusty", "10"); err != sql.ErrNoRows {
+		t.Fatalf("Found a source that wasn't recorded: %v", err)
+	}
+
+	if err := dbImageSourceSet(db, "fingerprint", "https://images", "ubuntu/trusty"); err != nil {
+		t.Fatal(err)
+	}
+
+	fingerprint, fresh, err := dbImageSourceGet(db, "https://images", "ubuntu/trusty", "10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fingerprint != "fingerprint" || !fresh {
+		t.Fatalf("Wrong source: %s, fresh: %v", fingerprint, fresh)
+	}
+
+	if _, err := db.Exec("UPDATE images_source SET last_check_date=strftime('%s', 'now', '-11 day')"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, fresh, err = dbImageSourceGet(db, "https://images", "ubuntu/trusty", "10"); err != nil || fresh {
+		t.Fatalf("The remote doesn't need checking again: %v", err)
+	}
+
+	// Recording the alias again replaces its entry
+	if err := dbImageSourceSet(db, "fingerprint", "https://images", "ubuntu/trusty"); err != nil {
+		t.Fatal(err)
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM images_source").Scan(&count); err != nil || count != 1 {
+		t.Fatalf("Wrong number of sources: %d (%v)", count, err)
+	}
+}
+
 func Test_dbContainerConfigGet(t *testing.T) {
 	var db *sql.DB
 	var err error
@@ -620,3 +696,244 @@ func Test_dbDevicesGet_containers(t *testing.T) {
 	}
 
 }
+
+func Test_dbCertTokenUse_consumes_the_token(t *testing.T) {
+	var db *sql.DB
+	var err error
+
+	db = createTestDb(t)
+	defer db.Close()
+
+	_, err = dbCertTokenAdd(db, "somehash", "thename", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := &dbCertInfo{Fingerprint: "somefingerprint", Type: 1, Name: "somehost", Certificate: "somecert"}
+	err = dbCertTokenUse(db, "somehash", cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := dbCertGet(db, "somefingerprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if saved.Name != "thename" {
+		t.Errorf("Wrong certificate name: %s != thename", saved.Name)
+	}
+
+	cert = &dbCertInfo{Fingerprint: "otherfingerprint", Type: 1, Name: "otherhost", Certificate: "othercert"}
+	err = dbCertTokenUse(db, "somehash", cert)
+	if err != NoSuchObjectError {
+		t.Errorf("A token could be used twice: %v", err)
+	}
+}
+
+func Test_dbCertTokenUse_expired_token(t *testing.T) {
+	var db *sql.DB
+	var err error
+
+	db = createTestDb(t)
+	defer db.Close()
+
+	_, err = dbCertTokenAdd(db, "somehash", "thename", time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := &dbCertInfo{Fingerprint: "somefingerprint", Type: 1, Name: "somehost", Certificate: "somecert"}
+	err = dbCertTokenUse(db, "somehash", cert)
+	if err != NoSuchObjectError {
+		t.Errorf("An expired token could be used: %v", err)
+	}
+
+	if _, err := dbCertGet(db, "somefingerprint"); err == nil {
+		t.Errorf("The certificate of an expired token was added")
+	}
+
+	tokens, err := dbCertTokensGet(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tokens) != 0 {
+		t.Errorf("Expired tokens are listed: %d", len(tokens))
+	}
+}
+
+func Test_dbTx_rolls_back_on_error(t *testing.T) {
+	db := createTestDb(t)
+	defer db.Close()
+
+	err := dbTx(db, func(tx *sql.Tx) error {
+		_, err := tx.Exec("INSERT INTO profiles (name) VALUES ('txprofile')")
+		if err != nil {
+			return err
+		}
+
+		return fmt.Errorf("failed")
+	})
+	if err == nil || err.Error() != "failed" {
+		t.Fatalf("Wrong error: %v", err)
+	}
+
+	var count int
+	err = db.QueryRow("SELECT count(*) FROM profiles WHERE name='txprofile'").Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 0 {
+		t.Errorf("The transaction wasn't rolled back")
+	}
+
+	// The write lock was released
+	_, err = dbExec(db, "INSERT INTO profiles (name) VALUES ('txprofile')")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_isDbLockedError(t *testing.T) {
+	if !isDbLockedError(sqlite3.Error{Code: sqlite3.ErrBusy}) {
+		t.Error("A busy database should be retried")
+	}
+
+	if isDbLockedError(sqlite3.Error{Code: sqlite3.ErrConstraint}) {
+		t.Error("A constraint failure shouldn't be retried")
+	}
+}
+
+func Test_dbDumpValue(t *testing.T) {
+	values := map[string]interface{}{
+		"NULL":                        nil,
+		"42":                          int64(42),
+		"1.5":                         1.5,
+		"'it''s'":                     "it's",
+		"X'00ff'":                     []byte{0, 255},
+		"'2016-01-01 00:00:00+00:00'": time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	for expected, value := range values {
+		if result := dbDumpValue(value); result != expected {
+			t.Errorf("Wrong dump of %v: %s", value, result)
+		}
+	}
+}
+
+func Test_dbDump_can_be_restored(t *testing.T) {
+	db := createTestDb(t)
+	defer db.Close()
+
+	dump, err := dbDump(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+
+	if _, err := restored.Exec(dump); err != nil {
+		t.Fatalf("Failed to restore the dump: %s", err)
+	}
+
+	var name string
+	err = restored.QueryRow("SELECT name FROM containers WHERE id=1").Scan(&name)
+	if err != nil || name != "thename" {
+		t.Errorf("Wrong restored container: %s, %v", name, err)
+	}
+}
+
+func Test_dbCheck_on_a_consistent_database(t *testing.T) {
+	db := createTestDb(t)
+	defer db.Close()
+
+	check, err := dbCheck(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !check.OK {
+		t.Errorf("Problems found: %v %v", check.Integrity, check.ForeignKeys)
+	}
+}
+
+func Test_dbClusterMembers(t *testing.T) {
+	db := createTestDb(t)
+	defer db.Close()
+
+	member := dbClusterMember{Name: "host2", Address: "10.0.0.2:8443", Certificate: "cert"}
+	if err := dbClusterMemberAdd(db, member); err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := dbClusterMembersGet(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(members) != 1 || members[0].Name != "host2" || members[0].Address != "10.0.0.2:8443" {
+		t.Fatalf("Wrong members: %v", members)
+	}
+
+	if err := dbClusterMemberDelete(db, "host2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := dbClusterMemberGet(db, "host2"); err != sql.ErrNoRows {
+		t.Errorf("Member still there after being deleted: %v", err)
+	}
+}
+
+func Test_ValidContainerConfigKey_environment(t *testing.T) {
+	for _, key := range []string{"environment.FOO", "environment._foo_2"} {
+		if !ValidContainerConfigKey(key) {
+			t.Errorf("Refused a valid environment key: %s", key)
+		}
+	}
+
+	for _, key := range []string{"environment.", "environment.2FOO", "environment.FOO BAR", "environment.FOO=BAR"} {
+		if ValidContainerConfigKey(key) {
+			t.Errorf("Accepted a bad environment key: %s", key)
+		}
+	}
+}
+
+func Test_dbCertTokenUse_keeps_the_token_if_the_certificate_isnt_added(t *testing.T) {
+	var db *sql.DB
+	var err error
+
+	db = createTestDb(t)
+	defer db.Close()
+
+	_, err = dbCertTokenAdd(db, "somehash", "thename", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The fingerprint is already trusted
+	cert := &dbCertInfo{Fingerprint: "somefingerprint", Type: 1, Name: "somehost", Certificate: "somecert"}
+	err = dbCertSave(db, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = dbCertTokenUse(db, "somehash", cert)
+	if err == nil {
+		t.Fatal("A certificate was added twice")
+	}
+
+	tokens, err := dbCertTokensGet(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tokens) != 1 {
+		t.Errorf("The token was used up: %d tokens left", len(tokens))
+	}
+}
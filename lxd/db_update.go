@@ -14,6 +14,197 @@ import (
 	log "gopkg.in/inconshreveable/log15.v2"
 )
 
+func dbUpdateFromV29(db *sql.DB) error {
+	stmt := `
+CREATE TABLE tmp (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    network_id INTEGER NOT NULL,
+    protocol VARCHAR(255) NOT NULL,
+    listen_address VARCHAR(255) NOT NULL DEFAULT '',
+    listen_port INTEGER NOT NULL,
+    target_address VARCHAR(255) NOT NULL,
+    target_port INTEGER NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    UNIQUE (protocol, listen_address, listen_port),
+    FOREIGN KEY (network_id) REFERENCES networks (id) ON DELETE CASCADE
+);
+
+INSERT INTO tmp SELECT * FROM networks_forwards;
+
+DROP TABLE networks_forwards;
+ALTER TABLE tmp RENAME TO networks_forwards;
+INSERT INTO schema (version, updated_at) VALUES (?, strftime("%s"));`
+	_, err := db.Exec(stmt, 30)
+	return err
+}
+
+func dbUpdateFromV28(db *sql.DB) error {
+	stmt := `
+ALTER TABLE containers ADD COLUMN snapshot_size INTEGER NOT NULL DEFAULT -1;
+INSERT INTO schema (version, updated_at) VALUES (?, strftime("%s"));`
+	_, err := db.Exec(stmt, 29)
+	return err
+}
+
+func dbUpdateFromV27(db *sql.DB) error {
+	stmt := `
+ALTER TABLE cluster_members ADD COLUMN trusted_by_join INTEGER NOT NULL DEFAULT 0;
+UPDATE cluster_members SET trusted_by_join=1;
+INSERT INTO schema (version, updated_at) VALUES (?, strftime("%s"));`
+	_, err := db.Exec(stmt, 28)
+	return err
+}
+
+func dbUpdateFromV26(db *sql.DB) error {
+	stmt := `
+ALTER TABLE certificates ADD COLUMN added_date DATETIME NOT NULL DEFAULT 0;
+INSERT INTO schema (version, updated_at) VALUES (?, strftime("%s"));`
+	_, err := db.Exec(stmt, 27)
+	return err
+}
+
+func dbUpdateFromV25(db *sql.DB) error {
+	stmt := `
+CREATE TABLE IF NOT EXISTS images_source (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    image_id INTEGER NOT NULL,
+    server TEXT NOT NULL,
+    alias VARCHAR(255) NOT NULL,
+    last_check_date DATETIME NOT NULL,
+    UNIQUE (server, alias),
+    FOREIGN KEY (image_id) REFERENCES images (id) ON DELETE CASCADE
+);
+INSERT INTO schema (version, updated_at) VALUES (?, strftime("%s"));`
+	_, err := db.Exec(stmt, 26)
+	return err
+}
+
+func dbUpdateFromV24(db *sql.DB) error {
+	stmt := `
+CREATE TABLE IF NOT EXISTS networks_forwards (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    network_id INTEGER NOT NULL,
+    protocol VARCHAR(255) NOT NULL,
+    listen_address VARCHAR(255) NOT NULL DEFAULT '',
+    listen_port INTEGER NOT NULL,
+    target_address VARCHAR(255) NOT NULL,
+    target_port INTEGER NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    UNIQUE (protocol, listen_port),
+    FOREIGN KEY (network_id) REFERENCES networks (id) ON DELETE CASCADE
+);
+INSERT INTO schema (version, updated_at) VALUES (?, strftime("%s"));`
+	_, err := db.Exec(stmt, 25)
+	return err
+}
+
+func dbUpdateFromV23(db *sql.DB) error {
+	stmt := `
+CREATE TABLE IF NOT EXISTS networks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    UNIQUE (name)
+);
+CREATE TABLE IF NOT EXISTS networks_config (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    network_id INTEGER NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    value TEXT,
+    UNIQUE (network_id, key),
+    FOREIGN KEY (network_id) REFERENCES networks (id) ON DELETE CASCADE
+);
+INSERT INTO schema (version, updated_at) VALUES (?, strftime("%s"));`
+	_, err := db.Exec(stmt, 24)
+	return err
+}
+
+func dbUpdateFromV22(db *sql.DB) error {
+	stmt := `
+CREATE TABLE IF NOT EXISTS cluster_members (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    address VARCHAR(255) NOT NULL,
+    certificate TEXT NOT NULL,
+    UNIQUE (name)
+);
+INSERT INTO schema (version, updated_at) VALUES (?, strftime("%s"));`
+	_, err := db.Exec(stmt, 23)
+	return err
+}
+
+func dbUpdateFromV21(db *sql.DB) error {
+	stmt := `
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    date DATETIME NOT NULL,
+    client VARCHAR(255) NOT NULL,
+    method VARCHAR(255) NOT NULL,
+    url TEXT NOT NULL,
+    summary TEXT NOT NULL,
+    status_code INTEGER NOT NULL
+);
+INSERT INTO schema (version, updated_at) VALUES (?, strftime("%s"));`
+	_, err := db.Exec(stmt, 22)
+	return err
+}
+
+func dbUpdateFromV20(db *sql.DB) error {
+	stmt := `
+ALTER TABLE certificates ADD COLUMN read_only INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE certificates ADD COLUMN restricted INTEGER NOT NULL DEFAULT 0;
+CREATE TABLE IF NOT EXISTS certificates_containers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    certificate_id INTEGER NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    FOREIGN KEY (certificate_id) REFERENCES certificates (id) ON DELETE CASCADE,
+    UNIQUE (certificate_id, name)
+);
+INSERT INTO schema (version, updated_at) VALUES (?, strftime("%s"));`
+	_, err := db.Exec(stmt, 21)
+	return err
+}
+
+func dbUpdateFromV19(db *sql.DB) error {
+	stmt := `
+CREATE TABLE IF NOT EXISTS certificates_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    token_hash VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    UNIQUE (token_hash)
+);
+INSERT INTO schema (version, updated_at) VALUES (?, strftime("%s"));`
+	_, err := db.Exec(stmt, 20)
+	return err
+}
+
+func dbUpdateFromV18(db *sql.DB) error {
+	stmt := `
+CREATE TABLE IF NOT EXISTS operations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    url VARCHAR(255) NOT NULL,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    status_code INTEGER NOT NULL,
+    resources TEXT,
+    metadata TEXT,
+    may_cancel INTEGER NOT NULL DEFAULT 0,
+    UNIQUE (url)
+);
+INSERT INTO schema (version, updated_at) VALUES (?, strftime("%s"));`
+	_, err := db.Exec(stmt, 19)
+	return err
+}
+
+func dbUpdateFromV17(db *sql.DB) error {
+	stmt := `
+ALTER TABLE containers ADD COLUMN creation_date DATETIME NOT NULL DEFAULT 0;
+INSERT INTO schema (version, updated_at) VALUES (?, strftime("%s"));`
+	_, err := db.Exec(stmt, 18)
+	return err
+}
+
 func dbUpdateFromV16(db *sql.DB) error {
 	stmt := `
 UPDATE config SET key='storage.lvm_vg_name' WHERE key = 'core.lvm_vg_name';
@@ -159,7 +350,7 @@ func dbUpdateFromV11(d *Daemon) error {
 			// containers/<container>/snapshots/<snap0>
 			//   to
 			// snapshots/<container>/<snap0>
-			output, err := storageRsyncCopy(oldPath, newPath)
+			output, err := storageRsyncCopy(oldPath, newPath, nil)
 			if err != nil {
 				shared.Log.Error(
 					"Failed rsync snapshot",
@@ -221,7 +412,7 @@ func dbUpdateFromV10(d *Daemon) error {
 		}
 
 		shared.Debugf("Restarting all the containers following directory rename")
-		containersShutdown(d)
+		containersShutdown(d, false, containersShutdownTimeout)
 		containersRestart(d)
 	}
 
@@ -716,6 +907,84 @@ func dbUpdate(d *Daemon, prevVersion int) error {
 			return err
 		}
 	}
+	if prevVersion < 18 {
+		err = dbUpdateFromV17(db)
+		if err != nil {
+			return err
+		}
+	}
+	if prevVersion < 19 {
+		err = dbUpdateFromV18(db)
+		if err != nil {
+			return err
+		}
+	}
+	if prevVersion < 20 {
+		err = dbUpdateFromV19(db)
+		if err != nil {
+			return err
+		}
+	}
+	if prevVersion < 21 {
+		err = dbUpdateFromV20(db)
+		if err != nil {
+			return err
+		}
+	}
+	if prevVersion < 22 {
+		err = dbUpdateFromV21(db)
+		if err != nil {
+			return err
+		}
+	}
+	if prevVersion < 23 {
+		err = dbUpdateFromV22(db)
+		if err != nil {
+			return err
+		}
+	}
+	if prevVersion < 24 {
+		err = dbUpdateFromV23(db)
+		if err != nil {
+			return err
+		}
+	}
+	if prevVersion < 25 {
+		err = dbUpdateFromV24(db)
+		if err != nil {
+			return err
+		}
+	}
+	if prevVersion < 26 {
+		err = dbUpdateFromV25(db)
+		if err != nil {
+			return err
+		}
+	}
+	if prevVersion < 27 {
+		err = dbUpdateFromV26(db)
+		if err != nil {
+			return err
+		}
+	}
+	if prevVersion < 28 {
+		err = dbUpdateFromV27(db)
+		if err != nil {
+			return err
+		}
+	}
+	if prevVersion < 29 {
+		err = dbUpdateFromV28(db)
+		if err != nil {
+			return err
+		}
+	}
+	if prevVersion < 30 {
+		err = dbUpdateFromV29(db)
+		if err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"fmt"
+	"net"
+	"net/http"
+	httppprof "net/http/pprof"
 	"os"
 	"os/signal"
 	"runtime/pprof"
 	"syscall"
 
+	log "gopkg.in/inconshreveable/log15.v2"
+
 	"github.com/lxc/lxd/shared"
 )
 
@@ -28,3 +34,55 @@ func memProfiler() {
 		doMemDump()
 	}
 }
+
+// debugMux serves the Go profiling endpoints, goroutine dumps included
+// (/debug/pprof/goroutine?debug=2).
+func debugMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", httppprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", httppprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", httppprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", httppprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", httppprof.Trace)
+
+	return mux
+}
+
+/*
+ * UpdateDebugAddress moves the listener of the profiling endpoints to the
+ * given address, or stops it if the address is empty. There's no
+ * authentication there, so it's meant to be a local address. The current
+ * listener is kept if the new address can't be bound.
+ */
+func (d *Daemon) UpdateDebugAddress(address string) error {
+	d.debugListenerLock.Lock()
+	defer d.debugListenerLock.Unlock()
+
+	if address == "" {
+		if d.debugListener != nil {
+			d.debugListener.Close()
+			d.debugListener = nil
+		}
+
+		return nil
+	}
+
+	if d.debugListener != nil && d.debugListener.Addr().String() == address {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("cannot listen on debug socket: %v", err)
+	}
+
+	if d.debugListener != nil {
+		d.debugListener.Close()
+	}
+
+	shared.Log.Info("Serving the profiling endpoints", log.Ctx{"socket": listener.Addr()})
+	d.debugListener = listener
+	go http.Serve(listener, debugMux())
+
+	return nil
+}
@@ -5,9 +5,15 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -28,6 +34,14 @@ func addBlockDev(dev string) ([]string, error) {
 	return line, err
 }
 
+/*
+ * deviceIsRootDisk tells whether a device is the container's root disk, a
+ * disk mounted on / without a source: the rootfs the container already has.
+ */
+func deviceIsRootDisk(d shared.Device) bool {
+	return d["type"] == "disk" && d["path"] == "/" && d["source"] == ""
+}
+
 func deviceToLxc(d shared.Device) ([][]string, error) {
 	switch d["type"] {
 	case "unix-char":
@@ -35,11 +49,21 @@ func deviceToLxc(d shared.Device) ([][]string, error) {
 	case "unix-block":
 		return nil, fmt.Errorf("Not implemented")
 	case "nic":
-		if d["nictype"] != "bridged" && d["nictype"] != "" {
+		var lines [][]string
+		switch d["nictype"] {
+		case "bridged", "":
+			lines = append(lines, []string{"lxc.network.type", "veth"})
+		case "macvlan":
+			lines = append(lines, []string{"lxc.network.type", "macvlan"})
+			lines = append(lines, []string{"lxc.network.macvlan.mode", "bridge"})
+		case "physical":
+			lines = append(lines, []string{"lxc.network.type", "phys"})
+		default:
 			return nil, fmt.Errorf("Bad nic type: %s\n", d["nictype"])
 		}
-		var l1 = []string{"lxc.network.type", "veth"}
-		var lines = [][]string{l1}
+		if d["parent"] == "" && (d["nictype"] == "macvlan" || d["nictype"] == "physical") {
+			return nil, fmt.Errorf("No parent given for %s nic\n", d["nictype"])
+		}
 		var l2 []string
 		if d["hwaddr"] != "" {
 			l2 = []string{"lxc.network.hwaddr", d["hwaddr"]}
@@ -59,6 +83,10 @@ func deviceToLxc(d shared.Device) ([][]string, error) {
 		}
 		return lines, nil
 	case "disk":
+		if deviceIsRootDisk(d) {
+			return nil, nil
+		}
+
 		var p string
 		configLines := [][]string{}
 		if d["path"] == "/" || d["path"] == "" {
@@ -196,11 +224,17 @@ func validDeviceConfig(t, k, v string) bool {
 			return true
 		case "mtu":
 			return true
+		case "vlan":
+			id, err := strconv.Atoi(v)
+			return err == nil && id >= 0 && id < 4095
 		case "nictype":
-			if v != "bridged" && v != "" {
-				return false
-			}
-			return true
+			return shared.StringInSlice(v, []string{"", "bridged", "macvlan", "physical", "sriov"})
+		case "ipv4.address":
+			ip := networkDeviceAddress(v)
+			return ip != nil && ip.To4() != nil
+		case "ipv6.address":
+			ip := networkDeviceAddress(v)
+			return ip != nil && ip.To4() == nil
 		default:
 			return false
 		}
@@ -258,17 +292,36 @@ func nextUnusedNic(c container) string {
 	}
 }
 
+/*
+ * setupNic creates the host side of a nic being added to a running
+ * container, returning the interface to move into it. A physical nic is
+ * its parent itself.
+ */
 func setupNic(c container, d map[string]string) (string, error) {
-	if d["nictype"] != "bridged" {
-		return "", fmt.Errorf("Unsupported nic type: %s\n", d["nictype"])
-	}
 	if d["parent"] == "" {
-		return "", fmt.Errorf("No bridge given\n")
+		return "", fmt.Errorf("No parent given\n")
 	}
 	if d["name"] == "" {
 		d["name"] = nextUnusedNic(c)
 	}
 
+	switch d["nictype"] {
+	case "bridged", "":
+	case "macvlan":
+		n := tempNic()
+		err := exec.Command("ip", "link", "add", n, "link", d["parent"], "type", "macvlan", "mode", "bridge").Run()
+		if err != nil {
+			return "", err
+		}
+		return n, nil
+	case "physical":
+		return d["parent"], nil
+	case "sriov":
+		return setupSRIOVNic(d)
+	default:
+		return "", fmt.Errorf("Unsupported nic type: %s\n", d["nictype"])
+	}
+
 	n1 := tempNic()
 	n2 := tempNic()
 
@@ -285,6 +338,110 @@ func setupNic(c container, d map[string]string) (string, error) {
 	return n2, nil
 }
 
+/*
+ * sriovReserved holds the VFs handed out to containers which haven't taken
+ * them off the host yet, so that they aren't handed out twice meanwhile.
+ */
+var sriovReserved = map[string]bool{}
+var sriovLock sync.Mutex
+
+/*
+ * sriovFreeVF returns the index and interface of the first VF of an SR-IOV
+ * device (its /sys/class/net/<parent>/device) which is still on the host
+ * and not in used. The VFs are left for the administrator to enable.
+ */
+func sriovFreeVF(device string, used []string) (int, string, error) {
+	numvfs, err := ioutil.ReadFile(path.Join(device, "sriov_numvfs"))
+	if err != nil {
+		return -1, "", fmt.Errorf("Not an SR-IOV device")
+	}
+
+	if strings.TrimSpace(string(numvfs)) == "0" {
+		return -1, "", fmt.Errorf("No VF is enabled (see sriov_numvfs)")
+	}
+
+	vfs, err := filepath.Glob(path.Join(device, "virtfn*"))
+	if err != nil {
+		return -1, "", err
+	}
+
+	indexes := []int{}
+	for _, vf := range vfs {
+		index, err := strconv.Atoi(strings.TrimPrefix(path.Base(vf), "virtfn"))
+		if err == nil {
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Ints(indexes)
+
+	for _, index := range indexes {
+		// The VFs in a container don't show up there
+		names, err := shared.ReadDir(path.Join(device, fmt.Sprintf("virtfn%d", index), "net"))
+		if err != nil || len(names) != 1 || shared.StringInSlice(names[0], used) {
+			continue
+		}
+
+		return index, names[0], nil
+	}
+
+	return -1, "", fmt.Errorf("No free VF")
+}
+
+/*
+ * setupSRIOVNic allocates a VF of the parent of an sriov nic, setting its
+ * MAC and VLAN, and returns its interface. The VF stays reserved until it's
+ * given to sriovReleaseVF, once the container took it or failed to.
+ */
+func setupSRIOVNic(d shared.Device) (string, error) {
+	if d["parent"] == "" {
+		return "", fmt.Errorf("No parent given for sriov nic\n")
+	}
+
+	sriovLock.Lock()
+	reserved := []string{}
+	for vf := range sriovReserved {
+		reserved = append(reserved, vf)
+	}
+
+	index, vf, err := sriovFreeVF(path.Join("/sys/class/net", d["parent"], "device"), reserved)
+	if err == nil {
+		sriovReserved[vf] = true
+	}
+	sriovLock.Unlock()
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to allocate a VF of %s: %s", d["parent"], err)
+	}
+
+	vlan := d["vlan"]
+	if vlan == "" {
+		vlan = "0"
+	}
+
+	commands := [][]string{{"ip", "link", "set", "dev", d["parent"], "vf", strconv.Itoa(index), "vlan", vlan}}
+	if d["hwaddr"] != "" {
+		commands = append(commands,
+			[]string{"ip", "link", "set", "dev", d["parent"], "vf", strconv.Itoa(index), "mac", d["hwaddr"]},
+			[]string{"ip", "link", "set", "dev", vf, "address", d["hwaddr"]})
+	}
+
+	for _, command := range commands {
+		if err := networkExec(command[0], command[1:]...); err != nil {
+			sriovReleaseVF(vf)
+			return "", err
+		}
+	}
+
+	return vf, nil
+}
+
+// sriovReleaseVF drops the reservation of a VF setupSRIOVNic handed out.
+func sriovReleaseVF(vf string) {
+	sriovLock.Lock()
+	delete(sriovReserved, vf)
+	sriovLock.Unlock()
+}
+
 func removeInterface(nic string) {
 	_ = exec.Command("ip", "link", "del", nic).Run()
 }
@@ -304,7 +461,7 @@ func removeInterface(nic string) {
  * setns into the container's netns (only) and remove the nic.  for
  * now just don't do it, but don't fail either.
  */
-func detachInterface(c container, key string) error {
+func detachInterface(c container, dev shared.Device) error {
 	options := lxc.DefaultAttachOptions
 	options.ClearEnv = false
 	options.Namespaces = syscall.CLONE_NEWNET
@@ -317,13 +474,30 @@ func detachInterface(c container, key string) error {
 	options.StdinFd = nullfd
 	options.StdoutFd = nullfd
 	options.StderrFd = nullfd
-	command := []string{"ip", "link", "del", key}
+	commands := [][]string{{"ip", "link", "del", dev["name"]}}
+	if dev["nictype"] == "physical" || dev["nictype"] == "sriov" {
+		// Give it back to the host, under its name there, or for a VF,
+		// one which can't conflict
+		hostName := dev["parent"]
+		if dev["nictype"] == "sriov" {
+			hostName = tempNic()
+		}
+		commands = [][]string{
+			{"ip", "link", "set", "dev", dev["name"], "down"},
+			{"ip", "link", "set", "dev", dev["name"], "name", hostName},
+			{"ip", "link", "set", "dev", hostName, "netns", "1"},
+		}
+	}
 	lxContainer, err := c.LXContainerGet()
 	if err != nil {
 		return err
 	}
-	_, err = lxContainer.RunCommand(command, options)
-	return err
+	for _, command := range commands {
+		if _, err := lxContainer.RunCommand(command, options); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func txUpdateNic(tx *sql.Tx, cId int, devname string, nicname string) error {
@@ -358,10 +532,14 @@ func devicesApplyDeltaLive(tx *sql.Tx, c container, preDevList shared.Devices, p
 			if dev["name"] == "" {
 				return fmt.Errorf("Do not know a name for the nic for device %s\n", key)
 			}
-			if err := detachInterface(c, dev["name"]); err != nil {
+			if err := detachInterface(c, dev); err != nil {
 				return fmt.Errorf("Error removing device %s (nic %s) from container %s: %s", key, dev["name"], c.NameGet(), err)
 			}
 		case "disk":
+			if deviceIsRootDisk(dev) {
+				continue
+			}
+
 			return c.DetachMount(dev)
 		}
 	}
@@ -378,8 +556,15 @@ func devicesApplyDeltaLive(tx *sql.Tx, c container, preDevList shared.Devices, p
 			if tmpName, err = setupNic(c, dev); err != nil {
 				return fmt.Errorf("Unable to create nic %s for container %s: %s", dev["name"], c.NameGet(), err)
 			}
-			if err := lxContainer.AttachInterface(tmpName, dev["name"]); err != nil {
-				removeInterface(tmpName)
+			err := lxContainer.AttachInterface(tmpName, dev["name"])
+			if dev["nictype"] == "sriov" {
+				sriovReleaseVF(tmpName)
+			}
+
+			if err != nil {
+				if dev["nictype"] != "physical" && dev["nictype"] != "sriov" {
+					removeInterface(tmpName)
+				}
 				return fmt.Errorf("Unable to move nic %s into container %s as %s: %s", tmpName, c.NameGet(), dev["name"], err)
 			}
 
@@ -388,6 +573,10 @@ func devicesApplyDeltaLive(tx *sql.Tx, c container, preDevList shared.Devices, p
 				return err
 			}
 		case "disk":
+			if deviceIsRootDisk(dev) {
+				continue
+			}
+
 			if dev["source"] == "" || dev["path"] == "" {
 				return fmt.Errorf("no source or destination given")
 			}
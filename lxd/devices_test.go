@@ -1,6 +1,10 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
 	"testing"
 
 	"github.com/lxc/lxd/shared"
@@ -98,3 +102,109 @@ func Test_nic_device_returns_config_line(t *testing.T) {
 		}
 	}
 }
+
+func Test_macvlan_nic_device_returns_config_lines(t *testing.T) {
+	var device shared.Device
+	device = make(shared.Device)
+
+	device["type"] = "nic"
+	device["nictype"] = "macvlan"
+	device["parent"] = "eth0"
+
+	result, err := deviceToLxc(device)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]string{
+		{"lxc.network.type", "macvlan"},
+		{"lxc.network.macvlan.mode", "bridge"},
+		{"lxc.network.link", "eth0"},
+	}
+
+	if len(result) != len(expected) {
+		t.Fatalf("Expected '%s', got '%s' instead!", expected, result)
+	}
+
+	for i := range expected {
+		if result[i][0] != expected[i][0] || result[i][1] != expected[i][1] {
+			t.Errorf("Expected '%s', got '%s' instead!", expected[i], result[i])
+		}
+	}
+}
+
+func Test_physical_nic_device_needs_parent(t *testing.T) {
+	var device shared.Device
+	device = make(shared.Device)
+
+	device["type"] = "nic"
+	device["nictype"] = "physical"
+
+	if _, err := deviceToLxc(device); err == nil {
+		t.Error("physical nic without a parent was accepted.")
+	}
+
+	device["parent"] = "eth1"
+	result, err := deviceToLxc(device)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result[0][0] != "lxc.network.type" || result[0][1] != "phys" {
+		t.Errorf("Expected a phys network, got '%s' instead!", result)
+	}
+}
+
+func Test_sriov_free_vf(t *testing.T) {
+	device, err := ioutil.TempDir("", "lxd_sriov")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(device)
+
+	ioutil.WriteFile(path.Join(device, "sriov_numvfs"), []byte("0\n"), 0644)
+	ioutil.WriteFile(path.Join(device, "sriov_totalvfs"), []byte("4\n"), 0644)
+
+	if _, _, err := sriovFreeVF(device, nil); err == nil {
+		t.Error("A VF was allocated while none is enabled.")
+	}
+
+	numvfs, _ := ioutil.ReadFile(path.Join(device, "sriov_numvfs"))
+	if strings.TrimSpace(string(numvfs)) != "0" {
+		t.Errorf("The VFs were enabled: %s", numvfs)
+	}
+
+	ioutil.WriteFile(path.Join(device, "sriov_numvfs"), []byte("4\n"), 0644)
+
+	// virtfn1 is in a container, virtfn0 was just allocated
+	for vf, name := range map[string]string{"virtfn0": "vf0", "virtfn1": "", "virtfn2": "vf2", "virtfn10": "vf10"} {
+		os.MkdirAll(path.Join(device, vf, "net", name), 0755)
+	}
+
+	index, name, err := sriovFreeVF(device, []string{"vf0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if index != 2 || name != "vf2" {
+		t.Errorf("Expected VF 2 (vf2), got %d (%s) instead!", index, name)
+	}
+
+	if _, _, err := sriovFreeVF(device, []string{"vf0", "vf2", "vf10"}); err == nil {
+		t.Error("A VF was allocated twice.")
+	}
+}
+
+func Test_root_disk_device_has_no_mount_entry(t *testing.T) {
+	device := shared.Device{"type": "disk", "path": "/"}
+
+	result, err := deviceToLxc(device)
+	if err != nil || len(result) != 0 {
+		t.Errorf("Got %v (%v) for the root disk", result, err)
+	}
+
+	device["source"] = "/srv"
+	if deviceIsRootDisk(device) {
+		t.Errorf("A disk with a source was taken for the root disk")
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/lxc/lxd/shared"
+)
+
+var requestCancellersLock sync.Mutex
+var requestCancellers = map[*http.Request]*operationCanceller{}
+
+/*
+ * requestCancellerStart gives the request a canceller, which gets cancelled
+ * if the client disconnects while the request is being handled, so that
+ * the handlers doing a lot of work (unpacking an image, ...) can abort it.
+ * The returned function releases the canceller once the request is handled.
+ */
+func requestCancellerStart(w http.ResponseWriter, r *http.Request) func() {
+	canceller := &operationCanceller{}
+
+	requestCancellersLock.Lock()
+	requestCancellers[r] = canceller
+	requestCancellersLock.Unlock()
+
+	done := make(chan bool)
+	if notifier, ok := w.(http.CloseNotifier); ok {
+		closed := notifier.CloseNotify()
+		go func() {
+			select {
+			case <-closed:
+				shared.Debugf("Client went away, cancelling %s %s (request %s)",
+					r.Method, r.URL.RequestURI(), w.Header().Get("X-LXD-request-id"))
+				canceller.Cancel()
+			case <-done:
+			}
+		}()
+	}
+
+	return func() {
+		close(done)
+
+		requestCancellersLock.Lock()
+		delete(requestCancellers, r)
+		requestCancellersLock.Unlock()
+	}
+}
+
+// requestCanceller returns the canceller of a request being handled, or nil
+// (which never gets cancelled) for any other request.
+func requestCanceller(r *http.Request) *operationCanceller {
+	requestCancellersLock.Lock()
+	defer requestCancellersLock.Unlock()
+
+	return requestCancellers[r]
+}
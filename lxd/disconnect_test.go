@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type closeNotifyingRecorder struct {
+	*httptest.ResponseRecorder
+	closed chan bool
+}
+
+func (r *closeNotifyingRecorder) CloseNotify() <-chan bool {
+	return r.closed
+}
+
+func Test_request_canceller_is_cancelled_when_the_client_goes_away(t *testing.T) {
+	r, err := http.NewRequest("POST", "/1.0/images", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := &closeNotifyingRecorder{httptest.NewRecorder(), make(chan bool, 1)}
+	release := requestCancellerStart(w, r)
+
+	canceller := requestCanceller(r)
+	if canceller == nil || canceller.Cancelled() {
+		t.Fatal("The request should have a canceller which isn't cancelled")
+	}
+
+	cancelled := make(chan bool, 1)
+	canceller.OnCancel(func() { cancelled <- true })
+
+	w.closed <- true
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The request wasn't cancelled")
+	}
+
+	release()
+	if requestCanceller(r) != nil {
+		t.Error("The canceller wasn't released")
+	}
+}
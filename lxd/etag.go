@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// etagHash hashes the updatable part of a resource into its ETag.
+func etagHash(data interface{}) (string, error) {
+	content, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("\"%x\"", sha256.Sum256(content)), nil
+}
+
+/*
+ * etagCheck fails if the request comes with an If-Match header which
+ * doesn't match the ETag of the current state of the resource, meaning it
+ * changed since the client last fetched it.
+ */
+func etagCheck(r *http.Request, current interface{}) error {
+	match := r.Header.Get("If-Match")
+	if match == "" || match == "*" {
+		return nil
+	}
+
+	etag, err := etagHash(current)
+	if err != nil {
+		return err
+	}
+
+	if strings.Trim(etag, "\"") != strings.Trim(match, "\"") {
+		return fmt.Errorf("ETag doesn't match: %s vs %s", etag, match)
+	}
+
+	return nil
+}
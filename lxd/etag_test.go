@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func Test_etag_check(t *testing.T) {
+	current := map[string]string{"limits.cpus": "2"}
+
+	etag, err := etagHash(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := http.NewRequest("PUT", "/1.0/containers/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := etagCheck(r, current); err != nil {
+		t.Errorf("A request without If-Match should be accepted: %s", err)
+	}
+
+	r.Header.Set("If-Match", etag)
+	if err := etagCheck(r, current); err != nil {
+		t.Errorf("A matching ETag should be accepted: %s", err)
+	}
+
+	current["limits.cpus"] = "4"
+	if err := etagCheck(r, current); err == nil {
+		t.Error("A stale ETag should be refused")
+	}
+}
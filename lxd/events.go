@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/satori/go.uuid"
+	log "gopkg.in/inconshreveable/log15.v2"
+
+	"github.com/lxc/lxd/shared"
+)
+
+type eventsHandler struct {
+}
+
+func logContextMap(ctx []interface{}) map[string]string {
+	var key string
+	ctxMap := map[string]string{}
+
+	for _, entry := range ctx {
+		if key == "" {
+			key = entry.(string)
+		} else {
+			ctxMap[key] = fmt.Sprintf("%s", entry)
+			key = ""
+		}
+	}
+
+	return ctxMap
+}
+
+func (h eventsHandler) Log(r *log.Record) error {
+	eventSend("logging", fmt.Sprintf("/%s", shared.APIVersion), shared.Jmap{
+		"message": r.Msg,
+		"level":   r.Lvl.String(),
+		"context": logContextMap(r.Ctx)})
+	return nil
+}
+
+var eventsLock sync.Mutex
+var eventListeners map[string]*eventListener = make(map[string]*eventListener)
+
+type eventListener struct {
+	connection   *websocket.Conn
+	messageTypes []string
+	active       chan bool
+	id           string
+	msgLock      sync.Mutex
+}
+
+type eventsServe struct {
+	req *http.Request
+}
+
+func (r *eventsServe) Render(w http.ResponseWriter) error {
+	return eventsSocket(r.req, w)
+}
+
+func eventsSocket(r *http.Request, w http.ResponseWriter) error {
+	listener := eventListener{}
+
+	typeStr := r.FormValue("type")
+	if typeStr == "" {
+		typeStr = "logging,operations,lifecycle"
+	}
+
+	c, err := shared.WebsocketUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return err
+	}
+
+	listener.active = make(chan bool, 1)
+	listener.connection = c
+	listener.id = uuid.NewV4().String()
+	listener.messageTypes = strings.Split(typeStr, ",")
+
+	eventsLock.Lock()
+	eventListeners[listener.id] = &listener
+	eventsLock.Unlock()
+
+	shared.Debugf("New events listener: %s", listener.id)
+
+	<-listener.active
+
+	return nil
+}
+
+func eventsGet(d *Daemon, r *http.Request) Response {
+	return &eventsServe{r}
+}
+
+var eventsCmd = Command{name: "events", get: eventsGet}
+
+func eventSend(eventType string, resource string, eventMessage interface{}) error {
+	event := shared.Jmap{}
+	event["type"] = eventType
+	event["timestamp"] = time.Now()
+	event["resource"] = resource
+	event["metadata"] = eventMessage
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	eventsLock.Lock()
+	for _, listener := range eventListeners {
+		if !shared.StringInSlice(eventType, listener.messageTypes) {
+			continue
+		}
+
+		go func(listener *eventListener, body []byte) {
+			if listener == nil {
+				return
+			}
+
+			listener.msgLock.Lock()
+			err := listener.connection.WriteMessage(websocket.TextMessage, body)
+			listener.msgLock.Unlock()
+
+			if err != nil {
+				listener.connection.Close()
+				listener.active <- false
+
+				eventsLock.Lock()
+				delete(eventListeners, listener.id)
+				eventsLock.Unlock()
+
+				shared.Debugf("Disconnected events listener: %s", listener.id)
+			}
+		}(listener, body)
+	}
+	eventsLock.Unlock()
+
+	return nil
+}
+
+/*
+ * eventSendLifecycle notifies the "lifecycle" listeners that an action
+ * (created, started, stopped, ...) happened on a container or snapshot.
+ * The action is prefixed by the kind of resource, e.g. "container-started"
+ * or "snapshot-deleted".
+ */
+func eventSendLifecycle(c container, action string, context shared.Jmap) error {
+	kind := "container"
+	if c.IsSnapshot() {
+		kind = "snapshot"
+	}
+
+	if context == nil {
+		context = shared.Jmap{}
+	}
+
+	resource := fmt.Sprintf("/%s/containers/%s", shared.APIVersion,
+		strings.Replace(c.NameGet(), shared.SnapshotDelimiter, "/snapshots/", 1))
+
+	return eventSend("lifecycle", resource, shared.Jmap{
+		"action":  fmt.Sprintf("%s-%s", kind, action),
+		"context": context})
+}
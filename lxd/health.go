@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+)
+
+// ready tells whether the daemon finished initializing.
+func (d *Daemon) ready() bool {
+	select {
+	case <-d.readyChan:
+		return true
+	default:
+		return false
+	}
+}
+
+func (d *Daemon) health() shared.ServerHealth {
+	return shared.ServerHealth{
+		Ready:     d.ready(),
+		Database:  d.db != nil && d.db.Ping() == nil,
+		Storage:   d.IsMock || d.Storage != nil,
+		Listeners: len(d.Sockets),
+	}
+}
+
+/*
+ * healthGet is meant for load balancers and service managers: it's
+ * available to anyone and fails with a 503 unless the daemon is fully
+ * initialized and its database and storage are usable. Only trusted
+ * clients are told what's wrong, or the state of the daemon.
+ */
+func healthGet(d *Daemon, r *http.Request) Response {
+	health := d.health()
+	trusted := d.isTrustedClient(r)
+
+	problems := []string{}
+	if !health.Ready {
+		problems = append(problems, "initializing")
+	}
+
+	if d.shuttingDown() {
+		problems = append(problems, "shutting down")
+	}
+
+	if !health.Database {
+		problems = append(problems, "database unavailable")
+	}
+
+	if !health.Storage {
+		problems = append(problems, "storage unavailable")
+	}
+
+	if !d.IsMock && health.Listeners == 0 {
+		problems = append(problems, "not listening")
+	}
+
+	if len(problems) > 0 {
+		if !trusted {
+			return ServiceUnavailable(fmt.Errorf("LXD isn't ready"))
+		}
+
+		return ServiceUnavailable(fmt.Errorf("LXD isn't ready: %s", strings.Join(problems, ", ")))
+	}
+
+	if !trusted {
+		return EmptySyncResponse
+	}
+
+	return SyncResponse(true, health)
+}
+
+var healthCmd = Command{name: "health", untrustedGet: true, get: healthGet}
+
+/*
+ * readyGet blocks until the daemon finished initializing, or fails with a
+ * 503 once the number of seconds passed as ?timeout= elapsed. It's what
+ * "lxd waitready" relies on.
+ */
+func readyGet(d *Daemon, r *http.Request) Response {
+	var expired <-chan time.Time
+	if value := r.FormValue("timeout"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			return BadRequest(fmt.Errorf("Invalid timeout: %s", value))
+		}
+
+		expired = time.After(time.Duration(seconds) * time.Second)
+	}
+
+	select {
+	case <-d.readyChan:
+		return SyncResponse(true, d.health())
+	case <-expired:
+		return ServiceUnavailable(fmt.Errorf("LXD isn't ready: initializing"))
+	}
+}
+
+var readyCmd = Command{name: "ready", get: readyGet}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func Test_health_not_ready(t *testing.T) {
+	d := &Daemon{IsMock: true, readyChan: make(chan bool)}
+
+	// Over the unix socket
+	r := &http.Request{RemoteAddr: "@"}
+	resp, ok := healthGet(d, r).(*ErrorResponse)
+	if !ok || resp.code != 503 {
+		t.Fatalf("Daemon reported healthy while initializing")
+	}
+
+	close(d.readyChan)
+	if !d.ready() {
+		t.Errorf("Daemon not ready after initializing")
+	}
+
+	// Still no database
+	resp, ok = healthGet(d, r).(*ErrorResponse)
+	if !ok || resp.msg != "LXD isn't ready: database unavailable" {
+		t.Errorf("Wrong health: %v", resp)
+	}
+
+	// Untrusted clients aren't told why
+	r = &http.Request{RemoteAddr: "127.0.0.1:1234"}
+	resp, ok = healthGet(d, r).(*ErrorResponse)
+	if !ok || resp.msg != "LXD isn't ready" {
+		t.Errorf("Wrong health for an untrusted client: %v", resp)
+	}
+}
+
+func Test_ready_timeout(t *testing.T) {
+	d := &Daemon{IsMock: true, readyChan: make(chan bool)}
+
+	r, _ := http.NewRequest("GET", "/1.0/ready?timeout=0", nil)
+	resp, ok := readyGet(d, r).(*ErrorResponse)
+	if !ok || resp.code != 503 {
+		t.Fatalf("Daemon reported ready while initializing")
+	}
+
+	r, _ = http.NewRequest("GET", "/1.0/ready?timeout=-1", nil)
+	resp, ok = readyGet(d, r).(*ErrorResponse)
+	if !ok || resp.code != 400 {
+		t.Fatalf("Negative timeout wasn't rejected")
+	}
+
+	go close(d.readyChan)
+
+	r, _ = http.NewRequest("GET", "/1.0/ready", nil)
+	if _, ok := readyGet(d, r).(*syncResponse); !ok {
+		t.Errorf("Daemon not ready after initializing")
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"crypto/sha256"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -15,6 +16,7 @@ import (
 	"os/exec"
 	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/gorilla/mux"
 	"gopkg.in/yaml.v2"
@@ -60,7 +62,9 @@ func detectCompression(fname string) ([]string, string, error) {
 
 }
 
-func untar(tarball string, path string) error {
+// untar unpacks the tarball into path, unless the canceller gets
+// cancelled in the meantime.
+func untar(tarball string, path string, canceller *operationCanceller) error {
 	extractArgs, _, err := detectCompression(tarball)
 	if err != nil {
 		return err
@@ -70,18 +74,33 @@ func untar(tarball string, path string) error {
 	args = append(args, extractArgs...)
 	args = append(args, tarball)
 
-	output, err := exec.Command("tar", args...).CombinedOutput()
+	output := &bytes.Buffer{}
+	cmd := exec.Command("tar", args...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := canceller.OnCancel(func() { cmd.Process.Kill() })
+	err = cmd.Wait()
+	done()
+
+	if canceller.Cancelled() {
+		return errOperationCancelled
+	}
+
 	if err != nil {
 		shared.Debugf("Unpacking failed")
-		shared.Debugf(string(output))
+		shared.Debugf(output.String())
 		return err
 	}
 
 	return nil
 }
 
-func untarImage(imagefname string, destpath string) error {
-	err := untar(imagefname, destpath)
+func untarImage(imagefname string, destpath string, canceller *operationCanceller) error {
+	err := untar(imagefname, destpath, canceller)
 	if err != nil {
 		return err
 	}
@@ -93,7 +112,7 @@ func untarImage(imagefname string, destpath string) error {
 			return fmt.Errorf("Error creating rootfs directory")
 		}
 
-		err = untar(imagefname+".rootfs", rootfsPath)
+		err = untar(imagefname+".rootfs", rootfsPath, canceller)
 		if err != nil {
 			return err
 		}
@@ -113,6 +132,10 @@ type imagePostReq struct {
 	Public     bool              `json:"public"`
 	Source     map[string]string `json:"source"`
 	Properties map[string]string `json:"properties"`
+	Aliases    []string          `json:"aliases"`
+
+	// Whether to delete the images the aliases pointed to, once unused
+	DeleteReplaced bool `json:"delete_replaced"`
 }
 
 type imageMetadata struct {
@@ -128,7 +151,8 @@ type imageMetadata struct {
  * exports it as an image.
  */
 func imgPostContInfo(d *Daemon, r *http.Request, req imagePostReq,
-	builddir string) (info shared.ImageInfo, err error) {
+	builddir string, canceller *operationCanceller,
+	progress *operationProgress) (info shared.ImageInfo, err error) {
 
 	info.Properties = map[string]string{}
 	name := req.Source["name"]
@@ -179,13 +203,29 @@ func imgPostContInfo(d *Daemon, r *http.Request, req imagePostReq,
 		return info, err
 	}
 
-	if err := c.ExportToTar(snap, tarfile); err != nil {
-		tarfile.Close()
+	// Closing the file makes the export fail
+	done := canceller.OnCancel(func() { tarfile.Close() })
+	progress.Stage("Exporting container", 0)
+	err = c.ExportToTar(snap, progress.Writer(tarfile))
+	done()
+	tarfile.Close()
+	if err != nil {
 		return info, fmt.Errorf("imgPostContInfo: exportToTar failed: %s\n", err)
 	}
-	tarfile.Close()
 
-	_, err = exec.Command("gzip", tarfile.Name()).CombinedOutput()
+	if canceller.Cancelled() {
+		return info, errOperationCancelled
+	}
+
+	progress.Stage("Compressing image", 0)
+	cmd := exec.Command("gzip", tarfile.Name())
+	if err := cmd.Start(); err != nil {
+		return info, err
+	}
+
+	done = canceller.OnCancel(func() { cmd.Process.Kill() })
+	err = cmd.Wait()
+	done()
 	if err != nil {
 		return info, err
 	}
@@ -216,6 +256,58 @@ func imgPostContInfo(d *Daemon, r *http.Request, req imagePostReq,
 	return info, nil
 }
 
+/*
+ * imgPostContAsync publishes a container or snapshot as an image in the
+ * background, the operation's cancel hook interrupting the export and
+ * removing the build directory.
+ */
+func imgPostContAsync(d *Daemon, r *http.Request, req imagePostReq) Response {
+	canceller := &operationCanceller{}
+	progress := &operationProgress{}
+	logger := requestLog(r)
+	run := func() shared.OperationResult {
+		builddir, err := ioutil.TempDir(shared.VarPath("images"), "lxd_build_")
+		if err != nil {
+			return shared.OperationError(err)
+		}
+
+		defer func() {
+			if err := os.RemoveAll(builddir); err != nil {
+				logger.Error(
+					"Deleting temporary directory",
+					log.Ctx{"builddir": builddir, "err": err})
+			}
+		}()
+
+		info, err := imgPostContInfo(d, r, req, builddir, canceller, progress)
+		if err != nil {
+			return shared.OperationError(err)
+		}
+
+		if canceller.Cancelled() {
+			os.Remove(shared.VarPath("images", info.Fingerprint))
+			return shared.OperationError(errOperationCancelled)
+		}
+
+		progress.Stage("Importing image", 0)
+		metadata, err := imageBuildFromInfo(d, info, canceller)
+		if err != nil {
+			if canceller.Cancelled() {
+				os.Remove(shared.VarPath("images", info.Fingerprint))
+			}
+			return shared.OperationError(err)
+		}
+
+		if err := imageAliasesReplace(d, info.Fingerprint, req.Aliases, req.DeleteReplaced); err != nil {
+			return shared.OperationError(err)
+		}
+
+		return imageOperationResult(metadata)
+	}
+
+	return &asyncResponse{run: run, cancel: canceller.Cancel, progress: progress}
+}
+
 func imgPostRemoteInfo(d *Daemon, req imagePostReq) Response {
 	var err error
 	var hash string
@@ -239,30 +331,51 @@ func imgPostRemoteInfo(d *Daemon, req imagePostReq) Response {
 		return BadRequest(fmt.Errorf("must specify one of alias or fingerprint for init from image"))
 	}
 
-	err = d.ImageDownload(
-		req.Source["server"], hash, req.Source["secret"], false)
+	canceller := &operationCanceller{}
+	progress := &operationProgress{}
+	run := func() shared.OperationResult {
+		err := d.ImageDownload(
+			req.Source["server"], hash, req.Source["secret"], false, canceller, progress)
 
-	if err != nil {
-		return InternalError(err)
-	}
-
-	info, err := dbImageGet(d.db, hash, false, false)
-	if err != nil {
-		return InternalError(err)
-	}
+		if err != nil {
+			return shared.OperationError(err)
+		}
 
-	if req.Public {
-		err = dbImageSetPublic(d.db, info.Id, req.Public)
+		info, err := dbImageGet(d.db, hash, false, false)
 		if err != nil {
-			return InternalError(err)
+			return shared.OperationError(err)
+		}
+
+		if req.Public {
+			err = dbImageSetPublic(d.db, info.Id, req.Public)
+			if err != nil {
+				return shared.OperationError(err)
+			}
+		}
+
+		if err := imageAliasesReplace(d, info.Fingerprint, req.Aliases, req.DeleteReplaced); err != nil {
+			return shared.OperationError(err)
 		}
+
+		metadata := make(map[string]string)
+		metadata["fingerprint"] = info.Fingerprint
+		metadata["size"] = strconv.FormatInt(info.Size, 10)
+
+		return imageOperationResult(metadata)
 	}
 
-	metadata := make(map[string]string)
-	metadata["fingerprint"] = info.Fingerprint
-	metadata["size"] = strconv.FormatInt(info.Size, 10)
+	return &asyncResponse{run: run, cancel: canceller.Cancel, progress: progress, class: operationClassImageImport}
+}
 
-	return SyncResponse(true, metadata)
+// imageOperationResult returns the result of an operation which created
+// the image described by metadata.
+func imageOperationResult(metadata map[string]string) shared.OperationResult {
+	md, err := json.Marshal(metadata)
+	if err != nil {
+		return shared.OperationError(err)
+	}
+
+	return shared.OperationResult{Metadata: md}
 }
 
 func getImgPostInfo(d *Daemon, r *http.Request,
@@ -272,7 +385,6 @@ func getImgPostInfo(d *Daemon, r *http.Request,
 	logger := shared.Log.New(log.Ctx{"function": "getImgPostInfo"})
 
 	info.Public, _ = strconv.Atoi(r.Header.Get("X-LXD-public"))
-	propHeaders := r.Header[http.CanonicalHeaderKey("X-LXD-properties")]
 	ctype, ctypeParams, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
 	if err != nil {
 		ctype = "application/octet-stream"
@@ -442,13 +554,8 @@ func getImgPostInfo(d *Daemon, r *http.Request,
 	info.ExpiryDate = imageMeta.ExpiryDate
 
 	info.Properties = imageMeta.Properties
-	if len(propHeaders) > 0 {
-		for _, ph := range propHeaders {
-			p, _ := url.ParseQuery(ph)
-			for pkey, pval := range p {
-				info.Properties[pkey] = pval[0]
-			}
-		}
+	for key, value := range imagePropertiesHeader(r) {
+		info.Properties[key] = value
 	}
 
 	return info, nil
@@ -456,62 +563,53 @@ func getImgPostInfo(d *Daemon, r *http.Request,
 
 func dbInsertImage(d *Daemon, fp string, fname string, sz int64, public int,
 	arch int, creationDate int64, expiryDate int64, properties map[string]string) error {
-	tx, err := dbBegin(d.db)
-	if err != nil {
-		return err
-	}
-
-	stmt, err := tx.Prepare(`INSERT INTO images (fingerprint, filename, size, public, architecture, creation_date, expiry_date, upload_date) VALUES (?, ?, ?, ?, ?, ?, ?, strftime("%s"))`)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-	defer stmt.Close()
+	return dbTx(d.db, func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(`INSERT INTO images (fingerprint, filename, size, public, architecture, creation_date, expiry_date, upload_date) VALUES (?, ?, ?, ?, ?, ?, ?, strftime("%s"))`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
 
-	result, err := stmt.Exec(fp, fname, sz, public, arch, creationDate, expiryDate)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
+		result, err := stmt.Exec(fp, fname, sz, public, arch, creationDate, expiryDate)
+		if err != nil {
+			return err
+		}
 
-	if len(properties) > 0 {
+		if len(properties) == 0 {
+			return nil
+		}
 
 		id64, err := result.LastInsertId()
 		if err != nil {
-			tx.Rollback()
 			return err
 		}
 		id := int(id64)
 
 		pstmt, err := tx.Prepare(`INSERT INTO images_properties (image_id, type, key, value) VALUES (?, 0, ?, ?)`)
 		if err != nil {
-			tx.Rollback()
 			return err
 		}
 		defer pstmt.Close()
 
 		for k, v := range properties {
-
 			// we can assume, that there is just one
 			// value per key
 			_, err = pstmt.Exec(id, k, v)
 			if err != nil {
-				tx.Rollback()
 				return err
 			}
 		}
 
-	}
-
-	if err := txCommit(tx); err != nil {
-		return err
-	}
-
-	return nil
+		return nil
+	})
 }
 
-func imageBuildFromInfo(d *Daemon, info shared.ImageInfo) (metadata map[string]string, err error) {
-	err = d.Storage.ImageCreate(info.Fingerprint)
+/*
+ * imageBuildFromInfo unpacks the image for the storage backend and records
+ * it in the database, unless the canceller gets cancelled first.
+ */
+func imageBuildFromInfo(d *Daemon, info shared.ImageInfo, canceller *operationCanceller) (metadata map[string]string, err error) {
+	err = d.Storage.ImageCreate(info.Fingerprint, canceller)
 	if err != nil {
 		return metadata, err
 	}
@@ -581,11 +679,16 @@ func imagesPost(d *Daemon, r *http.Request) Response {
 
 	if err == nil {
 		/* Processing image request */
+		if err := imageAliasesValidate(req.Aliases); err != nil {
+			return BadRequest(err)
+		}
+
+		if err := imagePropertiesValidate(req.Properties); err != nil {
+			return BadRequest(err)
+		}
+
 		if req.Source["type"] == "container" || req.Source["type"] == "snapshot" {
-			info, err = imgPostContInfo(d, r, req, builddir)
-			if err != nil {
-				return SmartError(err)
-			}
+			return imgPostContAsync(d, r, req)
 		} else if req.Source["type"] == "image" {
 			return imgPostRemoteInfo(d, req)
 		} else {
@@ -593,6 +696,14 @@ func imagesPost(d *Daemon, r *http.Request) Response {
 		}
 	} else {
 		/* Processing image upload */
+		if err := imageAliasesValidate(imageAliasesHeader(r)); err != nil {
+			return BadRequest(err)
+		}
+
+		if err := imagePropertiesValidate(imagePropertiesHeader(r)); err != nil {
+			return BadRequest(err)
+		}
+
 		info, err = getImgPostInfo(d, r, builddir, post)
 		if err != nil {
 			return SmartError(err)
@@ -601,20 +712,172 @@ func imagesPost(d *Daemon, r *http.Request) Response {
 
 	defer func() {
 		if err := os.RemoveAll(builddir); err != nil {
-			shared.Log.Error(
+			requestLog(r).Error(
 				"Deleting temporary directory",
 				log.Ctx{"builddir": builddir, "err": err})
 		}
 	}()
 
-	metadata, err := imageBuildFromInfo(d, info)
+	/*
+	 * Unpacking the image can take a while, there's no point in going
+	 * on if the client which uploaded it is gone.
+	 */
+	canceller := requestCanceller(r)
+	metadata, err := imageBuildFromInfo(d, info, canceller)
 	if err != nil {
+		if canceller.Cancelled() {
+			os.Remove(shared.VarPath("images", info.Fingerprint))
+			os.Remove(shared.VarPath("images", info.Fingerprint+".rootfs"))
+		}
+		return SmartError(err)
+	}
+
+	if err := imageAliasesReplace(d, info.Fingerprint, imageAliasesHeader(r), r.Header.Get("X-LXD-delete-replaced") == "true"); err != nil {
 		return SmartError(err)
 	}
 
 	return SyncResponse(true, metadata)
 }
 
+// imageAliasesHeader returns the aliases of the X-LXD-aliases headers of an
+// upload, comma separated lists.
+func imageAliasesHeader(r *http.Request) []string {
+	aliases := []string{}
+	for _, value := range r.Header[http.CanonicalHeaderKey("X-LXD-aliases")] {
+		for _, alias := range strings.Split(value, ",") {
+			if alias = strings.TrimSpace(alias); alias != "" {
+				aliases = append(aliases, alias)
+			}
+		}
+	}
+
+	return aliases
+}
+
+// imagePropertiesHeader returns the properties of the X-LXD-properties
+// headers of an upload, URL encoded.
+func imagePropertiesHeader(r *http.Request) map[string]string {
+	properties := map[string]string{}
+	for _, value := range r.Header[http.CanonicalHeaderKey("X-LXD-properties")] {
+		query, _ := url.ParseQuery(value)
+		for key, values := range query {
+			properties[key] = values[0]
+		}
+	}
+
+	return properties
+}
+
+/*
+ * imageAliasValidName checks that name can be used for an alias. Aliases
+ * may contain slashes, but not the ':' separating them from the remote on
+ * the client side nor the ',' separating those of an upload, nor spaces.
+ */
+func imageAliasValidName(name string) error {
+	if name == "" {
+		return fmt.Errorf("Alias name can't be empty")
+	}
+
+	if len(name) > 255 {
+		return fmt.Errorf("Alias name too long, %d characters where 255 are allowed: '%s'", len(name), name)
+	}
+
+	for _, r := range name {
+		if r == ':' || r == ',' || unicode.IsSpace(r) || unicode.IsControl(r) {
+			return fmt.Errorf("Invalid character %q in alias name '%s'", r, name)
+		}
+	}
+
+	if name == "." || name == ".." || strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") {
+		return fmt.Errorf("Invalid alias name: '%s'", name)
+	}
+
+	return nil
+}
+
+// imageAliasesValidate checks the names of the aliases given to an image.
+func imageAliasesValidate(aliases []string) error {
+	for _, alias := range aliases {
+		if err := imageAliasValidName(alias); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * imagePropertyValidKey checks that key can be used for an image property:
+ * letters, digits, '.', '-' and '_', 255 characters at most.
+ */
+func imagePropertyValidKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("Image property key can't be empty")
+	}
+
+	if len(key) > 255 {
+		return fmt.Errorf("Image property key too long, %d characters where 255 are allowed: '%s'", len(key), key)
+	}
+
+	for _, r := range key {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '.' && r != '-' && r != '_' {
+			return fmt.Errorf("Invalid character %q in image property key '%s', only letters, digits, '.', '-' and '_' are allowed", r, key)
+		}
+	}
+
+	return nil
+}
+
+// imagePropertiesValidate checks the keys of the properties given to an image.
+func imagePropertiesValidate(properties map[string]string) error {
+	for key := range properties {
+		if err := imagePropertyValidKey(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * imageAliasesReplace points aliases to a new image, all at once, creating
+ * those which don't exist. If asked to, the images they pointed to are then
+ * deleted if they're left unused, without aliases nor containers created
+ * from them, so that rolling an image out behind its aliases doesn't leave
+ * the old one behind.
+ */
+func imageAliasesReplace(d *Daemon, fingerprint string, aliases []string, deleteReplaced bool) error {
+	if len(aliases) == 0 {
+		return nil
+	}
+
+	oldImages, err := dbImageAliasesReplace(d.db, fingerprint, aliases)
+	if err != nil {
+		return err
+	}
+
+	if !deleteReplaced {
+		return nil
+	}
+
+	for _, old := range oldImages {
+		if old == fingerprint {
+			continue
+		}
+
+		used, err := dbImageIsUsed(d.db, old)
+		if err != nil || used {
+			continue
+		}
+
+		if err := doDeleteImage(d, old); err != nil {
+			shared.Log.Warn("Failed to delete the replaced image", log.Ctx{"image": old, "err": err})
+		}
+	}
+
+	return nil
+}
+
 func getImageMetadata(fname string) (*imageMetadata, error) {
 	metadataName := "metadata.yaml"
 
@@ -725,6 +988,14 @@ func doDeleteImage(d *Daemon, fingerprint string) error {
 func imageDelete(d *Daemon, r *http.Request) Response {
 	fingerprint := mux.Vars(r)["fingerprint"]
 
+	// Containers waiting on a download would be left without their image
+	d.imagesDownloadingLock.RLock()
+	_, downloading := d.imagesDownloading[fingerprint]
+	d.imagesDownloadingLock.RUnlock()
+	if downloading {
+		return InUse(fmt.Errorf("The image is being downloaded"))
+	}
+
 	if err := doDeleteImage(d, fingerprint); err != nil {
 		return SmartError(err)
 	}
@@ -848,7 +1119,13 @@ func imageGet(d *Daemon, r *http.Request) Response {
 		return response
 	}
 
-	return SyncResponse(true, info)
+	return SyncResponseETag(true, info, imageETag(info))
+}
+
+// imageETag returns what an image's ETag is computed from: its properties
+// and whether it's public.
+func imageETag(info shared.ImageInfo) interface{} {
+	return []interface{}{info.Public, info.Properties}
 }
 
 type imagePutReq struct {
@@ -863,40 +1140,94 @@ func imagePut(d *Daemon, r *http.Request) Response {
 		return BadRequest(err)
 	}
 
+	if err := imagePropertiesValidate(imageRaw.Properties); err != nil {
+		return BadRequest(err)
+	}
+
+	patchLock.Lock()
+	defer patchLock.Unlock()
+
 	imgInfo, err := dbImageGet(d.db, fingerprint, false, false)
 	if err != nil {
 		return SmartError(err)
 	}
 
-	tx, err := dbBegin(d.db)
-	if err != nil {
-		return InternalError(err)
+	info, response := doImageGet(d, imgInfo.Fingerprint, false)
+	if response != nil {
+		return response
 	}
 
-	_, err = tx.Exec(`DELETE FROM images_properties WHERE image_id=?`, imgInfo.Id)
+	if err := etagCheck(r, imageETag(info)); err != nil {
+		return PreconditionFailed(err)
+	}
 
-	stmt, err := tx.Prepare(`INSERT INTO images_properties (image_id, type, key, value) VALUES (?, ?, ?, ?)`)
-	if err != nil {
-		tx.Rollback()
-		return InternalError(err)
+	return doImageUpdate(d, imgInfo, imageRaw)
+}
+
+/*
+ * imagePatch merges the properties it's given into the image's, a
+ * property set to "" being removed.
+ */
+func imagePatch(d *Daemon, r *http.Request) Response {
+	fingerprint := mux.Vars(r)["fingerprint"]
+
+	imageRaw := imagePutReq{}
+	if err := json.NewDecoder(r.Body).Decode(&imageRaw); err != nil {
+		return BadRequest(err)
 	}
 
+	// Properties which are removed can have been set before the checks
 	for key, value := range imageRaw.Properties {
-		_, err = stmt.Exec(imgInfo.Id, 0, key, value)
-		if err != nil {
-			tx.Rollback()
-			return InternalError(err)
+		if err := imagePropertyValidKey(key); err != nil && value != "" {
+			return BadRequest(err)
 		}
 	}
 
-	if err := txCommit(tx); err != nil {
+	patchLock.Lock()
+	defer patchLock.Unlock()
+
+	imgInfo, err := dbImageGet(d.db, fingerprint, false, false)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	info, response := doImageGet(d, imgInfo.Fingerprint, false)
+	if response != nil {
+		return response
+	}
+
+	imageRaw.Properties = patchConfig(info.Properties, imageRaw.Properties)
+
+	return doImageUpdate(d, imgInfo, imageRaw)
+}
+
+func doImageUpdate(d *Daemon, imgInfo *shared.ImageBaseInfo, imageRaw imagePutReq) Response {
+	err := dbTx(d.db, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM images_properties WHERE image_id=?`, imgInfo.Id)
+
+		stmt, err := tx.Prepare(`INSERT INTO images_properties (image_id, type, key, value) VALUES (?, ?, ?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for key, value := range imageRaw.Properties {
+			_, err = stmt.Exec(imgInfo.Id, 0, key, value)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
 		return InternalError(err)
 	}
 
 	return EmptySyncResponse
 }
 
-var imageCmd = Command{name: "images/{fingerprint}", untrustedGet: true, get: imageGet, put: imagePut, delete: imageDelete}
+var imageCmd = Command{name: "images/{fingerprint}", untrustedGet: true, get: imageGet, put: imagePut, patch: imagePatch, delete: imageDelete}
 
 type aliasPostReq struct {
 	Name        string `json:"name"`
@@ -913,6 +1244,10 @@ func aliasesPost(d *Daemon, r *http.Request) Response {
 	if req.Name == "" || req.Target == "" {
 		return BadRequest(fmt.Errorf("name and target are required"))
 	}
+
+	if err := imageAliasValidName(req.Name); err != nil {
+		return BadRequest(err)
+	}
 	if req.Description == "" {
 		req.Description = req.Name
 	}
@@ -948,7 +1283,7 @@ func aliasesGet(d *Daemon, r *http.Request) Response {
 		return BadRequest(err)
 	}
 	responseStr := []string{}
-	responseMap := []shared.ImageAlias{}
+	responseMap := []shared.ImageAliasInfo{}
 	for _, res := range results {
 		name = res[0].(string)
 		if !recursion {
@@ -982,8 +1317,13 @@ func aliasGet(d *Daemon, r *http.Request) Response {
 	return SyncResponse(true, alias)
 }
 
-func doAliasGet(d *Daemon, name string, isTrustedClient bool) (shared.ImageAlias, error) {
-	q := `SELECT images.fingerprint, images_aliases.description
+/*
+ * doAliasGet returns an alias along with its target, which is auto-updating
+ * if it's the image cached for an alias of a remote server (images_source).
+ */
+func doAliasGet(d *Daemon, name string, isTrustedClient bool) (shared.ImageAliasInfo, error) {
+	q := `SELECT images.fingerprint, images_aliases.description,
+			 EXISTS (SELECT 1 FROM images_source WHERE images_source.image_id=images.id)
 			 FROM images_aliases
 			 INNER JOIN images
 			 ON images_aliases.image_id=images.id
@@ -993,14 +1333,15 @@ func doAliasGet(d *Daemon, name string, isTrustedClient bool) (shared.ImageAlias
 	}
 
 	var fingerprint, description string
+	var autoUpdate bool
 	arg1 := []interface{}{name}
-	arg2 := []interface{}{&fingerprint, &description}
+	arg2 := []interface{}{&fingerprint, &description, &autoUpdate}
 	err := dbQueryRowScan(d.db, q, arg1, arg2)
 	if err != nil {
-		return shared.ImageAlias{}, err
+		return shared.ImageAliasInfo{}, err
 	}
 
-	return shared.ImageAlias{Name: fingerprint, Description: description}, nil
+	return shared.ImageAliasInfo{Name: name, Target: fingerprint, Description: description, AutoUpdate: autoUpdate}, nil
 }
 
 func aliasDelete(d *Daemon, r *http.Request) Response {
@@ -1039,13 +1380,24 @@ func imageExport(d *Daemon, r *http.Request) Response {
 	if shared.PathExists(rootfsPath) {
 		files := make([]fileResponseEntry, 2)
 
+		// Split images are always named after their fingerprint
+		_, metaExt, err := detectCompression(imagePath)
+		if err != nil {
+			metaExt = ""
+		}
+
+		_, rootfsExt, err := detectCompression(rootfsPath)
+		if err != nil {
+			rootfsExt = ""
+		}
+
 		files[0].identifier = "metadata"
 		files[0].path = imagePath
-		files[0].filename = "meta-" + filename
+		files[0].filename = "meta-" + imgInfo.Fingerprint + metaExt
 
 		files[1].identifier = "rootfs"
 		files[1].path = rootfsPath
-		files[1].filename = filename
+		files[1].filename = imgInfo.Fingerprint + rootfsExt
 
 		return FileResponse(r, files, nil, false)
 	}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_image_alias_valid_name(t *testing.T) {
+	for _, name := range []string{"ubuntu", "ubuntu/16.04/amd64", "foo-image_2"} {
+		if err := imageAliasValidName(name); err != nil {
+			t.Errorf("%s was rejected: %s", name, err)
+		}
+	}
+
+	for _, name := range []string{"", ".", "/foo", "foo/", "remote:foo", "foo,bar", "foo bar", "foo\n", strings.Repeat("a", 256)} {
+		if err := imageAliasValidName(name); err == nil {
+			t.Errorf("%s was accepted", name)
+		}
+	}
+}
+
+func Test_image_property_valid_key(t *testing.T) {
+	for _, key := range []string{"os", "release", "user.build-id", "prop_1"} {
+		if err := imagePropertyValidKey(key); err != nil {
+			t.Errorf("%s was rejected: %s", key, err)
+		}
+	}
+
+	for _, key := range []string{"", "a b", "a=b", "a/b", "clé", strings.Repeat("a", 256)} {
+		if err := imagePropertyValidKey(key); err == nil {
+			t.Errorf("%s was accepted", key)
+		}
+	}
+}
+
+func Test_alias_get(t *testing.T) {
+	db := createTestDb(t)
+	defer db.Close()
+	d := &Daemon{IsMock: true, db: db}
+
+	alias, err := doAliasGet(d, "somealias", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if alias.Name != "somealias" || alias.Target != "fingerprint" || alias.Description != "some description" || alias.AutoUpdate {
+		t.Errorf("Wrong alias: %+v", alias)
+	}
+
+	if err := dbImageSourceSet(db, "fingerprint", "https://images", "ubuntu/trusty"); err != nil {
+		t.Fatal(err)
+	}
+
+	if alias, err := doAliasGet(d, "somealias", true); err != nil || !alias.AutoUpdate {
+		t.Errorf("The target of the alias isn't auto-updating: %+v (%v)", alias, err)
+	}
+}
@@ -17,15 +17,21 @@ import (
 	"github.com/lxc/lxd/shared/gnuflag"
 )
 
+var concurrency = gnuflag.Int("concurrency", 0, "With batch, number of containers handled in parallel (defaults to the number of CPUs).")
 var cpuProfile = gnuflag.String("cpuprofile", "", "Enable cpu profiling into the specified file.")
 var debug = gnuflag.Bool("debug", false, "Enables debug mode.")
 var group = gnuflag.String("group", "", "Group which owns the shared socket.")
 var help = gnuflag.Bool("help", false, "Print this help message.")
 var logfile = gnuflag.String("logfile", "", "Logfile to log to (e.g., /var/log/lxd/lxd.log).")
 var memProfile = gnuflag.String("memprofile", "", "Enable memory profiling into the specified file.")
+var preseedFlag = gnuflag.Bool("preseed", false, "With init, read the configuration to apply as YAML from stdin.")
 var printGoroutines = gnuflag.Int("print-goroutines-every", -1, "For debugging, print a complete stack trace every n seconds")
-var syslogFlag = gnuflag.Bool("syslog", false, "Enables syslog logging.")
+var socketFlag = gnuflag.String("socket", "", "Path of the control socket (defaults to $LXD_SOCKET, or unix.socket in LXD's directory).")
+var socketMode = gnuflag.String("socket-mode", "0660", "Permissions of the control socket.")
+var statefulFlag = gnuflag.Bool("stateful", false, "With shutdown, checkpoint the running containers rather than stopping them, to restore them on the next start.")
+var syslogFlag = gnuflag.Bool("syslog", false, "Enables syslog logging (picked up by journald on systemd systems).")
 var verbose = gnuflag.Bool("verbose", false, "Enables verbose mode.")
+var timeoutFlag = gnuflag.Int("timeout", 0, "With shutdown, number of seconds each container is given to shut down cleanly (defaults to 30). With waitready, number of seconds to wait for (defaults to no limit).")
 var version = gnuflag.Bool("version", false, "Print LXD's version number and exit.")
 
 func init() {
@@ -52,9 +58,23 @@ func run() error {
 
 		fmt.Printf("\nCommands:\n")
 		fmt.Printf("    shutdown\n")
-		fmt.Printf("        Perform a clean shutdown of LXD and all running containers\n")
+		fmt.Printf("        Perform a clean shutdown of LXD and all running containers (see --stateful and --timeout)\n")
+		fmt.Printf("    waitready\n")
+		fmt.Printf("        Wait for LXD to be up and done initializing its database and storage (see --timeout)\n")
 		fmt.Printf("    activateifneeded\n")
 		fmt.Printf("        Check if LXD should be started (at boot) and if so, spawn it through socket activation\n")
+		fmt.Printf("    database dump\n")
+		fmt.Printf("        Write the content of the database as SQL to stdout, for backups\n")
+		fmt.Printf("    database check\n")
+		fmt.Printf("        Check the consistency of the database\n")
+		fmt.Printf("    batch <create|launch> <image> <count> [<prefix>]\n")
+		fmt.Printf("        Create (and start) a batch of containers in parallel and report how long it took\n")
+		fmt.Printf("    batch <start|stop> [<prefix>]\n")
+		fmt.Printf("        Start or stop a batch of containers in parallel and report how long it took\n")
+		fmt.Printf("    batch delete <prefix>\n")
+		fmt.Printf("        Delete a batch of containers in parallel and report how long it took\n")
+		fmt.Printf("    init --preseed\n")
+		fmt.Printf("        Configure LXD (storage, network address, trust password) from a YAML document on stdin\n")
 
 		fmt.Printf("\nInternal commands (don't call directly):\n")
 		fmt.Printf("    forkgetfile\n")
@@ -87,12 +107,18 @@ func run() error {
 		syslog = "lxd"
 	}
 
-	err := shared.SetLogger(syslog, *logfile, *verbose, *debug)
+	err := shared.SetLogger(syslog, *logfile, *verbose, *debug, eventsHandler{})
 	if err != nil {
 		fmt.Printf("%s", err)
 		return nil
 	}
 
+	// The sub-commands talking to the daemon (shutdown, waitready, ...)
+	// find its socket through $LXD_SOCKET, which --socket overrides
+	if *socketFlag != "" {
+		os.Setenv("LXD_SOCKET", *socketFlag)
+	}
+
 	// Process sub-commands
 	if len(os.Args) > 1 {
 		// "forkputfile" and "forkgetfile" are handled specially in copyfile.go
@@ -103,8 +129,16 @@ func run() error {
 			return migration.MigrateContainer(os.Args[1:])
 		case "shutdown":
 			return cleanShutdown()
+		case "waitready":
+			return waitReady()
 		case "activateifneeded":
 			return activateIfNeeded()
+		case "init":
+			return cmdInit()
+		case "database":
+			return cmdDatabase(os.Args[1:])
+		case "batch":
+			return cmdBatch(gnuflag.Args())
 		}
 	}
 
@@ -172,7 +206,8 @@ func run() error {
 		shared.Log.Info(
 			fmt.Sprintf("Received '%s signal', shutting down containers.", sig))
 
-		containersShutdown(d)
+		d.Drain()
+		containersShutdown(d, false, containersShutdownTimeout)
 
 		ret = d.Stop()
 		wg.Done()
@@ -183,9 +218,15 @@ func run() error {
 		signal.Notify(ch, syscall.SIGINT)
 		signal.Notify(ch, syscall.SIGQUIT)
 		signal.Notify(ch, syscall.SIGTERM)
-		sig := <-ch
 
-		shared.Log.Info(fmt.Sprintf("Received '%s signal', exiting.\n", sig))
+		select {
+		case sig := <-ch:
+			shared.Log.Info(fmt.Sprintf("Received '%s signal', exiting.\n", sig))
+		case <-d.exitChan:
+			shared.Log.Info("Asked to exit through the API, exiting.")
+		}
+
+		d.Drain()
 		ret = d.Stop()
 		wg.Done()
 	}()
@@ -205,14 +246,27 @@ func cleanShutdown() error {
 		return err
 	}
 
-	pid := serverStatus.Environment.ServerPid
-	if pid < 1 {
-		return fmt.Errorf("Invalid server PID: %d", pid)
-	}
+	if c.HasExtension("shutdown") {
+		resp, err := c.Shutdown(*statefulFlag, *timeoutFlag)
+		if err != nil {
+			return err
+		}
 
-	err = syscall.Kill(pid, syscall.SIGPWR)
-	if err != nil {
-		return err
+		// The daemon may be gone before the answer gets through
+		op, err := c.WaitFor(resp.Operation)
+		if err == nil && op.StatusCode != shared.Success {
+			return op.GetError()
+		}
+	} else {
+		pid := serverStatus.Environment.ServerPid
+		if pid < 1 {
+			return fmt.Errorf("Invalid server PID: %d", pid)
+		}
+
+		err = syscall.Kill(pid, syscall.SIGPWR)
+		if err != nil {
+			return err
+		}
 	}
 
 	// This should be replaced with a connection to /1.0/events once the
@@ -232,6 +286,46 @@ func cleanShutdown() error {
 	return fmt.Errorf("LXD still running after 60s timeout.")
 }
 
+/*
+ * waitReady implements "lxd waitready": it waits for the daemon's socket
+ * to answer and then for the daemon to be done initializing, giving up
+ * after --timeout seconds if set.
+ */
+func waitReady() error {
+	var deadline time.Time
+	if *timeoutFlag > 0 {
+		deadline = time.Now().Add(time.Duration(*timeoutFlag) * time.Second)
+	}
+
+	for {
+		c, err := lxd.NewClient(&lxd.DefaultConfig, "local")
+		if err == nil {
+			err = c.Finger()
+		}
+
+		if err == nil {
+			// Daemons predating the readiness endpoint only answer
+			// once initialized
+			if !c.HasExtension("waitready") {
+				return nil
+			}
+
+			remaining := 0
+			if !deadline.IsZero() {
+				remaining = int(deadline.Sub(time.Now()).Seconds()) + 1
+			}
+
+			return c.WaitReady(remaining)
+		}
+
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return fmt.Errorf("LXD still not running after %ds timeout.", *timeoutFlag)
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
 func activateIfNeeded() error {
 	// Don't start a full daemon, we just need DB access
 	d := &Daemon{
@@ -258,7 +352,7 @@ func activateIfNeeded() error {
 	}
 
 	// Look for auto-started or previously started containers
-	containers, err := doContainersGet(d, true)
+	containers, err := doContainersGet(d, true, nil)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+)
+
+/*
+ * apiMetrics keeps track of the number of API requests the daemon handled
+ * and of the time it spent on them, per endpoint, method and status code.
+ * Its zero value is ready to use.
+ */
+type apiMetrics struct {
+	lock     sync.Mutex
+	requests map[apiMetricsKey]*apiMetricsValue
+}
+
+type apiMetricsKey struct {
+	endpoint string
+	method   string
+	code     int
+}
+
+type apiMetricsValue struct {
+	count   int64
+	seconds float64
+}
+
+// record accounts for a request which took duration to handle.
+func (m *apiMetrics) record(endpoint string, method string, code int, duration time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.requests == nil {
+		m.requests = map[apiMetricsKey]*apiMetricsValue{}
+	}
+
+	key := apiMetricsKey{endpoint: endpoint, method: method, code: code}
+	value, ok := m.requests[key]
+	if !ok {
+		value = &apiMetricsValue{}
+		m.requests[key] = value
+	}
+
+	value.count++
+	value.seconds += duration.Seconds()
+}
+
+// samples returns the request counts and the time spent handling them.
+func (m *apiMetrics) samples() ([]metricsSample, []metricsSample) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	keys := []apiMetricsKey{}
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+
+	sort.Sort(apiMetricsKeys(keys))
+
+	counts := []metricsSample{}
+	durations := []metricsSample{}
+	for _, key := range keys {
+		value := m.requests[key]
+		labels := []metricsLabel{
+			{"endpoint", key.endpoint},
+			{"method", key.method},
+			{"code", strconv.Itoa(key.code)},
+		}
+
+		counts = append(counts, metricsSample{labels: labels, value: float64(value.count)})
+		durations = append(durations,
+			metricsSample{suffix: "_sum", labels: labels, value: value.seconds},
+			metricsSample{suffix: "_count", labels: labels, value: float64(value.count)})
+	}
+
+	return counts, durations
+}
+
+type apiMetricsKeys []apiMetricsKey
+
+func (s apiMetricsKeys) Len() int {
+	return len(s)
+}
+
+func (s apiMetricsKeys) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s apiMetricsKeys) Less(i, j int) bool {
+	if s[i].endpoint != s[j].endpoint {
+		return s[i].endpoint < s[j].endpoint
+	}
+
+	if s[i].method != s[j].method {
+		return s[i].method < s[j].method
+	}
+
+	return s[i].code < s[j].code
+}
+
+type metricsLabel struct {
+	name  string
+	value string
+}
+
+// metricsSample is a single value of a metric, suffix being appended to the
+// metric name (as in _sum and _count for summaries).
+type metricsSample struct {
+	suffix string
+	labels []metricsLabel
+	value  float64
+}
+
+var metricsLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricsWrite writes a metric in the Prometheus text exposition format.
+func metricsWrite(w io.Writer, name string, kind string, help string, samples []metricsSample) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+
+	for _, sample := range samples {
+		labels := []string{}
+		for _, label := range sample.labels {
+			labels = append(labels, fmt.Sprintf("%s=\"%s\"", label.name, metricsLabelEscaper.Replace(label.value)))
+		}
+
+		value := strconv.FormatFloat(sample.value, 'g', -1, 64)
+		if len(labels) == 0 {
+			fmt.Fprintf(w, "%s%s %s\n", name, sample.suffix, value)
+		} else {
+			fmt.Fprintf(w, "%s%s{%s} %s\n", name, sample.suffix, strings.Join(labels, ","), value)
+		}
+	}
+}
+
+// metricsOperations returns the number of operations in each status.
+func metricsOperations() []metricsSample {
+	statuses := []shared.StatusCode{shared.Pending, shared.Running, shared.Cancelling, shared.Success, shared.Failure, shared.Cancelled}
+	counts := map[shared.StatusCode]int{}
+
+	lock.Lock()
+	for _, op := range operations {
+		counts[op.StatusCode]++
+	}
+	lock.Unlock()
+
+	samples := []metricsSample{}
+	for _, status := range statuses {
+		samples = append(samples, metricsSample{
+			labels: []metricsLabel{{"status", status.String()}},
+			value:  float64(counts[status]),
+		})
+	}
+
+	return samples
+}
+
+// metricsContainers writes the state and resource usage of every container.
+func metricsContainers(d *Daemon, w io.Writer) error {
+	names, err := dbContainersList(d.db, cTypeRegular)
+	if err != nil {
+		return err
+	}
+
+	running := []metricsSample{}
+	memory := []metricsSample{}
+	cpu := []metricsSample{}
+	for _, name := range names {
+		c, err := containerLXDLoad(d, name)
+		if err != nil {
+			continue
+		}
+
+		state, err := c.RenderState()
+		if err != nil {
+			continue
+		}
+
+		labels := []metricsLabel{{"name", name}}
+		if state.Status.StatusCode != shared.Running {
+			running = append(running, metricsSample{labels: labels, value: 0})
+			continue
+		}
+
+		running = append(running, metricsSample{labels: labels, value: 1})
+		if state.Status.MemoryUsage >= 0 {
+			memory = append(memory, metricsSample{labels: labels, value: float64(state.Status.MemoryUsage)})
+		}
+
+		if state.Status.CPUUsage >= 0 {
+			cpu = append(cpu, metricsSample{labels: labels, value: float64(state.Status.CPUUsage) / float64(time.Second)})
+		}
+	}
+
+	metricsWrite(w, "lxd_container_running", "gauge", "Whether the container is running.", running)
+	metricsWrite(w, "lxd_container_memory_usage_bytes", "gauge", "Memory used by the running container.", memory)
+	metricsWrite(w, "lxd_container_cpu_usage_seconds_total", "counter", "CPU time consumed by the running container.", cpu)
+
+	return nil
+}
+
+type metricsResponse struct {
+	buf *bytes.Buffer
+}
+
+func (r *metricsResponse) Render(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, err := w.Write(r.buf.Bytes())
+	return err
+}
+
+func metricsGet(d *Daemon, r *http.Request) Response {
+	enabled, err := d.ConfigValueGet("core.metrics")
+	if err != nil {
+		return InternalError(err)
+	}
+
+	if enabled != "true" {
+		return NotFound
+	}
+
+	buf := &bytes.Buffer{}
+
+	metricsWrite(buf, "lxd_operations", "gauge", "Number of operations known to the daemon, by status.", metricsOperations())
+
+	counts, durations := d.apiMetrics.samples()
+	metricsWrite(buf, "lxd_api_requests_total", "counter", "Number of API requests handled.", counts)
+	metricsWrite(buf, "lxd_api_request_duration_seconds", "summary", "Time spent handling API requests.", durations)
+
+	images, size, err := dbImagesCacheSize(d.db)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	metricsWrite(buf, "lxd_images_cached", "gauge", "Number of images cached from remotes.", []metricsSample{{value: float64(images)}})
+	metricsWrite(buf, "lxd_images_cached_bytes", "gauge", "Size of the images cached from remotes.", []metricsSample{{value: float64(size)}})
+	metricsWrite(buf, "lxd_goroutines", "gauge", "Number of goroutines of the daemon.", []metricsSample{{value: float64(runtime.NumGoroutine())}})
+
+	if err := metricsContainers(d, buf); err != nil {
+		return InternalError(err)
+	}
+
+	return &metricsResponse{buf}
+}
+
+var metricsCmd = Command{name: "metrics", get: metricsGet}
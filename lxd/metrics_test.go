@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func Test_metricsWrite(t *testing.T) {
+	buf := &bytes.Buffer{}
+	metricsWrite(buf, "lxd_test", "gauge", "A test metric.", []metricsSample{
+		{value: 1},
+		{labels: []metricsLabel{{"name", "c1"}, {"path", "a\"b\\c\nd"}}, value: 0.5},
+		{suffix: "_count", labels: []metricsLabel{{"name", "c1"}}, value: 3},
+	})
+
+	expected := `# HELP lxd_test A test metric.
+# TYPE lxd_test gauge
+lxd_test 1
+lxd_test{name="c1",path="a\"b\\c\nd"} 0.5
+lxd_test_count{name="c1"} 3
+`
+	if buf.String() != expected {
+		t.Errorf("Wrong output:\n%s", buf.String())
+	}
+}
+
+func Test_apiMetrics(t *testing.T) {
+	m := apiMetrics{}
+	m.record("/1.0/profiles", "POST", 200, time.Second)
+	m.record("/1.0", "GET", 200, time.Second)
+	m.record("/1.0", "GET", 200, 2*time.Second)
+
+	counts, durations := m.samples()
+	if len(counts) != 2 || len(durations) != 4 {
+		t.Fatalf("Wrong number of samples: %d, %d", len(counts), len(durations))
+	}
+
+	if counts[0].labels[0].value != "/1.0" || counts[0].value != 2 {
+		t.Errorf("Wrong count: %v", counts[0])
+	}
+
+	if durations[0].suffix != "_sum" || durations[0].value != 3 {
+		t.Errorf("Wrong duration: %v", durations[0])
+	}
+}
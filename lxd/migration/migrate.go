@@ -41,6 +41,9 @@ type migrationFields struct {
 
 	container *lxc.Container
 	idmapset  *shared.IdmapSet
+
+	// Closing cancel, if set, aborts the migration.
+	cancel chan bool
 }
 
 func (c *migrationFields) send(m proto.Message) error {
@@ -91,6 +94,27 @@ func (c *migrationFields) disconnect() {
 	}
 }
 
+/*
+ * watchCancel closes the connections to the other end when the migration
+ * gets cancelled, which makes the transfers in progress fail.
+ */
+func (c *migrationFields) watchCancel(finished chan bool) {
+	if c.cancel == nil {
+		return
+	}
+
+	select {
+	case <-c.cancel:
+		shared.Debugf("Migration cancelled, closing the connections")
+		for _, conn := range []*websocket.Conn{c.controlConn, c.fsConn, c.criuConn} {
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	case <-finished:
+	}
+}
+
 func (c *migrationFields) sendControl(err error) {
 	message := ""
 	if err != nil {
@@ -154,10 +178,15 @@ type migrationSourceWs struct {
 	migrationFields
 
 	allConnected chan bool
+
+	// Whether to compress the control websocket, when the sink supports it.
+	compress bool
 }
 
-func NewMigrationSource(c *lxc.Container, idmapset *shared.IdmapSet) (shared.OperationWebsocket, error) {
-	ret := migrationSourceWs{migrationFields{container: c, idmapset: idmapset}, make(chan bool, 1)}
+// NewMigrationSource sets up the sending end of a migration, which closing
+// cancel, if set, aborts.
+func NewMigrationSource(c *lxc.Container, idmapset *shared.IdmapSet, compress bool, cancel chan bool) (shared.OperationWebsocket, error) {
+	ret := migrationSourceWs{migrationFields{container: c, idmapset: idmapset, cancel: cancel}, make(chan bool, 1), compress}
 
 	var err error
 	ret.controlSecret, err = shared.RandomCryptoString()
@@ -210,7 +239,12 @@ func (s *migrationSourceWs) Connect(secret string, r *http.Request, w http.Respo
 		return os.ErrPermission
 	}
 
-	c, err := shared.WebsocketUpgrader.Upgrade(w, r, nil)
+	upgrader := shared.WebsocketUpgrader
+	if s.compress && secret == s.controlSecret {
+		upgrader = shared.WebsocketCompressedUpgrader
+	}
+
+	c, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		return err
 	}
@@ -225,7 +259,17 @@ func (s *migrationSourceWs) Connect(secret string, r *http.Request, w http.Respo
 }
 
 func (s *migrationSourceWs) Do() shared.OperationResult {
-	<-s.allConnected
+	select {
+	case <-s.allConnected:
+	case <-s.cancel:
+		// Close the connections already made
+		s.watchCancel(nil)
+		return shared.OperationError(fmt.Errorf("Migration cancelled"))
+	}
+
+	finished := make(chan bool)
+	defer close(finished)
+	go s.watchCancel(finished)
 
 	criuType := CRIUType_CRIU_RSYNC.Enum()
 	if !s.live {
@@ -349,11 +393,14 @@ type MigrationSinkArgs struct {
 	Container *lxc.Container
 	Secrets   map[string]string
 	IdMapSet  *shared.IdmapSet
+
+	// Closing Cancel, if set, aborts the migration.
+	Cancel chan bool
 }
 
 func NewMigrationSink(args *MigrationSinkArgs) (func() error, error) {
 	sink := migrationSink{
-		migrationFields{container: args.Container},
+		migrationFields{container: args.Container, cancel: args.Cancel},
 		args.Url,
 		args.Dialer,
 		args.IdMapSet,
@@ -382,7 +429,15 @@ func (c *migrationSink) connectWithSecret(secret string) (*websocket.Conn, error
 	// TODO: we shouldn't assume this is a HTTP URL
 	url := c.url + "?" + query.Encode()
 
-	return lxd.WebsocketDial(c.dialer, url)
+	/*
+	 * Only offer compression on the control channel, the filesystem and
+	 * CRIU transfers are better off uncompressed. The source decides
+	 * whether it's actually used.
+	 */
+	dialer := c.dialer
+	dialer.EnableCompression = secret == c.controlSecret
+
+	return lxd.WebsocketDial(dialer, url)
 }
 
 func (c *migrationSink) do() error {
@@ -393,6 +448,10 @@ func (c *migrationSink) do() error {
 	}
 	defer c.disconnect()
 
+	finished := make(chan bool)
+	defer close(finished)
+	go c.watchCancel(finished)
+
 	c.fsConn, err = c.connectWithSecret(c.fsSecret)
 	if err != nil {
 		c.sendControl(err)
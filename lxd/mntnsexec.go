@@ -121,9 +121,37 @@ int manip_file_in_ns(char *host, int pid, char *container, bool is_put, uid_t ui
 			goto close_container;
 		}
 
+		if (fchmod(container_fd, mode) < 0) {
+			perror("fchmod");
+			goto close_container;
+		}
+
+		ret = 0;
+	} else {
+		struct stat sb;
+
+		if (copy(host_fd, container_fd) < 0)
+			goto close_container;
+
+		// Mirror the ownership and mode on the host side so they can be
+		// reported back to the client.
+		if (fstat(container_fd, &sb) < 0) {
+			perror("fstat");
+			goto close_container;
+		}
+
+		if (fchown(host_fd, sb.st_uid, sb.st_gid) < 0) {
+			perror("fchown");
+			goto close_container;
+		}
+
+		if (fchmod(host_fd, sb.st_mode & 07777) < 0) {
+			perror("fchmod");
+			goto close_container;
+		}
+
 		ret = 0;
-	} else
-		ret = copy(host_fd, container_fd);
+	}
 
 close_container:
 	close(container_fd);
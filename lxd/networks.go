@@ -1,12 +1,15 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
 	"path"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"gopkg.in/lxc/go-lxc.v2"
@@ -15,24 +18,20 @@ import (
 )
 
 func networksGet(d *Daemon, r *http.Request) Response {
-	recursionStr := r.FormValue("recursion")
-	recursion, err := strconv.Atoi(recursionStr)
-	if err != nil {
-		recursion = 0
-	}
+	recursion := d.isRecursionRequest(r)
 
-	ifs, err := net.Interfaces()
+	names, err := networkNames(d)
 	if err != nil {
 		return InternalError(err)
 	}
 
 	resultString := []string{}
-	resultMap := []network{}
-	for _, iface := range ifs {
-		if recursion == 0 {
-			resultString = append(resultString, fmt.Sprintf("/%s/networks/%s", shared.APIVersion, iface.Name))
+	resultMap := []shared.NetworkConfig{}
+	for _, name := range names {
+		if !recursion {
+			resultString = append(resultString, fmt.Sprintf("/%s/networks/%s", shared.APIVersion, name))
 		} else {
-			net, err := doNetworkGet(d, iface.Name)
+			net, err := doNetworkGet(d, name)
 			if err != nil {
 				continue
 			}
@@ -41,21 +40,80 @@ func networksGet(d *Daemon, r *http.Request) Response {
 		}
 	}
 
-	if recursion == 0 {
+	if !recursion {
 		return SyncResponse(true, resultString)
 	}
 
 	return SyncResponse(true, resultMap)
 }
 
-var networksCmd = Command{name: "networks", get: networksGet}
+// networkNames returns the host's interfaces and the managed networks.
+func networkNames(d *Daemon) ([]string, error) {
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := dbNetworks(d.db)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, iface := range ifs {
+		if !shared.StringInSlice(iface.Name, names) {
+			names = append(names, iface.Name)
+		}
+	}
+
+	return names, nil
+}
+
+type networksPostReq struct {
+	Name   string            `json:"name"`
+	Config map[string]string `json:"config"`
+}
+
+/*
+ * networksPost creates a managed network: a bridge which the daemon sets
+ * up (addresses and firewall rules) on every start.
+ */
+func networksPost(d *Daemon, r *http.Request) Response {
+	req := networksPostReq{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return BadRequest(err)
+	}
+
+	if err := networkValidName(req.Name); err != nil {
+		return BadRequest(err)
+	}
+
+	if req.Config == nil {
+		req.Config = map[string]string{}
+	}
+
+	if err := networkValidateConfig(req.Name, req.Config); err != nil {
+		return BadRequest(err)
+	}
+
+	if _, err := net.InterfaceByName(req.Name); err == nil {
+		return Conflict
+	}
+
+	if _, err := dbNetworkCreate(d.db, req.Name, req.Config); err != nil {
+		return SmartError(err)
+	}
+
+	if err := networkBridgeUp(req.Name, req.Config, nil); err != nil {
+		networkBridgeDelete(req.Name)
+		dbNetworkDelete(d.db, req.Name)
+		return InternalError(err)
+	}
 
-type network struct {
-	Name    string   `json:"name"`
-	Type    string   `json:"type"`
-	Members []string `json:"members"`
+	return EmptySyncResponse
 }
 
+var networksCmd = Command{name: "networks", get: networksGet, post: networksPost}
+
 func children(iface string) []string {
 	p := path.Join("/sys/class/net", iface, "brif")
 
@@ -96,21 +154,40 @@ func networkGet(d *Daemon, r *http.Request) Response {
 
 	n, err := doNetworkGet(d, name)
 	if err != nil {
-		return InternalError(err)
+		return SmartError(err)
 	}
 
-	return SyncResponse(true, &n)
+	return SyncResponseETag(true, &n, n.Config)
 }
 
-func doNetworkGet(d *Daemon, name string) (network, error) {
+func doNetworkGet(d *Daemon, name string) (shared.NetworkConfig, error) {
+	n := shared.NetworkConfig{Name: name, Config: map[string]string{}, Members: []string{}}
+
+	config, err := dbNetworkConfigGet(d.db, name)
+	if err == nil {
+		n.Managed = true
+		n.Type = "bridge"
+		n.Config = config
+	} else if err != NoSuchObjectError {
+		return shared.NetworkConfig{}, err
+	}
+
+	n.UsedBy, err = dbNetworkUsers(d.db, name)
+	if err != nil {
+		return shared.NetworkConfig{}, err
+	}
+
 	iface, err := net.InterfaceByName(name)
 	if err != nil {
-		return network{}, err
+		if n.Managed {
+			// The bridge couldn't be brought up
+			return n, nil
+		}
+
+		return shared.NetworkConfig{}, NoSuchObjectError
 	}
 
-	n := network{}
-	n.Name = iface.Name
-	n.Members = make([]string, 0)
+	n.State = networkState(iface)
 
 	if shared.IsLoopback(iface) {
 		n.Type = "loopback"
@@ -119,12 +196,12 @@ func doNetworkGet(d *Daemon, name string) (network, error) {
 		for _, ct := range lxc.ActiveContainerNames(d.lxcpath) {
 			c, err := containerLXDLoad(d, ct)
 			if err != nil {
-				return network{}, err
+				return shared.NetworkConfig{}, err
 			}
 
 			lxContainer, err := c.LXContainerGet()
 			if err != nil {
-				return network{}, err
+				return shared.NetworkConfig{}, err
 			}
 
 			if isOnBridge(lxContainer, n.Name) {
@@ -138,4 +215,273 @@ func doNetworkGet(d *Daemon, name string) (network, error) {
 	return n, nil
 }
 
-var networkCmd = Command{name: "networks/{name}", get: networkGet}
+// networkState returns whether an interface is up, its MTU and its traffic.
+func networkState(iface *net.Interface) *shared.NetworkState {
+	state := shared.NetworkState{State: "down", MTU: iface.MTU}
+	if iface.Flags&net.FlagUp != 0 {
+		state.State = "up"
+	}
+
+	state.Counters = networkCounters(path.Join("/sys/class/net", iface.Name, "statistics"))
+
+	return &state
+}
+
+/*
+ * networkCounters reads the counters of an interface from its statistics
+ * directory in sysfs, leaving those which can't be read to 0.
+ */
+func networkCounters(dir string) shared.NetworkCounters {
+	read := func(name string) int64 {
+		content, err := ioutil.ReadFile(path.Join(dir, name))
+		if err != nil {
+			return 0
+		}
+
+		value, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+		if err != nil {
+			return 0
+		}
+
+		return value
+	}
+
+	return shared.NetworkCounters{
+		BytesReceived:   read("rx_bytes"),
+		BytesSent:       read("tx_bytes"),
+		PacketsReceived: read("rx_packets"),
+		PacketsSent:     read("tx_packets"),
+	}
+}
+
+func networkPut(d *Daemon, r *http.Request) Response {
+	name := mux.Vars(r)["name"]
+
+	patchLock.Lock()
+	defer patchLock.Unlock()
+
+	config, err := dbNetworkConfigGet(d.db, name)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	if err := etagCheck(r, config); err != nil {
+		return PreconditionFailed(err)
+	}
+
+	req := networksPostReq{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return BadRequest(err)
+	}
+
+	return doNetworkUpdate(d, name, config, req.Config)
+}
+
+// networkPatch merges the config keys it's given into the network's.
+func networkPatch(d *Daemon, r *http.Request) Response {
+	name := mux.Vars(r)["name"]
+
+	req := networksPostReq{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return BadRequest(err)
+	}
+
+	patchLock.Lock()
+	defer patchLock.Unlock()
+
+	config, err := dbNetworkConfigGet(d.db, name)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	return doNetworkUpdate(d, name, config, patchConfig(config, req.Config))
+}
+
+/*
+ * doNetworkUpdate reconfigures the bridge of a managed network and then
+ * stores its new configuration, going back to the old one on failure.
+ */
+func doNetworkUpdate(d *Daemon, name string, oldConfig map[string]string, config map[string]string) Response {
+	if config == nil {
+		config = map[string]string{}
+	}
+
+	if err := networkValidateConfig(name, config); err != nil {
+		return BadRequest(err)
+	}
+
+	forwards, err := dbNetworkForwardsGet(d.db, name)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	// The port forwards must still point into the network
+	for _, forward := range forwards {
+		if err := networkValidateForward(config, forward); err != nil {
+			return BadRequest(fmt.Errorf("Port forward %s: %s", forward.Name(), err))
+		}
+	}
+
+	// And so must the static addresses of its nics
+	addresses, err := dbNetworkDeviceAddresses(d.db, name)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	for _, address := range addresses {
+		if err := networkCheckDeviceAddress(config, address); err != nil {
+			return BadRequest(err)
+		}
+	}
+
+	if err := networkBridgeUp(name, config, forwards); err != nil {
+		networkBridgeUp(name, oldConfig, forwards)
+		return InternalError(err)
+	}
+
+	if err := dbNetworkConfigSet(d.db, name, config); err != nil {
+		networkBridgeUp(name, oldConfig, forwards)
+		return InternalError(err)
+	}
+
+	return EmptySyncResponse
+}
+
+// networkCheckDeviceAddress checks a static address of a nic on a network.
+func networkCheckDeviceAddress(config map[string]string, address string) error {
+	ip := networkDeviceAddress(address)
+	if ip == nil {
+		return nil
+	}
+
+	family := "ipv4"
+	if ip.To4() == nil {
+		family = "ipv6"
+	}
+
+	_, subnet, err := networkAddress(config, family)
+	if err != nil {
+		return err
+	}
+
+	if subnet == nil || !subnet.Contains(ip) {
+		return fmt.Errorf("%s is still the static address of a nic on the network", ip)
+	}
+
+	return nil
+}
+
+/*
+ * networkPost renames a managed network, which is only possible while no
+ * nic device refers to it.
+ */
+func networkPost(d *Daemon, r *http.Request) Response {
+	name := mux.Vars(r)["name"]
+
+	req := networksPostReq{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return BadRequest(err)
+	}
+
+	config, err := dbNetworkConfigGet(d.db, name)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	if err := networkValidName(req.Name); err != nil {
+		return BadRequest(err)
+	}
+
+	// The tunnels are named after the network
+	if err := networkValidateConfig(req.Name, config); err != nil {
+		return BadRequest(err)
+	}
+
+	if _, err := net.InterfaceByName(req.Name); err == nil {
+		return Conflict
+	}
+
+	if _, err := dbNetworkIDGet(d.db, req.Name); err == nil {
+		return Conflict
+	}
+
+	users, err := dbNetworkUsers(d.db, name)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	if len(users) > 0 {
+		return InUse(fmt.Errorf("The network is in use by: %s", strings.Join(users, ", ")))
+	}
+
+	forwards, err := dbNetworkForwardsGet(d.db, name)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	if err := networkBridgeDelete(name); err != nil {
+		return InternalError(err)
+	}
+
+	if err := dbNetworkRename(d.db, name, req.Name); err != nil {
+		networkBridgeUp(name, config, forwards)
+		return SmartError(err)
+	}
+
+	if err := networkBridgeUp(req.Name, config, forwards); err != nil {
+		networkBridgeDelete(req.Name)
+		dbNetworkRename(d.db, req.Name, name)
+		networkBridgeUp(name, config, forwards)
+		return InternalError(err)
+	}
+
+	return EmptySyncResponse
+}
+
+// networkDelete removes a managed network, unless a nic device uses it.
+func networkDelete(d *Daemon, r *http.Request) Response {
+	name := mux.Vars(r)["name"]
+
+	if _, err := dbNetworkIDGet(d.db, name); err != nil {
+		return SmartError(err)
+	}
+
+	users, err := dbNetworkUsers(d.db, name)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	if len(users) > 0 {
+		return InUse(fmt.Errorf("The network is in use by: %s", strings.Join(users, ", ")))
+	}
+
+	if err := networkBridgeDelete(name); err != nil {
+		return InternalError(err)
+	}
+
+	if err := dbNetworkDelete(d.db, name); err != nil {
+		return InternalError(err)
+	}
+
+	return EmptySyncResponse
+}
+
+// networkLeasesGet returns the DHCP leases of a managed network.
+func networkLeasesGet(d *Daemon, r *http.Request) Response {
+	name := mux.Vars(r)["name"]
+
+	if _, err := dbNetworkIDGet(d.db, name); err != nil {
+		return SmartError(err)
+	}
+
+	leases, err := networkLeases(name)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return SyncResponse(true, leases)
+}
+
+var networkLeasesCmd = Command{name: "networks/{name}/leases", get: networkLeasesGet}
+
+var networkCmd = Command{name: "networks/{name}", get: networkGet, put: networkPut, patch: networkPatch, post: networkPost, delete: networkDelete}
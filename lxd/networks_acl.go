@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// networkACLChain is the ebtables chain of a network's ACL.
+func networkACLChain(name string) string {
+	return fmt.Sprintf("lxd-%s", name)
+}
+
+// networkACLAddress parses the source or destination of an ACL rule.
+func networkACLAddress(value string) (*net.IPNet, error) {
+	if value == "any" {
+		return nil, nil
+	}
+
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("Invalid address: '%s'", value)
+		}
+
+		bits := 32
+		if ip.To4() == nil {
+			bits = 128
+		}
+
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, subnet, err := net.ParseCIDR(value)
+	return subnet, err
+}
+
+/*
+ * networkACLRules returns the ebtables rules of the chain filtering the
+ * traffic between the containers of a network. acl.rules is a comma
+ * separated list of "allow|deny <source> <destination> [tcp|udp [port]]",
+ * the addresses being "any", IPs or subnets, and the first matching rule
+ * applies. Other traffic is dropped when bridge.isolation is true, but for
+ * that going through the network's tunnels. It's nil when the network
+ * doesn't restrict that traffic.
+ */
+func networkACLRules(name string, config map[string]string) ([][]string, error) {
+	rules := [][]string{}
+
+	for _, entry := range strings.Split(config["acl.rules"], ",") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if len(fields) < 3 || len(fields) > 5 {
+			return nil, fmt.Errorf("Invalid ACL rule: '%s'", strings.TrimSpace(entry))
+		}
+
+		target := map[string]string{"allow": "ACCEPT", "deny": "DROP"}[fields[0]]
+		if target == "" {
+			return nil, fmt.Errorf("Invalid ACL rule action: '%s'", fields[0])
+		}
+
+		source, err := networkACLAddress(fields[1])
+		if err != nil {
+			return nil, err
+		}
+
+		destination, err := networkACLAddress(fields[2])
+		if err != nil {
+			return nil, err
+		}
+
+		protocol := ""
+		if len(fields) > 3 {
+			protocol = fields[3]
+			if protocol != "tcp" && protocol != "udp" {
+				return nil, fmt.Errorf("Invalid ACL rule protocol: '%s'", protocol)
+			}
+		}
+
+		port := ""
+		if len(fields) > 4 {
+			port = fields[4]
+			if value, err := strconv.Atoi(port); err != nil || value < 1 || value > 65535 {
+				return nil, fmt.Errorf("Invalid ACL rule port: '%s'", port)
+			}
+		}
+
+		families := []string{"ipv4", "ipv6"}
+		if source != nil && destination != nil && (source.IP.To4() == nil) != (destination.IP.To4() == nil) {
+			return nil, fmt.Errorf("Invalid ACL rule, mixing IPv4 and IPv6: '%s'", strings.TrimSpace(entry))
+		} else if source != nil {
+			families = []string{networkFamily(source.IP)}
+		} else if destination != nil {
+			families = []string{networkFamily(destination.IP)}
+		}
+
+		for _, family := range families {
+			prefix := "--ip"
+			args := []string{"-p", "IPv4"}
+			if family == "ipv6" {
+				prefix = "--ip6"
+				args = []string{"-p", "IPv6"}
+			}
+
+			if source != nil {
+				args = append(args, prefix+"-src", source.String())
+			}
+
+			if destination != nil {
+				args = append(args, prefix+"-dst", destination.String())
+			}
+
+			if protocol != "" {
+				args = append(args, prefix+"-proto", protocol)
+			}
+
+			if port != "" {
+				args = append(args, prefix+"-dport", port)
+			}
+
+			rules = append(rules, append(args, "-j", target))
+		}
+	}
+
+	if config["bridge.isolation"] == "true" {
+		// The tunnels lead to other hosts rather than containers
+		for _, tunnel := range networkTunnels(config) {
+			device := networkTunnelDevice(name, tunnel)
+			rules = append(rules, []string{"-i", device, "-j", "RETURN"}, []string{"-o", device, "-j", "RETURN"})
+		}
+
+		rules = append(rules, []string{"-j", "DROP"})
+	}
+
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	// The containers still need to find each other for what's allowed,
+	// with ARP and with the IPv6 neighbor discovery and router messages
+	discovery := [][]string{
+		{"-p", "ARP", "-j", "ACCEPT"},
+		{"-p", "IPv6", "--ip6-proto", "ipv6-icmp", "--ip6-icmp-type", "133:137", "-j", "ACCEPT"},
+	}
+
+	return append(discovery, rules...), nil
+}
+
+// networkFamily returns whether an address is ipv4 or ipv6.
+func networkFamily(ip net.IP) string {
+	if ip.To4() == nil {
+		return "ipv6"
+	}
+
+	return "ipv4"
+}
+
+/*
+ * networkACLSet replaces the ebtables chain filtering the traffic between
+ * the containers of a network, which the frames it forwards go through.
+ */
+func networkACLSet(name string, config map[string]string) error {
+	if err := networkACLClear(name); err != nil {
+		return err
+	}
+
+	rules, err := networkACLRules(name, config)
+	if err != nil || rules == nil {
+		return err
+	}
+
+	if _, err := exec.LookPath("ebtables"); err != nil {
+		return fmt.Errorf("ebtables is needed for bridge.isolation and acl.rules")
+	}
+
+	chain := networkACLChain(name)
+	if err := networkExec("ebtables", "-N", chain, "-P", "RETURN"); err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		if err := networkExec("ebtables", append([]string{"-A", chain}, rule...)...); err != nil {
+			return err
+		}
+	}
+
+	return networkExec("ebtables", "-A", "FORWARD", "--logical-in", name, "-j", chain)
+}
+
+// networkACLClear removes the ebtables chain of a network, if it has one.
+func networkACLClear(name string) error {
+	if _, err := exec.LookPath("ebtables"); err != nil {
+		return nil
+	}
+
+	chain := networkACLChain(name)
+	if err := exec.Command("ebtables", "-L", chain).Run(); err != nil {
+		// No such chain
+		return nil
+	}
+
+	// The jump may be missing if setting the chain up failed midway
+	exec.Command("ebtables", "-D", "FORWARD", "--logical-in", name, "-j", chain).Run()
+
+	return networkExec("ebtables", "-X", chain)
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/lxc/lxd/shared"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// networkExec runs a command, turning its output into the error if it fails.
+func networkExec(name string, args ...string) error {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %s", name, strings.Join(args, " "), strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+// networkValidName tells whether name can be used for a bridge.
+func networkValidName(name string) error {
+	if name == "" || name == "." || name == ".." {
+		return fmt.Errorf("Invalid network name: '%s'", name)
+	}
+
+	// IFNAMSIZ, including the trailing NUL
+	if len(name) > 15 {
+		return fmt.Errorf("Network name too long: '%s'", name)
+	}
+
+	if strings.ContainsAny(name, "/: \t\n") {
+		return fmt.Errorf("Invalid network name: '%s'", name)
+	}
+
+	return nil
+}
+
+/*
+ * networkAddress parses the ipv4.address or ipv6.address of a network,
+ * returning nil if it's unset.
+ */
+func networkAddress(config map[string]string, family string) (net.IP, *net.IPNet, error) {
+	value := config[family+".address"]
+	if value == "" || value == "none" {
+		return nil, nil, nil
+	}
+
+	ip, subnet, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if (family == "ipv4") != (ip.To4() != nil) {
+		return nil, nil, fmt.Errorf("Not an %s address: '%s'", family, value)
+	}
+
+	if ip.Equal(subnet.IP) {
+		return nil, nil, fmt.Errorf("The bridge can't use the network address: '%s'", value)
+	}
+
+	return ip, subnet, nil
+}
+
+// networkValidateConfig checks the configuration of a managed network.
+func networkValidateConfig(name string, config map[string]string) error {
+	for key, value := range config {
+		info, ok := configKeyLookup(networkConfigKeys, key)
+		if !ok {
+			return fmt.Errorf("Bad network config key: '%s'", key)
+		}
+
+		if info.Type == "boolean" && value != "" && value != "true" && value != "false" {
+			return fmt.Errorf("Bad value for %s: '%s'", key, value)
+		}
+	}
+
+	for _, family := range []string{"ipv4", "ipv6"} {
+		if _, _, err := networkAddress(config, family); err != nil {
+			return fmt.Errorf("Bad value for %s.address: %s", family, err)
+		}
+	}
+
+	for _, family := range []string{"ipv4", "ipv6"} {
+		if _, err := networkDHCPRanges(config, family); err != nil {
+			return fmt.Errorf("Bad value for %s.dhcp.ranges: %s", family, err)
+		}
+	}
+
+	if _, subnet, _ := networkAddress(config, "ipv6"); subnet != nil && config["ipv6.dhcp"] != "false" && config["ipv6.dhcp.stateful"] != "true" {
+		if ones, _ := subnet.Mask.Size(); ones != 64 {
+			return fmt.Errorf("SLAAC needs a /64 subnet, use ipv6.dhcp.stateful for others")
+		}
+	}
+
+	if mode := config["dns.mode"]; mode != "" && mode != "managed" && mode != "none" {
+		return fmt.Errorf("Bad value for dns.mode: '%s'", mode)
+	}
+
+	for _, tunnel := range networkTunnels(config) {
+		if _, err := networkTunnelArgs(name, tunnel, config); err != nil {
+			return err
+		}
+	}
+
+	if _, err := networkACLRules(name, config); err != nil {
+		return fmt.Errorf("Bad value for acl.rules: %s", err)
+	}
+
+	return nil
+}
+
+func networkSysctlSet(key string, value string) error {
+	return ioutil.WriteFile(path.Join("/proc/sys", key), []byte(value), 0)
+}
+
+/*
+ * networkKeepAcceptingRA makes the host's interfaces which accept router
+ * advertisements keep doing so once IPv6 forwarding is enabled, which
+ * would otherwise make them ignore those.
+ */
+func networkKeepAcceptingRA() {
+	paths, err := filepath.Glob("/proc/sys/net/ipv6/conf/*/accept_ra")
+	if err != nil {
+		return
+	}
+
+	for _, p := range paths {
+		content, err := ioutil.ReadFile(p)
+		if err != nil || strings.TrimSpace(string(content)) != "1" {
+			continue
+		}
+
+		ioutil.WriteFile(p, []byte("2"), 0)
+	}
+}
+
+/*
+ * networkBridgeUp creates the bridge of a managed network if it doesn't
+ * exist yet and sets its tunnels, addresses, firewall rules (including
+ * those of its port forwards), ACL and dnsmasq to match config.
+ */
+func networkBridgeUp(name string, config map[string]string, forwards []shared.NetworkForward) error {
+	if !shared.PathExists(path.Join("/sys/class/net", name)) {
+		if err := networkExec("ip", "link", "add", name, "type", "bridge"); err != nil {
+			return err
+		}
+	}
+
+	if err := networkExec("ip", "link", "set", name, "up"); err != nil {
+		return err
+	}
+
+	if err := networkTunnelsUp(name, config); err != nil {
+		return err
+	}
+
+	for _, family := range []string{"ipv4", "ipv6"} {
+		flag := "-4"
+		forwarding := "net/ipv4/ip_forward"
+		if family == "ipv6" {
+			flag = "-6"
+			forwarding = "net/ipv6/conf/all/forwarding"
+		}
+
+		// Link-local addresses are left alone
+		if err := networkExec("ip", flag, "addr", "flush", "dev", name, "scope", "global"); err != nil {
+			return err
+		}
+
+		ip, subnet, err := networkAddress(config, family)
+		if err != nil {
+			return err
+		}
+
+		if ip == nil {
+			continue
+		}
+
+		ones, _ := subnet.Mask.Size()
+		if err := networkExec("ip", flag, "addr", "add", fmt.Sprintf("%s/%d", ip, ones), "dev", name); err != nil {
+			return err
+		}
+
+		if err := networkSysctlSet(forwarding, "1"); err != nil {
+			return err
+		}
+
+		if family == "ipv6" {
+			networkKeepAcceptingRA()
+		}
+	}
+
+	if err := networkFirewallSet(name, config, forwards); err != nil {
+		return err
+	}
+
+	if err := networkACLSet(name, config); err != nil {
+		return err
+	}
+
+	return networkDnsmasqStart(name, config)
+}
+
+// networkBridgeDelete removes the bridge of a managed network, its rules,
+// tunnels, ACL and dnsmasq.
+func networkBridgeDelete(name string) error {
+	if err := networkDnsmasqStop(name); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(networkPath(name)); err != nil {
+		return err
+	}
+
+	if err := networkFirewallClear(name); err != nil {
+		return err
+	}
+
+	if err := networkTunnelsClear(name); err != nil {
+		return err
+	}
+
+	if err := networkACLClear(name); err != nil {
+		return err
+	}
+
+	if !shared.PathExists(path.Join("/sys/class/net", name)) {
+		return nil
+	}
+
+	return networkExec("ip", "link", "del", name)
+}
+
+// networkStartup brings up the managed networks as the daemon starts.
+func networkStartup(d *Daemon) {
+	names, err := dbNetworks(d.db)
+	if err != nil {
+		shared.Log.Error("Failed to list the managed networks", log.Ctx{"err": err})
+		return
+	}
+
+	for _, name := range names {
+		config, err := dbNetworkConfigGet(d.db, name)
+		if err != nil {
+			shared.Log.Error("Failed to bring up network", log.Ctx{"network": name, "err": err})
+			continue
+		}
+
+		forwards, err := dbNetworkForwardsGet(d.db, name)
+		if err == nil {
+			err = networkBridgeUp(name, config, forwards)
+		}
+
+		if err != nil {
+			shared.Log.Error("Failed to bring up network", log.Ctx{"network": name, "err": err})
+		}
+	}
+}
+
+/*
+ * networkFreeSubnet returns the first 10.x.y.0/24 subnet overlapping none
+ * of those in use.
+ */
+func networkFreeSubnet(used []*net.IPNet) (*net.IPNet, error) {
+	for i := 0; i < 256*256; i++ {
+		subnet := &net.IPNet{
+			IP:   net.IPv4(10, byte(i/256), byte(i%256), 0).To4(),
+			Mask: net.CIDRMask(24, 32),
+		}
+
+		free := true
+		for _, u := range used {
+			if u.Contains(subnet.IP) || subnet.Contains(u.IP) {
+				free = false
+				break
+			}
+		}
+
+		if free {
+			return subnet, nil
+		}
+	}
+
+	return nil, fmt.Errorf("No free subnet for the default network")
+}
+
+/*
+ * networkDefaultSetup gives the default profile's nic a managed bridge,
+ * lxdbr0 with NAT on a free subnet, on the first start of hosts without
+ * the lxcbr0 of lxc-net.
+ */
+func networkDefaultSetup(d *Daemon) error {
+	if shared.PathExists("/sys/class/net/lxcbr0") {
+		return nil
+	}
+
+	name := "lxdbr0"
+	if _, err := dbNetworkIDGet(d.db, name); err != NoSuchObjectError {
+		return err
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return err
+	}
+
+	used := []*net.IPNet{}
+	for _, addr := range addrs {
+		if subnet, ok := addr.(*net.IPNet); ok && subnet.IP.To4() != nil {
+			used = append(used, subnet)
+		}
+	}
+
+	subnet, err := networkFreeSubnet(used)
+	if err != nil {
+		return err
+	}
+
+	ip := subnet.IP.To4()
+	config := map[string]string{
+		"ipv4.address": fmt.Sprintf("%d.%d.%d.1/24", ip[0], ip[1], ip[2]),
+		"ipv4.nat":     "true",
+	}
+
+	if _, err := dbNetworkCreate(d.db, name, config); err != nil {
+		return err
+	}
+
+	if err := networkBridgeUp(name, config, nil); err != nil {
+		networkBridgeDelete(name)
+		dbNetworkDelete(d.db, name)
+		return err
+	}
+
+	devices, err := dbDevicesGet(d.db, "default", true)
+	if err != nil {
+		return err
+	}
+
+	if devices["eth0"]["parent"] != "lxcbr0" {
+		return nil
+	}
+	devices["eth0"]["parent"] = name
+
+	id, err := dbProfileIDGet(d.db, "default")
+	if err != nil {
+		return err
+	}
+
+	config, err = dbProfileConfigGet(d.db, "default")
+	if err != nil {
+		return err
+	}
+
+	return dbTx(d.db, func(tx *sql.Tx) error {
+		if err := dbProfileConfigClear(tx, id); err != nil {
+			return err
+		}
+
+		if err := dbProfileConfigAdd(tx, id, config); err != nil {
+			return err
+		}
+
+		return dbDevicesAdd(tx, "profile", id, devices)
+	})
+}
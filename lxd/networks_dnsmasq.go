@@ -0,0 +1,559 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+)
+
+// networkPath returns where the files of a managed network's dnsmasq live.
+func networkPath(name string, file ...string) string {
+	return shared.VarPath(append([]string{"networks", name}, file...)...)
+}
+
+/*
+ * networkDHCPRanges returns the ranges a network's DHCP server hands out
+ * for family (ipv4 or ipv6), as "start,end" arguments to dnsmasq. By
+ * default, the whole subnet but for the bridge's address is handed out.
+ */
+func networkDHCPRanges(config map[string]string, family string) ([]string, error) {
+	ip, subnet, err := networkAddress(config, family)
+	if err != nil || ip == nil {
+		return nil, err
+	}
+
+	ranges := []string{}
+	if config[family+".dhcp.ranges"] != "" {
+		for _, r := range strings.Split(config[family+".dhcp.ranges"], ",") {
+			bounds := strings.SplitN(strings.TrimSpace(r), "-", 2)
+			if len(bounds) != 2 {
+				return nil, fmt.Errorf("Invalid DHCP range: '%s'", r)
+			}
+
+			start := net.ParseIP(bounds[0])
+			end := net.ParseIP(bounds[1])
+			if start == nil || end == nil || !subnet.Contains(start) || !subnet.Contains(end) {
+				return nil, fmt.Errorf("Invalid DHCP range: '%s'", r)
+			}
+
+			if bytes.Compare(start.To16(), end.To16()) > 0 {
+				return nil, fmt.Errorf("Invalid DHCP range, it starts after its end: '%s'", r)
+			}
+
+			ranges = append(ranges, fmt.Sprintf("%s,%s", start, end))
+		}
+
+		return ranges, nil
+	}
+
+	first := subnet.IP
+	last := make(net.IP, len(subnet.IP))
+	for i := range last {
+		last[i] = subnet.IP[i] | ^subnet.Mask[i]
+	}
+
+	// Skip the network address and, for IPv4, the broadcast one, unless
+	// the subnet is a point to point link (/31 or /127) without them
+	ones, bits := subnet.Mask.Size()
+	if bits-ones > 1 {
+		first = networkIPAdd(first, 1)
+		if family == "ipv4" {
+			last = networkIPAdd(last, -1)
+		}
+	}
+
+	if first.Equal(ip) {
+		first = networkIPAdd(first, 1)
+	}
+
+	if last.Equal(ip) {
+		last = networkIPAdd(last, -1)
+	}
+
+	// Nothing is left to hand out
+	if bytes.Compare(first.To16(), last.To16()) > 0 {
+		return ranges, nil
+	}
+
+	return append(ranges, fmt.Sprintf("%s,%s", first, last)), nil
+}
+
+// networkIPAdd returns the address n addresses after ip.
+func networkIPAdd(ip net.IP, n int64) net.IP {
+	if ip.To4() != nil {
+		ip = ip.To4()
+	}
+
+	value := big.NewInt(0).SetBytes(ip)
+	value.Add(value, big.NewInt(n))
+
+	// Left pad to the size of the address
+	bytes := value.Bytes()
+	result := make(net.IP, len(ip))
+	copy(result[len(result)-len(bytes):], bytes)
+
+	return result
+}
+
+/*
+ * networkDnsmasqArgs returns how dnsmasq is run for a network, or nil if
+ * it needs neither DHCP, router advertisements nor DNS.
+ */
+func networkDnsmasqArgs(name string, config map[string]string) ([]string, error) {
+	ipv4, _, err := networkAddress(config, "ipv4")
+	if err != nil {
+		return nil, err
+	}
+
+	ipv6, subnet6, err := networkAddress(config, "ipv6")
+	if err != nil {
+		return nil, err
+	}
+
+	dhcp4 := ipv4 != nil && config["ipv4.dhcp"] != "false"
+	dhcp6 := ipv6 != nil && config["ipv6.dhcp"] != "false"
+	dns := config["dns.mode"] != "none"
+	if !dhcp4 && !dhcp6 && !dns {
+		return nil, nil
+	}
+
+	args := []string{
+		"--conf-file=",
+		"--strict-order",
+		"--bind-interfaces",
+		"--except-interface=lo",
+		fmt.Sprintf("--interface=%s", name),
+		fmt.Sprintf("--pid-file=%s", networkPath(name, "dnsmasq.pid")),
+	}
+
+	if dns {
+		domain := config["dns.domain"]
+		if domain == "" {
+			domain = "lxd"
+		}
+
+		args = append(args,
+			fmt.Sprintf("--domain=%s", domain),
+			fmt.Sprintf("--local=/%s/", domain),
+			"--expand-hosts")
+	} else {
+		args = append(args, "--port=0")
+	}
+
+	if dhcp4 || dhcp6 {
+		args = append(args,
+			"--dhcp-no-override",
+			"--dhcp-authoritative",
+			fmt.Sprintf("--dhcp-leasefile=%s", networkPath(name, "dnsmasq.leases")),
+			fmt.Sprintf("--dhcp-hostsdir=%s", networkPath(name, "dnsmasq.hosts")))
+	}
+
+	if dhcp4 {
+		ranges, err := networkDHCPRanges(config, "ipv4")
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range ranges {
+			args = append(args, fmt.Sprintf("--dhcp-range=%s,1h", r))
+		}
+	}
+
+	if dhcp6 {
+		args = append(args, "--enable-ra")
+
+		ones, _ := subnet6.Mask.Size()
+		if config["ipv6.dhcp.stateful"] == "true" {
+			ranges, err := networkDHCPRanges(config, "ipv6")
+			if err != nil {
+				return nil, err
+			}
+
+			for _, r := range ranges {
+				args = append(args, fmt.Sprintf("--dhcp-range=%s,%d,1h", r, ones))
+			}
+		} else {
+			// SLAAC, DHCPv6 only giving out the DNS server
+			args = append(args, fmt.Sprintf("--dhcp-range=%s,ra-stateless,ra-names,%d", subnet6.IP, ones))
+		}
+	}
+
+	return args, nil
+}
+
+// networkLeases returns the current DHCP leases of a managed network.
+func networkLeases(name string) ([]shared.NetworkLease, error) {
+	content, err := ioutil.ReadFile(networkPath(name, "dnsmasq.leases"))
+	if os.IsNotExist(err) {
+		return []shared.NetworkLease{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return networkParseLeases(string(content)), nil
+}
+
+/*
+ * networkParseLeases parses a dnsmasq lease file. Its lines are "expiry
+ * hwaddr address hostname client-id", except for the DHCPv6 ones, which
+ * come after a "duid" line and have the IAID instead of the MAC.
+ */
+func networkParseLeases(content string) []shared.NetworkLease {
+	leases := []shared.NetworkLease{}
+
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] == "duid" {
+			continue
+		}
+
+		lease := shared.NetworkLease{Address: fields[2]}
+
+		// An expiry of 0 means the lease never expires
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		if expiry != 0 {
+			lease.Expiry = time.Unix(expiry, 0).UTC()
+		}
+
+		if _, err := net.ParseMAC(fields[1]); err == nil {
+			lease.Hwaddr = fields[1]
+		}
+
+		if fields[3] != "*" {
+			lease.Hostname = fields[3]
+		}
+
+		leases = append(leases, lease)
+	}
+
+	return leases
+}
+
+/*
+ * networkDnsmasqPid returns the PID of the dnsmasq of a network, 0 if it
+ * isn't running.
+ */
+func networkDnsmasqPid(name string) (int, error) {
+	content, err := ioutil.ReadFile(networkPath(name, "dnsmasq.pid"))
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return 0, nil
+	}
+
+	// Make sure the PID wasn't reused since
+	cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil || !strings.Contains(string(cmdline), "dnsmasq") {
+		return 0, nil
+	}
+
+	return pid, nil
+}
+
+/*
+ * networkDnsmasqStop kills the dnsmasq of a network, if it's running, and
+ * waits for it to be gone so that a new one can take over its sockets.
+ */
+func networkDnsmasqStop(name string) error {
+	pid, err := networkDnsmasqPid(name)
+	if err != nil {
+		return err
+	}
+
+	if pid != 0 {
+		if err := syscall.Kill(pid, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+			return err
+		}
+
+		// The pid file is left behind, so look at the process itself
+		stopped := false
+		for i := 0; i < 50 && !stopped; i++ {
+			cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+			stopped = err != nil || !strings.Contains(string(cmdline), "dnsmasq")
+			if !stopped {
+				time.Sleep(100 * time.Millisecond)
+			}
+		}
+
+		if !stopped {
+			return fmt.Errorf("dnsmasq (pid %d) of network %s didn't exit", pid, name)
+		}
+	}
+
+	err = os.Remove(networkPath(name, "dnsmasq.pid"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+/*
+ * networkDnsmasqReload has the dnsmasq of a network read its dhcp-host
+ * entries again. It only notices the new and changed ones by itself, not
+ * those which were removed.
+ */
+func networkDnsmasqReload(name string) error {
+	pid, err := networkDnsmasqPid(name)
+	if err != nil || pid == 0 {
+		return err
+	}
+
+	if err := syscall.Kill(pid, syscall.SIGHUP); err != nil && err != syscall.ESRCH {
+		return err
+	}
+
+	return nil
+}
+
+/*
+ * networkDnsmasqStart (re)starts the dnsmasq serving DHCP and DNS on a
+ * managed network, which keeps running while the daemon is down so that
+ * the containers can renew their leases.
+ */
+func networkDnsmasqStart(name string, config map[string]string) error {
+	if err := networkDnsmasqStop(name); err != nil {
+		return err
+	}
+
+	args, err := networkDnsmasqArgs(name, config)
+	if err != nil || args == nil {
+		return err
+	}
+
+	if _, err := exec.LookPath("dnsmasq"); err != nil {
+		return fmt.Errorf("dnsmasq is needed for DHCP and DNS on managed networks (see ipv4.dhcp and dns.mode)")
+	}
+
+	if err := os.MkdirAll(networkPath(name, "dnsmasq.hosts"), 0711); err != nil {
+		return err
+	}
+
+	// dnsmasq forks and writes its PID file once ready
+	return networkExec("dnsmasq", args...)
+}
+
+/*
+ * networkDHCPHost returns the dnsmasq dhcp-host entry of a nic device:
+ * naming its leases after hostname (registering it in the network's DNS)
+ * and reserving its ipv4.address and ipv6.address. It's "" if there's
+ * neither.
+ */
+func networkDHCPHost(config map[string]string, hostname string, device shared.Device) (string, error) {
+	if hostname == "" && device["ipv4.address"] == "" && device["ipv6.address"] == "" {
+		return "", nil
+	}
+
+	entry := []string{device["hwaddr"]}
+	for _, family := range []string{"ipv4", "ipv6"} {
+		if device[family+".address"] == "" {
+			continue
+		}
+
+		ip := networkDeviceAddress(device[family+".address"])
+		_, subnet, err := networkAddress(config, family)
+		if err != nil {
+			return "", err
+		}
+
+		if subnet == nil || !subnet.Contains(ip) {
+			return "", fmt.Errorf("%s isn't in the subnet of network %s", ip, device["parent"])
+		}
+
+		if family == "ipv6" {
+			entry = append(entry, fmt.Sprintf("[%s]", ip))
+		} else {
+			entry = append(entry, ip.String())
+		}
+	}
+
+	if hostname != "" {
+		entry = append(entry, hostname)
+	}
+
+	return strings.Join(entry, ","), nil
+}
+
+// networkBridged tells whether a nic device is a veth on its parent bridge.
+func networkBridged(device shared.Device) bool {
+	return device["nictype"] == "" || device["nictype"] == "bridged"
+}
+
+// networkDeviceAddress parses the ipv4.address or ipv6.address of a nic.
+func networkDeviceAddress(value string) net.IP {
+	ip, _, err := net.ParseCIDR(value)
+	if err != nil {
+		return net.ParseIP(value)
+	}
+
+	return ip
+}
+
+/*
+ * networkUpdateHosts writes the dhcp-host entries of a container's nic
+ * devices on managed networks, and has dnsmasq reload them. The
+ * container's name is only registered while it's running, its static
+ * addresses stay reserved.
+ */
+func networkUpdateHosts(d *Daemon, container string, devices shared.Devices, running bool) error {
+	changed, err := networkRemoveHosts(d, container)
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+	for name := range devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hosts := map[string][]string{}
+	for _, name := range names {
+		device := devices[name]
+		if device["type"] != "nic" || device["parent"] == "" || !networkBridged(device) {
+			continue
+		}
+
+		config, err := dbNetworkConfigGet(d.db, device["parent"])
+		if err == NoSuchObjectError {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		hostname := ""
+		if running {
+			hostname = container
+		}
+
+		entry, err := networkDHCPHost(config, hostname, device)
+		if err != nil {
+			return err
+		}
+
+		if entry != "" {
+			hosts[device["parent"]] = append(hosts[device["parent"]], entry)
+		}
+	}
+
+	for network, entries := range hosts {
+		if err := os.MkdirAll(networkPath(network, "dnsmasq.hosts"), 0711); err != nil {
+			return err
+		}
+
+		content := strings.Join(entries, "\n") + "\n"
+		if err := ioutil.WriteFile(networkPath(network, "dnsmasq.hosts", container), []byte(content), 0644); err != nil {
+			return err
+		}
+
+		if !shared.StringInSlice(network, changed) {
+			changed = append(changed, network)
+		}
+	}
+
+	return networkReloadHosts(changed)
+}
+
+/*
+ * networkReleaseLeases releases the DHCPv4 leases of a stopped container's
+ * nic devices on managed networks, so that dnsmasq stops resolving its
+ * name right away. This needs dhcp_release, the leases otherwise expiring
+ * on their own.
+ */
+func networkReleaseLeases(d *Daemon, devices shared.Devices) error {
+	if _, err := exec.LookPath("dhcp_release"); err != nil {
+		return nil
+	}
+
+	for _, device := range devices {
+		if device["type"] != "nic" || device["parent"] == "" || device["hwaddr"] == "" || !networkBridged(device) {
+			continue
+		}
+
+		if _, err := dbNetworkIDGet(d.db, device["parent"]); err == NoSuchObjectError {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		leases, err := networkLeases(device["parent"])
+		if err != nil {
+			return err
+		}
+
+		for _, lease := range leases {
+			if !strings.EqualFold(lease.Hwaddr, device["hwaddr"]) || net.ParseIP(lease.Address).To4() == nil {
+				continue
+			}
+
+			if err := networkExec("dhcp_release", device["parent"], lease.Address, lease.Hwaddr); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// networkClearHosts removes the dhcp-host entries of a container.
+func networkClearHosts(d *Daemon, container string) error {
+	changed, err := networkRemoveHosts(d, container)
+	if err != nil {
+		return err
+	}
+
+	return networkReloadHosts(changed)
+}
+
+// networkRemoveHosts removes the dhcp-host entries of a container, returning
+// the networks it had some on.
+func networkRemoveHosts(d *Daemon, container string) ([]string, error) {
+	networks, err := dbNetworks(d.db)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := []string{}
+	for _, network := range networks {
+		err := os.Remove(networkPath(network, "dnsmasq.hosts", container))
+		if err == nil {
+			changed = append(changed, network)
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	return changed, nil
+}
+
+// networkReloadHosts has the dnsmasq of each network reload its dhcp-host
+// entries.
+func networkReloadHosts(networks []string) error {
+	for _, network := range networks {
+		if err := networkDnsmasqReload(network); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/lxc/lxd/shared"
+)
+
+// networkRule is a firewall rule, as the arguments to iptables/ip6tables.
+type networkRule struct {
+	family string
+	table  string
+	chain  string
+	args   []string
+}
+
+func networkFirewallCommand(family string) string {
+	if family == "ipv6" {
+		return "ip6tables"
+	}
+
+	return "iptables"
+}
+
+func (r networkRule) run(action string) error {
+	args := append([]string{"-t", r.table, action, r.chain}, r.args...)
+	return networkExec(networkFirewallCommand(r.family), args...)
+}
+
+// networkRuleComment is what the rules of a network are tagged with.
+func networkRuleComment(name string) string {
+	return fmt.Sprintf("generated for LXD network %s", name)
+}
+
+/*
+ * networkRules returns the firewall rules a managed network needs: letting
+ * the containers reach dnsmasq and be forwarded traffic, masquerading
+ * their traffic when NAT is enabled and sending them what its port
+ * forwards are for.
+ */
+func networkRules(name string, config map[string]string, forwards []shared.NetworkForward) []networkRule {
+	comment := []string{"-m", "comment", "--comment", networkRuleComment(name)}
+
+	rules := []networkRule{}
+	add := func(family string, table string, chain string, args ...string) {
+		rules = append(rules, networkRule{family, table, chain, append(args, comment...)})
+	}
+
+	for _, family := range []string{"ipv4", "ipv6"} {
+		_, subnet, err := networkAddress(config, family)
+		if err != nil || subnet == nil {
+			continue
+		}
+
+		if config[family+".firewall"] != "false" {
+			dhcpPort := "67"
+			if family == "ipv6" {
+				dhcpPort = "547"
+			}
+
+			add(family, "filter", "INPUT", "-i", name, "-p", "udp", "--dport", dhcpPort, "-j", "ACCEPT")
+			add(family, "filter", "INPUT", "-i", name, "-p", "udp", "--dport", "53", "-j", "ACCEPT")
+			add(family, "filter", "INPUT", "-i", name, "-p", "tcp", "--dport", "53", "-j", "ACCEPT")
+			add(family, "filter", "FORWARD", "-i", name, "-j", "ACCEPT")
+			add(family, "filter", "FORWARD", "-o", name, "-j", "ACCEPT")
+		}
+
+		if config[family+".nat"] == "true" {
+			add(family, "nat", "POSTROUTING", "-s", subnet.String(), "!", "-d", subnet.String(), "-j", "MASQUERADE")
+		}
+	}
+
+	for _, forward := range forwards {
+		family := "ipv4"
+		target := fmt.Sprintf("%s:%d", forward.TargetAddress, forward.TargetPort)
+		if net.ParseIP(forward.TargetAddress).To4() == nil {
+			family = "ipv6"
+			target = fmt.Sprintf("[%s]:%d", forward.TargetAddress, forward.TargetPort)
+		}
+
+		// Any of the host's addresses, unless told otherwise
+		destination := []string{"-m", "addrtype", "--dst-type", "LOCAL"}
+		if forward.ListenAddress != "" {
+			destination = []string{"-d", forward.ListenAddress}
+		}
+
+		// OUTPUT is for the connections from the host itself
+		for _, chain := range []string{"PREROUTING", "OUTPUT"} {
+			args := append([]string{"-p", forward.Protocol}, destination...)
+			args = append(args, "--dport", strconv.Itoa(forward.ListenPort), "-j", "DNAT", "--to-destination", target)
+			add(family, "nat", chain, args...)
+		}
+	}
+
+	return rules
+}
+
+// networkValidateForward checks a port forward of a managed network.
+func networkValidateForward(config map[string]string, forward shared.NetworkForward) error {
+	if forward.Protocol != "tcp" && forward.Protocol != "udp" {
+		return fmt.Errorf("Invalid protocol: '%s'", forward.Protocol)
+	}
+
+	for _, port := range []int{forward.ListenPort, forward.TargetPort} {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("Invalid port: %d", port)
+		}
+	}
+
+	target := net.ParseIP(forward.TargetAddress)
+	if target == nil {
+		return fmt.Errorf("Invalid target address: '%s'", forward.TargetAddress)
+	}
+
+	family := "ipv4"
+	if target.To4() == nil {
+		family = "ipv6"
+	}
+
+	_, subnet, err := networkAddress(config, family)
+	if err != nil {
+		return err
+	}
+
+	if subnet == nil || !subnet.Contains(target) {
+		return fmt.Errorf("%s isn't in the subnet of the network", target)
+	}
+
+	if forward.ListenAddress != "" {
+		listen := net.ParseIP(forward.ListenAddress)
+		if listen == nil || (listen.To4() == nil) != (target.To4() == nil) {
+			return fmt.Errorf("Invalid listen address: '%s'", forward.ListenAddress)
+		}
+	}
+
+	return nil
+}
+
+/*
+ * networkFirewallSet replaces the rules of a network by those its config
+ * calls for. They're inserted at the top of their chains, so that they
+ * apply even with a default drop policy.
+ */
+func networkFirewallSet(name string, config map[string]string, forwards []shared.NetworkForward) error {
+	if err := networkFirewallClear(name); err != nil {
+		return err
+	}
+
+	for _, rule := range networkRules(name, config, forwards) {
+		if err := rule.run("-I"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * networkFirewallClear removes all the rules tagged as belonging to a
+ * network, whichever configuration they were generated from.
+ */
+func networkFirewallClear(name string) error {
+	tag := fmt.Sprintf("--comment \"%s\"", networkRuleComment(name))
+
+	for _, family := range []string{"ipv4", "ipv6"} {
+		command := networkFirewallCommand(family)
+		if _, err := exec.LookPath(command); err != nil {
+			continue
+		}
+
+		for _, table := range []string{"filter", "nat"} {
+			// The table may not be supported by the kernel
+			output, err := exec.Command(command, "-t", table, "-S").Output()
+			if err != nil {
+				continue
+			}
+
+			for _, line := range strings.Split(string(output), "\n") {
+				if !strings.HasPrefix(line, "-A ") || !strings.Contains(line, tag) {
+					continue
+				}
+
+				args := networkSplitRule(line)
+				args[0] = "-D"
+				if err := networkExec(command, append([]string{"-t", table}, args...)...); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// networkSplitRule splits a rule as printed by iptables -S into arguments.
+func networkSplitRule(line string) []string {
+	args := []string{}
+	current := ""
+	quoted := false
+	started := false
+
+	for _, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+			started = true
+		case c == ' ' && !quoted:
+			if started {
+				args = append(args, current)
+			}
+			current = ""
+			started = false
+		default:
+			current += string(c)
+			started = true
+		}
+	}
+
+	if started {
+		args = append(args, current)
+	}
+
+	return args
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/lxc/lxd/shared"
+)
+
+func networkForwardsGet(d *Daemon, r *http.Request) Response {
+	name := mux.Vars(r)["name"]
+
+	if _, err := dbNetworkIDGet(d.db, name); err != nil {
+		return SmartError(err)
+	}
+
+	forwards, err := dbNetworkForwardsGet(d.db, name)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	if d.isRecursionRequest(r) {
+		return SyncResponse(true, forwards)
+	}
+
+	result := []string{}
+	for _, forward := range forwards {
+		result = append(result, fmt.Sprintf("/%s/networks/%s/forwards/%s", shared.APIVersion, name, forward.Name()))
+	}
+
+	return SyncResponse(true, result)
+}
+
+/*
+ * networkForwardsPost adds a port forward to a managed network. Each
+ * protocol and port can only be forwarded once per listen address across
+ * all the networks, and not on the port the API is served on.
+ */
+func networkForwardsPost(d *Daemon, r *http.Request) Response {
+	name := mux.Vars(r)["name"]
+
+	req := shared.NetworkForward{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return BadRequest(err)
+	}
+
+	config, err := dbNetworkConfigGet(d.db, name)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	if err := networkValidateForward(config, req); err != nil {
+		return BadRequest(err)
+	}
+
+	if req.ListenAddress != "" {
+		req.ListenAddress = net.ParseIP(req.ListenAddress).String()
+	}
+
+	httpsAddress, err := d.ConfigValueGet("core.https_address")
+	if err != nil {
+		return InternalError(err)
+	}
+
+	for _, address := range httpsAddresses(httpsAddress) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			continue
+		}
+
+		apiPort, err := strconv.Atoi(port)
+		if err != nil {
+			continue
+		}
+
+		api := shared.NetworkForward{Protocol: "tcp", ListenPort: apiPort}
+		if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+			api.ListenAddress = ip.String()
+		}
+
+		if req.Overlaps(api) {
+			return BadRequest(fmt.Errorf("Port %d is used by the API (core.https_address)", req.ListenPort))
+		}
+	}
+
+	networks, err := dbNetworks(d.db)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	for _, network := range networks {
+		forwards, err := dbNetworkForwardsGet(d.db, network)
+		if err != nil {
+			return InternalError(err)
+		}
+
+		for _, forward := range forwards {
+			if forward.Overlaps(req) {
+				return Conflict
+			}
+		}
+	}
+
+	if err := dbNetworkForwardAdd(d.db, name, req); err != nil {
+		return SmartError(err)
+	}
+
+	if err := networkForwardsApply(d, name, config); err != nil {
+		dbNetworkForwardDelete(d.db, name, req.Protocol, req.ListenAddress, req.ListenPort)
+		networkForwardsApply(d, name, config)
+		return InternalError(err)
+	}
+
+	return EmptySyncResponse
+}
+
+var networkForwardsCmd = Command{name: "networks/{name}/forwards", get: networkForwardsGet, post: networkForwardsPost}
+
+// networkForwardsApply regenerates the firewall rules of a network.
+func networkForwardsApply(d *Daemon, name string, config map[string]string) error {
+	forwards, err := dbNetworkForwardsGet(d.db, name)
+	if err != nil {
+		return err
+	}
+
+	return networkFirewallSet(name, config, forwards)
+}
+
+/*
+ * networkForwardParse splits the name of a forward, like "tcp:80" or
+ * "tcp:10.0.0.1:80", into its protocol, listen address and port.
+ */
+func networkForwardParse(value string) (string, string, int, error) {
+	fields := strings.SplitN(value, ":", 2)
+	if len(fields) != 2 {
+		return "", "", -1, fmt.Errorf("Invalid port forward: '%s'", value)
+	}
+
+	address := ""
+	port := fields[1]
+	if host, hostPort, err := net.SplitHostPort(fields[1]); err == nil {
+		address = host
+		port = hostPort
+	}
+
+	number, err := strconv.Atoi(port)
+	if err != nil {
+		return "", "", -1, fmt.Errorf("Invalid port forward: '%s'", value)
+	}
+
+	if address != "" {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return "", "", -1, fmt.Errorf("Invalid port forward: '%s'", value)
+		}
+		address = ip.String()
+	}
+
+	return fields[0], address, number, nil
+}
+
+func networkForwardGet(d *Daemon, r *http.Request) Response {
+	name := mux.Vars(r)["name"]
+
+	protocol, address, port, err := networkForwardParse(mux.Vars(r)["forward"])
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	if _, err := dbNetworkIDGet(d.db, name); err != nil {
+		return SmartError(err)
+	}
+
+	forwards, err := dbNetworkForwardsGet(d.db, name)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	for _, forward := range forwards {
+		if forward.Protocol == protocol && forward.ListenAddress == address && forward.ListenPort == port {
+			return SyncResponse(true, forward)
+		}
+	}
+
+	return NotFound
+}
+
+func networkForwardDelete(d *Daemon, r *http.Request) Response {
+	name := mux.Vars(r)["name"]
+
+	protocol, address, port, err := networkForwardParse(mux.Vars(r)["forward"])
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	config, err := dbNetworkConfigGet(d.db, name)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	if err := dbNetworkForwardDelete(d.db, name, protocol, address, port); err != nil {
+		return SmartError(err)
+	}
+
+	if err := networkForwardsApply(d, name, config); err != nil {
+		return InternalError(err)
+	}
+
+	return EmptySyncResponse
+}
+
+var networkForwardCmd = Command{name: "networks/{name}/forwards/{forward}", get: networkForwardGet, delete: networkForwardDelete}
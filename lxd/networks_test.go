@@ -0,0 +1,481 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+)
+
+func Test_network_valid_name(t *testing.T) {
+	for _, name := range []string{"lxdbr0", "br-test", "abcdefghijklmno"} {
+		if err := networkValidName(name); err != nil {
+			t.Errorf("%s was rejected: %s", name, err)
+		}
+	}
+
+	for _, name := range []string{"", "..", "a/b", "br 0", "abcdefghijklmnop"} {
+		if err := networkValidName(name); err == nil {
+			t.Errorf("%s was accepted", name)
+		}
+	}
+}
+
+func Test_network_validate_config(t *testing.T) {
+	valid := []map[string]string{
+		{},
+		{"ipv4.address": "10.0.3.1/24", "ipv4.nat": "true"},
+		{"ipv4.address": "none", "ipv6.address": "fd42::1/64", "ipv6.nat": "false"},
+		{"ipv6.address": "fd42::1/120", "ipv6.dhcp.stateful": "true"},
+		{"tunnel.site2.protocol": "gre", "tunnel.site2.local": "192.0.2.1", "tunnel.site2.remote": "192.0.2.2"},
+		{"tunnel.mc.protocol": "vxlan", "tunnel.mc.id": "10", "tunnel.mc.interface": "eth0"},
+	}
+
+	for _, config := range valid {
+		if err := networkValidateConfig("lxdbr0", config); err != nil {
+			t.Errorf("%v was rejected: %s", config, err)
+		}
+	}
+
+	invalid := []map[string]string{
+		{"foo": "bar"},
+		{"ipv4.nat": "yes"},
+		{"ipv4.address": "10.0.3.1"},
+		{"ipv4.address": "10.0.3.0/24"},
+		{"ipv4.address": "fd42::1/64"},
+		{"ipv6.address": "10.0.3.1/24"},
+		{"ipv4.address": "10.0.3.1/24", "ipv4.dhcp.ranges": "10.0.4.2-10.0.4.10"},
+		{"ipv4.address": "10.0.3.1/24", "ipv4.dhcp.ranges": "10.0.3.2"},
+		{"ipv4.address": "10.0.3.1/24", "ipv4.dhcp.ranges": "10.0.3.20-10.0.3.10"},
+		{"dns.mode": "dynamic"},
+		{"ipv6.address": "fd42::1/120"},
+		{"ipv6.address": "fd42::1/64", "ipv6.dhcp.ranges": "fd43::1-fd43::10"},
+		{"tunnel.site2.protocol": "ipip", "tunnel.site2.remote": "192.0.2.2"},
+		{"tunnel.site2.protocol": "gre", "tunnel.site2.remote": "192.0.2.2"},
+		{"tunnel.site2.protocol": "vxlan", "tunnel.site2.remote": "host2"},
+		{"tunnel.site2.protocol": "vxlan", "tunnel.site2.id": "-1"},
+		{"tunnel.toolonganame.protocol": "vxlan"},
+		{"tunnel.site2.remote": "192.0.2.2"},
+	}
+
+	for _, config := range invalid {
+		if err := networkValidateConfig("lxdbr0", config); err == nil {
+			t.Errorf("%v was accepted", config)
+		}
+	}
+}
+
+func Test_network_counters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lxd_network_counters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, value := range map[string]string{"rx_bytes": "1024\n", "tx_bytes": "2048\n", "rx_packets": "bad\n"} {
+		if err := ioutil.WriteFile(path.Join(dir, name), []byte(value), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	counters := networkCounters(dir)
+	expected := shared.NetworkCounters{BytesReceived: 1024, BytesSent: 2048}
+	if counters != expected {
+		t.Errorf("Got %v instead of %v", counters, expected)
+	}
+}
+
+func Test_network_tunnel_args(t *testing.T) {
+	config := map[string]string{
+		"tunnel.gre.protocol":   "gre",
+		"tunnel.gre.local":      "192.0.2.1",
+		"tunnel.gre.remote":     "192.0.2.2",
+		"tunnel.uni.protocol":   "vxlan",
+		"tunnel.uni.id":         "10",
+		"tunnel.uni.remote":     "192.0.2.3",
+		"tunnel.multi.protocol": "vxlan",
+		"tunnel.multi.port":     "8472",
+		"tunnel.multi.ttl":      "4",
+	}
+
+	if tunnels := networkTunnels(config); strings.Join(tunnels, " ") != "gre multi uni" {
+		t.Errorf("Wrong tunnels: %v", tunnels)
+	}
+
+	expected := map[string]string{
+		"gre":   "lxdbr0-gre type gretap local 192.0.2.1 remote 192.0.2.2",
+		"uni":   "lxdbr0-uni type vxlan id 10 remote 192.0.2.3 dstport 4789",
+		"multi": "lxdbr0-multi type vxlan id 0 group 239.0.0.1 dstport 8472 ttl 4",
+	}
+
+	for tunnel, args := range expected {
+		result, err := networkTunnelArgs("lxdbr0", tunnel, config)
+		if err != nil {
+			t.Errorf("%s: %s", tunnel, err)
+			continue
+		}
+
+		if strings.Join(result, " ") != args {
+			t.Errorf("%s: got %v instead of %s", tunnel, result, args)
+		}
+	}
+}
+
+func Test_network_check_device_address(t *testing.T) {
+	config := map[string]string{"ipv4.address": "10.0.3.1/24"}
+
+	for _, address := range []string{"10.0.3.10", "10.0.3.10/24"} {
+		if err := networkCheckDeviceAddress(config, address); err != nil {
+			t.Errorf("%s was rejected: %s", address, err)
+		}
+	}
+
+	for _, address := range []string{"10.0.4.10", "fd42::10"} {
+		if err := networkCheckDeviceAddress(config, address); err == nil {
+			t.Errorf("%s was accepted", address)
+		}
+	}
+}
+
+func Test_network_acl_rules(t *testing.T) {
+	rules, err := networkACLRules("lxdbr0", map[string]string{})
+	if err != nil || rules != nil {
+		t.Errorf("ACL without rules nor isolation: %v, %v", rules, err)
+	}
+
+	config := map[string]string{
+		"bridge.isolation":      "true",
+		"acl.rules":             "allow any 10.0.3.10 tcp 80, deny 10.0.3.0/28 fd42::10, allow fd42::/64 any",
+		"tunnel.site2.protocol": "gre",
+	}
+
+	if _, err := networkACLRules("lxdbr0", config); err == nil {
+		t.Errorf("Rule mixing IPv4 and IPv6 was accepted")
+	}
+
+	config["acl.rules"] = "allow any 10.0.3.10 tcp 80, deny any any udp"
+	rules, err = networkACLRules("lxdbr0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"-p ARP -j ACCEPT",
+		"-p IPv6 --ip6-proto ipv6-icmp --ip6-icmp-type 133:137 -j ACCEPT",
+		"-p IPv4 --ip-dst 10.0.3.10/32 --ip-proto tcp --ip-dport 80 -j ACCEPT",
+		"-p IPv4 --ip-proto udp -j DROP",
+		"-p IPv6 --ip6-proto udp -j DROP",
+		"-i lxdbr0-site2 -j RETURN",
+		"-o lxdbr0-site2 -j RETURN",
+		"-j DROP",
+	}
+
+	if len(rules) != len(expected) {
+		t.Fatalf("Got %v instead of %v", rules, expected)
+	}
+
+	for i := range rules {
+		if strings.Join(rules[i], " ") != expected[i] {
+			t.Errorf("Got %v instead of %s", rules[i], expected[i])
+		}
+	}
+
+	for _, invalid := range []string{"permit any any", "allow any", "allow any host", "allow any any icmp", "allow any any tcp 0"} {
+		if _, err := networkACLRules("lxdbr0", map[string]string{"acl.rules": invalid}); err == nil {
+			t.Errorf("%s was accepted", invalid)
+		}
+	}
+}
+
+func Test_network_rules(t *testing.T) {
+	config := map[string]string{"ipv4.address": "10.0.3.1/24", "ipv4.nat": "true", "ipv6.address": "fd42::1/64", "ipv6.firewall": "false"}
+
+	rules := networkRules("lxdbr0", config, nil)
+	if len(rules) != 6 {
+		t.Fatalf("Expected 6 rules, got %v", rules)
+	}
+
+	for _, rule := range rules {
+		if rule.family != "ipv4" {
+			t.Errorf("Rule despite ipv6.firewall=false: %v", rule)
+		}
+
+		if !strings.Contains(strings.Join(rule.args, " "), "--comment generated for LXD network lxdbr0") {
+			t.Errorf("Rule without the network's comment: %v", rule)
+		}
+	}
+
+	nat := rules[len(rules)-1]
+	if nat.table != "nat" || nat.chain != "POSTROUTING" ||
+		!strings.HasPrefix(strings.Join(nat.args, " "), "-s 10.0.3.0/24 ! -d 10.0.3.0/24 -j MASQUERADE") {
+		t.Errorf("Wrong NAT rule: %v", nat)
+	}
+}
+
+func Test_network_forward_rules(t *testing.T) {
+	forwards := []shared.NetworkForward{
+		{Protocol: "tcp", ListenPort: 80, TargetAddress: "10.0.3.10", TargetPort: 8080},
+		{Protocol: "udp", ListenAddress: "2001:db8::1", ListenPort: 53, TargetAddress: "fd42::10", TargetPort: 53},
+	}
+
+	rules := networkRules("lxdbr0", map[string]string{}, forwards)
+	if len(rules) != 4 {
+		t.Fatalf("Expected 4 rules, got %v", rules)
+	}
+
+	expected := []string{
+		"ipv4 PREROUTING -p tcp -m addrtype --dst-type LOCAL --dport 80 -j DNAT --to-destination 10.0.3.10:8080",
+		"ipv4 OUTPUT -p tcp -m addrtype --dst-type LOCAL --dport 80 -j DNAT --to-destination 10.0.3.10:8080",
+		"ipv6 PREROUTING -p udp -d 2001:db8::1 --dport 53 -j DNAT --to-destination [fd42::10]:53",
+		"ipv6 OUTPUT -p udp -d 2001:db8::1 --dport 53 -j DNAT --to-destination [fd42::10]:53",
+	}
+
+	for i, rule := range rules {
+		if rule.table != "nat" || !strings.HasPrefix(strings.Join(append([]string{rule.family, rule.chain}, rule.args...), " "), expected[i]) {
+			t.Errorf("Wrong rule: %v instead of %s", rule, expected[i])
+		}
+	}
+}
+
+func Test_network_validate_forward(t *testing.T) {
+	config := map[string]string{"ipv4.address": "10.0.3.1/24", "ipv6.address": "fd42::1/64"}
+
+	valid := []shared.NetworkForward{
+		{Protocol: "tcp", ListenPort: 80, TargetAddress: "10.0.3.10", TargetPort: 8080},
+		{Protocol: "udp", ListenAddress: "192.0.2.1", ListenPort: 53, TargetAddress: "10.0.3.10", TargetPort: 53},
+		{Protocol: "tcp", ListenPort: 443, TargetAddress: "fd42::10", TargetPort: 443},
+	}
+
+	for _, forward := range valid {
+		if err := networkValidateForward(config, forward); err != nil {
+			t.Errorf("%v was rejected: %s", forward, err)
+		}
+	}
+
+	invalid := []shared.NetworkForward{
+		{Protocol: "icmp", ListenPort: 80, TargetAddress: "10.0.3.10", TargetPort: 80},
+		{Protocol: "tcp", ListenPort: 0, TargetAddress: "10.0.3.10", TargetPort: 80},
+		{Protocol: "tcp", ListenPort: 80, TargetAddress: "10.0.3.10", TargetPort: 65536},
+		{Protocol: "tcp", ListenPort: 80, TargetAddress: "10.0.4.10", TargetPort: 80},
+		{Protocol: "tcp", ListenPort: 80, TargetAddress: "c1", TargetPort: 80},
+		{Protocol: "tcp", ListenAddress: "2001:db8::1", ListenPort: 80, TargetAddress: "10.0.3.10", TargetPort: 80},
+	}
+
+	for _, forward := range invalid {
+		if err := networkValidateForward(config, forward); err == nil {
+			t.Errorf("%v was accepted", forward)
+		}
+	}
+}
+
+func Test_network_forward_parse(t *testing.T) {
+	forwards := []shared.NetworkForward{
+		{Protocol: "tcp", ListenPort: 80},
+		{Protocol: "udp", ListenAddress: "192.0.2.1", ListenPort: 53},
+		{Protocol: "tcp", ListenAddress: "2001:db8::1", ListenPort: 443},
+	}
+
+	for _, forward := range forwards {
+		protocol, address, port, err := networkForwardParse(forward.Name())
+		if err != nil {
+			t.Errorf("%s: %s", forward.Name(), err)
+		} else if protocol != forward.Protocol || address != forward.ListenAddress || port != forward.ListenPort {
+			t.Errorf("%s: got %s, %s, %d", forward.Name(), protocol, address, port)
+		}
+	}
+
+	for _, name := range []string{"tcp", "tcp:http", "tcp:somehost:80"} {
+		if _, _, _, err := networkForwardParse(name); err == nil {
+			t.Errorf("%s was accepted", name)
+		}
+	}
+}
+
+func Test_network_forward_overlaps(t *testing.T) {
+	all := shared.NetworkForward{Protocol: "tcp", ListenPort: 80}
+	one := shared.NetworkForward{Protocol: "tcp", ListenAddress: "192.0.2.1", ListenPort: 80}
+	other := shared.NetworkForward{Protocol: "tcp", ListenAddress: "192.0.2.2", ListenPort: 80}
+	udp := shared.NetworkForward{Protocol: "udp", ListenPort: 80}
+
+	if !all.Overlaps(one) || !one.Overlaps(all) || !one.Overlaps(one) {
+		t.Error("Forwards of the same port don't overlap")
+	}
+
+	if one.Overlaps(other) || all.Overlaps(udp) {
+		t.Error("Forwards of different addresses or protocols overlap")
+	}
+}
+
+func Test_network_split_rule(t *testing.T) {
+	line := `-A POSTROUTING -s 10.0.3.0/24 ! -d 10.0.3.0/24 -m comment --comment "generated for LXD network lxdbr0" -j MASQUERADE`
+	args := networkSplitRule(line)
+
+	expected := []string{"-A", "POSTROUTING", "-s", "10.0.3.0/24", "!", "-d", "10.0.3.0/24", "-m", "comment", "--comment", "generated for LXD network lxdbr0", "-j", "MASQUERADE"}
+	if strings.Join(args, "|") != strings.Join(expected, "|") {
+		t.Errorf("Wrong split: %q", args)
+	}
+}
+
+func Test_network_dhcp_ranges(t *testing.T) {
+	tests := []struct {
+		config map[string]string
+		ranges string
+	}{
+		{map[string]string{}, ""},
+		{map[string]string{"ipv4.address": "10.0.3.1/24"}, "10.0.3.2,10.0.3.254"},
+		{map[string]string{"ipv4.address": "10.0.3.254/24"}, "10.0.3.1,10.0.3.253"},
+		{map[string]string{"ipv4.address": "10.0.0.1/16"}, "10.0.0.2,10.0.255.254"},
+		{map[string]string{"ipv4.address": "10.0.0.1/31"}, "10.0.0.0,10.0.0.0"},
+		{map[string]string{"ipv4.address": "10.0.3.1/24", "ipv4.dhcp.ranges": "10.0.3.10-10.0.3.20, 10.0.3.50-10.0.3.60"}, "10.0.3.10,10.0.3.20 10.0.3.50,10.0.3.60"},
+		{map[string]string{"ipv6.address": "fd42::1/64"}, "fd42::2,fd42::ffff:ffff:ffff:ffff"},
+		{map[string]string{"ipv6.address": "fd42::1/120", "ipv6.dhcp.ranges": "fd42::10-fd42::20"}, "fd42::10,fd42::20"},
+	}
+
+	for _, test := range tests {
+		family := "ipv4"
+		if test.config["ipv6.address"] != "" {
+			family = "ipv6"
+		}
+
+		ranges, err := networkDHCPRanges(test.config, family)
+		if err != nil {
+			t.Errorf("%v: %s", test.config, err)
+			continue
+		}
+
+		if strings.Join(ranges, " ") != test.ranges {
+			t.Errorf("%v: got %v instead of %s", test.config, ranges, test.ranges)
+		}
+	}
+}
+
+func Test_network_dnsmasq_args(t *testing.T) {
+	args, err := networkDnsmasqArgs("lxdbr0", map[string]string{"dns.mode": "none"})
+	if err != nil || args != nil {
+		t.Errorf("dnsmasq run without DHCP nor DNS: %v, %v", args, err)
+	}
+
+	args, err = networkDnsmasqArgs("lxdbr0", map[string]string{"ipv4.address": "10.0.3.1/24", "dns.domain": "example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	joined := strings.Join(args, " ")
+	for _, arg := range []string{"--interface=lxdbr0", "--domain=example", "--dhcp-range=10.0.3.2,10.0.3.254,1h"} {
+		if !strings.Contains(joined, arg) {
+			t.Errorf("%s is missing from %s", arg, joined)
+		}
+	}
+
+	if strings.Contains(joined, "--enable-ra") {
+		t.Errorf("Router advertisements without IPv6: %s", joined)
+	}
+
+	args, err = networkDnsmasqArgs("lxdbr0", map[string]string{"ipv6.address": "fd42::1/64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	joined = strings.Join(args, " ")
+	for _, arg := range []string{"--enable-ra", "--dhcp-range=fd42::,ra-stateless,ra-names,64"} {
+		if !strings.Contains(joined, arg) {
+			t.Errorf("%s is missing from %s", arg, joined)
+		}
+	}
+
+	args, err = networkDnsmasqArgs("lxdbr0", map[string]string{"ipv6.address": "fd42::1/64", "ipv6.dhcp.stateful": "true", "ipv6.dhcp.ranges": "fd42::10-fd42::20"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if joined = strings.Join(args, " "); !strings.Contains(joined, "--dhcp-range=fd42::10,fd42::20,64,1h") {
+		t.Errorf("Stateful DHCPv6 range missing from %s", joined)
+	}
+}
+
+func Test_network_parse_leases(t *testing.T) {
+	content := `1466359992 00:16:3e:2c:89:d9 10.0.3.72 c1 01:00:16:3e:2c:89:d9
+0 00:16:3e:00:00:02 10.0.3.10 * *
+duid 00:01:00:01:1e:e4:3b:7a:52:54:00:12:34:56
+1466359992 1150493274 fd42::72 c1 00:01:00:01:1e:e4:3b:7a:52:54:00:12:34:56
+`
+
+	leases := networkParseLeases(content)
+	expected := []shared.NetworkLease{
+		{Hostname: "c1", Hwaddr: "00:16:3e:2c:89:d9", Address: "10.0.3.72", Expiry: time.Unix(1466359992, 0).UTC()},
+		{Hwaddr: "00:16:3e:00:00:02", Address: "10.0.3.10"},
+		{Hostname: "c1", Address: "fd42::72", Expiry: time.Unix(1466359992, 0).UTC()},
+	}
+
+	if len(leases) != len(expected) {
+		t.Fatalf("Got %v instead of %v", leases, expected)
+	}
+
+	for i := range leases {
+		if leases[i] != expected[i] {
+			t.Errorf("Got %v instead of %v", leases[i], expected[i])
+		}
+	}
+}
+
+func Test_network_dhcp_host(t *testing.T) {
+	config := map[string]string{"ipv4.address": "10.0.3.1/24", "ipv6.address": "fd42::1/64"}
+	device := shared.Device{"type": "nic", "parent": "lxdbr0", "hwaddr": "00:16:3e:00:00:01"}
+
+	entry, err := networkDHCPHost(config, "", device)
+	if err != nil || entry != "" {
+		t.Errorf("Entry without a name nor a static address: %s, %v", entry, err)
+	}
+
+	entry, err = networkDHCPHost(config, "c1", device)
+	if err != nil || entry != "00:16:3e:00:00:01,c1" {
+		t.Errorf("Wrong name registration: %s, %v", entry, err)
+	}
+
+	device["ipv4.address"] = "10.0.3.10"
+	device["ipv6.address"] = "fd42::10"
+	entry, err = networkDHCPHost(config, "c1", device)
+	if err != nil || entry != "00:16:3e:00:00:01,10.0.3.10,[fd42::10],c1" {
+		t.Errorf("Wrong reservation: %s, %v", entry, err)
+	}
+
+	entry, err = networkDHCPHost(config, "", device)
+	if err != nil || entry != "00:16:3e:00:00:01,10.0.3.10,[fd42::10]" {
+		t.Errorf("Wrong reservation of a stopped container: %s, %v", entry, err)
+	}
+
+	device["ipv4.address"] = "10.0.4.10"
+	if _, err := networkDHCPHost(config, "c1", device); err == nil {
+		t.Errorf("Address outside of the subnet was reserved")
+	}
+}
+
+func Test_network_free_subnet(t *testing.T) {
+	used := []*net.IPNet{}
+	for _, cidr := range []string{"10.0.0.5/24", "10.0.1.0/24", "10.0.2.0/23", "192.168.1.10/24"} {
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		used = append(used, subnet)
+	}
+
+	subnet, err := networkFreeSubnet(used)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if subnet.String() != "10.0.4.0/24" {
+		t.Errorf("Got %s instead of 10.0.4.0/24", subnet)
+	}
+
+	_, all, _ := net.ParseCIDR("10.0.0.0/8")
+	if _, err := networkFreeSubnet([]*net.IPNet{all}); err == nil {
+		t.Errorf("Got a subnet in a used 10.0.0.0/8")
+	}
+}
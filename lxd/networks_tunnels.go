@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lxc/lxd/shared"
+)
+
+// networkTunnels returns the names of the tunnels of a network, sorted.
+func networkTunnels(config map[string]string) []string {
+	names := []string{}
+	for key := range config {
+		if !strings.HasPrefix(key, "tunnel.") {
+			continue
+		}
+
+		fields := strings.SplitN(strings.TrimPrefix(key, "tunnel."), ".", 2)
+		if len(fields) == 2 && !shared.StringInSlice(fields[0], names) {
+			names = append(names, fields[0])
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// networkTunnelDevice returns the interface of a tunnel, added to the bridge.
+func networkTunnelDevice(bridge string, tunnel string) string {
+	return fmt.Sprintf("%s-%s", bridge, tunnel)
+}
+
+/*
+ * networkTunnelArgs returns the arguments to "ip link add" creating a
+ * tunnel: a gretap interface between two hosts, or a vxlan one, either
+ * unicast to a remote or multicast to a group.
+ */
+func networkTunnelArgs(bridge string, tunnel string, config map[string]string) ([]string, error) {
+	get := func(key string) string {
+		return config[fmt.Sprintf("tunnel.%s.%s", tunnel, key)]
+	}
+
+	if err := networkValidName(networkTunnelDevice(bridge, tunnel)); err != nil {
+		return nil, fmt.Errorf("Invalid tunnel name: '%s'", tunnel)
+	}
+
+	for _, key := range []string{"local", "remote", "group"} {
+		if get(key) != "" && net.ParseIP(get(key)) == nil {
+			return nil, fmt.Errorf("Bad value for tunnel.%s.%s: '%s'", tunnel, key, get(key))
+		}
+	}
+
+	for _, key := range []string{"id", "port", "ttl"} {
+		if _, err := strconv.ParseUint(get(key), 10, 32); get(key) != "" && err != nil {
+			return nil, fmt.Errorf("Bad value for tunnel.%s.%s: '%s'", tunnel, key, get(key))
+		}
+	}
+
+	args := []string{networkTunnelDevice(bridge, tunnel)}
+	switch get("protocol") {
+	case "gre":
+		if get("local") == "" || get("remote") == "" {
+			return nil, fmt.Errorf("GRE tunnel %s needs a local and a remote address", tunnel)
+		}
+
+		args = append(args, "type", "gretap", "local", get("local"), "remote", get("remote"))
+	case "vxlan":
+		id := get("id")
+		if id == "" {
+			id = "0"
+		}
+
+		args = append(args, "type", "vxlan", "id", id)
+		if get("local") != "" {
+			args = append(args, "local", get("local"))
+		}
+
+		if get("remote") != "" {
+			args = append(args, "remote", get("remote"))
+		} else {
+			group := get("group")
+			if group == "" {
+				group = "239.0.0.1"
+			}
+
+			args = append(args, "group", group)
+			if get("interface") != "" {
+				args = append(args, "dev", get("interface"))
+			}
+		}
+
+		// The IANA port rather than the kernel's default one
+		port := get("port")
+		if port == "" {
+			port = "4789"
+		}
+		args = append(args, "dstport", port)
+
+		if get("ttl") != "" {
+			args = append(args, "ttl", get("ttl"))
+		}
+	default:
+		return nil, fmt.Errorf("Bad value for tunnel.%s.protocol: '%s'", tunnel, get("protocol"))
+	}
+
+	return args, nil
+}
+
+// networkTunnelKinds are the types of the interfaces networkTunnelArgs
+// creates.
+var networkTunnelKinds = []string{"gretap", "vxlan"}
+
+/*
+ * networkIsTunnel tells whether an interface of a bridge is one of the
+ * tunnels LXD added to it: named after the bridge and of one of the types
+ * LXD creates, rather than something else plugged into it.
+ */
+func networkIsTunnel(bridge string, iface string) bool {
+	if !strings.HasPrefix(iface, bridge+"-") || len(iface) == len(bridge)+1 {
+		return false
+	}
+
+	output, err := exec.Command("ip", "-d", "-o", "link", "show", "dev", iface).Output()
+	if err != nil {
+		return false
+	}
+
+	for _, field := range strings.Fields(string(output)) {
+		if shared.StringInSlice(field, networkTunnelKinds) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// networkTunnelsClear removes the tunnels added to a bridge.
+func networkTunnelsClear(bridge string) error {
+	ifaces, err := shared.ReadDir(path.Join("/sys/class/net", bridge, "brif"))
+	if err != nil {
+		return nil
+	}
+
+	for _, iface := range ifaces {
+		if !networkIsTunnel(bridge, iface) {
+			continue
+		}
+
+		if err := networkExec("ip", "link", "del", iface); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * networkTunnelsUp recreates the tunnels of a network and adds them to its
+ * bridge, joining it with those of other hosts.
+ */
+func networkTunnelsUp(bridge string, config map[string]string) error {
+	if err := networkTunnelsClear(bridge); err != nil {
+		return err
+	}
+
+	for _, tunnel := range networkTunnels(config) {
+		args, err := networkTunnelArgs(bridge, tunnel, config)
+		if err != nil {
+			return err
+		}
+
+		if err := networkExec("ip", append([]string{"link", "add"}, args...)...); err != nil {
+			return err
+		}
+
+		device := networkTunnelDevice(bridge, tunnel)
+		if err := networkExec("ip", "link", "set", device, "master", bridge); err != nil {
+			return err
+		}
+
+		if err := networkExec("ip", "link", "set", device, "up"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
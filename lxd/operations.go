@@ -1,14 +1,19 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/satori/go.uuid"
+	log "gopkg.in/inconshreveable/log15.v2"
 
 	"github.com/lxc/lxd/shared"
 )
@@ -16,6 +21,97 @@ import (
 var lock sync.Mutex
 var operations map[string]*shared.Operation = make(map[string]*shared.Operation)
 
+// operationsDB is where the operations get recorded, so that they survive
+// a restart of the daemon. It's set by operationsInit.
+var operationsDB *sql.DB
+
+/*
+ * operationsChanged holds, for each operation, a channel which gets closed
+ * the next time the operation is updated, waking up whoever waits on it.
+ */
+var operationsChanged = map[string]chan bool{}
+
+/*
+ * operationsByResource indexes the operations which haven't finished yet by
+ * the URL of each resource they touch, as well as by those of the resources
+ * it's part of, so that a container's operations include its snapshots'.
+ */
+var operationsByResource = map[string]map[string]bool{}
+
+// operationsHistoryExpiry is how long operations are kept in the database
+// after their last update.
+const operationsHistoryExpiry = 24 * time.Hour
+
+/*
+ * The operations of some classes can be limited in number by a server key,
+ * those over the limit staying pending until one of the running ones
+ * finishes, in the order they were queued. operationClassKeys maps those
+ * classes to their key.
+ */
+const (
+	operationClassImageImport       = "image_import"
+	operationClassMigration         = "migration"
+	operationClassContainerCreation = "container_creation"
+)
+
+var operationClassKeys = map[string]string{
+	operationClassImageImport:       "core.concurrent_image_imports",
+	operationClassMigration:         "core.concurrent_migrations",
+	operationClassContainerCreation: "core.concurrent_container_creations",
+}
+
+// operationsLimits holds the limit of each class, 0 for none.
+var operationsLimits = map[string]int{}
+
+// operationsRunning counts the running operations of each class.
+var operationsRunning = map[string]int{}
+
+// operationsQueue holds the operations of each class waiting for a slot.
+var operationsQueue = map[string][]string{}
+
+// operationsClasses holds the class of the operations which have one.
+var operationsClasses = map[string]string{}
+
+// operationsRequests holds the ID of the request which created each
+// operation, which its log lines carry.
+var operationsRequests = map[string]string{}
+
+// operationsSlotFreed wakes up the queued operations, with the lock held.
+var operationsSlotFreed = sync.NewCond(&lock)
+
+/*
+ * operationsInit loads the operations recorded by the previous runs of the
+ * daemon. Those which hadn't finished can't be resumed, so they are marked
+ * as failed, letting any client still waiting on them know.
+ */
+func operationsInit(d *Daemon) error {
+	err := dbOperationsPrune(d.db, time.Now().Add(-operationsHistoryExpiry))
+	if err != nil {
+		return err
+	}
+
+	ops, err := dbOperationsGet(d.db)
+	if err != nil {
+		return err
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	operationsDB = d.db
+	for id, op := range ops {
+		op.Chan = make(chan bool, 1)
+		if !op.StatusCode.IsFinal() {
+			op.SetResult(shared.OperationError(fmt.Errorf("Operation interrupted by a daemon restart")))
+			operationUpdated(id, op)
+		}
+
+		operations[id] = op
+	}
+
+	return nil
+}
+
 func createOperation(metadata shared.Jmap, resources map[string][]string, run func() shared.OperationResult, cancel func() error, ws shared.OperationWebsocket) (string, error) {
 	id := uuid.NewV4().String()
 	op := shared.Operation{}
@@ -52,10 +148,62 @@ func createOperation(metadata shared.Jmap, resources map[string][]string, run fu
 
 	lock.Lock()
 	operations[url] = &op
+	operationIndex(url, &op)
+	operationUpdated(url, &op)
 	lock.Unlock()
+
 	return url, nil
 }
 
+/*
+ * operationResourceURLs returns the URL of a resource followed by those of
+ * the resources it's part of, down to /1.0/<collection>/<name>.
+ */
+func operationResourceURLs(url string) []string {
+	prefix := "/" + shared.APIVersion + "/"
+	fields := strings.Split(strings.TrimPrefix(url, prefix), "/")
+	if !strings.HasPrefix(url, prefix) || len(fields) < 2 {
+		return []string{url}
+	}
+
+	urls := []string{}
+	for i := len(fields); i >= 2; i-- {
+		urls = append(urls, prefix+strings.Join(fields[:i], "/"))
+	}
+
+	return urls
+}
+
+// operationIndex adds an operation to operationsByResource, it must be
+// called with the operations lock held.
+func operationIndex(id string, op *shared.Operation) {
+	for _, values := range op.Resources {
+		for _, value := range values {
+			for _, url := range operationResourceURLs(value) {
+				if operationsByResource[url] == nil {
+					operationsByResource[url] = map[string]bool{}
+				}
+				operationsByResource[url][id] = true
+			}
+		}
+	}
+}
+
+// operationUnindex removes an operation from operationsByResource, it must
+// be called with the operations lock held.
+func operationUnindex(id string, op *shared.Operation) {
+	for _, values := range op.Resources {
+		for _, value := range values {
+			for _, url := range operationResourceURLs(value) {
+				delete(operationsByResource[url], id)
+				if len(operationsByResource[url]) == 0 {
+					delete(operationsByResource, url)
+				}
+			}
+		}
+	}
+}
+
 func startOperation(id string) error {
 	lock.Lock()
 	op, ok := operations[id]
@@ -64,29 +212,486 @@ func startOperation(id string) error {
 		return fmt.Errorf("operation %s doesn't exist", id)
 	}
 
+	class := operationsClasses[id]
 	if op.Run != nil {
-		go func(op *shared.Operation) {
-			result := op.Run()
+		if class != "" {
+			operationsQueue[class] = append(operationsQueue[class], id)
+		}
+
+		go func(op *shared.Operation, run func() shared.OperationResult) {
+			if class != "" && !operationWaitSlot(id, op, class) {
+				return
+			}
 
-			shared.Debugf("Operation %s finished: %s", op.Run, result)
+			result := run()
 
 			lock.Lock()
-			op.SetResult(result)
+			if shared.Log != nil {
+				operationLog(id).Debug("operation finished", log.Ctx{"result": result})
+			}
+			delete(operationsRequests, id)
+			if class != "" {
+				operationsRunning[class]--
+				delete(operationsClasses, id)
+				operationsSlotFreed.Broadcast()
+			}
+
+			cancelled := op.StatusCode == shared.Cancelling || op.StatusCode == shared.Cancelled
+			if cancelled && result.Error != nil {
+				/* The run function was interrupted by a cancel
+				 * request, report that rather than the error it
+				 * failed with. One which was done by the time
+				 * the cancel got to it keeps its result. */
+				op.SetStatus(shared.Cancelled)
+				op.Chan <- true
+			} else {
+				op.SetResult(result)
+			}
+			operationUpdated(id, op)
 			lock.Unlock()
-		}(op)
+		}(op, op.Run)
+	}
+
+	// Queued operations are running once they get a slot
+	if class == "" || op.Run == nil {
+		op.SetStatus(shared.Running)
+		operationUpdated(id, op)
+	}
+	lock.Unlock()
+
+	return nil
+}
+
+// operationRequestSet records the request which created an operation.
+func operationRequestSet(id string, requestID string) {
+	lock.Lock()
+	operationsRequests[id] = requestID
+	lock.Unlock()
+}
+
+// operationLog returns the logger for an operation, tagging the lines with
+// the operation and the request which created it. The lock must be held.
+func operationLog(id string) log.Logger {
+	ctx := log.Ctx{"operation": id}
+	if requestID, ok := operationsRequests[id]; ok {
+		ctx["request"] = requestID
+	}
+
+	return shared.Log.New(ctx)
+}
+
+// operationClassSet makes an operation, not started yet, one of a class.
+func operationClassSet(id string, class string) {
+	lock.Lock()
+	operationsClasses[id] = class
+	lock.Unlock()
+}
+
+/*
+ * operationWaitSlot waits for an operation to be first in the queue of its
+ * class with the limit not reached, then makes it running. It returns
+ * false, removing the operation from the queue, if the operation got
+ * cancelled in the meantime.
+ */
+func operationWaitSlot(id string, op *shared.Operation, class string) bool {
+	lock.Lock()
+	defer lock.Unlock()
+
+	for {
+		if op.StatusCode.IsFinal() {
+			break
+		}
+
+		// Being cancelled, it will soon be final
+		limit := operationsLimits[class]
+		if op.StatusCode != shared.Cancelling && operationsQueue[class][0] == id && (limit <= 0 || operationsRunning[class] < limit) {
+			break
+		}
+
+		operationsSlotFreed.Wait()
+	}
+
+	queue := operationsQueue[class]
+	for i, queued := range queue {
+		if queued == id {
+			operationsQueue[class] = append(queue[:i:i], queue[i+1:]...)
+			break
+		}
+	}
+
+	// The next one may be able to go too
+	operationsSlotFreed.Broadcast()
+
+	if op.StatusCode.IsFinal() {
+		delete(operationsClasses, id)
+		return false
 	}
 
+	operationsRunning[class]++
 	op.SetStatus(shared.Running)
+	operationUpdated(id, op)
+
+	return true
+}
+
+/*
+ * operationClassAcquire takes a slot of a class for a step of an operation
+ * which isn't of that class as a whole, e.g. each container of a batch. It
+ * waits for the limit to allow it, letting the queued operations of the
+ * class go first, and returns false if the canceller gets cancelled in the
+ * meantime. Otherwise the slot is to be given back with
+ * operationClassRelease.
+ */
+func operationClassAcquire(class string, canceller *operationCanceller) bool {
+	// The canceller isn't to be used with the lock held, see Cancel
+	cancelled := false
+	done := canceller.OnCancel(func() {
+		lock.Lock()
+		cancelled = true
+		operationsSlotFreed.Broadcast()
+		lock.Unlock()
+	})
+	defer done()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	for !cancelled {
+		limit := operationsLimits[class]
+		if len(operationsQueue[class]) == 0 && (limit <= 0 || operationsRunning[class] < limit) {
+			operationsRunning[class]++
+			return true
+		}
+
+		operationsSlotFreed.Wait()
+	}
+
+	return false
+}
+
+// operationClassRelease gives back a slot taken by operationClassAcquire.
+func operationClassRelease(class string) {
+	lock.Lock()
+	operationsRunning[class]--
+	operationsSlotFreed.Broadcast()
 	lock.Unlock()
+}
+
+// operationsLimitsLoad applies the limits of the operation classes set in
+// the server configuration.
+func operationsLimitsLoad(d *Daemon) {
+	limits := map[string]int{}
+	for class, key := range operationClassKeys {
+		value, err := d.ConfigValueGet(key)
+		if err != nil || value == "" {
+			continue
+		}
+
+		limits[class], _ = strconv.Atoi(value)
+	}
+
+	lock.Lock()
+	operationsLimits = limits
+	operationsSlotFreed.Broadcast()
+	lock.Unlock()
+}
+
+// operationUpdated notifies the event listeners of an operation's
+// current state and records it in the database, it must be called with
+// the operations lock held.
+func operationUpdated(id string, op *shared.Operation) {
+	eventSend("operations", id, op)
+
+	if op.StatusCode.IsFinal() {
+		operationUnindex(id, op)
+	}
+
+	if changed, ok := operationsChanged[id]; ok {
+		close(changed)
+		delete(operationsChanged, id)
+	}
+
+	if operationsDB == nil {
+		return
+	}
+
+	if err := dbOperationSave(operationsDB, id, op); err != nil {
+		shared.Log.Error("Failed to record the operation", log.Ctx{"operation": id, "err": err})
+	}
+}
+
+// operationsActive returns the number of operations which haven't
+// finished yet.
+func operationsActive() int {
+	lock.Lock()
+	defer lock.Unlock()
+
+	count := 0
+	for _, op := range operations {
+		if !op.StatusCode.IsFinal() {
+			count++
+		}
+	}
+
+	return count
+}
+
+var errOperationCancelled = fmt.Errorf("Operation cancelled")
+
+/*
+ * operationCanceller lets a long running operation be interrupted. As it
+ * goes, the operation's run function registers hooks to abort its current
+ * step (closing a download, killing a process, ...) and to clean up what
+ * it left behind (partial files, build directories, ...). Cancel, used as
+ * the operation's cancel function, then runs the registered hooks, most
+ * recent first.
+ */
+type operationCanceller struct {
+	lock      sync.Mutex
+	cancelled bool
+	nextID    int
+	hooks     map[int]func()
+}
+
+/*
+ * OnCancel registers a hook to run if the operation gets cancelled, or
+ * runs it right away if it already was. The returned function unregisters
+ * the hook, once the step it covers is over.
+ */
+func (c *operationCanceller) OnCancel(hook func()) func() {
+	if c == nil {
+		return func() {}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.cancelled {
+		hook()
+		return func() {}
+	}
+
+	if c.hooks == nil {
+		c.hooks = map[int]func(){}
+	}
+
+	id := c.nextID
+	c.nextID++
+	c.hooks[id] = hook
+
+	return func() {
+		c.lock.Lock()
+		delete(c.hooks, id)
+		c.lock.Unlock()
+	}
+}
+
+// Cancelled returns whether the operation was cancelled.
+func (c *operationCanceller) Cancelled() bool {
+	if c == nil {
+		return false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.cancelled
+}
+
+// Cancel runs the registered hooks, most recent first.
+func (c *operationCanceller) Cancel() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.cancelled = true
+	for id := c.nextID - 1; id >= 0; id-- {
+		if hook, ok := c.hooks[id]; ok {
+			hook()
+		}
+	}
+	c.hooks = nil
 
 	return nil
 }
 
+// How often at most the progress of an operation gets published.
+const operationProgressInterval = time.Second
+
+/*
+ * operationProgress publishes how far a long running operation got, in the
+ * "progress" key of its metadata (see shared.OperationProgress). It gets
+ * bound to its operation by the asyncResponse it's part of; until then, or
+ * if nil, updating it is a no-op.
+ */
+type operationProgress struct {
+	lock       sync.Mutex
+	id         string
+	progress   shared.OperationProgress
+	stageStart time.Time
+	lastSent   time.Time
+}
+
+func (p *operationProgress) bind(id string) {
+	if p == nil {
+		return
+	}
+
+	p.lock.Lock()
+	p.id = id
+	p.lock.Unlock()
+}
+
+// Stage starts a new step of the operation, which will process total bytes
+// (zero if that isn't known).
+func (p *operationProgress) Stage(stage string, total int64) {
+	if p == nil {
+		return
+	}
+
+	if total < 0 {
+		total = 0
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.progress = shared.OperationProgress{Stage: stage, Total: total}
+	p.stageStart = time.Now()
+	p.publish(true)
+}
+
+// Add accounts for n more bytes processed by the current stage.
+func (p *operationProgress) Add(n int64) {
+	if p == nil || n <= 0 {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.progress.Processed += n
+	if p.progress.Total > 0 {
+		p.progress.Percent = int(p.progress.Processed * 100 / p.progress.Total)
+		if p.progress.Percent > 100 {
+			p.progress.Percent = 100
+		}
+	}
+
+	if elapsed := time.Since(p.stageStart).Seconds(); elapsed > 0 {
+		p.progress.Speed = int64(float64(p.progress.Processed) / elapsed)
+	}
+
+	p.publish(false)
+}
+
+func (p *operationProgress) publish(force bool) {
+	if p.id == "" || (!force && time.Since(p.lastSent) < operationProgressInterval) {
+		return
+	}
+	p.lastSent = time.Now()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	op, ok := operations[p.id]
+	if !ok || op.StatusCode.IsFinal() {
+		return
+	}
+
+	md := shared.Jmap{}
+	if op.Metadata != nil {
+		if err := json.Unmarshal(op.Metadata, &md); err != nil || md == nil {
+			md = shared.Jmap{}
+		}
+	}
+	md["progress"] = p.progress
+
+	out, err := json.Marshal(md)
+	if err != nil {
+		return
+	}
+
+	op.Metadata = out
+	op.UpdatedAt = time.Now()
+	operationUpdated(p.id, op)
+}
+
+// Reader wraps r so that the bytes read from it count as processed.
+func (p *operationProgress) Reader(r io.Reader) io.Reader {
+	return &progressReader{r, p}
+}
+
+// Writer wraps w so that the bytes written to it count as processed.
+func (p *operationProgress) Writer(w io.Writer) io.Writer {
+	return &progressWriter{w, p}
+}
+
+type progressReader struct {
+	io.Reader
+	progress *operationProgress
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.progress.Add(int64(n))
+	return n, err
+}
+
+type progressWriter struct {
+	io.Writer
+	progress *operationProgress
+}
+
+func (w *progressWriter) Write(b []byte) (int, error) {
+	n, err := w.Writer.Write(b)
+	w.progress.Add(int64(n))
+	return n, err
+}
+
+/*
+ * operationsMatching returns the operations touching the resource at url,
+ * or all of them if url is empty. It must be called with the operations
+ * lock held.
+ */
+func operationsMatching(url string) map[string]*shared.Operation {
+	if url == "" {
+		return operations
+	}
+
+	matching := map[string]*shared.Operation{}
+	for id := range operationsByResource[strings.TrimSuffix(url, "/")] {
+		if op, ok := operations[id]; ok {
+			matching[id] = op
+		}
+	}
+
+	return matching
+}
+
 func operationsGet(d *Daemon, r *http.Request) Response {
+	resource := r.FormValue("resource")
+
+	if d.isRecursionRequest(r) {
+		ops := map[string][]shared.Operation{"pending": {}, "running": {}}
+
+		lock.Lock()
+		for _, v := range operationsMatching(resource) {
+			switch v.StatusCode {
+			case shared.Pending:
+				ops["pending"] = append(ops["pending"], *v)
+			case shared.Running:
+				ops["running"] = append(ops["running"], *v)
+			}
+		}
+		lock.Unlock()
+
+		return SyncResponse(true, ops)
+	}
+
 	ops := shared.Jmap{"pending": make([]string, 0, 0), "running": make([]string, 0, 0)}
 
 	lock.Lock()
-	for k, v := range operations {
+	for k, v := range operationsMatching(resource) {
 		switch v.StatusCode {
 		case shared.Pending:
 			ops["pending"] = append(ops["pending"].([]string), k)
@@ -123,7 +728,8 @@ func operationDelete(d *Daemon, r *http.Request) Response {
 		return NotFound
 	}
 
-	if op.Cancel == nil && op.Run != nil {
+	// Queued operations can always be cancelled, they didn't start yet
+	if op.Cancel == nil && op.Run != nil && op.StatusCode != shared.Pending {
 		lock.Unlock()
 		return BadRequest(fmt.Errorf("Can't cancel %s!", id))
 	}
@@ -136,6 +742,10 @@ func operationDelete(d *Daemon, r *http.Request) Response {
 	}
 
 	if op.Cancel != nil {
+		/* A running operation gets its final status once its run
+		 * function returns, it may still succeed despite the cancel. */
+		running := op.StatusCode == shared.Running && op.Run != nil
+
 		cancel := op.Cancel
 		op.SetStatus(shared.Cancelling)
 		lock.Unlock()
@@ -143,7 +753,13 @@ func operationDelete(d *Daemon, r *http.Request) Response {
 		err := cancel()
 
 		lock.Lock()
-		op.SetStatusByErr(err)
+		if err != nil {
+			op.SetStatusByErr(err)
+		} else if !running && !op.StatusCode.IsFinal() {
+			op.SetStatus(shared.Cancelled)
+		}
+		operationUpdated(id, op)
+		operationsSlotFreed.Broadcast()
 		lock.Unlock()
 
 		if err != nil {
@@ -151,6 +767,8 @@ func operationDelete(d *Daemon, r *http.Request) Response {
 		}
 	} else {
 		op.SetStatus(shared.Cancelled)
+		operationUpdated(id, op)
+		operationsSlotFreed.Broadcast()
 		lock.Unlock()
 	}
 
@@ -159,48 +777,120 @@ func operationDelete(d *Daemon, r *http.Request) Response {
 
 var operationCmd = Command{name: "operations/{id}", get: operationGet, delete: operationDelete}
 
+// operationWaitDone is the status waited for by default: any final status.
+const operationWaitDone = shared.StatusCode(0)
+
+/*
+ * operationWaitStatus parses the status a wait request is for, given either
+ * by code (status_code=103) or by name (status=running). "done" stands for
+ * any final status.
+ */
+func operationWaitStatus(r *http.Request) (shared.StatusCode, error) {
+	if code := r.FormValue("status_code"); code != "" {
+		status, err := strconv.Atoi(code)
+		if err != nil {
+			return 0, fmt.Errorf("Bad status_code: '%s'", code)
+		}
+
+		return shared.StatusCode(status), nil
+	}
+
+	name := r.FormValue("status")
+	if name == "" || strings.EqualFold(name, "done") {
+		return operationWaitDone, nil
+	}
+
+	for _, status := range []shared.StatusCode{shared.Pending, shared.Running, shared.Cancelling, shared.Success, shared.Failure, shared.Cancelled} {
+		if strings.EqualFold(name, status.String()) {
+			return status, nil
+		}
+	}
+
+	return 0, fmt.Errorf("Bad status: '%s'", name)
+}
+
+/*
+ * operationWaitGet waits for the operation to reach the requested status,
+ * or any final status since it won't change anymore, for up to timeout
+ * seconds (forever by default) and returns the operation as it is then.
+ */
 func operationWaitGet(d *Daemon, r *http.Request) Response {
-	targetStatus, err := shared.AtoiEmptyDefault(r.FormValue("status_code"), int(shared.Success))
+	target, err := operationWaitStatus(r)
 	if err != nil {
-		return InternalError(err)
+		return BadRequest(err)
 	}
 
 	timeout, err := shared.AtoiEmptyDefault(r.FormValue("timeout"), -1)
 	if err != nil {
-		return InternalError(err)
+		return BadRequest(fmt.Errorf("Bad timeout: '%s'", r.FormValue("timeout")))
 	}
 
-	lock.Lock()
-	id := shared.OperationsURL(mux.Vars(r)["id"])
-	op, ok := operations[id]
-	if !ok {
-		lock.Unlock()
-		return NotFound
+	var expired <-chan time.Time
+	if timeout >= 0 {
+		expired = time.After(time.Duration(timeout) * time.Second)
 	}
 
-	status := op.StatusCode
-	lock.Unlock()
+	id := shared.OperationsURL(mux.Vars(r)["id"])
 
-	if int(status) != targetStatus && (status == shared.Pending || status == shared.Running) {
+	lock.Lock()
+	defer lock.Unlock()
 
-		if timeout >= 0 {
-			select {
-			case <-op.Chan:
-				break
-			case <-time.After(time.Duration(timeout) * time.Second):
-				break
-			}
-		} else {
-			<-op.Chan
+	for {
+		op, ok := operations[id]
+		if !ok {
+			return NotFound
+		}
+
+		if op.StatusCode == target || op.StatusCode.IsFinal() {
+			return SyncResponse(true, op)
+		}
+
+		changed, ok := operationsChanged[id]
+		if !ok {
+			changed = make(chan bool)
+			operationsChanged[id] = changed
+		}
+
+		lock.Unlock()
+		select {
+		case <-changed:
+			lock.Lock()
+		case <-expired:
+			lock.Lock()
+			return SyncResponse(true, op)
 		}
 	}
+}
+
+var operationWait = Command{name: "operations/{id}/wait", get: operationWaitGet}
 
+// operationWaitFinal waits for an operation to finish and returns the
+// status it finished with, or false if it doesn't exist.
+func operationWaitFinal(id string) (shared.StatusCode, bool) {
 	lock.Lock()
 	defer lock.Unlock()
-	return SyncResponse(true, op)
-}
 
-var operationWait = Command{name: "operations/{id}/wait", get: operationWaitGet}
+	for {
+		op, ok := operations[id]
+		if !ok {
+			return 0, false
+		}
+
+		if op.StatusCode.IsFinal() {
+			return op.StatusCode, true
+		}
+
+		changed, ok := operationsChanged[id]
+		if !ok {
+			changed = make(chan bool)
+			operationsChanged[id] = changed
+		}
+
+		lock.Unlock()
+		<-changed
+		lock.Lock()
+	}
+}
 
 type websocketServe struct {
 	req    *http.Request
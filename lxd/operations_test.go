@@ -0,0 +1,422 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+)
+
+func Test_operation_canceller_runs_hooks_in_reverse_order(t *testing.T) {
+	canceller := &operationCanceller{}
+	order := []string{}
+
+	canceller.OnCancel(func() { order = append(order, "first") })
+	done := canceller.OnCancel(func() { order = append(order, "unregistered") })
+	canceller.OnCancel(func() { order = append(order, "last") })
+	done()
+
+	if canceller.Cancelled() {
+		t.Fatal("The operation shouldn't be cancelled yet")
+	}
+
+	if err := canceller.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !canceller.Cancelled() {
+		t.Error("The operation should be cancelled")
+	}
+
+	if len(order) != 2 || order[0] != "last" || order[1] != "first" {
+		t.Errorf("Wrong hooks run: %v", order)
+	}
+}
+
+func Test_operation_canceller_runs_late_hooks_right_away(t *testing.T) {
+	canceller := &operationCanceller{}
+	canceller.Cancel()
+
+	called := false
+	canceller.OnCancel(func() { called = true })
+
+	if !called {
+		t.Error("A hook registered after the cancel should run right away")
+	}
+}
+
+func Test_nil_operation_canceller_is_never_cancelled(t *testing.T) {
+	var canceller *operationCanceller
+
+	canceller.OnCancel(func() { t.Error("The hook shouldn't run") })()
+
+	if canceller.Cancelled() {
+		t.Error("A nil canceller shouldn't be cancelled")
+	}
+}
+
+func Test_operation_progress_is_published_in_metadata(t *testing.T) {
+	id, err := createOperation(shared.Jmap{"foo": "bar"}, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		lock.Lock()
+		delete(operations, id)
+		lock.Unlock()
+	}()
+
+	progress := &operationProgress{}
+	progress.Stage("Not bound yet", 0)
+	progress.bind(id)
+	progress.Stage("Downloading image", 200)
+	progress.Writer(ioutil.Discard).Write(make([]byte, 50))
+
+	lock.Lock()
+	metadata := operations[id].Metadata
+	lock.Unlock()
+
+	md := struct {
+		Foo      string                   `json:"foo"`
+		Progress shared.OperationProgress `json:"progress"`
+	}{}
+	if err := json.Unmarshal(metadata, &md); err != nil {
+		t.Fatal(err)
+	}
+
+	if md.Foo != "bar" {
+		t.Errorf("The original metadata was lost: %s", metadata)
+	}
+
+	// Updates within a stage are rate limited, the stage start isn't
+	if md.Progress.Stage != "Downloading image" || md.Progress.Total != 200 {
+		t.Errorf("Wrong progress: %s", metadata)
+	}
+
+	progress.Stage("Unpacking image", 0)
+	lock.Lock()
+	metadata = operations[id].Metadata
+	lock.Unlock()
+
+	if err := json.Unmarshal(metadata, &md); err != nil {
+		t.Fatal(err)
+	}
+
+	if md.Progress.Stage != "Unpacking image" || md.Progress.Processed != 0 {
+		t.Errorf("Wrong progress: %s", metadata)
+	}
+}
+
+func Test_nil_operation_progress_is_a_noop(t *testing.T) {
+	var progress *operationProgress
+	progress.Stage("Downloading image", 100)
+	progress.Add(10)
+}
+
+func Test_operations_active_only_counts_unfinished_operations(t *testing.T) {
+	before := operationsActive()
+
+	id, err := createOperation(nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		lock.Lock()
+		delete(operations, id)
+		lock.Unlock()
+	}()
+
+	if operationsActive() != before+1 {
+		t.Fatalf("The pending operation isn't counted")
+	}
+
+	lock.Lock()
+	operations[id].SetResult(shared.OperationSuccess)
+	lock.Unlock()
+
+	if operationsActive() != before {
+		t.Errorf("The finished operation is still counted")
+	}
+}
+
+func Test_operations_get_with_recursion(t *testing.T) {
+	id, err := createOperation(shared.Jmap{"foo": "bar"}, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		lock.Lock()
+		delete(operations, id)
+		lock.Unlock()
+	}()
+
+	d := &Daemon{IsMock: true}
+	r, err := http.NewRequest("GET", "/1.0/operations?recursion=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, ok := operationsGet(d, r).(*syncResponse)
+	if !ok {
+		t.Fatal("Wrong response type")
+	}
+
+	ops := resp.metadata.(map[string][]shared.Operation)
+	found := false
+	for _, op := range ops["pending"] {
+		if string(op.Metadata) == `{"foo":"bar"}` {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Errorf("The pending operation is missing: %v", ops)
+	}
+}
+
+func Test_operation_wait_status(t *testing.T) {
+	statuses := map[string]shared.StatusCode{
+		"":                 operationWaitDone,
+		"?status=done":     operationWaitDone,
+		"?status=Running":  shared.Running,
+		"?status=running":  shared.Running,
+		"?status_code=200": shared.Success,
+	}
+
+	for query, expected := range statuses {
+		r, err := http.NewRequest("GET", "/1.0/operations/foo/wait"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		status, err := operationWaitStatus(r)
+		if err != nil {
+			t.Errorf("%q: %s", query, err)
+		} else if status != expected {
+			t.Errorf("%q: got %d instead of %d", query, status, expected)
+		}
+	}
+
+	r, err := http.NewRequest("GET", "/1.0/operations/foo/wait?status=asleep", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := operationWaitStatus(r); err == nil {
+		t.Error("An unknown status was accepted")
+	}
+}
+
+func Test_operations_over_the_class_limit_stay_pending(t *testing.T) {
+	lock.Lock()
+	operationsLimits = map[string]int{"test": 1}
+	lock.Unlock()
+	defer func() {
+		lock.Lock()
+		operationsLimits = map[string]int{}
+		lock.Unlock()
+	}()
+
+	release := make(chan bool)
+	run := func() shared.OperationResult {
+		<-release
+		return shared.OperationSuccess
+	}
+
+	ids := []string{}
+	for i := 0; i < 3; i++ {
+		id, err := createOperation(nil, nil, run, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			lock.Lock()
+			delete(operations, id)
+			lock.Unlock()
+		}()
+
+		operationClassSet(id, "test")
+		if err := startOperation(id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	status := func(id string) shared.StatusCode {
+		for i := 0; i < 100; i++ {
+			lock.Lock()
+			op := operations[id]
+			code := op.StatusCode
+			lock.Unlock()
+
+			if code != shared.Pending {
+				return code
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		return shared.Pending
+	}
+
+	if status(ids[0]) != shared.Running {
+		t.Fatal("The first operation didn't start")
+	}
+
+	if status(ids[1]) != shared.Pending || status(ids[2]) != shared.Pending {
+		t.Fatal("The operations over the limit didn't stay pending")
+	}
+
+	// Cancelling a queued operation takes it out of the queue
+	lock.Lock()
+	operations[ids[1]].SetStatus(shared.Cancelled)
+	operationsSlotFreed.Broadcast()
+	lock.Unlock()
+
+	release <- true
+	if status(ids[2]) != shared.Running {
+		t.Fatal("The next operation didn't start once the first finished")
+	}
+
+	lock.Lock()
+	cancelled := operations[ids[1]].StatusCode
+	lock.Unlock()
+	if cancelled != shared.Cancelled {
+		t.Errorf("The cancelled operation ran: %s", cancelled)
+	}
+
+	release <- true
+}
+
+func Test_operation_class_slots_are_shared_with_steps(t *testing.T) {
+	lock.Lock()
+	operationsLimits = map[string]int{"test": 1}
+	lock.Unlock()
+	defer func() {
+		lock.Lock()
+		operationsLimits = map[string]int{}
+		lock.Unlock()
+	}()
+
+	if !operationClassAcquire("test", nil) {
+		t.Fatal("The free slot wasn't taken")
+	}
+
+	canceller := &operationCanceller{}
+	acquired := make(chan bool)
+	go func() {
+		acquired <- operationClassAcquire("test", canceller)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("A slot over the limit was taken")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	canceller.Cancel()
+	if <-acquired {
+		t.Error("A slot was taken after the cancel")
+	}
+
+	operationClassRelease("test")
+	if !operationClassAcquire("test", nil) {
+		t.Fatal("The released slot wasn't taken")
+	}
+	operationClassRelease("test")
+}
+
+func Test_operations_get_filtered_by_resource(t *testing.T) {
+	foo, err := createOperation(nil, map[string][]string{"containers": {"foo/snap0"}}, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bar, err := createOperation(nil, map[string][]string{"containers": {"bar"}}, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		lock.Lock()
+		delete(operations, foo)
+		delete(operations, bar)
+		lock.Unlock()
+	}()
+
+	list := func(resource string) []string {
+		r, err := http.NewRequest("GET", "/1.0/operations?resource="+resource, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp := operationsGet(&Daemon{IsMock: true}, r).(*syncResponse)
+		return resp.metadata.(shared.Jmap)["pending"].([]string)
+	}
+
+	for _, resource := range []string{"/1.0/containers/foo", "/1.0/containers/foo/snap0/"} {
+		if ops := list(resource); len(ops) != 1 || ops[0] != foo {
+			t.Errorf("Wrong operations for %s: %v", resource, ops)
+		}
+	}
+
+	if ops := list("/1.0/containers/foo2"); len(ops) != 0 {
+		t.Errorf("Operations of another container were listed: %v", ops)
+	}
+
+	lock.Lock()
+	operations[bar].SetResult(shared.OperationSuccess)
+	operationUpdated(bar, operations[bar])
+	lock.Unlock()
+
+	if ops := list("/1.0/containers/bar"); len(ops) != 0 {
+		t.Errorf("The finished operation is still listed: %v", ops)
+	}
+
+	lock.Lock()
+	_, indexed := operationsByResource["/1.0/containers/bar"]
+	lock.Unlock()
+
+	if indexed {
+		t.Errorf("The finished operation is still indexed")
+	}
+}
+
+func Test_operation_done_before_the_cancel_keeps_its_result(t *testing.T) {
+	release := make(chan bool)
+	run := func() shared.OperationResult {
+		<-release
+		return shared.OperationSuccess
+	}
+
+	id, err := createOperation(nil, nil, run, func() error { return nil }, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		lock.Lock()
+		delete(operations, id)
+		lock.Unlock()
+	}()
+
+	if err := startOperation(id); err != nil {
+		t.Fatal(err)
+	}
+
+	// The cancel request comes in as the run function is returning
+	lock.Lock()
+	op := operations[id]
+	op.SetStatus(shared.Cancelling)
+	lock.Unlock()
+
+	release <- true
+	<-op.Chan
+
+	lock.Lock()
+	status := op.StatusCode
+	lock.Unlock()
+	if status != shared.Success {
+		t.Errorf("The operation which succeeded ended up %s", status)
+	}
+}
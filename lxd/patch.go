@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/lxc/lxd/shared"
+)
+
+/*
+ * patchLock serializes PATCH requests, so that each of them gets merged
+ * into the result of the previous ones rather than into a state they may
+ * be about to replace. PUT requests hold it between checking their
+ * If-Match header and writing, so that nothing changes in between.
+ */
+var patchLock sync.Mutex
+
+/*
+ * patchConfig returns a copy of config with changes applied to it; a key
+ * set to "" in changes is removed.
+ */
+func patchConfig(config map[string]string, changes map[string]string) map[string]string {
+	result := map[string]string{}
+	for key, value := range config {
+		result[key] = value
+	}
+
+	for key, value := range changes {
+		if value == "" {
+			delete(result, key)
+		} else {
+			result[key] = value
+		}
+	}
+
+	return result
+}
+
+/*
+ * patchDevices returns a copy of devices with changes applied to it. The
+ * devices in changes replace the current ones as a whole; those set to
+ * null are removed.
+ */
+func patchDevices(devices shared.Devices, changes shared.Devices) shared.Devices {
+	result := shared.Devices{}
+	for name, device := range devices {
+		result[name] = device
+	}
+
+	for name, device := range changes {
+		if device == nil {
+			delete(result, name)
+		} else {
+			result[name] = device
+		}
+	}
+
+	return result
+}
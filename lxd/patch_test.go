@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/lxc/lxd/shared"
+)
+
+func Test_patch_config_merges_and_removes_keys(t *testing.T) {
+	config := map[string]string{"limits.cpus": "2", "limits.memory": "1GB"}
+
+	result := patchConfig(config, map[string]string{"limits.cpus": "", "security.nesting": "true"})
+
+	if len(result) != 2 || result["limits.memory"] != "1GB" || result["security.nesting"] != "true" {
+		t.Errorf("Wrong patched config: %v", result)
+	}
+
+	if len(config) != 2 || config["limits.cpus"] != "2" {
+		t.Errorf("The original config was modified: %v", config)
+	}
+}
+
+func Test_patch_devices_replaces_and_removes_devices(t *testing.T) {
+	devices := shared.Devices{
+		"eth0": shared.Device{"type": "nic", "nictype": "bridged", "parent": "lxcbr0"},
+		"root": shared.Device{"type": "disk", "path": "/"}}
+
+	result := patchDevices(devices, shared.Devices{
+		"eth0": shared.Device{"type": "nic", "nictype": "macvlan", "parent": "eth0"},
+		"root": nil})
+
+	if len(result) != 1 || result["eth0"]["nictype"] != "macvlan" || result["eth0"]["parent"] != "eth0" {
+		t.Errorf("Wrong patched devices: %v", result)
+	}
+
+	if len(devices) != 2 {
+		t.Errorf("The original devices were modified: %v", devices)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/lxc/lxd"
+	"github.com/lxc/lxd/shared"
+)
+
+/*
+ * initPreseed is the YAML document "lxd init --preseed" reads from its
+ * standard input, for instance:
+ *
+ *   storage_backend: lvm
+ *   storage_pool: vg0
+ *   network_address: 10.0.0.1
+ *   network_port: 8443
+ *   trust_password: secret
+ *   config:
+ *     images.remote_cache_expiry: 5
+ *
+ * Config holds any other server configuration key.
+ */
+type initPreseed struct {
+	StorageBackend  string            `yaml:"storage_backend"`
+	StoragePool     string            `yaml:"storage_pool"`
+	StorageThinpool string            `yaml:"storage_thinpool"`
+	NetworkAddress  string            `yaml:"network_address"`
+	NetworkPort     int               `yaml:"network_port"`
+	TrustPassword   string            `yaml:"trust_password"`
+	Config          map[string]string `yaml:"config"`
+}
+
+type initConfigValue struct {
+	key   string
+	value string
+}
+
+/*
+ * configValues returns the server configuration the preseed stands for, in
+ * the order it must be applied: the LVM thin pool name is only used when
+ * the volume group is set.
+ */
+func (p *initPreseed) configValues() ([]initConfigValue, error) {
+	values := []initConfigValue{}
+
+	keys := []string{}
+	for key := range p.Config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		values = append(values, initConfigValue{key, p.Config[key]})
+	}
+
+	switch p.StorageBackend {
+	case "", "dir":
+		if p.StoragePool != "" || p.StorageThinpool != "" {
+			return nil, fmt.Errorf("storage_pool and storage_thinpool only apply to the lvm backend")
+		}
+	case "lvm":
+		if p.StoragePool == "" {
+			return nil, fmt.Errorf("The lvm backend needs a volume group in storage_pool")
+		}
+
+		if p.StorageThinpool != "" {
+			values = append(values, initConfigValue{"storage.lvm_thinpool_name", p.StorageThinpool})
+		}
+		values = append(values, initConfigValue{"storage.lvm_vg_name", p.StoragePool})
+	default:
+		return nil, fmt.Errorf("Unsupported storage backend: %s", p.StorageBackend)
+	}
+
+	if p.NetworkAddress != "" {
+		port := shared.DefaultPort
+		if p.NetworkPort != 0 {
+			port = strconv.Itoa(p.NetworkPort)
+		}
+
+		values = append(values, initConfigValue{"core.https_address", net.JoinHostPort(p.NetworkAddress, port)})
+	} else if p.NetworkPort != 0 {
+		return nil, fmt.Errorf("network_port needs a network_address")
+	}
+
+	if p.TrustPassword != "" {
+		values = append(values, initConfigValue{"core.trust_password", p.TrustPassword})
+	}
+
+	return values, nil
+}
+
+/*
+ * cmdInit configures the local daemon. Only the non-interactive mode,
+ * reading a YAML preseed from the standard input, is supported.
+ */
+func cmdInit() error {
+	if !*preseedFlag {
+		return fmt.Errorf("lxd init can only be run with --preseed, reading its configuration from stdin")
+	}
+
+	content, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	preseed := initPreseed{}
+	if err := yaml.Unmarshal(content, &preseed); err != nil {
+		return fmt.Errorf("Invalid preseed: %s", err)
+	}
+
+	values, err := preseed.configValues()
+	if err != nil {
+		return err
+	}
+
+	c, err := lxd.NewClient(&lxd.DefaultConfig, "local")
+	if err != nil {
+		return err
+	}
+
+	for _, value := range values {
+		if _, err := c.SetServerConfig(value.key, value.value); err != nil {
+			return fmt.Errorf("Failed to set %s: %s", value.key, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_preseed_config_values(t *testing.T) {
+	preseed := initPreseed{
+		StorageBackend:  "lvm",
+		StoragePool:     "vg0",
+		StorageThinpool: "pool",
+		NetworkAddress:  "::1",
+		TrustPassword:   "secret",
+		Config:          map[string]string{"images.remote_cache_expiry": "5", "core.api_rate_limit": "10"},
+	}
+
+	values, err := preseed.configValues()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []initConfigValue{
+		{"core.api_rate_limit", "10"},
+		{"images.remote_cache_expiry", "5"},
+		{"storage.lvm_thinpool_name", "pool"},
+		{"storage.lvm_vg_name", "vg0"},
+		{"core.https_address", "[::1]:8443"},
+		{"core.trust_password", "secret"},
+	}
+
+	if len(values) != len(expected) {
+		t.Fatalf("Wrong values: %v", values)
+	}
+
+	for i := range expected {
+		if values[i] != expected[i] {
+			t.Errorf("Wrong value %d: %v", i, values[i])
+		}
+	}
+}
+
+func Test_preseed_invalid(t *testing.T) {
+	invalid := []initPreseed{
+		{StorageBackend: "zfs"},
+		{StorageBackend: "lvm"},
+		{StoragePool: "vg0"},
+		{NetworkPort: 8443},
+	}
+
+	for _, preseed := range invalid {
+		if _, err := preseed.configValues(); err == nil {
+			t.Errorf("Invalid preseed accepted: %v", preseed)
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/mux"
 	_ "github.com/mattn/go-sqlite3"
@@ -28,22 +30,20 @@ func profilesGet(d *Daemon, r *http.Request) Response {
 
 	recursion := d.isRecursionRequest(r)
 
-	resultString := make([]string, len(results))
-	resultMap := make([]*shared.ProfileConfig, len(results))
-	i := 0
+	resultString := []string{}
+	resultMap := []*shared.ProfileConfig{}
 	for _, name := range results {
 		if !recursion {
 			url := fmt.Sprintf("/%s/profiles/%s", shared.APIVersion, name)
-			resultString[i] = url
+			resultString = append(resultString, url)
 		} else {
 			profile, err := doProfileGet(d, name)
 			if err != nil {
-				shared.Log.Error("Failed to get profile", log.Ctx{"profile": name})
+				requestLog(r).Error("Failed to get profile", log.Ctx{"profile": name})
 				continue
 			}
-			resultMap[i] = profile
+			resultMap = append(resultMap, profile)
 		}
-		i++
 	}
 
 	if !recursion {
@@ -88,13 +88,34 @@ func doProfileGet(d *Daemon, name string) (*shared.ProfileConfig, error) {
 		return nil, err
 	}
 
+	usedBy, err := profileUsedBy(d, name)
+	if err != nil {
+		return nil, err
+	}
+
 	return &shared.ProfileConfig{
 		Name:    name,
 		Config:  config,
 		Devices: devices,
+		UsedBy:  usedBy,
 	}, nil
 }
 
+// profileUsedBy returns the URLs of the containers and snapshots using a profile.
+func profileUsedBy(d *Daemon, name string) ([]string, error) {
+	names, err := dbProfileContainersGet(d.db, name)
+	if err != nil {
+		return nil, err
+	}
+
+	usedBy := []string{}
+	for _, cname := range names {
+		usedBy = append(usedBy, containerURL(cname))
+	}
+
+	return usedBy, nil
+}
+
 func profileGet(d *Daemon, r *http.Request) Response {
 	name := mux.Vars(r)["name"]
 
@@ -103,7 +124,12 @@ func profileGet(d *Daemon, r *http.Request) Response {
 		return SmartError(err)
 	}
 
-	return SyncResponse(true, resp)
+	return SyncResponseETag(true, resp, profileETag(resp))
+}
+
+// profileETag returns what a profile's ETag is computed from.
+func profileETag(profile *shared.ProfileConfig) interface{} {
+	return []interface{}{profile.Config, profile.Devices}
 }
 
 func getRunningContainersWithProfile(d *Daemon, profile string) []container {
@@ -128,71 +154,206 @@ func getRunningContainersWithProfile(d *Daemon, profile string) []container {
 func profilePut(d *Daemon, r *http.Request) Response {
 	name := mux.Vars(r)["name"]
 
+	patchLock.Lock()
+	defer patchLock.Unlock()
+
+	profile, err := doProfileGet(d, name)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	if err := etagCheck(r, profileETag(profile)); err != nil {
+		return PreconditionFailed(err)
+	}
+
 	req := profilesPostReq{}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		return BadRequest(err)
 	}
 
-	preDevList, err := dbDevicesGet(d.db, name, true)
-	if err != nil {
-		return InternalError(err)
+	return doProfileUpdate(d, name, req)
+}
+
+/*
+ * profilePatch merges the config keys and devices it's given into the
+ * profile, see containerPatchReq.
+ */
+func profilePatch(d *Daemon, r *http.Request) Response {
+	name := mux.Vars(r)["name"]
+
+	req := profilesPostReq{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return BadRequest(err)
 	}
-	clist := getRunningContainersWithProfile(d, name)
 
-	id, err := dbProfileIDGet(d.db, name)
+	patchLock.Lock()
+	defer patchLock.Unlock()
+
+	profile, err := doProfileGet(d, name)
 	if err != nil {
-		return InternalError(fmt.Errorf("Failed to retrieve profile='%s'", name))
+		return SmartError(err)
 	}
 
-	tx, err := dbBegin(d.db)
+	req.Config = patchConfig(profile.Config, req.Config)
+	req.Devices = patchDevices(profile.Devices, req.Devices)
+
+	return doProfileUpdate(d, name, req)
+}
+
+/*
+ * doProfileUpdate replaces the config and devices of a profile and applies
+ * the changes to the running containers using it as far as possible. The
+ * URLs of those which need a restart for the rest are returned.
+ */
+func doProfileUpdate(d *Daemon, name string, req profilesPostReq) Response {
+	preDevList, err := dbDevicesGet(d.db, name, true)
 	if err != nil {
 		return InternalError(err)
 	}
+	clist := getRunningContainersWithProfile(d, name)
 
-	err = dbProfileConfigClear(tx, id)
-	if err != nil {
-		tx.Rollback()
-		return InternalError(err)
+	oldConfigs := map[string]map[string]string{}
+	for _, c := range clist {
+		config := map[string]string{}
+		for k, v := range c.ConfigGet() {
+			config[k] = v
+		}
+		oldConfigs[c.NameGet()] = config
 	}
 
-	err = dbProfileConfigAdd(tx, id, req.Config)
+	id, err := dbProfileIDGet(d.db, name)
 	if err != nil {
-		tx.Rollback()
-		return SmartError(err)
+		return InternalError(fmt.Errorf("Failed to retrieve profile='%s'", name))
 	}
 
-	err = dbDevicesAdd(tx, "profile", id, req.Devices)
+	restartNeeded := []string{}
+	err = dbTx(d.db, func(tx *sql.Tx) error {
+		err := dbProfileConfigClear(tx, id)
+		if err != nil {
+			return err
+		}
+
+		err = dbProfileConfigAdd(tx, id, req.Config)
+		if err != nil {
+			return err
+		}
+
+		err = dbDevicesAdd(tx, "profile", id, req.Devices)
+		if err != nil {
+			return err
+		}
+
+		postDevList := req.Devices
+		// do our best to update the device list for each container using
+		// this profile
+		for _, c := range clist {
+			if !c.IsRunning() {
+				continue
+			}
+			shared.Log.Debug("Updating the devices from a profile", log.Ctx{"container": c.NameGet(), "profile": name})
+			if err := devicesApplyDeltaLive(tx, c, preDevList, postDevList); err != nil {
+				shared.Log.Warn("Failed to update the devices from a profile", log.Ctx{"container": c.NameGet(), "profile": name, "err": err})
+				restartNeeded = append(restartNeeded, containerURL(c.NameGet()))
+			}
+		}
+
+		return nil
+	})
 	if err != nil {
-		tx.Rollback()
 		return SmartError(err)
 	}
 
-	postDevList := req.Devices
-	// do our best to update the device list for each container using
-	// this profile
+	// The containers are loaded again to get their new expanded config
 	for _, c := range clist {
-		if !c.IsRunning() {
+		url := containerURL(c.NameGet())
+		if !c.IsRunning() || shared.StringInSlice(url, restartNeeded) {
 			continue
 		}
-		fmt.Printf("Updating profile device list for %s\n", c.NameGet())
-		if err := devicesApplyDeltaLive(tx, c, preDevList, postDevList); err != nil {
-			shared.Debugf("Warning: failed to update device list for container %s (profile %s updated)", c.NameGet(), name)
+
+		updated, err := containerLXDLoad(d, c.NameGet())
+		if err != nil || containerApplyConfigLive(updated, oldConfigs[c.NameGet()]) {
+			restartNeeded = append(restartNeeded, url)
 		}
 	}
 
-	err = txCommit(tx)
+	d.cpuScheduleTrigger()
+	return SyncResponse(true, shared.Jmap{"restart_needed": restartNeeded})
+}
+
+func profilePost(d *Daemon, r *http.Request) Response {
+	name := mux.Vars(r)["name"]
+
+	req := profilesPostReq{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return BadRequest(err)
+	}
+
+	// Sanity checks
+	if req.Name == "" {
+		return BadRequest(fmt.Errorf("No name provided"))
+	}
+
+	if resp := profileDefaultCheck(d, name); resp != nil {
+		return resp
+	}
+
+	// Containers reference profiles by ID, so the rename is picked up
+	// by all of them without having to touch containers_profiles.
+	err := dbProfileRename(d.db, name, req.Name)
+	if err == DbErrAlreadyDefined {
+		return Conflict
+	}
+
 	if err != nil {
-		return InternalError(err)
+		return SmartError(err)
 	}
 
 	return EmptySyncResponse
 }
 
-// The handler for the delete operation.
+/*
+ * profileDefaultCheck refuses to let the default profile go while there are
+ * containers, new ones getting it unless told otherwise.
+ */
+func profileDefaultCheck(d *Daemon, name string) Response {
+	if name != "default" {
+		return nil
+	}
+
+	containers, err := dbContainersList(d.db, cTypeRegular)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	if len(containers) > 0 {
+		return BadRequest(fmt.Errorf("The default profile can't be removed or renamed while there are containers"))
+	}
+
+	return nil
+}
+
+// The handler for the delete operation, refused while containers use it.
 func profileDelete(d *Daemon, r *http.Request) Response {
 	name := mux.Vars(r)["name"]
-	err := dbProfileDelete(d.db, name)
 
+	if _, err := dbProfileConfigGet(d.db, name); err != nil {
+		return SmartError(err)
+	}
+
+	if resp := profileDefaultCheck(d, name); resp != nil {
+		return resp
+	}
+
+	usedBy, err := profileUsedBy(d, name)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	if len(usedBy) > 0 {
+		return InUse(fmt.Errorf("The profile is in use by: %s", strings.Join(usedBy, ", ")))
+	}
+
+	err = dbProfileDelete(d.db, name)
 	if err != nil {
 		return InternalError(err)
 	}
@@ -200,4 +361,4 @@ func profileDelete(d *Daemon, r *http.Request) Response {
 	return EmptySyncResponse
 }
 
-var profileCmd = Command{name: "profiles/{name}", get: profileGet, put: profilePut, delete: profileDelete}
+var profileCmd = Command{name: "profiles/{name}", get: profileGet, put: profilePut, patch: profilePatch, post: profilePost, delete: profileDelete}
@@ -53,3 +53,45 @@ func Test_removing_a_profile_deletes_associated_configuration_entries(t *testing
 		t.Errorf("Deleting a profile didn't delete the related profiles_config! There are %d left", len(config))
 	}
 }
+
+func Test_renaming_a_profile_keeps_the_containers_using_it(t *testing.T) {
+	d := &Daemon{}
+	err := initializeDbObject(d, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statements := `
+    INSERT INTO containers (name, architecture, type) VALUES ('thename', 1, 1);
+    INSERT INTO profiles (name) VALUES ('theprofile');
+    INSERT INTO containers_profiles (container_id, profile_id) VALUES (1, 3);`
+
+	_, err = d.db.Exec(statements)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = dbProfileRename(d.db, "theprofile", "default")
+	if err != DbErrAlreadyDefined {
+		t.Errorf("Renaming to an existing profile didn't fail: %v", err)
+	}
+
+	err = dbProfileRename(d.db, "nosuchprofile", "newprofile")
+	if err != NoSuchObjectError {
+		t.Errorf("Renaming a missing profile didn't fail: %v", err)
+	}
+
+	err = dbProfileRename(d.db, "theprofile", "newprofile")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profiles, err := dbContainerProfilesGet(d.db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(profiles) != 1 || profiles[0] != "newprofile" {
+		t.Errorf("The container's profiles weren't renamed: %v", profiles)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Past that many tracked clients, those which were idle long enough to
+// have their allowance fully restored are forgotten, and if that isn't
+// enough the one seen the longest ago.
+const rateLimiterMaxClients = 1024
+
+/*
+ * rateLimiter throttles the API requests of each client with a token
+ * bucket: a client can send up to burst requests in a row, and is then
+ * allowed rate requests per second. Its zero value doesn't limit anything.
+ */
+type rateLimiter struct {
+	lock    sync.Mutex
+	rate    float64
+	burst   int
+	buckets map[string]*rateBucket
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// setLimits changes the limits, a zero rate turning them off.
+func (l *rateLimiter) setLimits(rate float64, burst int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.rate = rate
+	l.burst = burst
+	l.buckets = nil
+}
+
+// allow consumes one request of the client's allowance, if it has any left.
+func (l *rateLimiter) allow(client string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.rate <= 0 {
+		return true
+	}
+
+	if l.buckets == nil {
+		l.buckets = map[string]*rateBucket{}
+	}
+
+	now := time.Now()
+	bucket, ok := l.buckets[client]
+	if !ok {
+		l.prune(now)
+		bucket = &rateBucket{tokens: float64(l.burst), last: now}
+		l.buckets[client] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.last).Seconds() * l.rate
+	if bucket.tokens > float64(l.burst) {
+		bucket.tokens = float64(l.burst)
+	}
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+
+	bucket.tokens--
+	return true
+}
+
+func (l *rateLimiter) prune(now time.Time) {
+	if len(l.buckets) < rateLimiterMaxClients {
+		return
+	}
+
+	refill := time.Duration(float64(l.burst) / l.rate * float64(time.Second))
+	for client, bucket := range l.buckets {
+		if now.Sub(bucket.last) > refill {
+			delete(l.buckets, client)
+		}
+	}
+
+	for len(l.buckets) >= rateLimiterMaxClients {
+		oldest := ""
+		for client, bucket := range l.buckets {
+			if oldest == "" || bucket.last.Before(l.buckets[oldest].last) {
+				oldest = client
+			}
+		}
+
+		delete(l.buckets, oldest)
+	}
+}
+
+/*
+ * rateLimitClient identifies the client a request is accounted to: the
+ * certificate of a trusted client, the address of any other, as untrusted
+ * clients can make up as many certificates as they want.
+ */
+func rateLimitClient(r *http.Request, trusted bool) string {
+	if trusted && r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return certGenerateFingerprint(r.TLS.PeerCertificates[0])
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// rateLimitsLoad applies the limits set by core.api_rate_limit and
+// core.api_rate_burst.
+func rateLimitsLoad(d *Daemon) {
+	rate := 0
+	value, err := d.ConfigValueGet("core.api_rate_limit")
+	if err == nil && value != "" {
+		rate, _ = strconv.Atoi(value)
+	}
+
+	burst := rate
+	value, err = d.ConfigValueGet("core.api_rate_burst")
+	if err == nil && value != "" {
+		if b, err := strconv.Atoi(value); err == nil && b > 0 {
+			burst = b
+		}
+	}
+
+	d.apiLimiter.setLimits(float64(rate), burst)
+}
+
+/*
+ * rateLimited returns whether the request goes over the API rate limits.
+ * Local clients, on the unix socket, aren't limited.
+ */
+func (d *Daemon) rateLimited(r *http.Request) bool {
+	if r.RemoteAddr == "@" {
+		return false
+	}
+
+	return !d.apiLimiter.allow(rateLimitClient(r, d.isTrustedClient(r)))
+}
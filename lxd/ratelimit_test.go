@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_rate_limiter_allows_bursts_then_throttles(t *testing.T) {
+	limiter := rateLimiter{}
+	limiter.setLimits(1, 3)
+
+	for i := 0; i < 3; i++ {
+		if !limiter.allow("client") {
+			t.Fatalf("Request %d of the burst was refused", i)
+		}
+	}
+
+	if limiter.allow("client") {
+		t.Error("A request past the burst was allowed")
+	}
+
+	if !limiter.allow("other") {
+		t.Error("Clients should have their own allowance")
+	}
+
+	limiter.buckets["client"].last = time.Now().Add(-time.Second)
+	if !limiter.allow("client") {
+		t.Error("The allowance should have been restored over time")
+	}
+}
+
+func Test_rate_limiter_tracks_a_bounded_number_of_clients(t *testing.T) {
+	limiter := rateLimiter{}
+	limiter.setLimits(1, 3)
+
+	for i := 0; i < 2*rateLimiterMaxClients; i++ {
+		limiter.allow(fmt.Sprintf("client%d", i))
+	}
+
+	if len(limiter.buckets) > rateLimiterMaxClients {
+		t.Errorf("%d clients are tracked", len(limiter.buckets))
+	}
+
+	if _, ok := limiter.buckets[fmt.Sprintf("client%d", 2*rateLimiterMaxClients-1)]; !ok {
+		t.Error("The last client was forgotten")
+	}
+}
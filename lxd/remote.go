@@ -1,10 +1,13 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 
 	"github.com/lxc/lxd/shared"
+
+	log "gopkg.in/inconshreveable/log15.v2"
 )
 
 func remoteGetImageFingerprint(
@@ -19,9 +22,46 @@ func remoteGetImageFingerprint(
 		return "", err
 	}
 
-	var result shared.ImageAlias
+	var result shared.ImageAliasInfo
 	if err = json.Unmarshal(resp.Metadata, &result); err != nil {
 		return "", fmt.Errorf("Error reading alias\n")
 	}
-	return result.Name, nil
+	return result.Target, nil
+}
+
+/*
+ * remoteCachedImageFingerprint resolves an alias of a remote server, only
+ * asking the server if it wasn't asked in the last
+ * images.remote_cache_expiry days: the image cached for the alias is used
+ * in between, as well as when the server can't be reached. It also returns
+ * whether the server was asked, in which case the image should be recorded
+ * with dbImageSourceSet once downloaded.
+ */
+func remoteCachedImageFingerprint(d *Daemon, server string, alias string) (string, bool, error) {
+	expiry, err := dbImageExpiryGet(d.db)
+	if err != nil {
+		return "", false, err
+	}
+
+	cached, fresh, err := dbImageSourceGet(d.db, server, alias, expiry)
+	if err != nil && err != sql.ErrNoRows {
+		return "", false, err
+	}
+
+	if fresh {
+		return cached, false, nil
+	}
+
+	fp, err := remoteGetImageFingerprint(d, server, alias)
+	if err != nil {
+		if cached == "" {
+			return "", false, err
+		}
+
+		shared.Log.Warn("Failed to check the remote alias, using the cached image",
+			log.Ctx{"server": server, "alias": alias, "image": cached, "err": err})
+		return cached, false, nil
+	}
+
+	return fp, true, nil
 }
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/lxc/lxd/shared"
+)
+
+/*
+ * parseCPUInfo reads /proc/cpuinfo, returning the CPU sockets of the host
+ * along with the total number of threads. Architectures which don't report
+ * the physical layout are shown as a single socket with a core per thread.
+ */
+func parseCPUInfo(r io.Reader) ([]shared.ResourcesCPUSocket, int, error) {
+	sockets := map[int]*shared.ResourcesCPUSocket{}
+	cores := map[int]map[int]bool{}
+	total := 0
+
+	socket, core := 0, -1
+	model := ""
+	seen := false
+	flush := func() {
+		if !seen {
+			return
+		}
+
+		s, ok := sockets[socket]
+		if !ok {
+			s = &shared.ResourcesCPUSocket{Socket: socket}
+			sockets[socket] = s
+			cores[socket] = map[int]bool{}
+		}
+
+		if s.Model == "" {
+			s.Model = model
+		}
+
+		if core < 0 {
+			core = s.Threads
+		}
+		cores[socket][core] = true
+		s.Cores = len(cores[socket])
+		s.Threads++
+		total++
+
+		socket, core = 0, -1
+		model = ""
+		seen = false
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.SplitN(line, ":", 2)
+		if len(fields) != 2 {
+			if strings.TrimSpace(line) == "" {
+				flush()
+			}
+			continue
+		}
+
+		key := strings.TrimSpace(fields[0])
+		value := strings.TrimSpace(fields[1])
+		switch key {
+		case "processor":
+			// Some architectures have it as a header line
+			if _, err := strconv.Atoi(value); err == nil {
+				seen = true
+			}
+		case "physical id":
+			v, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, 0, fmt.Errorf("Bad physical id: %s", value)
+			}
+			socket = v
+		case "core id":
+			v, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, 0, fmt.Errorf("Bad core id: %s", value)
+			}
+			core = v
+		case "model name", "cpu model", "cpu":
+			if model == "" {
+				model = value
+			}
+		}
+	}
+	flush()
+
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	ids := []int{}
+	for id := range sockets {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	result := []shared.ResourcesCPUSocket{}
+	for _, id := range ids {
+		result = append(result, *sockets[id])
+	}
+
+	return result, total, nil
+}
+
+// parseMemInfo reads the total and available memory from /proc/meminfo.
+func parseMemInfo(r io.Reader) (shared.ResourcesMemory, error) {
+	memory := shared.ResourcesMemory{}
+	values := map[string]uint64{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		// Sizes are given in kB
+		if len(fields) == 3 && fields[2] == "kB" {
+			value *= 1024
+		}
+
+		values[strings.TrimSuffix(fields[0], ":")] = value
+	}
+
+	if err := scanner.Err(); err != nil {
+		return memory, err
+	}
+
+	memory.Total = values["MemTotal"]
+	if available, ok := values["MemAvailable"]; ok {
+		memory.Available = available
+	} else {
+		// Kernels older than 3.14 don't report it
+		memory.Available = values["MemFree"] + values["Buffers"] + values["Cached"]
+	}
+
+	return memory, nil
+}
+
+// resourcesNUMANodes lists the NUMA nodes of the host, with their CPUs and
+// memory.
+func resourcesNUMANodes() ([]shared.ResourcesNUMANode, error) {
+	nodes := []shared.ResourcesNUMANode{}
+
+	paths, err := filepath.Glob("/sys/devices/system/node/node[0-9]*")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		id, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(path), "node"))
+		if err != nil {
+			continue
+		}
+
+		node := shared.ResourcesNUMANode{Node: id}
+
+		cpus, err := ioutil.ReadFile(filepath.Join(path, "cpulist"))
+		if err != nil {
+			return nil, err
+		}
+		node.CPUs = strings.TrimSpace(string(cpus))
+
+		// The per-node meminfo lines are prefixed with "Node <id>"
+		content, err := ioutil.ReadFile(filepath.Join(path, "meminfo"))
+		if err == nil {
+			prefix := fmt.Sprintf("Node %d ", id)
+			memory, err := parseMemInfo(strings.NewReader(strings.Replace(string(content), prefix, "", -1)))
+			if err != nil {
+				return nil, err
+			}
+			node.Memory = memory.Total
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	sort.Sort(numaNodesByID(nodes))
+	return nodes, nil
+}
+
+type numaNodesByID []shared.ResourcesNUMANode
+
+func (a numaNodesByID) Len() int           { return len(a) }
+func (a numaNodesByID) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a numaNodesByID) Less(i, j int) bool { return a[i].Node < a[j].Node }
+
+// resourcesGPUs lists the GPUs of the host, as found in /sys/class/drm.
+func resourcesGPUs() ([]shared.ResourcesGPU, error) {
+	gpus := []shared.ResourcesGPU{}
+
+	paths, err := filepath.Glob("/sys/class/drm/card[0-9]*")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		// Skip the connectors (card0-HDMI-A-1, ...)
+		if strings.Contains(filepath.Base(path), "-") {
+			continue
+		}
+
+		gpu := shared.ResourcesGPU{Card: filepath.Base(path)}
+
+		vendor, err := ioutil.ReadFile(filepath.Join(path, "device", "vendor"))
+		if err == nil {
+			gpu.Vendor = strings.TrimSpace(string(vendor))
+		}
+
+		product, err := ioutil.ReadFile(filepath.Join(path, "device", "device"))
+		if err == nil {
+			gpu.Product = strings.TrimSpace(string(product))
+		}
+
+		driver, err := os.Readlink(filepath.Join(path, "device", "driver"))
+		if err == nil {
+			gpu.Driver = filepath.Base(driver)
+		}
+
+		gpus = append(gpus, gpu)
+	}
+
+	return gpus, nil
+}
+
+/*
+ * resourcesStorage reports the capacity of the storage containers are
+ * created on: the thin pool for LVM, the filesystem holding LXD's
+ * containers directory otherwise.
+ */
+func resourcesStorage(d *Daemon) (shared.ResourcesStorage, error) {
+	storage := shared.ResourcesStorage{Driver: "dir"}
+	if d.Storage != nil {
+		storage.Driver = d.Storage.GetStorageTypeName()
+	}
+
+	if d.Storage != nil && d.Storage.GetStorageType() == storageTypeLvm {
+		vgName, err := d.ConfigValueGet("storage.lvm_vg_name")
+		if err != nil {
+			return storage, err
+		}
+
+		poolName, err := d.ConfigValueGet("storage.lvm_thinpool_name")
+		if err != nil {
+			return storage, err
+		}
+
+		if poolName == "" {
+			poolName = storageLvmDefaultThinPoolName
+		}
+
+		output, err := exec.Command("lvs", "--noheadings", "--nosuffix", "--units", "b",
+			"-o", "lv_size,data_percent", fmt.Sprintf("%s/%s", vgName, poolName)).CombinedOutput()
+		if err != nil {
+			return storage, fmt.Errorf("Error getting the thin pool usage: %v\noutput:'%s'", err, string(output))
+		}
+
+		fields := strings.Fields(string(output))
+		if len(fields) != 2 {
+			return storage, fmt.Errorf("Unexpected lvs output: '%s'", string(output))
+		}
+
+		size, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return storage, err
+		}
+
+		used, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return storage, err
+		}
+
+		storage.Total = size
+		storage.Available = uint64(float64(size) * (100 - used) / 100)
+		return storage, nil
+	}
+
+	fs := syscall.Statfs_t{}
+	if err := syscall.Statfs(shared.VarPath("containers"), &fs); err != nil {
+		return storage, err
+	}
+
+	storage.Total = fs.Blocks * uint64(fs.Bsize)
+	storage.Available = fs.Bavail * uint64(fs.Bsize)
+	return storage, nil
+}
+
+func resourcesGet(d *Daemon, r *http.Request) Response {
+	resources := shared.Resources{}
+
+	cpuinfo, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return InternalError(err)
+	}
+	defer cpuinfo.Close()
+
+	resources.CPU.Sockets, resources.CPU.Total, err = parseCPUInfo(cpuinfo)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	resources.CPU.NUMANodes, err = resourcesNUMANodes()
+	if err != nil {
+		return InternalError(err)
+	}
+
+	meminfo, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return InternalError(err)
+	}
+	defer meminfo.Close()
+
+	resources.Memory, err = parseMemInfo(meminfo)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	resources.Storage, err = resourcesStorage(d)
+	if err != nil {
+		return StorageError(err)
+	}
+
+	resources.GPUs, err = resourcesGPUs()
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return SyncResponse(true, resources)
+}
+
+var resourcesCmd = Command{name: "resources", get: resourcesGet}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const testCPUInfo = `processor	: 0
+physical id	: 0
+core id		: 0
+model name	: Intel(R) Xeon(R) CPU E5-2620 v3 @ 2.40GHz
+
+processor	: 1
+physical id	: 0
+core id		: 0
+model name	: Intel(R) Xeon(R) CPU E5-2620 v3 @ 2.40GHz
+
+processor	: 2
+physical id	: 1
+core id		: 0
+model name	: Intel(R) Xeon(R) CPU E5-2620 v3 @ 2.40GHz
+
+processor	: 3
+physical id	: 1
+core id		: 1
+model name	: Intel(R) Xeon(R) CPU E5-2620 v3 @ 2.40GHz
+`
+
+func Test_parseCPUInfo(t *testing.T) {
+	sockets, total, err := parseCPUInfo(strings.NewReader(testCPUInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if total != 4 {
+		t.Errorf("Wrong number of threads: %d", total)
+	}
+
+	if len(sockets) != 2 {
+		t.Fatalf("Wrong number of sockets: %d", len(sockets))
+	}
+
+	if sockets[0].Cores != 1 || sockets[0].Threads != 2 {
+		t.Errorf("Wrong layout for the first socket: %+v", sockets[0])
+	}
+
+	if sockets[1].Cores != 2 || sockets[1].Threads != 2 {
+		t.Errorf("Wrong layout for the second socket: %+v", sockets[1])
+	}
+
+	if !strings.HasPrefix(sockets[1].Model, "Intel(R) Xeon(R)") {
+		t.Errorf("Wrong model: %s", sockets[1].Model)
+	}
+}
+
+func Test_parseCPUInfo_without_topology(t *testing.T) {
+	cpuinfo := "processor\t: 0\ncpu\t\t: POWER8E\n\nprocessor\t: 1\ncpu\t\t: POWER8E\n"
+
+	sockets, total, err := parseCPUInfo(strings.NewReader(cpuinfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if total != 2 || len(sockets) != 1 || sockets[0].Cores != 2 || sockets[0].Model != "POWER8E" {
+		t.Errorf("Wrong CPUs: %d, %+v", total, sockets)
+	}
+}
+
+func Test_parseMemInfo(t *testing.T) {
+	memory, err := parseMemInfo(strings.NewReader("MemTotal:        2048 kB\nMemFree:          512 kB\nMemAvailable:    1024 kB\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if memory.Total != 2048*1024 || memory.Available != 1024*1024 {
+		t.Errorf("Wrong memory: %+v", memory)
+	}
+
+	memory, err = parseMemInfo(strings.NewReader("MemTotal: 2048 kB\nMemFree: 512 kB\nBuffers: 128 kB\nCached: 256 kB\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if memory.Available != 896*1024 {
+		t.Errorf("Wrong available memory on old kernels: %d", memory.Available)
+	}
+}
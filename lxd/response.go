@@ -29,6 +29,7 @@ type Response interface {
 
 type syncResponse struct {
 	success  bool
+	etag     interface{}
 	metadata interface{}
 }
 
@@ -143,6 +144,13 @@ func (r *syncResponse) Render(w http.ResponseWriter) error {
 		status = shared.Failure
 	}
 
+	if r.etag != nil {
+		etag, err := etagHash(r.etag)
+		if err == nil {
+			w.Header().Set("ETag", etag)
+		}
+	}
+
 	resp := resp{Type: lxd.Sync, Status: status.String(), StatusCode: status, Metadata: r.metadata}
 	return WriteJSON(w, resp)
 }
@@ -153,10 +161,18 @@ func (r *syncResponse) Render(w http.ResponseWriter) error {
  * responses.
  */
 func SyncResponse(success bool, metadata interface{}) Response {
-	return &syncResponse{success, metadata}
+	return &syncResponse{success, nil, metadata}
+}
+
+/*
+ * SyncResponseETag is like SyncResponse, with an ETag header hashed out of
+ * etag, the part of the resource that can be updated (see etagCheck).
+ */
+func SyncResponseETag(success bool, metadata interface{}, etag interface{}) Response {
+	return &syncResponse{success, etag, metadata}
 }
 
-var EmptySyncResponse = &syncResponse{true, make(map[string]interface{})}
+var EmptySyncResponse = &syncResponse{true, nil, make(map[string]interface{})}
 
 type async struct {
 	Type       lxd.ResponseType    `json:"type"`
@@ -173,7 +189,17 @@ type asyncResponse struct {
 	ws        shared.OperationWebsocket
 	resources map[string][]string
 	metadata  shared.Jmap
+	progress  *operationProgress
 	done      chan shared.OperationResult
+
+	// The class of the operation, if its concurrency can be limited
+	class string
+
+	// The URL of the operation, once rendered
+	id string
+
+	// The request which created the operation
+	requestID string
 }
 
 func (r *asyncResponse) Render(w http.ResponseWriter) error {
@@ -181,6 +207,16 @@ func (r *asyncResponse) Render(w http.ResponseWriter) error {
 	if err != nil {
 		return err
 	}
+	r.progress.bind(op)
+	r.id = op
+
+	if r.class != "" {
+		operationClassSet(op, r.class)
+	}
+
+	if r.requestID != "" {
+		operationRequestSet(op, r.requestID)
+	}
 
 	err = startOperation(op)
 	if err != nil {
@@ -221,8 +257,9 @@ func AsyncResponseWithWs(ws shared.OperationWebsocket, cancel func() error) Resp
 }
 
 type ErrorResponse struct {
-	code int
-	msg  string
+	code    int
+	errCode shared.ErrorCode
+	msg     string
 }
 
 func (r *ErrorResponse) Render(w http.ResponseWriter) error {
@@ -236,7 +273,15 @@ func (r *ErrorResponse) Render(w http.ResponseWriter) error {
 		output = io.MultiWriter(buf, captured)
 	}
 
-	err := json.NewEncoder(output).Encode(shared.Jmap{"type": lxd.Error, "error": r.msg, "error_code": r.code})
+	details := shared.ErrorDetails{Code: r.errCode, Type: r.errCode.String()}
+	body := shared.Jmap{"type": lxd.Error, "error": r.msg, "error_code": r.code, "metadata": details}
+
+	// So that the failure can be found in the daemon's log
+	if requestID := w.Header().Get("X-LXD-request-id"); requestID != "" {
+		body["request_id"] = requestID
+	}
+
+	err := json.NewEncoder(output).Encode(body)
 
 	if err != nil {
 		return err
@@ -250,17 +295,37 @@ func (r *ErrorResponse) Render(w http.ResponseWriter) error {
 }
 
 /* Some standard responses */
-var NotImplemented = &ErrorResponse{http.StatusNotImplemented, "not implemented"}
-var NotFound = &ErrorResponse{http.StatusNotFound, "not found"}
-var Forbidden = &ErrorResponse{http.StatusForbidden, "not authorized"}
-var Conflict = &ErrorResponse{http.StatusConflict, "already exists"}
+var NotImplemented = &ErrorResponse{http.StatusNotImplemented, shared.NotImplementedCode, "not implemented"}
+var NotFound = &ErrorResponse{http.StatusNotFound, shared.NotFoundCode, "not found"}
+var Forbidden = &ErrorResponse{http.StatusForbidden, shared.ForbiddenCode, "not authorized"}
+var Conflict = &ErrorResponse{http.StatusConflict, shared.AlreadyExistsCode, "already exists"}
+var TooManyRequests = &ErrorResponse{429, shared.RateLimitedCode, "too many requests"}
+
+func PreconditionFailed(err error) Response {
+	return &ErrorResponse{http.StatusPreconditionFailed, shared.PreconditionFailedCode, err.Error()}
+}
 
 func BadRequest(err error) Response {
-	return &ErrorResponse{http.StatusBadRequest, err.Error()}
+	return &ErrorResponse{http.StatusBadRequest, shared.InvalidRequestCode, err.Error()}
+}
+
+// InUse is returned when an object can't be removed while others use it.
+func InUse(err error) Response {
+	return &ErrorResponse{http.StatusBadRequest, shared.InUseCode, err.Error()}
 }
 
 func InternalError(err error) Response {
-	return &ErrorResponse{http.StatusInternalServerError, err.Error()}
+	return &ErrorResponse{http.StatusInternalServerError, shared.InternalErrorCode, err.Error()}
+}
+
+// StorageError is returned when the storage backend fails.
+func StorageError(err error) Response {
+	return &ErrorResponse{http.StatusInternalServerError, shared.StorageFailureCode, err.Error()}
+}
+
+// ServiceUnavailable is returned when the daemon can't handle requests yet.
+func ServiceUnavailable(err error) Response {
+	return &ErrorResponse{http.StatusServiceUnavailable, shared.NotReadyCode, err.Error()}
 }
 
 /*
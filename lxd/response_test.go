@@ -0,0 +1,62 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lxc/lxd/shared"
+)
+
+func Test_error_responses_carry_their_code(t *testing.T) {
+	responses := map[shared.ErrorCode]Response{
+		shared.NotFoundCode:       SmartError(sql.ErrNoRows),
+		shared.AlreadyExistsCode:  SmartError(DbErrAlreadyDefined),
+		shared.InvalidRequestCode: BadRequest(NoSuchObjectError),
+		shared.StorageFailureCode: StorageError(NoSuchObjectError),
+	}
+
+	for code, response := range responses {
+		w := httptest.NewRecorder()
+		if err := response.Render(w); err != nil {
+			t.Fatal(err)
+		}
+
+		body := struct {
+			Code     int                 `json:"error_code"`
+			Metadata shared.ErrorDetails `json:"metadata"`
+		}{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+
+		if body.Metadata.Code != code || body.Metadata.Type != code.String() {
+			t.Errorf("Wrong error details, expected %d: %s", code, w.Body.String())
+		}
+
+		if body.Code != w.Code || w.Code == http.StatusOK {
+			t.Errorf("Wrong HTTP code %d: %s", w.Code, w.Body.String())
+		}
+	}
+}
+
+func Test_error_responses_carry_the_request_id(t *testing.T) {
+	w := httptest.NewRecorder()
+	w.Header().Set("X-LXD-request-id", "some-id")
+	if err := NotFound.Render(w); err != nil {
+		t.Fatal(err)
+	}
+
+	body := struct {
+		RequestID string `json:"request_id"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	if body.RequestID != "some-id" {
+		t.Errorf("Wrong request id: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+)
+
+/*
+ * serverCertificate serves the certificate of the HTTPS listeners,
+ * reloading it whenever server.crt or server.key change on disk so that
+ * replacing them doesn't need a restart of the daemon. A certificate which
+ * fails to load (say because only one of the files was replaced yet) is
+ * ignored, the previous one being used until the files change again.
+ */
+type serverCertificate struct {
+	lock    sync.Mutex
+	certf   string
+	keyf    string
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func (c *serverCertificate) lastModified() (time.Time, error) {
+	modTime := time.Time{}
+	for _, path := range []string{c.certf, c.keyf} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+
+	return modTime, nil
+}
+
+// get returns the current certificate, it's used as GetCertificate.
+func (c *serverCertificate) get(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	modTime, err := c.lastModified()
+	if err == nil && (c.cert == nil || !modTime.Equal(c.modTime)) {
+		cert, err := tls.LoadX509KeyPair(c.certf, c.keyf)
+		if err != nil {
+			if c.cert == nil {
+				return nil, err
+			}
+
+			shared.Logf("Failed to reload the server certificate, keeping the current one: %s", err)
+		} else {
+			if c.cert != nil {
+				shared.Logf("Reloaded the server certificate")
+			}
+
+			c.cert = &cert
+		}
+
+		// Don't try again until the files change
+		c.modTime = modTime
+	}
+
+	if c.cert == nil {
+		return nil, fmt.Errorf("No server certificate: %s", err)
+	}
+
+	return c.cert, nil
+}
+
+// listenerTLSConfig returns the TLS configuration of the HTTPS listeners.
+func (d *Daemon) listenerTLSConfig() (*tls.Config, error) {
+	config, err := shared.GetTLSConfig(d.certf, d.keyf)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without those, GetCertificate is always used
+	config.Certificates = nil
+	config.NameToCertificate = nil
+	config.GetCertificate = d.serverCert.get
+
+	return config, nil
+}
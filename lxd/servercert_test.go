@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestCert(t *testing.T, certf string, keyf string, name string, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(certf, certPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(keyf, keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Chtimes(certf, modTime, modTime)
+	os.Chtimes(keyf, modTime, modTime)
+}
+
+func certCommonName(t *testing.T, c *serverCertificate) string {
+	cert, err := c.get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return parsed.Subject.CommonName
+}
+
+func Test_server_certificate_reloads_when_replaced(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lxd_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &serverCertificate{certf: filepath.Join(dir, "server.crt"), keyf: filepath.Join(dir, "server.key")}
+	if _, err := c.get(nil); err == nil {
+		t.Fatal("A missing certificate should fail")
+	}
+
+	now := time.Now()
+	writeTestCert(t, c.certf, c.keyf, "first", now.Add(-time.Minute))
+	if name := certCommonName(t, c); name != "first" {
+		t.Fatalf("Wrong certificate: %s", name)
+	}
+
+	// Only the certificate was replaced, the pair doesn't match
+	ioutil.WriteFile(c.keyf, []byte("garbage"), 0600)
+	if name := certCommonName(t, c); name != "first" {
+		t.Fatalf("The previous certificate should be kept: %s", name)
+	}
+
+	writeTestCert(t, c.certf, c.keyf, "second", now)
+	if name := certCommonName(t, c); name != "second" {
+		t.Errorf("The certificate wasn't reloaded: %s", name)
+	}
+}
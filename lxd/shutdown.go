@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os/exec"
+	"time"
+
+	"github.com/lxc/lxd/shared"
+)
+
+type shutdownPostReq struct {
+	Stateful bool `json:"stateful"`
+	Timeout  int  `json:"timeout"`
+}
+
+/*
+ * shutdownPost stops all the running containers, checkpointing them if
+ * asked to, and then makes the daemon exit, for the host to be rebooted.
+ * Only the requests following operations are served in the meantime, so
+ * that the client can wait for the containers to be stopped.
+ */
+func shutdownPost(d *Daemon, r *http.Request) Response {
+	req := shutdownPostReq{}
+	if err := shared.ReadToJSON(r.Body, &req); err != nil {
+		return BadRequest(err)
+	}
+
+	timeout := containersShutdownTimeout
+	if req.Timeout > 0 {
+		timeout = time.Duration(req.Timeout) * time.Second
+	}
+
+	if req.Stateful {
+		if _, err := exec.LookPath("criu"); err != nil {
+			return BadRequest(fmt.Errorf("Unable to checkpoint the containers, criu isn't installed"))
+		}
+	}
+
+	run := func() shared.OperationResult {
+		d.shutdownOnce.Do(func() { close(d.shutdownChan) })
+
+		// Exit once the operation is over, whether it worked or not
+		defer d.exitOnce.Do(func() { close(d.exitChan) })
+
+		err := containersShutdown(d, req.Stateful, timeout)
+		if err != nil {
+			return shared.OperationError(err)
+		}
+
+		return shared.OperationSuccess
+	}
+
+	return AsyncResponse(run, nil)
+}
+
+var shutdownCmd = Command{name: "shutdown", post: shutdownPost}
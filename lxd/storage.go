@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"os/exec"
@@ -48,8 +49,9 @@ func filesystemDetect(path string) (string, error) {
 	}
 }
 
-// storageRsyncCopy copies a directory using rsync (with the --devices option).
-func storageRsyncCopy(source string, dest string) (string, error) {
+// storageRsyncCopy copies a directory using rsync (with the --devices option),
+// unless the canceller gets cancelled first.
+func storageRsyncCopy(source string, dest string, canceller *operationCanceller) (string, error) {
 	if err := os.MkdirAll(dest, 0755); err != nil {
 		return "", err
 	}
@@ -59,7 +61,8 @@ func storageRsyncCopy(source string, dest string) (string, error) {
 		rsyncVerbosity = "-vi"
 	}
 
-	output, err := exec.Command(
+	output := &bytes.Buffer{}
+	cmd := exec.Command(
 		"rsync",
 		"-a",
 		"-HAX",
@@ -68,9 +71,22 @@ func storageRsyncCopy(source string, dest string) (string, error) {
 		"--checksum",
 		rsyncVerbosity,
 		shared.AddSlash(source),
-		dest).CombinedOutput()
+		dest)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
 
-	return string(output), err
+	done := canceller.OnCancel(func() { cmd.Process.Kill() })
+	err := cmd.Wait()
+	done()
+
+	if canceller.Cancelled() {
+		return output.String(), errOperationCancelled
+	}
+
+	return output.String(), err
 }
 
 func storageUnprivUserAclSet(c container, dpath string) error {
@@ -135,7 +151,11 @@ type storage interface {
 	ContainerCreateFromImage(container container, imageFingerprint string) error
 
 	ContainerDelete(container container) error
-	ContainerCopy(container container, sourceContainer container) error
+
+	// ContainerCopy copies sourceContainer's filesystem over to container,
+	// unless the canceller gets cancelled first.
+	ContainerCopy(container container, sourceContainer container, canceller *operationCanceller) error
+
 	ContainerStart(container container) error
 	ContainerStop(container container) error
 	ContainerRename(container container, newName string) error
@@ -146,7 +166,11 @@ type storage interface {
 	ContainerSnapshotDelete(snapshotContainer container) error
 	ContainerSnapshotRename(snapshotContainer container, newName string) error
 
-	ImageCreate(fingerprint string) error
+	// ContainerSnapshotUsage returns the space used by a snapshot, -1 if
+	// the backend can't tell.
+	ContainerSnapshotUsage(snapshotContainer container) (int64, error)
+
+	ImageCreate(fingerprint string, canceller *operationCanceller) error
 	ImageDelete(fingerprint string) error
 }
 
@@ -352,14 +376,15 @@ func (lw *storageLogWrapper) ContainerDelete(container container) error {
 }
 
 func (lw *storageLogWrapper) ContainerCopy(
-	container container, sourceContainer container) error {
+	container container, sourceContainer container,
+	canceller *operationCanceller) error {
 
 	lw.log.Debug(
 		"ContainerCopy",
 		log.Ctx{
 			"container": container.NameGet(),
 			"source":    sourceContainer.NameGet()})
-	return lw.w.ContainerCopy(container, sourceContainer)
+	return lw.w.ContainerCopy(container, sourceContainer, canceller)
 }
 
 func (lw *storageLogWrapper) ContainerStart(container container) error {
@@ -422,11 +447,19 @@ func (lw *storageLogWrapper) ContainerSnapshotRename(
 	return lw.w.ContainerSnapshotRename(snapshotContainer, newName)
 }
 
-func (lw *storageLogWrapper) ImageCreate(fingerprint string) error {
+func (lw *storageLogWrapper) ContainerSnapshotUsage(
+	snapshotContainer container) (int64, error) {
+
+	lw.log.Debug("ContainerSnapshotUsage",
+		log.Ctx{"snapshotContainer": snapshotContainer.NameGet()})
+	return lw.w.ContainerSnapshotUsage(snapshotContainer)
+}
+
+func (lw *storageLogWrapper) ImageCreate(fingerprint string, canceller *operationCanceller) error {
 	lw.log.Debug(
 		"ImageCreate",
 		log.Ctx{"fingerprint": fingerprint})
-	return lw.w.ImageCreate(fingerprint)
+	return lw.w.ImageCreate(fingerprint, canceller)
 }
 
 func (lw *storageLogWrapper) ImageDelete(fingerprint string) error {
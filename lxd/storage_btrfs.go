@@ -6,6 +6,7 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/lxc/lxd/shared"
@@ -76,7 +77,7 @@ func (s *storageBtrfs) ContainerCreateFromImage(
 
 	// Create the btrfs subvol of the image first if it doesn exists.
 	if !shared.PathExists(imageSubvol) {
-		if err := s.ImageCreate(imageFingerprint); err != nil {
+		if err := s.ImageCreate(imageFingerprint, nil); err != nil {
 			return err
 		}
 	}
@@ -121,7 +122,7 @@ func (s *storageBtrfs) ContainerDelete(container container) error {
 	return nil
 }
 
-func (s *storageBtrfs) ContainerCopy(container container, sourceContainer container) error {
+func (s *storageBtrfs) ContainerCopy(container container, sourceContainer container, canceller *operationCanceller) error {
 
 	subvol := sourceContainer.PathGet("")
 	dpath := container.PathGet("")
@@ -143,7 +144,8 @@ func (s *storageBtrfs) ContainerCopy(container container, sourceContainer contai
 		 */
 		output, err := storageRsyncCopy(
 			sourceContainer.PathGet(""),
-			container.PathGet(""))
+			container.PathGet(""),
+			canceller)
 		if err != nil {
 			s.ContainerDelete(container)
 
@@ -207,7 +209,8 @@ func (s *storageBtrfs) ContainerRestore(
 		if err := s.subvolCreate(targetSubVol); err == nil {
 			output, err := storageRsyncCopy(
 				sourceSubVol,
-				targetSubVol)
+				targetSubVol,
+				nil)
 
 			if err != nil {
 				s.log.Error(
@@ -260,7 +263,8 @@ func (s *storageBtrfs) ContainerSnapshotCreate(
 		 */
 		output, err := storageRsyncCopy(
 			subvol,
-			dpath)
+			dpath,
+			nil)
 		if err != nil {
 			s.ContainerSnapshotDelete(snapshotContainer)
 
@@ -322,7 +326,30 @@ func (s *storageBtrfs) ContainerSnapshotRename(
 	return nil
 }
 
-func (s *storageBtrfs) ImageCreate(fingerprint string) error {
+/*
+ * ContainerSnapshotUsage returns the space exclusively used by the
+ * snapshot's subvolume, which is only known when quotas are enabled.
+ */
+func (s *storageBtrfs) ContainerSnapshotUsage(
+	snapshotContainer container) (int64, error) {
+
+	output, err := exec.Command(
+		"btrfs", "qgroup", "show", "--raw", "-f", snapshotContainer.PathGet("")).CombinedOutput()
+	if err != nil {
+		// No quotas
+		return -1, nil
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(fields) < 3 {
+		return -1, fmt.Errorf("Unexpected output from btrfs qgroup show: '%s'", output)
+	}
+
+	return strconv.ParseInt(fields[2], 10, 64)
+}
+
+func (s *storageBtrfs) ImageCreate(fingerprint string, canceller *operationCanceller) error {
 	imagePath := shared.VarPath("images", fingerprint)
 	subvol := fmt.Sprintf("%s.btrfs", imagePath)
 
@@ -330,7 +357,8 @@ func (s *storageBtrfs) ImageCreate(fingerprint string) error {
 		return err
 	}
 
-	if err := untarImage(imagePath, subvol); err != nil {
+	if err := untarImage(imagePath, subvol, canceller); err != nil {
+		s.subvolDelete(subvol)
 		return err
 	}
 
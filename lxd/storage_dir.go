@@ -3,7 +3,9 @@ package main
 import (
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/lxc/lxd/shared"
@@ -57,7 +59,7 @@ func (s *storageDir) ContainerCreateFromImage(
 	}
 
 	imagePath := shared.VarPath("images", imageFingerprint)
-	if err := untarImage(imagePath, container.PathGet("")); err != nil {
+	if err := untarImage(imagePath, container.PathGet(""), nil); err != nil {
 		os.RemoveAll(rootfsPath)
 		return err
 	}
@@ -85,7 +87,8 @@ func (s *storageDir) ContainerDelete(container container) error {
 }
 
 func (s *storageDir) ContainerCopy(
-	container container, sourceContainer container) error {
+	container container, sourceContainer container,
+	canceller *operationCanceller) error {
 
 	oldPath := sourceContainer.RootfsPathGet()
 	newPath := container.RootfsPathGet()
@@ -93,7 +96,7 @@ func (s *storageDir) ContainerCopy(
 	/*
 	 * Copy by using rsync
 	 */
-	output, err := storageRsyncCopy(oldPath, newPath)
+	output, err := storageRsyncCopy(oldPath, newPath, canceller)
 	if err != nil {
 		s.ContainerDelete(container)
 		s.log.Error("ContainerCopy: rsync failed", log.Ctx{"output": string(output)})
@@ -139,7 +142,8 @@ func (s *storageDir) ContainerRestore(
 	// Restore using rsync
 	output, err := storageRsyncCopy(
 		sourcePath,
-		targetPath)
+		targetPath,
+		nil)
 
 	if err != nil {
 		s.log.Error(
@@ -166,7 +170,7 @@ func (s *storageDir) ContainerSnapshotCreate(
 	/*
 	 * Copy by using rsync
 	 */
-	output, err := storageRsyncCopy(oldPath, newPath)
+	output, err := storageRsyncCopy(oldPath, newPath, nil)
 	if err != nil {
 		s.ContainerDelete(snapshotContainer)
 		s.log.Error("ContainerSnapshotCreate: rsync failed",
@@ -220,7 +224,24 @@ func (s *storageDir) ContainerSnapshotRename(
 	return nil
 }
 
-func (s *storageDir) ImageCreate(fingerprint string) error {
+// ContainerSnapshotUsage returns the disk usage of the snapshot's copy.
+func (s *storageDir) ContainerSnapshotUsage(
+	snapshotContainer container) (int64, error) {
+
+	output, err := exec.Command("du", "-s", "-B1", snapshotContainer.PathGet("")).CombinedOutput()
+	if err != nil {
+		return -1, fmt.Errorf("Failed to get the snapshot's usage: %s", strings.TrimSpace(string(output)))
+	}
+
+	fields := strings.Fields(string(output))
+	if len(fields) == 0 {
+		return -1, fmt.Errorf("Unexpected output from du: '%s'", output)
+	}
+
+	return strconv.ParseInt(fields[0], 10, 64)
+}
+
+func (s *storageDir) ImageCreate(fingerprint string, canceller *operationCanceller) error {
 	return nil
 }
 
@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 
@@ -245,7 +246,7 @@ func (s *storageLvm) ContainerCreateFromImage(
 		"images", fmt.Sprintf("%s.lv", imageFingerprint))
 
 	if !shared.PathExists(imageLVFilename) {
-		if err := s.ImageCreate(imageLVFilename); err != nil {
+		if err := s.ImageCreate(imageLVFilename, nil); err != nil {
 			return err
 		}
 	}
@@ -310,7 +311,7 @@ func (s *storageLvm) ContainerDelete(container container) error {
 	return nil
 }
 
-func (s *storageLvm) ContainerCopy(container container, sourceContainer container) error {
+func (s *storageLvm) ContainerCopy(container container, sourceContainer container, canceller *operationCanceller) error {
 	if s.isLVMContainer(sourceContainer) {
 		if err := s.createSnapshotContainer(container, sourceContainer, false); err != nil {
 			s.log.Error("Error creating snapshot LV for copy", log.Ctx{"err": err})
@@ -332,7 +333,8 @@ func (s *storageLvm) ContainerCopy(container container, sourceContainer containe
 
 		output, err := storageRsyncCopy(
 			sourceContainer.PathGet(""),
-			container.PathGet(""))
+			container.PathGet(""),
+			canceller)
 		if err != nil {
 			s.log.Error("ContainerCopy: rsync failed", log.Ctx{"output": string(output)})
 			s.ContainerDelete(container)
@@ -509,7 +511,37 @@ func (s *storageLvm) ContainerSnapshotRename(
 	return nil
 }
 
-func (s *storageLvm) ImageCreate(fingerprint string) error {
+// ContainerSnapshotUsage returns the space the snapshot's thin LV uses.
+func (s *storageLvm) ContainerSnapshotUsage(
+	snapshotContainer container) (int64, error) {
+
+	lvName := containerNameToLVName(snapshotContainer.NameGet())
+	output, err := exec.Command(
+		"lvs", "--noheadings", "--units", "b", "--nosuffix",
+		"-o", "lv_size,data_percent", fmt.Sprintf("%s/%s", s.vgName, lvName)).CombinedOutput()
+	if err != nil {
+		return -1, fmt.Errorf("Failed to get the snapshot's usage: %s", strings.TrimSpace(string(output)))
+	}
+
+	fields := strings.Fields(string(output))
+	if len(fields) != 2 {
+		return -1, fmt.Errorf("Unexpected output from lvs: '%s'", output)
+	}
+
+	size, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return -1, err
+	}
+
+	percent, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return -1, err
+	}
+
+	return int64(float64(size) * percent / 100), nil
+}
+
+func (s *storageLvm) ImageCreate(fingerprint string, canceller *operationCanceller) error {
 	finalName := shared.VarPath("images", fingerprint)
 
 	lvpath, err := s.createThinLV(fingerprint)
@@ -546,7 +578,7 @@ func (s *storageLvm) ImageCreate(fingerprint string) error {
 
 	}
 
-	untarErr := untarImage(finalName, tempLVMountPoint)
+	untarErr := untarImage(finalName, tempLVMountPoint, canceller)
 
 	output, err = exec.Command("umount", tempLVMountPoint).CombinedOutput()
 	if err != nil {
@@ -561,6 +593,11 @@ func (s *storageLvm) ImageCreate(fingerprint string) error {
 			tempLVMountPoint, untarErr)
 	}
 
+	// Don't leave a partially unpacked image behind
+	if untarErr != nil {
+		s.ImageDelete(fingerprint)
+	}
+
 	return untarErr
 }
 
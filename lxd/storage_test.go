@@ -44,7 +44,8 @@ func (s *storageMock) ContainerDelete(container container) error {
 }
 
 func (s *storageMock) ContainerCopy(
-	container container, sourceContainer container) error {
+	container container, sourceContainer container,
+	canceller *operationCanceller) error {
 
 	return nil
 }
@@ -86,7 +87,13 @@ func (s *storageMock) ContainerSnapshotRename(
 	return nil
 }
 
-func (s *storageMock) ImageCreate(fingerprint string) error {
+func (s *storageMock) ContainerSnapshotUsage(
+	snapshotContainer container) (int64, error) {
+
+	return -1, nil
+}
+
+func (s *storageMock) ImageCreate(fingerprint string, canceller *operationCanceller) error {
 	return nil
 }
 
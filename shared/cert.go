@@ -24,9 +24,26 @@ import (
 
 // CertInfo is the representation of a Certificate in the API.
 type CertInfo struct {
-	Certificate string `json:"certificate"`
-	Fingerprint string `json:"fingerprint"`
-	Type        string `json:"type"`
+	Certificate string    `json:"certificate"`
+	Fingerprint string    `json:"fingerprint"`
+	Name        string    `json:"name"`
+	Type        string    `json:"type"`
+	ReadOnly    bool      `json:"read_only"`
+	Restricted  bool      `json:"restricted"`
+	Containers  []string  `json:"containers"`
+	AddedAt     time.Time `json:"added_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Expired     bool      `json:"expired"`
+}
+
+// CertTokenInfo describes a single-use token letting a client add its
+// certificate to the trust store. Token is only set when it's created.
+type CertTokenInfo struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Token     string    `json:"token,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 /*
@@ -2,6 +2,7 @@ package shared
 
 import (
 	"strconv"
+	"time"
 )
 
 type Ip struct {
@@ -12,10 +13,12 @@ type Ip struct {
 }
 
 type ContainerStatus struct {
-	Status     string     `json:"status"`
-	StatusCode StatusCode `json:"status_code"`
-	Init       int        `json:"init"`
-	Ips        []Ip       `json:"ips"`
+	Status      string     `json:"status"`
+	StatusCode  StatusCode `json:"status_code"`
+	Init        int        `json:"init"`
+	Ips         []Ip       `json:"ips"`
+	MemoryUsage int64      `json:"memory_usage"`
+	CPUUsage    int64      `json:"cpu_usage"`
 }
 
 type ContainerExecControl struct {
@@ -25,6 +28,7 @@ type ContainerExecControl struct {
 
 type ContainerState struct {
 	Architecture    int               `json:"architecture"`
+	CreatedAt       time.Time         `json:"created_at"`
 	Config          map[string]string `json:"config"`
 	Devices         Devices           `json:"devices"`
 	Ephemeral       bool              `json:"ephemeral"`
@@ -57,6 +61,13 @@ func (c *ContainerState) BriefState() BriefContainerState {
 	return retstate
 }
 
+type SnapshotInfo struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Stateful  bool      `json:"stateful"`
+	Size      int64     `json:"size"`
+}
+
 type ContainerInfo struct {
 	State ContainerState `json:"state"`
 	Snaps []string       `json:"snaps"`
@@ -99,4 +110,19 @@ type ProfileConfig struct {
 	Name    string            `json:"name"`
 	Config  map[string]string `json:"config"`
 	Devices Devices           `json:"devices"`
+	UsedBy  []string          `json:"used_by"`
+}
+
+// BatchResult is what a batch operation on containers reports once done.
+type BatchResult struct {
+	Action string `json:"action"`
+
+	// The containers the action succeeded on, and why it failed on the others
+	Containers []string          `json:"containers"`
+	Failures   map[string]string `json:"failures"`
+
+	// In seconds: the whole batch, then the average and slowest container
+	Duration float64 `json:"duration"`
+	Average  float64 `json:"average"`
+	Slowest  float64 `json:"slowest"`
 }
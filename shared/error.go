@@ -0,0 +1,43 @@
+package shared
+
+/*
+ * ErrorCode is a stable identifier of the cause of an API error, sent
+ * along with its (free form) message in the error response metadata.
+ */
+type ErrorCode int
+
+const (
+	InternalErrorCode      ErrorCode = 1000
+	InvalidRequestCode     ErrorCode = 1001
+	NotFoundCode           ErrorCode = 1002
+	AlreadyExistsCode      ErrorCode = 1003
+	ForbiddenCode          ErrorCode = 1004
+	NotImplementedCode     ErrorCode = 1005
+	PreconditionFailedCode ErrorCode = 1006
+	InUseCode              ErrorCode = 1007
+	StorageFailureCode     ErrorCode = 1008
+	RateLimitedCode        ErrorCode = 1009
+	NotReadyCode           ErrorCode = 1010
+)
+
+func (c ErrorCode) String() string {
+	return map[ErrorCode]string{
+		InternalErrorCode:      "internal-error",
+		InvalidRequestCode:     "invalid-request",
+		NotFoundCode:           "not-found",
+		AlreadyExistsCode:      "already-exists",
+		ForbiddenCode:          "forbidden",
+		NotImplementedCode:     "not-implemented",
+		PreconditionFailedCode: "precondition-failed",
+		InUseCode:              "in-use",
+		StorageFailureCode:     "storage-failure",
+		RateLimitedCode:        "rate-limited",
+		NotReadyCode:           "not-ready",
+	}[c]
+}
+
+// ErrorDetails is the metadata of an error response.
+type ErrorDetails struct {
+	Code ErrorCode `json:"code"`
+	Type string    `json:"type"`
+}
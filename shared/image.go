@@ -9,6 +9,17 @@ type ImageAlias struct {
 
 type ImageAliases []ImageAlias
 
+// ImageAliasInfo is an alias as /1.0/images/aliases/<name> returns it.
+type ImageAliasInfo struct {
+	Name        string `json:"name"`
+	Target      string `json:"target"`
+	Description string `json:"description"`
+
+	// Whether the target is the image cached for an alias of a remote
+	// server, which gets checked for a newer one once it's expired
+	AutoUpdate bool `json:"auto_update"`
+}
+
 type ImageInfo struct {
 	Aliases      ImageAliases      `json:"aliases"`
 	Architecture int               `json:"architecture"`
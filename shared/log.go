@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"path/filepath"
 	"runtime"
+	"sync"
 
 	log "gopkg.in/inconshreveable/log15.v2"
 )
@@ -13,24 +14,29 @@ var Log log.Logger
 var debug bool
 
 // SetLogger defines the *log.Logger where log messages are sent to.
-func SetLogger(syslog string, logfile string, verbose bool, debug bool) error {
+// customHandler, if not nil, gets every log message regardless of level.
+// The others (syslog, which is also picked up by journald on systemd
+// systems, logfile and stderr) only get the messages at or above the log
+// level, which SetLogLevel changes.
+func SetLogger(syslog string, logfile string, verbose bool, debug bool, customHandler log.Handler) error {
 	Log = log.New()
 
+	defaultLogLevel = log.LvlInfo
+	if debug {
+		defaultLogLevel = log.LvlDebug
+	}
+	SetLogLevel("")
+
 	var handlers []log.Handler
 
+	// Custom handler
+	if customHandler != nil {
+		handlers = append(handlers, customHandler)
+	}
+
 	// SyslogHandler
 	if syslog != "" {
-		if !debug {
-			handlers = append(
-				handlers,
-				log.LvlFilterHandler(
-					log.LvlInfo,
-					log.Must.SyslogHandler(syslog, log.LogfmtFormat()),
-				),
-			)
-		} else {
-			handlers = append(handlers, log.Must.SyslogHandler(syslog, log.LogfmtFormat()))
-		}
+		handlers = append(handlers, logLevelHandler(log.Must.SyslogHandler(syslog, log.LogfmtFormat())))
 	}
 
 	// FileHandler
@@ -39,32 +45,12 @@ func SetLogger(syslog string, logfile string, verbose bool, debug bool) error {
 			return fmt.Errorf("Log file path doesn't exist: %s\n", filepath.Dir(logfile))
 		}
 
-		if !debug {
-			handlers = append(
-				handlers,
-				log.LvlFilterHandler(
-					log.LvlInfo,
-					log.Must.FileHandler(logfile, log.LogfmtFormat()),
-				),
-			)
-		} else {
-			handlers = append(handlers, log.Must.FileHandler(logfile, log.LogfmtFormat()))
-		}
+		handlers = append(handlers, logLevelHandler(log.Must.FileHandler(logfile, log.LogfmtFormat())))
 	}
 
 	// StderrHandler
 	if verbose || debug {
-		if !debug {
-			handlers = append(
-				handlers,
-				log.LvlFilterHandler(
-					log.LvlInfo,
-					log.StderrHandler,
-				),
-			)
-		} else {
-			handlers = append(handlers, log.StderrHandler)
-		}
+		handlers = append(handlers, logLevelHandler(log.StderrHandler))
 	}
 
 	Log.SetHandler(log.MultiHandler(handlers...))
@@ -72,6 +58,45 @@ func SetLogger(syslog string, logfile string, verbose bool, debug bool) error {
 	return nil
 }
 
+var logLevelLock sync.RWMutex
+var logLevel log.Lvl
+var defaultLogLevel log.Lvl
+
+// logLevelHandler only passes the messages at or above the log level to h.
+func logLevelHandler(h log.Handler) log.Handler {
+	return log.FuncHandler(func(r *log.Record) error {
+		logLevelLock.RLock()
+		level := logLevel
+		logLevelLock.RUnlock()
+
+		if r.Lvl > level {
+			return nil
+		}
+
+		return h.Log(r)
+	})
+}
+
+// SetLogLevel changes the level of the messages which are logged. The
+// level is one of "debug", "info", "warn", "error" or "crit", or the empty
+// string to go back to the level SetLogger was called with.
+func SetLogLevel(level string) error {
+	lvl := defaultLogLevel
+	if level != "" {
+		var err error
+		lvl, err = log.LvlFromString(level)
+		if err != nil {
+			return err
+		}
+	}
+
+	logLevelLock.Lock()
+	logLevel = lvl
+	logLevelLock.Unlock()
+
+	return nil
+}
+
 // Logf sends to the logger registered via SetLogger the string resulting
 // from running format and args through Sprintf.
 func Logf(format string, args ...interface{}) {
@@ -0,0 +1,30 @@
+package shared
+
+import (
+	"testing"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+func Test_log_level_changes_the_logged_messages(t *testing.T) {
+	count := 0
+	handler := logLevelHandler(log.FuncHandler(func(r *log.Record) error {
+		count++
+		return nil
+	}))
+
+	defaultLogLevel = log.LvlWarn
+	SetLogLevel("")
+	handler.Log(&log.Record{Lvl: log.LvlError})
+	handler.Log(&log.Record{Lvl: log.LvlInfo})
+	if count != 1 {
+		t.Errorf("Wrong number of messages logged at the warn level: %d", count)
+	}
+
+	defaultLogLevel = log.LvlDebug
+	SetLogLevel("")
+	handler.Log(&log.Record{Lvl: log.LvlDebug})
+	if count != 2 {
+		t.Errorf("Debug messages weren't logged at the debug level")
+	}
+}
@@ -5,10 +5,82 @@ import (
 	"io"
 	"io/ioutil"
 	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// NetworkConfig is how a network is represented by /1.0/networks.
+type NetworkConfig struct {
+	Name    string            `json:"name"`
+	Type    string            `json:"type"`
+	Managed bool              `json:"managed"`
+	Config  map[string]string `json:"config"`
+	Members []string          `json:"members"`
+	UsedBy  []string          `json:"used_by"`
+	State   *NetworkState     `json:"state"`
+}
+
+// NetworkState is the state of a network's interface, nil if it has none.
+type NetworkState struct {
+	State    string          `json:"state"`
+	MTU      int             `json:"mtu"`
+	Counters NetworkCounters `json:"counters"`
+}
+
+// NetworkCounters is the traffic of a network since its interface was created.
+type NetworkCounters struct {
+	BytesReceived   int64 `json:"bytes_received"`
+	BytesSent       int64 `json:"bytes_sent"`
+	PacketsReceived int64 `json:"packets_received"`
+	PacketsSent     int64 `json:"packets_sent"`
+}
+
+// NetworkLease is an address handed out by the DHCP server of a network.
+type NetworkLease struct {
+	Hostname string    `json:"hostname"`
+	Hwaddr   string    `json:"hwaddr"`
+	Address  string    `json:"address"`
+	Expiry   time.Time `json:"expiry"`
+}
+
+/*
+ * NetworkForward sends the traffic reaching the host on a port to a
+ * container of a managed network.
+ */
+type NetworkForward struct {
+	Protocol      string `json:"protocol"`
+	ListenAddress string `json:"listen_address"`
+	ListenPort    int    `json:"listen_port"`
+	TargetAddress string `json:"target_address"`
+	TargetPort    int    `json:"target_port"`
+	Description   string `json:"description"`
+}
+
+// Name is how the forward is referred to, for instance "tcp:80", or
+// "tcp:10.0.0.1:80" with a listen address.
+func (f NetworkForward) Name() string {
+	if f.ListenAddress != "" {
+		return fmt.Sprintf("%s:%s", f.Protocol, net.JoinHostPort(f.ListenAddress, strconv.Itoa(f.ListenPort)))
+	}
+
+	return fmt.Sprintf("%s:%d", f.Protocol, f.ListenPort)
+}
+
+// Overlaps tells whether two forwards would take the same port, a forward
+// without a listen address taking it on all addresses.
+func (f NetworkForward) Overlaps(other NetworkForward) bool {
+	if f.Protocol != other.Protocol || f.ListenPort != other.ListenPort {
+		return false
+	}
+
+	return f.ListenAddress == "" || other.ListenAddress == "" || f.ListenAddress == other.ListenAddress
+}
+
 func RFC3493Dialer(network, address string) (net.Conn, error) {
 	host, port, err := net.SplitHostPort(address)
 	if err != nil {
@@ -29,6 +101,56 @@ func RFC3493Dialer(network, address string) (net.Conn, error) {
 	return nil, fmt.Errorf("Unable to connect to: " + address)
 }
 
+// ProxyFromConfig returns a proxy function suitable for http.Transport
+// which uses the given HTTPS and HTTP proxies, except for the hosts listed
+// in the comma separated noProxy. When neither proxy is set, the usual
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are used.
+func ProxyFromConfig(httpsProxy string, httpProxy string, noProxy string) func(req *http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		if httpsProxy == "" && httpProxy == "" {
+			return http.ProxyFromEnvironment(req)
+		}
+
+		host, _, err := net.SplitHostPort(req.URL.Host)
+		if err != nil {
+			host = req.URL.Host
+		}
+
+		for _, entry := range strings.Split(noProxy, ",") {
+			entry = strings.TrimPrefix(strings.TrimSpace(entry), ".")
+			if entry == "" {
+				continue
+			}
+
+			if entry == "*" || host == entry || strings.HasSuffix(host, "."+entry) {
+				return nil, nil
+			}
+		}
+
+		proxy := httpProxy
+		if req.URL.Scheme == "https" || req.URL.Scheme == "wss" {
+			proxy = httpsProxy
+		}
+
+		if proxy == "" {
+			return nil, nil
+		}
+
+		// Accept bare "host:port" proxies as well, other schemes (e.g.
+		// socks5://) are kept as they are.
+		if !strings.Contains(proxy, "://") {
+			proxy = "http://" + proxy
+		}
+
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy address %q: %v", proxy, err)
+		}
+
+		return proxyURL, nil
+	}
+}
+
 func IsLoopback(iface *net.Interface) bool {
 	return int(iface.Flags&net.FlagLoopback) > 0
 }
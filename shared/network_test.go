@@ -0,0 +1,42 @@
+package shared
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestProxyFromConfig(t *testing.T) {
+	proxy := ProxyFromConfig("https://secure:3128", "plain:3128", "localhost, .example.net")
+
+	check := func(target string, expected string) {
+		req, err := http.NewRequest("GET", target, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		u, err := proxy(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if u == nil && expected == "" {
+			return
+		}
+
+		if u == nil || u.String() != expected {
+			t.Errorf("wrong proxy for %s: %v (expected %q)", target, u, expected)
+		}
+	}
+
+	check("https://images.linuxcontainers.org", "https://secure:3128")
+	check("http://images.linuxcontainers.org", "http://plain:3128")
+	check("https://localhost:8443/1.0", "")
+	check("https://foo.example.net/1.0", "")
+	check("https://example.net/1.0", "")
+	check("https://notexample.net/1.0", "https://secure:3128")
+
+	proxy = ProxyFromConfig("socks5://socks:1080", "", "")
+	check("https://images.linuxcontainers.org", "socks5://socks:1080")
+}
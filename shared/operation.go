@@ -15,6 +15,15 @@ var WebsocketUpgrader = websocket.Upgrader{
 	CheckOrigin:     func(r *http.Request) bool { return true },
 }
 
+// WebsocketCompressedUpgrader is like WebsocketUpgrader, but negotiates
+// per-message deflate compression with the clients which support it.
+var WebsocketCompressedUpgrader = websocket.Upgrader{
+	ReadBufferSize:    1024,
+	WriteBufferSize:   1024,
+	CheckOrigin:       func(r *http.Request) bool { return true },
+	EnableCompression: true,
+}
+
 // OperationWebsocket represents the /websocket endpoint for operations. Users
 // can connect by specifying a secret (given to them at operation creation
 // time). As soon as the operation is created, the websocket's Do() function is
@@ -49,6 +58,42 @@ func OperationError(err error) OperationResult {
 	return OperationResult{nil, err}
 }
 
+/*
+ * OperationProgress is the common schema of the "progress" key of the
+ * metadata of long running operations (image downloads, publishes,
+ * container copies and migrations). Processed and Total are in bytes and
+ * Speed in bytes per second; Total and Percent are zero when the size of
+ * the current stage isn't known in advance.
+ */
+type OperationProgress struct {
+	Stage     string `json:"stage"`
+	Percent   int    `json:"percent"`
+	Processed int64  `json:"processed"`
+	Total     int64  `json:"total"`
+	Speed     int64  `json:"speed"`
+}
+
+func (p OperationProgress) String() string {
+	out := p.Stage
+	if p.Processed > 0 || p.Total > 0 {
+		if out != "" {
+			out += ": "
+		}
+
+		if p.Total > 0 {
+			out += fmt.Sprintf("%d%% (%s/%s)", p.Percent, GetByteSizeString(p.Processed), GetByteSizeString(p.Total))
+		} else {
+			out += GetByteSizeString(p.Processed)
+		}
+
+		if p.Speed > 0 {
+			out += fmt.Sprintf(" %s/s", GetByteSizeString(p.Speed))
+		}
+	}
+
+	return out
+}
+
 type Operation struct {
 	CreatedAt  time.Time           `json:"created_at"`
 	UpdatedAt  time.Time           `json:"updated_at"`
@@ -76,6 +121,10 @@ type Operation struct {
 }
 
 func (o *Operation) GetError() error {
+	if o.StatusCode == Cancelled {
+		return fmt.Errorf("Operation cancelled")
+	}
+
 	if o.StatusCode == Failure {
 		var s string
 		if err := json.Unmarshal(o.Metadata, &s); err != nil {
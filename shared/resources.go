@@ -0,0 +1,48 @@
+package shared
+
+// Resources describes the capacity of the host LXD runs on.
+type Resources struct {
+	CPU     ResourcesCPU     `json:"cpu"`
+	Memory  ResourcesMemory  `json:"memory"`
+	Storage ResourcesStorage `json:"storage"`
+	GPUs    []ResourcesGPU   `json:"gpus"`
+}
+
+type ResourcesCPU struct {
+	Sockets   []ResourcesCPUSocket `json:"sockets"`
+	NUMANodes []ResourcesNUMANode  `json:"numa_nodes"`
+	Total     int                  `json:"total"`
+}
+
+type ResourcesCPUSocket struct {
+	Socket  int    `json:"socket"`
+	Model   string `json:"model"`
+	Cores   int    `json:"cores"`
+	Threads int    `json:"threads"`
+}
+
+type ResourcesNUMANode struct {
+	Node   int    `json:"node"`
+	CPUs   string `json:"cpus"`
+	Memory uint64 `json:"memory"`
+}
+
+// ResourcesMemory sizes are in bytes.
+type ResourcesMemory struct {
+	Total     uint64 `json:"total"`
+	Available uint64 `json:"available"`
+}
+
+// ResourcesStorage sizes are in bytes.
+type ResourcesStorage struct {
+	Driver    string `json:"driver"`
+	Total     uint64 `json:"total"`
+	Available uint64 `json:"available"`
+}
+
+type ResourcesGPU struct {
+	Card    string `json:"card"`
+	Vendor  string `json:"vendor"`
+	Product string `json:"product"`
+	Driver  string `json:"driver"`
+}
@@ -1,5 +1,9 @@
 package shared
 
+import (
+	"strings"
+)
+
 type ServerStateEnvironment struct {
 	Addresses          []string `json:"addresses"`
 	Architectures      []int    `json:"architectures"`
@@ -16,10 +20,11 @@ type ServerStateEnvironment struct {
 }
 
 type ServerState struct {
-	APICompat   int                    `json:"api_compat"`
-	Auth        string                 `json:"auth"`
-	Environment ServerStateEnvironment `json:"environment"`
-	Config      map[string]interface{} `json:"config"`
+	APICompat     int                    `json:"api_compat"`
+	APIExtensions []string               `json:"api_extensions"`
+	Auth          string                 `json:"auth"`
+	Environment   ServerStateEnvironment `json:"environment"`
+	Config        map[string]interface{} `json:"config"`
 }
 
 type BriefServerState struct {
@@ -30,3 +35,104 @@ func (c *ServerState) BriefState() BriefServerState {
 	retstate := BriefServerState{Config: c.Config}
 	return retstate
 }
+
+/*
+ * ServerHealth tells which parts of the daemon are up: whether it finished
+ * initializing, can reach its database and storage, and how many sockets it
+ * serves the API on.
+ */
+type ServerHealth struct {
+	Ready     bool `json:"ready"`
+	Database  bool `json:"database"`
+	Storage   bool `json:"storage"`
+	Listeners int  `json:"listeners"`
+}
+
+// DatabaseDump is the content of the database, as SQL statements.
+type DatabaseDump struct {
+	Dump string `json:"dump"`
+}
+
+/*
+ * DatabaseCheck is the result of the database consistency checks: the
+ * problems SQLite found (or just "ok") and the rows referencing a missing
+ * one.
+ */
+type DatabaseCheck struct {
+	OK          bool     `json:"ok"`
+	Integrity   []string `json:"integrity"`
+	ForeignKeys []string `json:"foreign_keys"`
+}
+
+/*
+ * ConfigKeyInfo documents a configuration key. A name ending with ".*" or
+ * containing "<name>" stands for a family of keys (like "user.*").
+ * LiveUpdate tells whether changing the key takes effect right away, rather
+ * than on the next restart of the container.
+ */
+type ConfigKeyInfo struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Default     string `json:"default"`
+	Description string `json:"description"`
+	LiveUpdate  bool   `json:"live_update"`
+}
+
+// Match tells whether the key, or its family, includes the given key.
+func (k ConfigKeyInfo) Match(name string) bool {
+	if strings.HasSuffix(k.Name, ".*") {
+		prefix := strings.TrimSuffix(k.Name, "*")
+		return strings.HasPrefix(name, prefix) && len(name) > len(prefix)
+	}
+
+	if i := strings.Index(k.Name, "<name>"); i >= 0 {
+		prefix := k.Name[:i]
+		suffix := k.Name[i+len("<name>"):]
+		return strings.HasPrefix(name, prefix) && strings.HasSuffix(name, suffix) &&
+			len(name) > len(prefix)+len(suffix)
+	}
+
+	return k.Name == name
+}
+
+type ConfigKeys struct {
+	Server    []ConfigKeyInfo `json:"server"`
+	Container []ConfigKeyInfo `json:"container"`
+	Network   []ConfigKeyInfo `json:"network"`
+}
+
+/*
+ * ClusterInfo is how a daemon introduces itself to the other members of a
+ * cluster: its name and its base64 encoded server certificate.
+ */
+type ClusterInfo struct {
+	Name        string `json:"name"`
+	Certificate string `json:"certificate"`
+}
+
+/*
+ * ClusterMember is another daemon of the cluster. Address is the address and
+ * port of its HTTPS listener and Certificate its base64 encoded server
+ * certificate, the only one accepted when talking to it.
+ */
+type ClusterMember struct {
+	Name        string `json:"name"`
+	Address     string `json:"address"`
+	Certificate string `json:"certificate"`
+}
+
+// ClusterContainer is a container of the cluster and the member it's on.
+type ClusterContainer struct {
+	Member    string        `json:"member"`
+	Container ContainerInfo `json:"container"`
+}
+
+/*
+ * ClusterContainers lists the containers of all the members of the cluster.
+ * Unreachable has the members which couldn't be queried, their containers
+ * being missing from the list.
+ */
+type ClusterContainers struct {
+	Containers  []ClusterContainer `json:"containers"`
+	Unreachable []string           `json:"unreachable"`
+}
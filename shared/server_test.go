@@ -0,0 +1,29 @@
+package shared
+
+import (
+	"testing"
+)
+
+func Test_config_key_match(t *testing.T) {
+	tests := []struct {
+		key   string
+		name  string
+		match bool
+	}{
+		{"limits.cpus", "limits.cpus", true},
+		{"limits.cpus", "limits.cpus.foo", false},
+		{"user.*", "user.foo", true},
+		{"user.*", "user.", false},
+		{"user.*", "users.foo", false},
+		{"volatile.<name>.hwaddr", "volatile.eth0.hwaddr", true},
+		{"volatile.<name>.hwaddr", "volatile..hwaddr", false},
+		{"volatile.<name>.hwaddr", "volatile.eth0.name", false},
+	}
+
+	for _, test := range tests {
+		key := ConfigKeyInfo{Name: test.key}
+		if key.Match(test.name) != test.match {
+			t.Errorf("Wrong match of %s against %s, expected %v", test.name, test.key, test.match)
+		}
+	}
+}
@@ -119,6 +119,17 @@ func VarPath(path ...string) string {
 	return filepath.Join(items...)
 }
 
+// UnixSocketPath returns the path of LXD's control socket: $LXD_SOCKET if
+// set, unix.socket under VarPath otherwise.
+func UnixSocketPath() string {
+	path := os.Getenv("LXD_SOCKET")
+	if path != "" {
+		return path
+	}
+
+	return VarPath("unix.socket")
+}
+
 // LogPath returns the directory that LXD should put logs under. If LXD_DIR is
 // set, this path is $LXD_DIR/logs, otherwise it is /var/log/lxd.
 func LogPath(path ...string) string {
@@ -446,6 +457,23 @@ func IntInSlice(key int, list []int) bool {
 	return false
 }
 
+// GetByteSizeString renders a size in bytes in a human readable way.
+func GetByteSizeString(input int64) string {
+	if input < 1024 {
+		return fmt.Sprintf("%dB", input)
+	}
+
+	value := float64(input)
+	for _, unit := range []string{"kB", "MB", "GB", "TB", "PB", "EB"} {
+		value = value / 1024
+		if value < 1024 {
+			return fmt.Sprintf("%.2f%s", value, unit)
+		}
+	}
+
+	return fmt.Sprintf("%.2fEB", value)
+}
+
 /*
  * returns 1 if path is mounted shared:
  * returns 0 if path is not listed
@@ -100,3 +100,33 @@ func TestReadLastNLines(t *testing.T) {
 		}
 	}
 }
+
+func TestGetByteSizeString(t *testing.T) {
+	sizes := map[int64]string{
+		0:                      "0B",
+		1023:                   "1023B",
+		1024:                   "1.00kB",
+		1536:                   "1.50kB",
+		5 * 1024 * 1024:        "5.00MB",
+		3 * 1024 * 1024 * 1024: "3.00GB",
+	}
+
+	for size, expected := range sizes {
+		if result := GetByteSizeString(size); result != expected {
+			t.Errorf("%d: got %s expected %s", size, result, expected)
+		}
+	}
+}
+
+func TestOperationProgressString(t *testing.T) {
+	progress := OperationProgress{Stage: "Downloading image", Percent: 50, Processed: 1024, Total: 2048, Speed: 512}
+	expected := "Downloading image: 50% (1.00kB/2.00kB) 512B/s"
+	if result := progress.String(); result != expected {
+		t.Errorf("got %s expected %s", result, expected)
+	}
+
+	progress = OperationProgress{Stage: "Unpacking image"}
+	if result := progress.String(); result != "Unpacking image" {
+		t.Errorf("got %s expected Unpacking image", result)
+	}
+}
@@ -13,6 +13,17 @@ test_basic_usage() {
   ensure_import_testimage
   ensure_has_localhost_remote
 
+  # Test client and server versions
+  lxc version | grep "^Client version:"
+  lxc version localhost: | grep "^Server version:"
+  lxc version localhost: | grep "^LXC version:"
+  [ "$(lxc --version)" = "$(lxd --version)" ]
+
+  # Test the json output format
+  lxc list --format=json | grep '"status_code": 200'
+  lxc list --format=json | grep -q "^+" && false
+  lxc list --format=foo && false
+
   # Test image export
   sum=$(lxc image info testimage | grep ^Fingerprint | cut -d' ' -f2)
   lxc image export testimage ${LXD_DIR}/
@@ -34,6 +45,20 @@ test_basic_usage() {
   # Test filename for image export (should be "out")
   lxc image export testimage ${LXD_DIR}/
   [ "$sum" = "$(sha256sum ${LXD_DIR}/testimage.tar.xz | cut -d' ' -f1)" ]
+
+  # Test split image export
+  mkdir ${LXD_DIR}/split
+  tar -C ${LXD_DIR}/split -xf ${LXD_DIR}/testimage.tar.xz
+  tar -C ${LXD_DIR}/split -cf ${LXD_DIR}/meta.tar --exclude=./rootfs .
+  tar -C ${LXD_DIR}/split/rootfs -cf ${LXD_DIR}/rootfs.tar .
+  lxc image import ${LXD_DIR}/meta.tar ${LXD_DIR}/rootfs.tar --alias splitimage
+  splitsum=$(lxc image info splitimage | grep ^Fingerprint | cut -d' ' -f2)
+  lxc image export splitimage ${LXD_DIR}/splitexport
+  [ -f "${LXD_DIR}/splitexport/meta-${splitsum}.tar" ]
+  [ -f "${LXD_DIR}/splitexport/${splitsum}.tar" ]
+  lxc image export splitimage - | tar -tf - | grep "^meta-${splitsum}.tar$"
+  lxc image delete splitimage
+  rm -rf ${LXD_DIR}/split ${LXD_DIR}/splitexport ${LXD_DIR}/meta.tar ${LXD_DIR}/rootfs.tar
   rm ${LXD_DIR}/testimage.tar.xz
 
   # Test container creation
@@ -41,6 +66,38 @@ test_basic_usage() {
   lxc list | grep foo | grep Stopped
   lxc list fo | grep foo | grep Stopped
 
+  # Test raw API queries
+  lxc query /1.0 | grep api_compat
+  lxc query /1.0/containers | grep /1.0/containers/foo
+  lxc query -X PUT -d '{"action": "start", "timeout": 0, "force": false}' --wait /1.0/containers/foo/state | grep Success
+  lxc query -X PUT -d '{"action": "stop", "timeout": -1, "force": true}' --wait /1.0/containers/foo/state | grep Success
+  lxc query -X PUT -d '{not json' /1.0/containers/foo/state && false
+  lxc query /1.0/containers/nosuchcontainer && false
+
+  # Test name validation
+  lxc init testimage foo_bar && false
+  lxc init testimage 1foo && false
+  lxc init testimage foo- && false
+  lxc query -X POST -d '{"name": "foo.bar"}' /1.0/containers/foo && false
+  fp=$(lxc image info testimage | awk -F: '/^Fingerprint/ { print $2 }' | awk '{ print $1 }')
+  lxc query -X POST -d "{\"name\": \"bad alias\", \"target\": \"${fp}\"}" /1.0/images/aliases && false
+  lxc query -X POST -d "{\"name\": \"remote:alias\", \"target\": \"${fp}\"}" /1.0/images/aliases && false
+
+  # Test the scheduler hook
+  cat > ${LXD_DIR}/hook << EOF
+#!/bin/sh
+grep -q '"name":"refused"' && { echo "No room for it"; exit 1; }
+exit 0
+EOF
+  chmod +x ${LXD_DIR}/hook
+  lxc config set core.scheduler_hook ${LXD_DIR}/hook
+  lxc init testimage refused 2>&1 | grep "No room for it"
+  ! lxc list | grep -q refused
+  lxc init testimage allowed
+  lxc delete allowed
+  lxc config unset core.scheduler_hook
+  rm ${LXD_DIR}/hook
+
   # Test container rename
   lxc move foo bar
   lxc list | grep -v foo
@@ -81,8 +138,37 @@ test_basic_usage() {
   lxc init foo bar2
   lxc list | grep bar2
   lxc delete bar2
+
+  # publishing behind an alias only replaces the image it pointed to when asked
+  old=$(lxc image info foo | awk -F: '/^Fingerprint/ { print $2 }' | awk '{ print $1 }')
+  lxc publish bar --alias foo
+  lxc image info "$old"
+  lxc image delete "$old"
+  old=$(lxc image info foo | awk -F: '/^Fingerprint/ { print $2 }' | awk '{ print $1 }')
+  lxc publish bar --alias foo --delete-replaced
+  [ "$(lxc image info foo | awk -F: '/^Fingerprint/ { print $2 }' | awk '{ print $1 }')" != "$old" ]
+  lxc image info "$old" && false
   lxc image delete foo
 
+  # Test bulk creation from a name pattern
+  lxc init testimage bulk-2
+  lxc query -X POST -d '{"name": "bulk-%d", "count": 2, "source": {"type": "image", "alias": "testimage"}}' --wait /1.0/containers > ${LXD_DIR}/bulk.json
+  grep -q '"bulk-1"' ${LXD_DIR}/bulk.json
+  grep -q '"bulk-3"' ${LXD_DIR}/bulk.json
+  lxc query -X POST -d '{"name": "bulk", "count": 2, "source": {"type": "image", "alias": "testimage"}}' /1.0/containers && false
+  lxc query -X POST -d '{"name": "bulk-%d", "count": 2, "source": {"type": "none"}}' /1.0/containers && false
+  lxc delete bulk-1 bulk-2 bulk-3
+  rm -f ${LXD_DIR}/bulk.json
+
+  # Test container export, which doesn't leave any image behind
+  images=$(lxc image list | grep -c "|" || true)
+  lxc export bar ${LXD_DIR}/
+  tar -tf ${LXD_DIR}/bar.tar | grep -q "^metadata.yaml$"
+  lxc export bar/snap0 - --gzip | tar -tzf - | grep -q "^rootfs/"
+  lxc export bar/nosuchsnap ${LXD_DIR}/ && false
+  [ "$(lxc image list | grep -c "|" || true)" = "${images}" ]
+  rm -f ${LXD_DIR}/bar.tar
+
   # Delete the bar container we've used for several tests
   lxc delete bar
 
@@ -103,8 +189,89 @@ test_basic_usage() {
   wait_for my_curl -X POST $BASEURL/1.0/containers \
         -d "{\"name\":\"configtest\",\"config\":{\"raw.lxc\":\"lxc.hook.clone=/bin/true\"},\"source\":{\"type\":\"none\"}}"
   [ "$(my_curl $BASEURL/1.0/containers/configtest | jq -r .metadata.config[\"raw.lxc\"])" = "lxc.hook.clone=/bin/true" ]
+
+  # PATCH only changes the keys it's given
+  wait_for my_curl -X PATCH $BASEURL/1.0/containers/configtest \
+        -d "{\"config\":{\"limits.cpus\":\"1\"}}"
+  [ "$(my_curl $BASEURL/1.0/containers/configtest | jq -r .metadata.config[\"raw.lxc\"])" = "lxc.hook.clone=/bin/true" ]
+  [ "$(my_curl $BASEURL/1.0/containers/configtest | jq -r .metadata.config[\"limits.cpus\"])" = "1" ]
+  wait_for my_curl -X PATCH $BASEURL/1.0/containers/configtest \
+        -d "{\"config\":{\"raw.lxc\":\"\"}}"
+  [ "$(my_curl $BASEURL/1.0/containers/configtest | jq -r .metadata.config[\"raw.lxc\"])" = "null" ]
+
+  # A PUT based on a stale ETag is refused
+  etag=$(my_curl -i $BASEURL/1.0/containers/configtest | grep -i "^etag:" | cut -d' ' -f2 | tr -d '\r')
+  wait_for my_curl -X PATCH $BASEURL/1.0/containers/configtest \
+        -d "{\"config\":{\"limits.cpus\":\"2\"}}"
+  [ "$(my_curl -o /dev/null -w %{http_code} -X PUT -H "If-Match:${etag}" $BASEURL/1.0/containers/configtest \
+        -d "{\"config\":{\"limits.cpus\":\"1\"}}")" = "412" ]
   lxc delete configtest
 
+  # The daemon reports being healthy, only giving details to trusted clients
+  [ "$(my_curl "$BASEURL/1.0/health" | jq -r .metadata.ready)" = "true" ]
+  [ "$(curl -k -s "$BASEURL/1.0/health" | jq -r .status_code)" = "200" ]
+  [ "$(curl -k -s "$BASEURL/1.0/health" | jq -r .metadata)" = "{}" ]
+  lxd waitready --timeout 10
+  [ "$(my_curl "$BASEURL/1.0/ready?timeout=1" | jq -r .metadata.storage)" = "true" ]
+
+  # The database can be checked and backed up
+  [ "$(my_curl "$BASEURL/1.0/database/check" | jq -r .metadata.ok)" = "true" ]
+  lxd database check
+  lxd database dump | grep -q "CREATE TABLE containers"
+  lxd database dump | grep -q "INSERT INTO \"profiles\" VALUES(.*'default'"
+
+  # The extensions the client relies on are advertised
+  my_curl "$BASEURL/1.0" | jq -r '.metadata.api_extensions[]' | grep -qx cluster
+
+  # A lone server is a cluster of its own
+  [ "$(my_curl "$BASEURL/1.0/cluster" | jq -r .metadata.name)" = "$(hostname)" ]
+  [ "$(my_curl "$BASEURL/1.0/cluster/members" | jq -r '.metadata | length')" = "0" ]
+  lxc cluster containers | grep -q MEMBER
+  my_curl "$BASEURL/1.0/containers?target=nosuchmember" | grep -q "Unknown cluster member"
+
+  # Requests get an ID, which errors repeat
+  my_curl -i "$BASEURL/1.0" | grep -qi "^X-LXD-request-id: "
+  [ "$(my_curl "$BASEURL/1.0/containers/nosuchcontainer" | jq -r .request_id)" != "null" ]
+
+  # Collections return full objects with recursion
+  [ "$(my_curl "$BASEURL/1.0/profiles" | jq -r '.metadata[0]')" = "/1.0/profiles/default" ]
+  [ "$(my_curl "$BASEURL/1.0/profiles?recursion=1" | jq -r '.metadata[0].name')" = "default" ]
+  my_curl "$BASEURL/1.0/operations?recursion=1" | jq -e '.metadata.running'
+  my_curl "$BASEURL/1.0/certificates/tokens?recursion=1" | jq -e '.metadata'
+
+  # The host resources are reported
+  [ "$(my_curl "$BASEURL/1.0/resources" | jq -r .metadata.cpu.total)" -gt 0 ]
+  [ "$(my_curl "$BASEURL/1.0/resources" | jq -r .metadata.memory.total)" -gt 0 ]
+
+  # The supported config keys can be listed
+  my_curl "$BASEURL/1.0/config_keys" | jq -r '.metadata.server[].name' | grep core.trust_password
+  my_curl "$BASEURL/1.0/config_keys" | jq -r '.metadata.container[].name' | grep 'user\.\*'
+
+  # Changes are recorded in the audit log
+  lxc profile create audittest
+  my_curl "$BASEURL/1.0/audit" | jq -r '.metadata[] | select(.method == "POST" and .url == "/1.0/profiles") | .summary' | grep audittest
+  my_curl "$BASEURL/1.0/audit" | jq -r '.metadata[] | select(.url == "/1.0/containers/configtest") | .status_code' | grep 412
+  lxc profile delete audittest
+
+  # Web pages from the allowed origins get CORS headers
+  ! my_curl -i -H "Origin: https://dashboard.example.com" "$BASEURL/1.0" | grep -qi "^Access-Control-Allow-Origin"
+  lxc config set core.https_allowed_origin https://dashboard.example.com
+  my_curl -i -X OPTIONS -H "Origin: https://dashboard.example.com" "$BASEURL/1.0/containers" | grep -qi "^Access-Control-Allow-Origin: https://dashboard.example.com"
+  lxc config unset core.https_allowed_origin
+
+  # The profiling endpoints are only served once given an address
+  lxc config set core.debug_address 127.0.0.1:8444
+  curl -s "http://127.0.0.1:8444/debug/pprof/goroutine?debug=2" | grep -q "^goroutine"
+  lxc config unset core.debug_address
+  ! curl -s "http://127.0.0.1:8444/debug/pprof/"
+
+  # Metrics are only exported once enabled
+  [ "$(my_curl -o /dev/null -w %{http_code} "$BASEURL/1.0/metrics")" = "404" ]
+  lxc config set core.metrics true
+  my_curl "$BASEURL/1.0/metrics" | grep '^lxd_api_requests_total{endpoint="/1.0/profiles",method="POST",code="200"}'
+  my_curl "$BASEURL/1.0/metrics" | grep '^lxd_images_cached '
+  lxc config unset core.metrics
+
   # Anything below this will not get run inside Travis-CI
   if [ -n "$TRAVIS_PULL_REQUEST" ]; then
     return
@@ -127,11 +294,36 @@ test_basic_usage() {
     false
   fi
 
+  # containers can be handled in bulk
+  lxd batch --concurrency 2 create testimage 3 bulk
+  [ "$(lxc list | grep -c bulk-)" = "3" ]
+  lxd batch delete bulk
+  ! lxc list | grep -q bulk-
+
   # check that we can set the environment
+  lxc config set foo environment.BOOT_BAND gojira
+  lxc config set foo "environment.BAD NAME" value && false
+  lxc config set foo environment.BAD_VALUE "$(printf 'a\nb')" && false
+  lxc stop foo --force
+  lxc start foo
+  lxc exec foo -- cat /proc/1/environ | tr '\0' '\n' | grep -q "^BOOT_BAND=gojira$"
+  lxc config unset foo environment.BOOT_BAND
   lxc exec foo pwd | grep /root
   lxc exec --env BEST_BAND=meshuggah foo env | grep meshuggah
   lxc exec foo ip link show | grep eth0
 
+  # check that the exit status of the command is propagated
+  lxc exec foo -- /bin/true
+  ret=0
+  lxc exec foo -- /bin/sh -c "exit 42" || ret=$?
+  [ "$ret" -eq 42 ]
+
+  # exec works the same without websocket compression
+  lxc config set core.websocket_compression false
+  lxc exec foo pwd | grep /root
+  lxc config unset core.websocket_compression
+  ! lxc config set core.websocket_compression maybe
+
   # test file transfer
   echo abc > ${LXD_DIR}/in
 
@@ -148,6 +340,13 @@ test_basic_usage() {
 
   echo foo | lxc exec foo tee /tmp/foo
 
+  # Non-interactive commands keep stdout and stderr apart
+  [ "$(lxc exec --mode=non-interactive foo -- /bin/sh -c 'echo out; echo err >&2' 2>/dev/null)" = "out" ]
+  [ "$(lxc exec --mode=non-interactive foo -- /bin/sh -c 'echo out; echo err >&2' 2>&1 >/dev/null)" = "err" ]
+  echo bar | lxc exec --mode=non-interactive foo -- tee /tmp/bar | grep bar
+  lxc exec foo -- cat /tmp/bar | grep bar
+  lxc exec --mode=bogus foo -- /bin/true && false
+
   # Detect regressions/hangs in exec
   sum=$(ps aux | tee ${LXD_DIR}/out | lxc exec foo md5sum | cut -d' ' -f1)
   [ "$sum" = "$(md5sum ${LXD_DIR}/out | cut -d' ' -f1)" ]
@@ -157,8 +356,38 @@ test_basic_usage() {
   content=$(cat "${LXD_DIR}/containers/foo/rootfs/tmp/foo")
   [ "$content" = "foo" ]
 
+  # test pausing and resuming
+  lxc pause foo
+  lxc list | grep foo | grep Frozen
+  lxc resume foo
+  lxc list | grep foo | grep Running
+
+  # test user defined command aliases
+  lxc alias add list pause && false
+  lxc alias add freeze pause
+  lxc alias list | grep freeze
+  lxc freeze foo
+  lxc list | grep foo | grep Frozen
+  lxc resume foo
+  lxc alias remove freeze
+  lxc alias list | grep -q freeze && false
+  lxc freeze foo && false
+
+  # test lifecycle events
+  $(which lxc) monitor --config "${LXD_CONF}" --type=lifecycle > ${LXD_DIR}/lifecycle.log 2>&1 &
+  monitor_pid=$!
+  sleep 1
+  lxc stop foo --force
+  lxc start foo
+  sleep 1
+  kill ${monitor_pid}
+  grep -q container-stopped ${LXD_DIR}/lifecycle.log
+  grep -q container-started ${LXD_DIR}/lifecycle.log
+  rm -f ${LXD_DIR}/lifecycle.log
+
   # cleanup
-  lxc delete foo
+  lxc delete foo && false
+  lxc delete foo --force
 
   # check that an apparmor profile is created for this container, that it is
   # unloaded on stop, and that it is deleted when the container is deleted
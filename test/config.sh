@@ -29,13 +29,43 @@ test_config_profiles() {
   lxc profile set unconfined raw.lxc "lxc.aa_profile=unconfined"
   lxc profile apply foo onenic,unconfined
 
+  # unknown or duplicate profiles must be refused
+  lxc profile apply foo onenic,nosuchprofile && false
+  lxc profile apply foo onenic,onenic && false
+
   lxc config device list foo | grep home
   lxc config device show foo | grep "/mnt"
   lxc config show foo | grep "onenic" -A1 | grep "unconfined"
+  lxc config show foo | grep -q "lxcbr0" && false
+  lxc config show --expanded foo | grep "lxcbr0"
+  lxc config show --expanded foo | grep "lxc.aa_profile=unconfined"
+
+  # the container's devices replace the profiles' ones of the same name
+  lxc config device add foo eth0 none
+  [ "$(my_curl "$BASEURL/1.0/containers/foo" | jq -r '.metadata.expanded_devices.eth0.type')" = "none" ]
+  lxc config device remove foo eth0
+  [ "$(my_curl "$BASEURL/1.0/containers/foo" | jq -r '.metadata.expanded_devices.eth0.parent')" = "lxcbr0" ]
   lxc profile list | grep onenic
+
+  # profiles in use report their containers and can't be deleted
+  my_curl "$BASEURL/1.0/profiles/onenic" | jq -r '.metadata.used_by[]' | grep -q "^/1.0/containers/foo$"
+  lxc profile delete onenic && false
   lxc profile device list onenic | grep eth0
   lxc profile device show onenic | grep lxcbr0
 
+  # copying and renaming profiles
+  lxc profile copy onenic onenic-copy
+  lxc profile device show onenic-copy | grep lxcbr0
+  lxc profile rename onenic-copy twonic
+  lxc profile list | grep twonic
+  lxc profile list | grep onenic-copy && false
+  lxc profile rename twonic onenic && false
+  lxc profile rename nosuchprofile twonic && false
+  lxc profile rename default notdefault && false
+  lxc profile delete default && false
+  lxc profile device show default | grep -q "path: /"
+  lxc profile delete twonic
+
   if [ -z "$TRAVIS_PULL_REQUEST" ]; then
     # test live-adding a nic
     lxc start foo
@@ -88,6 +118,14 @@ test_config_profiles() {
 
   lxc delete foo
 
+  # config keys can be set at creation time
+  lxc init testimage foo -c user.prop=value -c boot.autostart=true
+  lxc config get foo user.prop | grep value
+  lxc config get foo boot.autostart | grep true
+  lxc config set foo limits.cpu.allowance 10 && false
+  lxc config set foo limits.cpu.allowance 10%
+  lxc delete foo
+
   # Anything below this will not get run inside Travis-CI
   if [ -n "$TRAVIS_PULL_REQUEST" ]; then
     return
@@ -100,6 +138,19 @@ test_config_profiles() {
   lxc exec foo -- cat /proc/self/attr/current | grep unconfined
   lxc exec foo -- ls /sys/class/net | grep eth0
 
+  # profile changes apply to the running containers, or report the restart
+  [ "$(my_curl -X PATCH "$BASEURL/1.0/profiles/onenic" -d '{"config": {"limits.memory": "128M"}}' | jq -r '.metadata.restart_needed | length')" = "0" ]
+  grep -q 134217728 /sys/fs/cgroup/memory/lxc/foo/memory.limit_in_bytes
+  my_curl -X PATCH "$BASEURL/1.0/profiles/onenic" -d '{"config": {"security.privileged": "true"}}' | jq -r '.metadata.restart_needed[]' | grep -q "^/1.0/containers/foo$"
+  lxc profile unset onenic security.privileged
+  lxc profile unset onenic limits.memory
+
+  # the allowance is applied by the scheduler, in the background
+  lxc config set foo limits.cpu.allowance 10%
+  sleep 1
+  [ "$(cat /sys/fs/cgroup/cpu/lxc/foo/cpu.cfs_quota_us)" = "$(($(nproc) * 10000))" ]
+  lxc config unset foo limits.cpu.allowance
+
   lxc stop foo --force
   lxc delete foo
 }
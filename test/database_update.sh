@@ -11,12 +11,12 @@ cat schema1.sql | sqlite3 ${MIGRATE_DB} > /dev/null
 spawn_lxd 127.0.0.1:18447 "${LXD_MIGRATE_DIR}"
 
 # Assert there are enough tables.
-expected_tables=15
+expected_tables=23
 tables=`sqlite3 ${MIGRATE_DB} ".dump" | grep "CREATE TABLE" | wc -l`
 [ $tables -eq $expected_tables ] || { echo "FAIL: Wrong number of tables after database migration. Found: $tables, expected $expected_tables"; false; }
 
-# There should be 10 "ON DELETE CASCADE" occurences
-expected_cascades=10
+# There should be 13 "ON DELETE CASCADE" occurences
+expected_cascades=13
 cascades=`sqlite3 ${MIGRATE_DB} ".dump" | grep "ON DELETE CASCADE" | wc -l`
 [ $cascades -eq $expected_cascades ] || { echo "FAIL: Wrong number of ON DELETE CASCADE foreign keys. Found: $cascades, exected: $expected_cascades"; false; }
 }
@@ -23,5 +23,24 @@ test_filemanip() {
   [ -f ${LXD_DIR}/containers/filemanip/rootfs/tmp/outside/main.sh ]
 
   rm -rf /tmp/outside
-  lxc delete filemanip
+
+  # Check that mode and ownership are applied on push and kept on pull
+  lxc file push --mode=0600 --uid=1000 --gid=1000 main.sh filemanip/tmp/perms
+  lxc exec filemanip -- stat -c "%a %u %g" /tmp/perms | grep "600 1000 1000"
+  lxc file pull --preserve filemanip/tmp/perms ${LXD_DIR}/perms
+  [ "$(stat -c %a ${LXD_DIR}/perms)" = "600" ]
+  rm -f ${LXD_DIR}/perms
+
+  # Check that several files can be pushed at once
+  mkdir ${LXD_DIR}/multi
+  for i in $(seq 10); do
+    echo "file $i" > ${LXD_DIR}/multi/file$i
+  done
+  lxc exec filemanip -- mkdir /tmp/multi
+  lxc file push ${LXD_DIR}/multi/* filemanip/tmp/multi/
+  [ "$(lxc exec filemanip -- ls /tmp/multi | wc -l)" = "10" ]
+  lxc exec filemanip -- cat /tmp/multi/file7 | grep "file 7"
+  lxc file push ${LXD_DIR}/multi/file1 ${LXD_DIR}/multi/file2 filemanip/tmp/multi/single && false
+  rm -rf ${LXD_DIR}/multi
+  lxc delete filemanip --force
 }
@@ -115,7 +115,7 @@ test_mixing_storage() {
     lvs lxd_test_vg/reg--container--sticks--around-regsnap && die "we should NOT have a snap lv for a reg container"
 
     lxc config unset storage.lvm_vg_name && die "shouldn't be able to unset config with existing lv containers"
-    lxc delete lvm-container || die "couldn't delete container"
+    lxc delete lvm-container --force || die "couldn't delete container"
     lxc image delete testimage || die "couldn't delete lvm-backed image"
 
     lxc delete lvm-from-reg || die "couldn't delete lvm-from-reg"
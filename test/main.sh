@@ -142,6 +142,7 @@ fi
 . ./filemanip.sh
 . ./fuidshift.sh
 . ./migration.sh
+. ./networks.sh
 . ./remote.sh
 . ./signoff.sh
 . ./snapshots.sh
@@ -178,11 +179,7 @@ spawn_lxd() {
   LXD_DIR=$lxddir lxd --logfile $lxddir/lxd.log $debug $extraargs $* 2>&1 & echo $! > $lxddir/lxd.pid
 
   echo "==> Confirming lxd on $addr is responsive"
-  alive=0
-  while [ $alive -eq 0 ]; do
-    [ -e "${lxddir}/unix.socket" ] && LXD_DIR=$lxddir lxc finger && alive=1
-    sleep 1s
-  done
+  LXD_DIR=$lxddir lxd waitready --timeout=300
 
   echo "==> Binding to network"
   LXD_DIR=$lxddir lxc config set core.https_address $addr
@@ -281,6 +278,10 @@ echo "==> TEST: profiles, devices and configuration"
 curtest=test_config_profiles
 test_config_profiles
 
+echo "==> TEST: managed networks"
+curtest=test_networks
+test_networks
+
 echo "==> TEST: server config"
 curtest=test_server_config
 test_server_config
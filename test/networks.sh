@@ -0,0 +1,140 @@
+test_networks() {
+  # Managed networks are bridges set up by LXD
+  my_curl -X POST "$BASEURL/1.0/networks" \
+    -d '{"name": "lxdt0", "config": {"ipv4.address": "10.251.0.1/24", "ipv4.nat": "true"}}' | jq -r .status_code | grep -q 200
+  [ -d /sys/class/net/lxdt0/bridge ]
+  ip -4 addr show dev lxdt0 | grep -q 10.251.0.1/24
+  iptables -t nat -S POSTROUTING | grep -q "generated for LXD network lxdt0"
+  iptables -S INPUT | grep "generated for LXD network lxdt0" | grep -q "dport 67"
+  iptables -S FORWARD | grep -q "generated for LXD network lxdt0"
+  [ "$(my_curl "$BASEURL/1.0/networks/lxdt0" | jq -r .metadata.managed)" = "true" ]
+  [ "$(my_curl "$BASEURL/1.0/networks/lxdt0" | jq -r .metadata.state.state)" = "up" ]
+  [ "$(my_curl "$BASEURL/1.0/networks/lxdt0" | jq -r .metadata.state.mtu)" = "$(cat /sys/class/net/lxdt0/mtu)" ]
+  my_curl "$BASEURL/1.0/networks/lo" | jq -r .metadata.state.counters.bytes_received | grep -q "^[0-9]\+$"
+
+  # DHCP and DNS are served by dnsmasq
+  pid=$(cat "${LXD_DIR}/networks/lxdt0/dnsmasq.pid")
+  tr '\0' ' ' < "/proc/${pid}/cmdline" | grep -q "dnsmasq.*dhcp-range=10.251.0.2,10.251.0.254"
+  [ "$(my_curl "$BASEURL/1.0/networks/lxdt0/leases" | jq -r '.metadata | length')" = "0" ]
+  [ "$(my_curl "$BASEURL/1.0/networks/lo/leases" | jq -r .error_code)" = "404" ]
+
+  # Invalid names and configurations are rejected
+  [ "$(my_curl -X POST "$BASEURL/1.0/networks" -d '{"name": "lxdt0"}' | jq -r .error_code)" = "409" ]
+  [ "$(my_curl -X POST "$BASEURL/1.0/networks" -d '{"name": "lxdt1", "config": {"ipv4.nat": "yes"}}' | jq -r .error_code)" = "400" ]
+  [ "$(my_curl -X POST "$BASEURL/1.0/networks" -d '{"name": "lxdtoolongofaname"}' | jq -r .error_code)" = "400" ]
+
+  # Changes are applied right away
+  my_curl -X PATCH "$BASEURL/1.0/networks/lxdt0" -d '{"config": {"ipv4.address": "10.252.0.1/24"}}' | jq -r .status_code | grep -q 200
+  ip -4 addr show dev lxdt0 | grep -q 10.252.0.1/24
+  ip -4 addr show dev lxdt0 | grep -q 10.251.0.1/24 && false
+  iptables -t nat -S POSTROUTING | grep -q "10.252.0.0/24"
+  iptables -t nat -S POSTROUTING | grep -q "10.251.0.0/24" && false
+
+  # IPv6 gets router advertisements and NAT66
+  my_curl -X PATCH "$BASEURL/1.0/networks/lxdt0" -d '{"config": {"ipv6.address": "fd42:4242::1/64", "ipv6.nat": "true"}}' | jq -r .status_code | grep -q 200
+  ip -6 addr show dev lxdt0 | grep -q fd42:4242::1/64
+  ip6tables -t nat -S POSTROUTING | grep -q "fd42:4242::/64"
+  pid=$(cat "${LXD_DIR}/networks/lxdt0/dnsmasq.pid")
+  tr '\0' ' ' < "/proc/${pid}/cmdline" | grep -q "enable-ra.*dhcp-range=fd42:4242::,ra-stateless"
+  [ "$(my_curl -X PATCH "$BASEURL/1.0/networks/lxdt0" -d '{"config": {"ipv6.address": "fd42:4242::1/80"}}' | jq -r .error_code)" = "400" ]
+
+  # Tunnels are added to the bridge, other interfaces plugged into it stay
+  ip link add lxdt0-dummy type dummy
+  ip link set lxdt0-dummy master lxdt0
+  my_curl -X PATCH "$BASEURL/1.0/networks/lxdt0" -d '{"config": {"tunnel.t1.protocol": "vxlan", "tunnel.t1.id": "10", "tunnel.t1.remote": "127.0.0.2"}}' | jq -r .status_code | grep -q 200
+  [ "$(basename "$(readlink /sys/class/net/lxdt0-t1/master)")" = "lxdt0" ]
+  [ "$(my_curl -X PATCH "$BASEURL/1.0/networks/lxdt0" -d '{"config": {"tunnel.t2.protocol": "gre"}}' | jq -r .error_code)" = "400" ]
+  my_curl -X PATCH "$BASEURL/1.0/networks/lxdt0" -d '{"config": {"tunnel.t1.protocol": "", "tunnel.t1.id": "", "tunnel.t1.remote": ""}}' | jq -r .status_code | grep -q 200
+  [ ! -e /sys/class/net/lxdt0-t1 ]
+  [ -e /sys/class/net/lxdt0-dummy ]
+  ip link del lxdt0-dummy
+
+  # The traffic between the containers goes through the network's ACL
+  if which ebtables >/dev/null 2>&1; then
+    my_curl -X PATCH "$BASEURL/1.0/networks/lxdt0" -d '{"config": {"bridge.isolation": "true", "acl.rules": "allow any 10.254.0.10 tcp 80"}}' | jq -r .status_code | grep -q 200
+    ebtables -L lxd-lxdt0 | grep -q "ip-dport 80"
+    [ "$(my_curl -X PATCH "$BASEURL/1.0/networks/lxdt0" -d '{"config": {"acl.rules": "permit any any"}}' | jq -r .error_code)" = "400" ]
+    my_curl -X PATCH "$BASEURL/1.0/networks/lxdt0" -d '{"config": {"bridge.isolation": "", "acl.rules": ""}}' | jq -r .status_code | grep -q 200
+    ebtables -L lxd-lxdt0 >/dev/null 2>&1 && false
+  fi
+
+  # Networks in use can't be removed
+  lxc profile create nettest
+  lxc profile device add nettest eth0 nic nictype=bridged parent=lxdt0
+  my_curl "$BASEURL/1.0/networks/lxdt0" | jq -r '.metadata.used_by[]' | grep -q "^/1.0/profiles/nettest$"
+  [ "$(my_curl -X DELETE "$BASEURL/1.0/networks/lxdt0" | jq -r .error_code)" = "400" ]
+  lxc profile delete nettest
+
+  # Static addresses are DHCP reservations
+  ensure_import_testimage
+  lxc init testimage nettest
+  lxc config device add nettest eth0 nic nictype=bridged parent=lxdt0 ipv4.address=10.252.0.300 && false
+  lxc config device add nettest eth0 nic nictype=bridged parent=lxdt0 ipv4.address=10.252.0.10
+  lxc start nettest
+  grep -q "10.252.0.10,nettest" "${LXD_DIR}/networks/lxdt0/dnsmasq.hosts/nettest"
+  lxc stop nettest --force
+  grep -q "10.252.0.10$" "${LXD_DIR}/networks/lxdt0/dnsmasq.hosts/nettest"
+  lxc delete nettest
+
+  # The names of the running containers are registered in the DNS
+  lxc init testimage nettest
+  lxc config device add nettest eth0 nic nictype=bridged parent=lxdt0
+  lxc start nettest
+  grep -q ",nettest$" "${LXD_DIR}/networks/lxdt0/dnsmasq.hosts/nettest"
+  lxc stop nettest --force
+  [ ! -e "${LXD_DIR}/networks/lxdt0/dnsmasq.hosts/nettest" ]
+  lxc delete nettest
+
+  # macvlan and physical nics sit on a host interface
+  ip link add lxdt-dummy0 type dummy
+  ip link add lxdt-dummy1 type dummy
+  lxc init testimage nettest
+  lxc config device add nettest eth0 nic nictype=macvlan parent=lxdt-dummy0
+  lxc config device add nettest eth1 nic nictype=physical parent=lxdt-dummy1
+  lxc config device add nettest eth2 nic nictype=vepa parent=lxdt-dummy0 && false
+  lxc start nettest
+  [ ! -e /sys/class/net/lxdt-dummy1 ]
+  lxc exec nettest -- ip link show eth0 | grep -q macvlan
+  lxc stop nettest --force
+  [ -e /sys/class/net/lxdt-dummy1 ]
+  lxc delete nettest
+  ip link del lxdt-dummy0
+  ip link del lxdt-dummy1
+  [ ! -e "${LXD_DIR}/networks/lxdt0/dnsmasq.hosts/nettest" ]
+
+  # Port forwards are DNAT rules
+  my_curl -X POST "$BASEURL/1.0/networks/lxdt0/forwards" \
+    -d '{"protocol": "tcp", "listen_port": 8080, "target_address": "10.252.0.10", "target_port": 80}' | jq -r .status_code | grep -q 200
+  iptables -t nat -S PREROUTING | grep "generated for LXD network lxdt0" | grep -q "10.252.0.10:80"
+  [ "$(my_curl "$BASEURL/1.0/networks/lxdt0/forwards/tcp:8080" | jq -r .metadata.target_port)" = "80" ]
+  [ "$(my_curl -X POST "$BASEURL/1.0/networks/lxdt0/forwards" -d '{"protocol": "tcp", "listen_port": 8080, "target_address": "10.252.0.11", "target_port": 80}' | jq -r .error_code)" = "409" ]
+  [ "$(my_curl -X POST "$BASEURL/1.0/networks/lxdt0/forwards" -d '{"protocol": "tcp", "listen_port": 8081, "target_address": "10.253.0.10", "target_port": 80}' | jq -r .error_code)" = "400" ]
+  [ "$(my_curl -X POST "$BASEURL/1.0/networks/lxdt0/forwards" -d '{"protocol": "tcp", "listen_address": "127.0.0.1", "listen_port": 8080, "target_address": "10.252.0.11", "target_port": 80}' | jq -r .error_code)" = "409" ]
+  [ "$(my_curl -X POST "$BASEURL/1.0/networks/lxdt0/forwards" -d "{\"protocol\": \"tcp\", \"listen_port\": ${BASEURL##*:}, \"target_address\": \"10.252.0.11\", \"target_port\": 80}" | jq -r .error_code)" = "400" ]
+  [ "$(my_curl -X PATCH "$BASEURL/1.0/networks/lxdt0" -d '{"config": {"ipv4.address": "10.253.0.1/24"}}' | jq -r .error_code)" = "400" ]
+  my_curl -X DELETE "$BASEURL/1.0/networks/lxdt0/forwards/tcp:8080" | jq -r .status_code | grep -q 200
+  iptables -t nat -S PREROUTING | grep -q "10.252.0.10:80" && false
+  [ "$(my_curl -X DELETE "$BASEURL/1.0/networks/lxdt0/forwards/tcp:8080" | jq -r .error_code)" = "404" ]
+
+  # Networks can be renamed while they're unused
+  lxc profile create nettest
+  lxc profile device add nettest eth0 nic nictype=bridged parent=lxdt0 ipv4.address=10.252.0.20
+  [ "$(my_curl -X PATCH "$BASEURL/1.0/networks/lxdt0" -d '{"config": {"ipv4.address": "10.254.0.1/24"}}' | jq -r .error_code)" = "400" ]
+  [ "$(my_curl -X POST "$BASEURL/1.0/networks/lxdt0" -d '{"name": "lxdt1"}' | jq -r .error_code)" = "400" ]
+  lxc profile delete nettest
+  my_curl -X POST "$BASEURL/1.0/networks/lxdt0" -d '{"name": "lxdt1"}' | jq -r .status_code | grep -q 200
+  [ ! -e /sys/class/net/lxdt0 ]
+  ip -4 addr show dev lxdt1 | grep -q 10.252.0.1/24
+  iptables -t nat -S POSTROUTING | grep -q "generated for LXD network lxdt1"
+  iptables -t nat -S POSTROUTING | grep -q "generated for LXD network lxdt0" && false
+  [ "$(my_curl -X POST "$BASEURL/1.0/networks/lxdt1" -d '{"name": "lo"}' | jq -r .error_code)" = "409" ]
+  my_curl -X POST "$BASEURL/1.0/networks/lxdt1" -d '{"name": "lxdt0"}' | jq -r .status_code | grep -q 200
+
+  my_curl -X DELETE "$BASEURL/1.0/networks/lxdt0" | jq -r .status_code | grep -q 200
+  [ ! -e /sys/class/net/lxdt0 ]
+  [ ! -e "${LXD_DIR}/networks/lxdt0" ]
+  iptables -t nat -S POSTROUTING | grep -q "generated for LXD network lxdt0" && false
+  iptables -S | grep -q "generated for LXD network lxdt0" && false
+  ip6tables -S | grep -q "generated for LXD network lxdt0" && false
+  [ "$(my_curl "$BASEURL/1.0/networks/lxdt0" | jq -r .error_code)" = "404" ]
+}
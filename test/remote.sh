@@ -49,6 +49,13 @@ test_remote_admin() {
   lxc remote list | grep 'foo'
   lxc remote list | grep -v 'localhost'
   [ "$(lxc remote get-default)" = "foo" ]
+  [ -f "$LXD_CONF/servercerts/foo.crt" ]
+  [ ! -f "$LXD_CONF/servercerts/localhost.crt" ]
+
+  lxc remote set-url nosuchremote https://127.0.0.1:18443 && false
+  lxc remote set-url foo https://127.0.0.1:18443 --accept-certificate
+  lxc remote list | grep 'foo' | grep 'https://127.0.0.1:18443'
+  lxc finger foo:
 
   lxc remote remove foo
   [ "$(lxc remote get-default)" = "" ]
@@ -70,6 +77,36 @@ test_remote_admin() {
     echo "wrong number of certs"
   fi
 
+  # certificates can be removed by name as well as fingerprint
+  lxc config trust list | grep client2
+  lxc config trust remove client2
+  lxc config trust list | grep -q client2 && false
+  lxc config trust remove client2 && false
+
+  # join tokens add the certificate once, under the token's name
+  token=$(lxc config trust token client2)
+  add_with_token="{\"type\": \"client\", \"token\": \"${token}\"}"
+  curl -k -s --cert "$LXD_CONF/client2.crt" --key "$LXD_CONF/client2.key" -X POST -d "${add_with_token}" https://127.0.0.1:18443/1.0/certificates | grep '"status_code":200'
+  lxc config trust list | grep client2
+  lxc config trust remove client2
+  curl -k -s --cert "$LXD_CONF/client2.crt" --key "$LXD_CONF/client2.key" -X POST -d "${add_with_token}" https://127.0.0.1:18443/1.0/certificates | grep '"error_code":403'
+  lxc config trust list | grep -q client2 && false
+
+  # read-only certificates can't change anything
+  lxc config trust add "$LXD_CONF/client2.crt" --read-only
+  lxc config trust list | grep client2 | grep read-only
+  curl -k -s --cert "$LXD_CONF/client2.crt" --key "$LXD_CONF/client2.key" https://127.0.0.1:18443/1.0/profiles | grep '"status_code":200'
+  curl -k -s --cert "$LXD_CONF/client2.crt" --key "$LXD_CONF/client2.key" -X PUT -d "{\"config\": {}}" https://127.0.0.1:18443/1.0 | grep '"error_code":403'
+  lxc config trust remove client2
+
+  # restricted certificates only see their containers
+  lxc config trust add "$LXD_CONF/client2.crt" --containers=nosuchcontainer
+  curl -k -s --cert "$LXD_CONF/client2.crt" --key "$LXD_CONF/client2.key" https://127.0.0.1:18443/1.0/containers | grep '"metadata":\[\]'
+  curl -k -s --cert "$LXD_CONF/client2.crt" --key "$LXD_CONF/client2.key" https://127.0.0.1:18443/1.0/profiles | grep '"error_code":403'
+  lxc config trust remove client2
+
+  lxc config trust add "$LXD_CONF/client2.crt"
+
   # Check that we can add domains with valid certs without confirmation:
 
   # avoid default high port behind some proxies:
@@ -121,6 +158,12 @@ test_remote_usage() {
   wait $C1PID
   lxc delete lxd2:c1
 
+  # The alias is resolved from the cache until the remote is due a check
+  lxc image alias delete localhost:testimage
+  lxc init localhost:testimage lxd2:c1
+  lxc delete lxd2:c1
+  lxc image alias create localhost:testimage $sum
+
   if [ -n "$TRAVIS_PULL_REQUEST" ]; then
     return
   fi
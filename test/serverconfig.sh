@@ -12,7 +12,59 @@ test_server_config() {
     lxc config unset core.trust_password
     lxc config show | grep -q -v "trust_password"
 
+    lxc config set core.proxy_https http://proxy.example.com:3128
+    lxc config set core.proxy_ignore_hosts "localhost,example.net"
+    lxc config show | grep -q "proxy_https"
+    lxc config unset core.proxy_https
+    lxc config unset core.proxy_ignore_hosts
+    lxc config show | grep -q -v "proxy_https"
+
+    lxc config set core.api_rate_limit abc && false
+    lxc config set core.api_rate_limit 100
+    lxc config set core.api_rate_burst 200
+    lxc config show | grep -q "api_rate_limit"
+    lxc config unset core.api_rate_limit
+    lxc config unset core.api_rate_burst
+
+    lxc config set core.concurrent_container_creations -1 && false
+    lxc config set core.concurrent_container_creations 2
+    lxc config set core.concurrent_image_imports 1
+    lxc config show | grep -q "concurrent_container_creations"
+    lxc config unset core.concurrent_container_creations
+    lxc config unset core.concurrent_image_imports
+
+    lxc config set core.log_level foo && false
+    lxc config set core.log_level debug
+    lxc config show | grep -q "log_level"
+    lxc config unset core.log_level
+
+    # preseeded configuration
+    cat << EOF | LXD_DIR=$LXD_SERVERCONFIG_DIR lxd init --preseed
+trust_password: preseeded
+config:
+  images.remote_cache_expiry: 5
+EOF
+    LXD_DIR=$LXD_SERVERCONFIG_DIR lxc config show | grep -q "remote_cache_expiry"
+    LXD_DIR=$LXD_SERVERCONFIG_DIR lxc config show | grep -q "trust_password"
+    echo "storage_backend: foo" | LXD_DIR=$LXD_SERVERCONFIG_DIR lxd init --preseed && false
+    LXD_DIR=$LXD_SERVERCONFIG_DIR lxd init && false
+    LXD_DIR=$LXD_SERVERCONFIG_DIR lxc config unset images.remote_cache_expiry
+    LXD_DIR=$LXD_SERVERCONFIG_DIR lxc config unset core.trust_password
+
     # test untrusted server GET
     my_curl -X GET https://127.0.0.1:18450/1.0 | grep -v -q environment
 
+    # the https listener moves without a restart
+    LXD_DIR=$LXD_SERVERCONFIG_DIR lxc config set core.https_address 127.0.0.1:18451
+    my_curl https://127.0.0.1:18451/1.0 | grep -q untrusted
+    my_curl https://127.0.0.1:18450/1.0 && false
+
+    # or listens on several addresses
+    LXD_DIR=$LXD_SERVERCONFIG_DIR lxc config set core.https_address 127.0.0.1:18450,127.0.0.1:18451
+    my_curl https://127.0.0.1:18450/1.0 | grep -q untrusted
+    my_curl https://127.0.0.1:18451/1.0 | grep -q untrusted
+    LXD_DIR=$LXD_SERVERCONFIG_DIR lxc config set core.https_address 127.0.0.1:18450
+    my_curl https://127.0.0.1:18450/1.0 | grep -q untrusted
+    my_curl https://127.0.0.1:18451/1.0 && false
+
 }
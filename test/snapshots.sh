@@ -16,9 +16,26 @@ test_snapshots() {
   lxc copy foo/tester foosnap1
   [ -d "$LXD_DIR/containers/foosnap1/rootfs" ]
 
+  # copies bring the snapshots along unless --container-only is passed
+  lxc copy foo foocopy
+  [ -d "$LXD_DIR/snapshots/foocopy/tester" ]
+  lxc copy foo foocopy2 --container-only
+  [ ! -d "$LXD_DIR/snapshots/foocopy2" ]
+  lxc delete foocopy
+  lxc delete foocopy2
+  [ ! -d "$LXD_DIR/snapshots/foocopy" ]
+
   lxc delete foo/snap0
   [ ! -d "$LXD_DIR/snapshots/foo/snap0" ]
 
+  # snapshots report when they were taken and the space they use
+  my_curl "$BASEURL/1.0/containers/foo/snapshots/snap1" | jq -r .metadata.created_at | grep -v "^0001-"
+  [ "$(my_curl "$BASEURL/1.0/containers/foo/snapshots?recursion=1" | jq -r '.metadata[] | select(.name == "snap1") | .size')" != "null" ]
+
+  # stateful snapshots need a running container
+  lxc snapshot foo stateful --stateful && false
+  [ ! -d "$LXD_DIR/snapshots/foo/stateful" ]
+
   # no CLI for this, so we use the API directly (rename a snapshot)
   wait_for my_curl -X POST $BASEURL/1.0/containers/foo/snapshots/tester -d "{\"name\":\"tester2\"}"
   [ ! -d "$LXD_DIR/snapshots/foo/tester" ]
@@ -105,6 +122,9 @@ test_snap_restore() {
    false
   fi
 
+  # snap0 has no running state to restore
+  lxc restore bar snap0 --stateful && false
+
   ##########################################################
 
   # test restore using full snapshot name
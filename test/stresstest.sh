@@ -130,7 +130,7 @@ createthread() {
         echo "createthread: starting loop $i out of $NUMCREATES"
         declare -a pids
         for j in `seq 1 20`; do
-            lxc launch busybox b.$i.$j &
+            lxc launch busybox b-$i-$j &
             pids[$j]=$!
         done
         for j in `seq 1 20`; do
@@ -139,7 +139,7 @@ createthread() {
         done
         echo "createthread: deleting..."
         for j in `seq 1 20`; do
-            lxc delete b.$i.$j &
+            lxc delete b-$i-$j &
             pids[$j]=$!
         done
         for j in `seq 1 20`; do